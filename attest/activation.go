@@ -3,34 +3,119 @@ package attest
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/big"
+	"sync"
 
 	"github.com/google/go-tpm/legacy/tpm2"
-	tpm1 "github.com/google/go-tpm/tpm"
-
-	// TODO(jsonp): Move activation generation code to internal package.
-	"github.com/google/go-tpm/legacy/tpm2/credactivation"
-	"github.com/google/go-tspi/verification"
+	"github.com/google/go-tpm/tpmutil"
+	"golang.org/x/crypto/hkdf"
 )
 
 const (
 	// minRSABits is the minimum accepted bit size of an RSA key.
 	minRSABits = 2048
-	// activationSecretLen is the size in bytes of the generated secret
+	// minECCBits is the minimum accepted bit size of an ECC key.
+	minECCBits = 256
+	// activationSecretLen is the default size in bytes of the secret
 	// which is generated for credential activation.
 	activationSecretLen = 32
-	// symBlockSize is the block size used for symmetric ciphers used
-	// when generating the credential activation challenge.
+	// minActivationSecretLen and maxActivationSecretLen bound the size in
+	// bytes of the activation secret. The upper bound is the size of the
+	// largest digest a TPM 2.0 credential can hold.
+	minActivationSecretLen = 16
+	maxActivationSecretLen = 64
+	// defaultMaxAKFieldSize is the default size in bytes above which the
+	// fields of AttestationParameters are rejected without being decoded.
+	// It is several times the size of the largest structures a TPM
+	// produces.
+	defaultMaxAKFieldSize = 16 << 10
+	// symBlockSize is the default block size used for symmetric ciphers
+	// used when generating the credential activation challenge.
 	symBlockSize = 16
 	// tpm20GeneratedMagic is a magic tag when can only be present on a
 	// TPM structure if the structure was generated wholly by the TPM.
 	tpm20GeneratedMagic = 0xff544347
+	// tpm12AlgRSA is TPM_ALG_RSA, as defined in section 4.8 of the TPM 1.2
+	// structures specification.
+	tpm12AlgRSA = 0x00000001
+)
+
+// Errors returned by CheckAKParameters and Generate, describing why an AK
+// was rejected. Callers can test for them with errors.Is; the returned
+// errors carry a more detailed message.
+var (
+	// ErrAKTooSmall is returned when the AK is smaller than the minimum
+	// accepted key size.
+	ErrAKTooSmall = errors.New("attestation key too small")
+	// ErrAKExportable is returned when the AK can leave the TPM.
+	ErrAKExportable = errors.New("AK is exportable")
+	// ErrAKNotRestricted is returned when the AK is not limited to
+	// signing structures produced by the TPM.
+	ErrAKNotRestricted = errors.New("AK is not restricted")
+	// ErrAKNotSigning is returned when the AK is not a signing key, or
+	// does not use one of the approved RSASSA, RSAPSS or ECDSA schemes.
+	ErrAKNotSigning = errors.New("AK is not a signing key")
+	// ErrAKNotTPMGenerated is returned when the creation attestation does
+	// not carry the magic value of a structure generated by the TPM.
+	ErrAKNotTPMGenerated = errors.New("AK creation attestation was not produced by a TPM")
+	// ErrAKNameMismatch is returned when the creation attestation refers
+	// to a different key or creation data than the one provided.
+	ErrAKNameMismatch = errors.New("AK creation attestation refers to a different key")
+	// ErrAKSignatureInvalid is returned when the signature over the creation
+	// attestation does not verify against the AK.
+	ErrAKSignatureInvalid = errors.New("AK creation signature is invalid")
+	// ErrAKCreationDataInvalid is returned when the creation data of the AK
+	// is not internally consistent, so could not have been produced by a
+	// TPM.
+	ErrAKCreationDataInvalid = errors.New("AK creation data is invalid")
+	// ErrAKNotInEndorsementHierarchy is returned when
+	// ActivationParameters.RequireEndorsementHierarchy is set, and the AK
+	// was not created in the endorsement hierarchy.
+	ErrAKNotInEndorsementHierarchy = errors.New("AK was not created in the endorsement hierarchy")
+	// ErrAKTemplateNotAllowed is returned when
+	// ActivationParameters.AllowedAKTemplates is set, and the AK was not
+	// created from one of the allowed templates.
+	ErrAKTemplateNotAllowed = errors.New("AK was not created from an allowed template")
+	// ErrTPM12NotBuilt is returned for TPM 1.2 keys and challenges when the
+	// package is built with the notpm12 build tag, which removes TPM 1.2
+	// support.
+	ErrTPM12NotBuilt = errors.New("TPM 1.2 support not built")
+	// ErrEKTooSmall is returned when the EK is smaller than the minimum
+	// accepted key size.
+	ErrEKTooSmall = errors.New("endorsement key too small")
+	// ErrAKFieldTooLarge is returned when a field of the AK's
+	// AttestationParameters is larger than the maximum accepted size, so
+	// is not decoded.
+	ErrAKFieldTooLarge = errors.New("AK attestation field too large")
 )
 
+// rejectionError is an error rejecting activation parameters, which matches
+// one of the ErrAK* or ErrEK* values using errors.Is.
+type rejectionError struct {
+	reason error
+	msg    string
+}
+
+func (e *rejectionError) Error() string { return e.msg }
+func (e *rejectionError) Unwrap() error { return e.reason }
+
+func rejectionErrorf(reason error, format string, args ...interface{}) error {
+	return &rejectionError{reason: reason, msg: fmt.Sprintf(format, args...)}
+}
+
 // ActivationParameters encapsulates the inputs for activating an AK.
 type ActivationParameters struct {
 	// TPMVersion holds the version of the TPM, either 1.2 or 2.0.
@@ -42,9 +127,16 @@ type ActivationParameters struct {
 	// Activation will verify that the provided EK is held on the same
 	// TPM as the AK. However, it is the caller's responsibility to
 	// ensure the EK they provide corresponds to the the device which
-	// they are trying to associate the AK with.
+	// they are trying to associate the AK with, for example by checking
+	// it against a verified EK certificate with MatchEKCertificate, or
+	// with VerifyEK, which also supports virtual TPMs.
 	EK crypto.PublicKey
 
+	// EKResolver, if set, is called by Generate to look up the EK when EK
+	// is nil, for example from a directory of enrolled devices. Errors it
+	// returns are wrapped in the error returned by Generate.
+	EKResolver func() (crypto.PublicKey, error)
+
 	// AK, the Attestation Key, describes the properties of
 	// an asymmetric key (managed by the TPM) which signs attestation
 	// structures.
@@ -53,138 +145,909 @@ type ActivationParameters struct {
 	AK AttestationParameters
 
 	// Rand is a source of randomness to generate a seed and secret for the
-	// challenge.
+	// challenge. All randomness is read from Rand, so a deterministic Rand
+	// yields byte-identical challenges, for example for golden tests. The
+	// exception is TPM 1.2 challenges using UseTCSDActivationFormat, which
+	// always read from crypto.Rand.
 	//
 	// If nil, this defaults to crypto.Rand.
 	Rand io.Reader
+
+	// SymmetricBlockSize is the size in bytes of the AES key used to
+	// protect the credential, which must match the symmetric parameters
+	// of the EK: 16 for AES-128, 24 for AES-192 or 32 for AES-256.
+	//
+	// If zero, this defaults to 16. Only used for TPM 2.0.
+	SymmetricBlockSize int
+
+	// EKTemplate describes the template of the EK, if it was not created
+	// from one of the default templates of the TCG EK Credential Profile
+	// (RSA 2048 or ECC P-256, with SHA-256 and AES-128). Its name
+	// algorithm and symmetric parameters are used to protect the
+	// credential, and SymmetricBlockSize must be zero or agree with it.
+	// The unique field of the template is ignored. Only used for TPM 2.0.
+	//
+	// The device must satisfy the policy of the EK to activate the
+	// credential. ActivateCredential supports EKs whose policy is
+	// TPM2_PolicySecret with the endorsement hierarchy, computed with the
+	// name algorithm of the EK.
+	EKTemplate *tpm2.Public
+
+	// MinEKBits is the minimum accepted size in bits of an RSA EK.
+	//
+	// If zero, this defaults to 2048. ECC EKs must use a curve of
+	// at least 256 bits.
+	MinEKBits int
+
+	// MinAKBits is the minimum accepted size in bits of an RSA AK, and
+	// MinAKECCBits that of the curve of an ECC AK. If zero, these default
+	// to 2048 and 256 respectively.
+	MinAKBits    int
+	MinAKECCBits int
+
+	// MaxAKFieldSize is the maximum accepted size in bytes of each of the
+	// Public, CreateData, CreateAttestation and CreateSignature fields of
+	// AK. Larger fields are rejected with ErrAKFieldTooLarge before they
+	// are decoded, so that clients can't exhaust the memory of a server
+	// with huge blobs.
+	//
+	// If zero, this defaults to 16 KiB.
+	MaxAKFieldSize int
+
+	// SecretLen is the size in bytes of the generated secret. It must be
+	// between 16 and 64 bytes. For TPM 2.0, secrets larger than 32 bytes
+	// require a TPM supporting a digest at least as large as the secret.
+	//
+	// If zero, this defaults to 32.
+	SecretLen int
+
+	// Logger, if set, receives a debug level event for each step of
+	// checking the AK, and for the step which failed, if any.
+	Logger *slog.Logger
+
+	// RequireEndorsementHierarchy rejects TPM 2.0 AKs whose creation data
+	// shows they were not created in the endorsement hierarchy, such as
+	// AKs created under the SRK in the owner hierarchy, with
+	// ErrAKNotInEndorsementHierarchy. The AK must be either a primary key
+	// of the endorsement hierarchy, or a child of the EK. The name of the
+	// EK is computed from EK and EKTemplate, or the default template for
+	// the type of EK.
+	//
+	// Activating the credential already proves the AK and EK reside on the
+	// same TPM; this is a policy some deployments enforce in addition.
+	RequireEndorsementHierarchy bool
+
+	// RequireLowS rejects ECDSA creation signatures of TPM 2.0 AKs in the
+	// high-S form, whose S value is greater than half the order of the
+	// curve, with ErrAKSignatureInvalid. TPMs don't normalize S, so about
+	// half of their signatures are in the high-S form. Such signatures are
+	// otherwise accepted, like their low-S counterparts, but are rejected
+	// by strict verifiers; setting this treats them as those verifiers
+	// would.
+	RequireLowS bool
+
+	// AllowedAKTemplates, if not empty, restricts TPM 2.0 AKs to those
+	// created from one of the given templates, as digests computed by
+	// AKTemplateDigest. AKs whose public area, other than the public key
+	// itself, does not match any of them are rejected with
+	// ErrAKTemplateNotAllowed. TPM 1.2 AKs are always rejected when set.
+	AllowedAKTemplates [][]byte
+
+	// ChallengeGenerator, if set, generates the challenge in place of the
+	// built-in TPM 1.2 and TPM 2.0 schemes, for TPMs which activate
+	// credentials differently. The AK and EK are checked as usual first.
+	ChallengeGenerator ChallengeGenerator
+
+	// AAD, if set, is additional authenticated data such as a session
+	// identifier, which binds the challenge to its context. The secret
+	// returned by Generate is then derived from the secret protected by
+	// the challenge with DeriveActivationSecret, so the device must apply
+	// DeriveActivationSecret with the same AAD to the secret returned by
+	// ActivateCredential for it to match.
+	AAD []byte
+
+	// RecipientKey, if set, is a public key to which Generate encrypts the
+	// secret, returning it in EncryptedCredential.SealedSecret in place of
+	// the plaintext secret, so that it can be stored or forwarded to the
+	// holder of the private key, which recovers it with OpenSealedSecret.
+	//
+	// RSA keys use RSA-OAEP with SHA-256. ECC keys, on the NIST curves,
+	// use an ephemeral ECDH key, from whose shared secret an AES-256-GCM
+	// key is derived with HKDF-SHA256; the sealed secret is the ephemeral
+	// public key as an uncompressed point, followed by the ciphertext.
+	RecipientKey crypto.PublicKey
+}
+
+// ChallengeGenerator generates the challenge of a credential activation,
+// protecting secret so it can only be recovered by the TPM holding the EK
+// and AK of p. p has been checked by the time GenerateChallenge is called.
+// rnd is the source of randomness to use.
+type ChallengeGenerator interface {
+	GenerateChallenge(rnd io.Reader, p *ActivationParameters, secret []byte) (*EncryptedCredential, error)
+}
+
+// logDebug emits a debug level event to p.Logger, if set.
+func (p *ActivationParameters) logDebug(msg string, args ...any) {
+	if p.Logger != nil {
+		p.Logger.Debug(msg, args...)
+	}
 }
 
-// checkAKParameters examines properties of an AK and a creation
+// CheckAKParameters examines properties of an AK and a creation
 // attestation, to determine if it is suitable for use as an attestation key.
-func (p *ActivationParameters) checkAKParameters() error {
+// It is called by Generate, and does not need to be called separately
+// before generating a challenge.
+//
+// ECDSA signatures of TPM 2.0 AKs are never deterministic in the sense of
+// RFC 6979, whatever the scheme of the key: the TPM draws the nonce of
+// each signature from its random number generator, so signing the same
+// data twice yields different signatures.
+func (p *ActivationParameters) CheckAKParameters() error {
 	switch p.TPMVersion {
 	case TPMVersion12:
 		return p.checkTPM12AKParameters()
 
 	case TPMVersion20:
-		return p.checkTPM20AKParameters()
+		_, err := p.checkTPM20AKParameters()
+		return err
 
 	default:
 		return fmt.Errorf("TPM version %d not supported", p.TPMVersion)
 	}
 }
 
-func (p *ActivationParameters) checkTPM12AKParameters() error {
-	// TODO(jsonp): Implement helper to parse public blobs, ie:
-	//   func ParsePublic(publicBlob []byte) (crypto.Public, error)
+// CheckedAKAttestation performs the same checks as CheckAKParameters and,
+// if they pass, returns the decoded creation attestation of a TPM 2.0 AK.
+// Fields such as FirmwareVersion and QualifiedSigner can then be used to
+// enforce policy on the device which created the AK.
+//
+// The firmware version and clock information are obfuscated by the TPM
+// for keys outside the endorsement and platform hierarchies, so they can
+// only be compared against values from the same TPM and hierarchy.
+func (p *ActivationParameters) CheckedAKAttestation() (*tpm2.AttestationData, error) {
+	if p.TPMVersion != TPMVersion20 {
+		return nil, fmt.Errorf("creation attestation is only available for TPM 2.0, got version %d", p.TPMVersion)
+	}
+	return p.checkTPM20AKParameters()
+}
 
-	pub, err := tpm1.UnmarshalPubRSAPublicKey(p.AK.Public)
-	if err != nil {
-		return fmt.Errorf("unmarshalling public key: %v", err)
+// decodeAttestationData decodes the creation attestation of an AK. It is
+// a variable so tests can check the attestation is only decoded once while
+// generating a challenge.
+var decodeAttestationData = tpm2.DecodeAttestationData
+
+// stripTPM2BAttest returns the TPMS_ATTEST structure held by b, removing
+// the size prefix of a TPM2B_ATTEST if present, as some client stacks send
+// the creation attestation in that framing. The TPM signs the TPMS_ATTEST
+// itself, so the signature is verified over the unwrapped structure.
+func stripTPM2BAttest(b []byte) []byte {
+	if len(b) >= 6 && binary.BigEndian.Uint32(b) != tpm20GeneratedMagic &&
+		int(binary.BigEndian.Uint16(b)) == len(b)-2 && binary.BigEndian.Uint32(b[2:]) == tpm20GeneratedMagic {
+		return b[2:]
 	}
-	if bits := pub.Size() * 8; bits < minRSABits {
-		return fmt.Errorf("attestation key too small: must be at least %d bits but was %d bits", minRSABits, bits)
+	return b
+}
+
+// akCheckFailed is called with each failed check of an AK, and reports
+// whether the remaining checks should be run.
+type akCheckFailed func(err error) bool
+
+// failFast returns an akCheckFailed which stores the first failure in err
+// and stops.
+func failFast(err *error) akCheckFailed {
+	return func(e error) bool {
+		*err = e
+		return false
 	}
-	return nil
 }
 
-func (p *ActivationParameters) checkTPM20AKParameters() error {
+// checkTPM20AKParameters checks the AK and returns its decoded creation
+// attestation.
+func (p *ActivationParameters) checkTPM20AKParameters() (att *tpm2.AttestationData, err error) {
+	defer func() {
+		if err != nil {
+			p.logDebug("AK check failed", "error", err)
+		}
+	}()
+	att = p.runTPM20AKChecks(failFast(&err))
+	if err != nil {
+		return nil, err
+	}
+	return att, nil
+}
+
+// runTPM20AKChecks runs the checks of a TPM 2.0 AK, calling fail for each
+// check which fails. Checks which depend on a failed check, such as those
+// needing a structure which could not be decoded, are skipped. The decoded
+// creation attestation is returned if it could be decoded.
+func (p *ActivationParameters) runTPM20AKChecks(fail akCheckFailed) *tpm2.AttestationData {
+	// Nothing is decoded from fields which are too large.
+	if err := p.checkAKFieldSizes(); err != nil {
+		fail(err)
+		return nil
+	}
 	if len(p.AK.CreateSignature) < 8 {
-		return fmt.Errorf("signature is too short to be valid: only %d bytes", len(p.AK.CreateSignature))
+		if !fail(rejectionErrorf(ErrAKSignatureInvalid, "signature is too short to be valid: only %d bytes", len(p.AK.CreateSignature))) {
+			return nil
+		}
 	}
 
 	pub, err := tpm2.DecodePublic(p.AK.Public)
+	havePub := err == nil
 	if err != nil {
-		return fmt.Errorf("DecodePublic() failed: %v", err)
+		if !fail(fmt.Errorf("DecodePublic() failed: %v", err)) {
+			return nil
+		}
+	} else {
+		p.logDebug("AK public area decoded", "type", pub.Type, "nameAlg", pub.NameAlg)
 	}
-	_, err = tpm2.DecodeCreationData(p.AK.CreateData)
+	creationData, err := tpm2.DecodeCreationData(p.AK.CreateData)
 	if err != nil {
-		return fmt.Errorf("DecodeCreationData() failed: %v", err)
+		creationData = nil
+		if !fail(fmt.Errorf("DecodeCreationData() failed: %v", err)) {
+			return nil
+		}
+	}
+	// Check the magic before decoding, as DecodeAttestationData also rejects
+	// structures that were not generated by a TPM.
+	var att *tpm2.AttestationData
+	createAttestation := stripTPM2BAttest(p.AK.CreateAttestation)
+	if len(createAttestation) >= 4 && binary.BigEndian.Uint32(createAttestation) != tpm20GeneratedMagic {
+		if !fail(rejectionErrorf(ErrAKNotTPMGenerated, "creation attestation was not produced by a TPM")) {
+			return nil
+		}
+	} else if att, err = decodeAttestationData(createAttestation); err != nil {
+		att = nil
+		if !fail(fmt.Errorf("DecodeAttestationData() failed: %v", err)) {
+			return nil
+		}
+	} else if att.Type != tpm2.TagAttestCreation {
+		typ := att.Type
+		att = nil
+		if !fail(fmt.Errorf("attestation does not apply to creation data, got tag %x", typ)) {
+			return nil
+		}
+	} else {
+		p.logDebug("AK creation attestation decoded")
+	}
+
+	// Make sure the AK has sane key parameters (Attestation can be faked if an AK
+	// can be used for arbitrary signatures).
+	// We verify the following:
+	// - Key is TPM backed.
+	// - Key is TPM generated.
+	// - Key is a restricted key (means it cannot do arbitrary signing/decrypt ops).
+	// - Key cannot be duplicated.
+	// - Key was generated by a call to TPM_Create*.
+	if att != nil && att.Magic != tpm20GeneratedMagic {
+		if !fail(rejectionErrorf(ErrAKNotTPMGenerated, "creation attestation was not produced by a TPM")) {
+			return nil
+		}
+	}
+	if havePub {
+		ok := true
+		cont := checkAKPublic20All(pub, p.minAKBits(), p.minAKECCBits(), func(err error) bool {
+			ok = false
+			return fail(err)
+		})
+		if !cont {
+			return nil
+		}
+		if ok {
+			p.logDebug("AK attributes checked")
+		}
+	}
+	if havePub && len(p.AllowedAKTemplates) > 0 {
+		if err := checkAKTemplate(pub, p.AllowedAKTemplates); err != nil {
+			if !fail(err) {
+				return nil
+			}
+		} else {
+			p.logDebug("AK template allowed")
+		}
+	}
+
+	// The name algorithm of the AK, which may differ from the hash of its
+	// signing scheme, is used for both the creation data digest and the
+	// name. The signing scheme's hash is only used to verify the signature
+	// below.
+	//
+	// Compute & verify that the creation data matches the digest in the
+	// attestation structure.
+	if havePub && att != nil {
+		nameHash, err := tpmHash(pub.NameAlg)
+		if err != nil {
+			if !fail(fmt.Errorf("HashConstructor() failed: %v", err)) {
+				return nil
+			}
+		} else {
+			h := nameHash.New()
+			h.Write(p.AK.CreateData)
+			if !bytes.Equal(att.AttestedCreationInfo.OpaqueDigest, h.Sum(nil)) {
+				if !fail(rejectionErrorf(ErrAKNameMismatch, "attestation refers to different public key")) {
+					return nil
+				}
+			}
+		}
+	}
+	if havePub && creationData != nil {
+		if err := checkCreationData20(creationData, pub); err != nil {
+			if !fail(err) {
+				return nil
+			}
+		} else {
+			p.logDebug("AK creation data verified")
+		}
+	}
+	if p.RequireEndorsementHierarchy && creationData != nil {
+		if err := p.checkEndorsementHierarchy20(creationData); err != nil {
+			if !fail(err) {
+				return nil
+			}
+		} else {
+			p.logDebug("AK endorsement hierarchy verified")
+		}
+	}
+
+	// Verify the attested creation name matches what is computed from
+	// the public key.
+	if havePub && att != nil {
+		match, err := VerifyKeyName(att.AttestedCreationInfo.Name, pub)
+		if err == nil && !match {
+			err = rejectionErrorf(ErrAKNameMismatch, "creation attestation refers to a different key")
+		}
+		if err != nil {
+			if !fail(err) {
+				return nil
+			}
+		} else {
+			p.logDebug("AK name verified")
+		}
+	}
+
+	// Check the signature over the attestation data verifies correctly,
+	// using the hash of the AK's signing scheme.
+	if havePub && len(p.AK.CreateSignature) >= 8 {
+		switch pub.Type {
+		case tpm2.AlgRSA:
+			err = verifyRSASignature(pub, createAttestation, p.AK.CreateSignature)
+		case tpm2.AlgECC:
+			err = verifyECDSASignature(pub, createAttestation, p.AK.CreateSignature, p.RequireLowS)
+		default:
+			err = fmt.Errorf("public key of alg 0x%x not supported", pub.Type)
+		}
+		if err != nil {
+			if !fail(err) {
+				return nil
+			}
+		} else {
+			p.logDebug("AK creation signature verified")
+		}
+	}
+	return att
+}
+
+// CheckAKParametersVerbose runs the checks of CheckAKParameters, but rather
+// than stopping at the first failure, returns every failure, for example
+// to explain to an operator all the reasons an AK was rejected. Checks
+// which depend on a failed check are skipped. It returns nil if the AK is
+// suitable for use as an attestation key.
+//
+// TPM 1.2 AKs are checked as by CheckAKParameters, so at most one failure
+// is returned for them. Security decisions should use CheckAKParameters or
+// Generate.
+func (p *ActivationParameters) CheckAKParametersVerbose() []error {
+	var errs []error
+	switch p.TPMVersion {
+	case TPMVersion12:
+		if err := p.checkTPM12AKParameters(); err != nil {
+			errs = append(errs, err)
+		}
+	case TPMVersion20:
+		p.runTPM20AKChecks(func(err error) bool {
+			errs = append(errs, err)
+			return true
+		})
+	default:
+		errs = append(errs, fmt.Errorf("TPM version %d not supported", p.TPMVersion))
+	}
+	return errs
+}
+
+// checkCreationData20 checks that creation data is internally consistent,
+// and consistent with the public area of the key it describes.
+//
+// The creation data is bound to the AK by the creation attestation, but
+// the AK is only shown to be held by the TPM once the credential has been
+// activated with the EK. Until then, the AK, its creation data and the
+// attestation may all have been crafted in software. This check doesn't
+// change that, but rejects creation data describing a creation which a
+// TPM could not have performed, such as one mixing digest algorithms.
+func checkCreationData20(cd *tpm2.CreationData, pub tpm2.Public) error {
+	// At least one locality is always set.
+	if cd.Locality == 0 {
+		return rejectionErrorf(ErrAKCreationDataInvalid, "creation data has no locality")
 	}
-	att, err := tpm2.DecodeAttestationData(p.AK.CreateAttestation)
+
+	// The PCR digest is computed with the name algorithm of the new key.
+	// If no PCRs were selected, it is the digest of no data.
+	nameHash, err := tpmHash(pub.NameAlg)
 	if err != nil {
-		return fmt.Errorf("DecodeAttestationData() failed: %v", err)
+		return fmt.Errorf("HashConstructor() failed: %v", err)
 	}
-	if att.Type != tpm2.TagAttestCreation {
-		return fmt.Errorf("attestation does not apply to creation data, got tag %x", att.Type)
+	if len(cd.PCRDigest) != nameHash.Size() {
+		return rejectionErrorf(ErrAKCreationDataInvalid, "creation data PCR digest has %d bytes, want %d for name algorithm %v", len(cd.PCRDigest), nameHash.Size(), pub.NameAlg)
+	}
+	if len(cd.PCRSelection.PCRs) == 0 && !bytes.Equal(cd.PCRDigest, nameHash.New().Sum(nil)) {
+		return rejectionErrorf(ErrAKCreationDataInvalid, "creation data PCR digest does not match the empty PCR selection")
 	}
 
-	// TODO: Support ECC AKs.
-	switch pub.Type {
+	// Keys created under a hierarchy have a handle as the name of their
+	// parent. Otherwise, the name and qualified name of the parent are
+	// digests computed with its name algorithm.
+	if cd.ParentName.Handle != nil {
+		if cd.ParentNameAlg != tpm2.AlgNull {
+			return rejectionErrorf(ErrAKCreationDataInvalid, "creation data parent name algorithm is %v, but parent is a hierarchy", cd.ParentNameAlg)
+		}
+		return nil
+	}
+	for _, name := range []tpm2.Name{cd.ParentName, cd.ParentQualifiedName} {
+		if name.Digest == nil {
+			return rejectionErrorf(ErrAKCreationDataInvalid, "creation data parent name has no digest")
+		}
+		if name.Digest.Alg != cd.ParentNameAlg {
+			return rejectionErrorf(ErrAKCreationDataInvalid, "creation data parent name uses %v, but parent name algorithm is %v", name.Digest.Alg, cd.ParentNameAlg)
+		}
+	}
+	return nil
+}
+
+// AKTemplateDigest returns the SHA-256 digest of a TPM 2.0 key template,
+// for use in ActivationParameters.AllowedAKTemplates. The unique field,
+// which holds the public key of created keys, is cleared before hashing,
+// so the public area of a key created from the template has the same
+// digest as the template itself.
+func AKTemplateDigest(tmpl tpm2.Public) ([]byte, error) {
+	switch tmpl.Type {
 	case tpm2.AlgRSA:
-		if pub.RSAParameters.KeyBits < minRSABits {
-			return fmt.Errorf("attestation key too small: must be at least %d bits but was %d bits", minRSABits, pub.RSAParameters.KeyBits)
+		if tmpl.RSAParameters == nil {
+			return nil, errors.New("RSA template has no RSA parameters")
 		}
+		params := *tmpl.RSAParameters
+		params.ModulusRaw = nil
+		tmpl.RSAParameters = &params
+	case tpm2.AlgECC:
+		if tmpl.ECCParameters == nil {
+			return nil, errors.New("ECC template has no ECC parameters")
+		}
+		params := *tmpl.ECCParameters
+		params.Point = tpm2.ECPoint{}
+		tmpl.ECCParameters = &params
 	default:
-		return fmt.Errorf("public key of alg 0x%x not supported", pub.Type)
+		return nil, fmt.Errorf("unsupported template type 0x%x", tmpl.Type)
 	}
+	enc, err := tmpl.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("encoding template: %v", err)
+	}
+	digest := sha256.Sum256(enc)
+	return digest[:], nil
+}
 
-	// Compute & verify that the creation data matches the digest in the
-	// attestation structure.
-	nameHash, err := pub.NameAlg.Hash()
+// checkAKTemplate checks that pub was created from one of the templates
+// with the given digests.
+func checkAKTemplate(pub tpm2.Public, allowed [][]byte) error {
+	digest, err := AKTemplateDigest(pub)
 	if err != nil {
-		return fmt.Errorf("HashConstructor() failed: %v", err)
+		return err
+	}
+	for _, d := range allowed {
+		if bytes.Equal(d, digest) {
+			return nil
+		}
+	}
+	return rejectionErrorf(ErrAKTemplateNotAllowed, "AK template digest %x is not allowed", digest)
+}
+
+// checkEndorsementHierarchy20 checks that creation data describes a key
+// created in the endorsement hierarchy: either a primary key of the
+// hierarchy, or a child of the EK.
+func (p *ActivationParameters) checkEndorsementHierarchy20(cd *tpm2.CreationData) error {
+	if h := cd.ParentName.Handle; h != nil {
+		if *h != tpm2.HandleEndorsement {
+			return rejectionErrorf(ErrAKNotInEndorsementHierarchy, "AK is a primary key of hierarchy 0x%x, not the endorsement hierarchy", *h)
+		}
+		return nil
+	}
+
+	ekPub, err := p.ekPublic20()
+	if err != nil {
+		return err
+	}
+	ekName, err := ekPub.Name()
+	if err != nil {
+		return fmt.Errorf("computing EK name: %v", err)
+	}
+	if d := cd.ParentName.Digest; d == nil || d.Alg != ekName.Digest.Alg || !bytes.Equal(d.Value, ekName.Digest.Value) {
+		return rejectionErrorf(ErrAKNotInEndorsementHierarchy, "AK parent is neither the endorsement hierarchy nor the EK")
+	}
+
+	// The qualified name of a primary key is the digest of the handle of
+	// its hierarchy followed by its name.
+	nameHash, err := tpmHash(ekPub.NameAlg)
+	if err != nil {
+		return err
+	}
+	encName, err := ekName.Digest.Encode()
+	if err != nil {
+		return fmt.Errorf("encoding EK name: %v", err)
 	}
 	h := nameHash.New()
-	h.Write(p.AK.CreateData)
-	if !bytes.Equal(att.AttestedCreationInfo.OpaqueDigest, h.Sum(nil)) {
-		return errors.New("attestation refers to different public key")
+	binary.Write(h, binary.BigEndian, uint32(tpm2.HandleEndorsement))
+	h.Write(encName)
+	if d := cd.ParentQualifiedName.Digest; d == nil || d.Alg != ekPub.NameAlg || !bytes.Equal(d.Value, h.Sum(nil)) {
+		return rejectionErrorf(ErrAKNotInEndorsementHierarchy, "AK parent qualified name is not that of an EK in the endorsement hierarchy")
 	}
+	return nil
+}
 
-	// Make sure the AK has sane key parameters (Attestation can be faked if an AK
-	// can be used for arbitrary signatures).
-	// We verify the following:
-	// - Key is TPM backed.
-	// - Key is TPM generated.
-	// - Key is a restricted key (means it cannot do arbitrary signing/decrypt ops).
-	// - Key cannot be duplicated.
-	// - Key was generated by a call to TPM_Create*.
-	if att.Magic != tpm20GeneratedMagic {
-		return errors.New("creation attestation was not produced by a TPM")
+// ekPublic20 returns the public area of the EK, built from EKTemplate or
+// the default template for its type.
+func (p *ActivationParameters) ekPublic20() (tpm2.Public, error) {
+	var pub tpm2.Public
+	switch ek := p.EK.(type) {
+	case nil:
+		return pub, errors.New("no EK provided")
+	case *rsa.PublicKey:
+		pub = defaultRSAEKTemplate
+		if p.EKTemplate != nil {
+			pub = *p.EKTemplate
+		}
+		if pub.Type != tpm2.AlgRSA || pub.RSAParameters == nil {
+			return pub, fmt.Errorf("EK template of type 0x%x does not match RSA EK", pub.Type)
+		}
+		params := *pub.RSAParameters
+		params.ModulusRaw = ek.N.Bytes()
+		params.ExponentRaw = 0
+		if ek.E != 65537 {
+			params.ExponentRaw = uint32(ek.E)
+		}
+		pub.RSAParameters = &params
+	case *ecdsa.PublicKey:
+		ecdhEK, err := ek.ECDH()
+		if err != nil {
+			return pub, fmt.Errorf("converting EK to ECDH key: %v", err)
+		}
+		return (&ActivationParameters{EK: ecdhEK, EKTemplate: p.EKTemplate}).ekPublic20()
+	case *ecdh.PublicKey:
+		pub = defaultECCEKTemplate
+		if p.EKTemplate != nil {
+			pub = *p.EKTemplate
+		}
+		if pub.Type != tpm2.AlgECC || pub.ECCParameters == nil {
+			return pub, fmt.Errorf("EK template of type 0x%x does not match ECC EK", pub.Type)
+		}
+		x, y, err := ecdhCoordinates(ek)
+		if err != nil {
+			return pub, err
+		}
+		params := *pub.ECCParameters
+		params.Point = tpm2.ECPoint{XRaw: x, YRaw: y}
+		pub.ECCParameters = &params
+	default:
+		return pub, fmt.Errorf("unsupported EK type %T", p.EK)
 	}
+	return pub, nil
+}
+
+// eccCurveBits maps TPM 2.0 ECC curves to their size in bits.
+var eccCurveBits = map[tpm2.EllipticCurve]int{
+	tpm2.CurveNISTP192: 192,
+	tpm2.CurveNISTP224: 224,
+	tpm2.CurveNISTP256: 256,
+	tpm2.CurveNISTP384: 384,
+	tpm2.CurveNISTP521: 521,
+	tpm2.CurveBNP256:   256,
+	tpm2.CurveBNP638:   638,
+	tpm2.CurveSM2P256:  256,
+}
+
+// minAKBits returns the minimum size of an RSA AK.
+func (p *ActivationParameters) minAKBits() int {
+	if p.MinAKBits == 0 {
+		return minRSABits
+	}
+	return p.MinAKBits
+}
+
+// minAKECCBits returns the minimum curve size of an ECC AK.
+func (p *ActivationParameters) minAKECCBits() int {
+	if p.MinAKECCBits == 0 {
+		return minECCBits
+	}
+	return p.MinAKECCBits
+}
+
+// checkAKFieldSizes checks that the fields of p.AK are no larger than the
+// maximum accepted size.
+func (p *ActivationParameters) checkAKFieldSizes() error {
+	max := p.MaxAKFieldSize
+	if max == 0 {
+		max = defaultMaxAKFieldSize
+	}
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{"Public", p.AK.Public},
+		{"CreateData", p.AK.CreateData},
+		{"CreateAttestation", p.AK.CreateAttestation},
+		{"CreateSignature", p.AK.CreateSignature},
+	} {
+		if len(f.data) > max {
+			return rejectionErrorf(ErrAKFieldTooLarge, "AK %s field too large: %d bytes, maximum is %d", f.name, len(f.data), max)
+		}
+	}
+	return nil
+}
+
+// checkAKPublic20 checks that a TPM 2.0 public area describes a key which is
+// suitable for use as an AK, and is at least rsaBits or eccBits in size.
+func checkAKPublic20(pub tpm2.Public, rsaBits, eccBits int) (err error) {
+	checkAKPublic20All(pub, rsaBits, eccBits, failFast(&err))
+	return err
+}
+
+// checkAKPublic20All runs the checks of checkAKPublic20, calling fail for
+// each check which fails. It reports false if fail stopped the checks.
+func checkAKPublic20All(pub tpm2.Public, rsaBits, eccBits int, fail akCheckFailed) bool {
 	if (pub.Attributes & tpm2.FlagFixedTPM) == 0 {
-		return errors.New("AK is exportable")
+		if !fail(rejectionErrorf(ErrAKExportable, "AK is exportable")) {
+			return false
+		}
 	}
 	if ((pub.Attributes & tpm2.FlagRestricted) == 0) || ((pub.Attributes & tpm2.FlagFixedParent) == 0) || ((pub.Attributes & tpm2.FlagSensitiveDataOrigin) == 0) {
-		return errors.New("provided key is not limited to attestation")
+		if !fail(rejectionErrorf(ErrAKNotRestricted, "provided key is not limited to attestation")) {
+			return false
+		}
+	}
+	// A restricted decryption key, such as a storage key, would pass the
+	// checks above.
+	if (pub.Attributes&tpm2.FlagSign) == 0 || (pub.Attributes&tpm2.FlagDecrypt) != 0 {
+		if !fail(rejectionErrorf(ErrAKNotSigning, "provided key is not a signing key")) {
+			return false
+		}
 	}
 
-	// Verify the attested creation name matches what is computed from
-	// the public key.
-	match, err := att.AttestedCreationInfo.Name.MatchesPublic(pub)
+	var schemeErr, sizeErr error
+	switch pub.Type {
+	case tpm2.AlgRSA:
+		if s := pub.RSAParameters.Sign; s == nil || (s.Alg != tpm2.AlgRSASSA && s.Alg != tpm2.AlgRSAPSS) {
+			schemeErr = rejectionErrorf(ErrAKNotSigning, "AK does not use an approved RSA signing scheme")
+		}
+		if int(pub.RSAParameters.KeyBits) < rsaBits {
+			sizeErr = rejectionErrorf(ErrAKTooSmall, "attestation key too small: must be at least %d bits but was %d bits", rsaBits, pub.RSAParameters.KeyBits)
+		}
+	case tpm2.AlgECC:
+		if s := pub.ECCParameters.Sign; s == nil || s.Alg != tpm2.AlgECDSA {
+			schemeErr = rejectionErrorf(ErrAKNotSigning, "AK does not use an approved ECC signing scheme")
+		}
+		// The size of the point's coordinates depends on how they were
+		// encoded, so use the size of the curve instead.
+		if bits, ok := eccCurveBits[pub.ECCParameters.CurveID]; !ok {
+			sizeErr = fmt.Errorf("unsupported ECC curve 0x%x", pub.ECCParameters.CurveID)
+		} else if bits < eccBits {
+			sizeErr = rejectionErrorf(ErrAKTooSmall, "attestation key too small: must be at least %d bits but was %d bits", eccBits, bits)
+		}
+	default:
+		sizeErr = fmt.Errorf("public key of alg 0x%x not supported", pub.Type)
+	}
+	for _, err := range []error{schemeErr, sizeErr} {
+		if err != nil && !fail(err) {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyKeyName reports whether a TPM 2.0 name, such as one reported in an
+// attestation, is the name of the public area pub. Unlike
+// tpm2.Name.MatchesPublic, the name must also be computed with the public
+// area's name algorithm, which may be any of SHA-1, SHA-256, SHA-384 or
+// SHA-512.
+//
+// An error is returned if name has no digest, or the digest cannot be
+// computed.
+func VerifyKeyName(name tpm2.Name, pub tpm2.Public) (bool, error) {
+	if name.Digest == nil {
+		return false, errors.New("name does not have a digest")
+	}
+	if name.Digest.Alg != pub.NameAlg {
+		return false, nil
+	}
+	return name.MatchesPublic(pub)
+}
+
+// AKFingerprint returns the name of a TPM 2.0 AK: the identifier of its
+// name algorithm followed by the digest of its public area. This is the
+// value the TPM reports in attestations, such as the creation name in
+// CreateAttestation, and is stable however the public area is transported.
+func AKFingerprint(params AttestationParameters) ([]byte, error) {
+	pub, err := tpm2.DecodePublic(params.Public)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("DecodePublic() failed: %v", err)
 	}
-	if !match {
-		return errors.New("creation attestation refers to a different key")
+	name, err := pub.Name()
+	if err != nil {
+		return nil, fmt.Errorf("computing name: %v", err)
 	}
+	return name.Digest.Encode()
+}
 
-	// Check the signature over the attestation data verifies correctly.
+func verifyRSASignature(pub tpm2.Public, data, sig []byte) error {
 	pk := rsa.PublicKey{E: int(pub.RSAParameters.Exponent()), N: pub.RSAParameters.Modulus()}
-	signHash, err := pub.RSAParameters.Sign.Hash.Hash()
+	scheme := pub.RSAParameters.Sign
+	if scheme == nil {
+		return errors.New("RSA key has no signing scheme")
+	}
+	signHash, err := tpmHash(scheme.Hash)
 	if err != nil {
 		return err
 	}
 	hsh := signHash.New()
-	hsh.Write(p.AK.CreateAttestation)
-	verifyHash, err := pub.RSAParameters.Sign.Hash.Hash()
+	hsh.Write(data)
+
+	if len(sig) < 8 {
+		return rejectionErrorf(ErrAKSignatureInvalid, "signature invalid: length of %d is shorter than 8", len(sig))
+	}
+
+	decodedSig, err := tpm2.DecodeSignature(bytes.NewBuffer(sig))
+	if err != nil {
+		return fmt.Errorf("DecodeSignature() failed: %v", err)
+	}
+	if decodedSig.RSA == nil {
+		return fmt.Errorf("expected RSA signature, got alg 0x%x", decodedSig.Alg)
+	}
+	if decodedSig.Alg != scheme.Alg {
+		return rejectionErrorf(ErrAKSignatureInvalid, "signature scheme 0x%x does not match the key's scheme 0x%x", decodedSig.Alg, scheme.Alg)
+	}
+
+	// The TPM signs with the scheme of the key, so AKs created with an
+	// RSAPSS scheme produce PSS signatures.
+	if scheme.Alg == tpm2.AlgRSAPSS {
+		err = rsa.VerifyPSS(&pk, signHash, hsh.Sum(nil), decodedSig.RSA.Signature, nil)
+	} else {
+		err = rsa.VerifyPKCS1v15(&pk, signHash, hsh.Sum(nil), decodedSig.RSA.Signature)
+	}
+	if err != nil {
+		return rejectionErrorf(ErrAKSignatureInvalid, "could not verify attestation: %v", err)
+	}
+	return nil
+}
+
+func verifyECDSASignature(pub tpm2.Public, data, sig []byte, requireLowS bool) error {
+	key, err := pub.Key()
+	if err != nil {
+		return fmt.Errorf("parsing public key: %v", err)
+	}
+	pk, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("expected *ecdsa.PublicKey, got %T", key)
+	}
+	// Reject points which are not on the curve, rather than relying on
+	// signature verification to fail.
+	if _, err := pk.ECDH(); err != nil {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	signHash, err := tpmHash(pub.ECCParameters.Sign.Hash)
 	if err != nil {
 		return err
 	}
+	hsh := signHash.New()
+	hsh.Write(data)
 
-	if len(p.AK.CreateSignature) < 8 {
-		return fmt.Errorf("signature invalid: length of %d is shorter than 8", len(p.AK.CreateSignature))
+	if len(sig) < 8 {
+		return rejectionErrorf(ErrAKSignatureInvalid, "signature invalid: length of %d is shorter than 8", len(sig))
 	}
 
-	sig, err := tpm2.DecodeSignature(bytes.NewBuffer(p.AK.CreateSignature))
+	decodedSig, err := tpm2.DecodeSignature(bytes.NewBuffer(sig))
 	if err != nil {
 		return fmt.Errorf("DecodeSignature() failed: %v", err)
 	}
+	if decodedSig.ECC == nil {
+		return fmt.Errorf("expected ECC signature, got alg 0x%x", decodedSig.Alg)
+	}
 
-	if err := rsa.VerifyPKCS1v15(&pk, verifyHash, hsh.Sum(nil), sig.RSA.Signature); err != nil {
-		return fmt.Errorf("could not verify attestation: %v", err)
+	if requireLowS && isHighS(pk.Curve, decodedSig.ECC.S) {
+		return rejectionErrorf(ErrAKSignatureInvalid, "could not verify attestation: ECDSA signature is not in low-S form")
 	}
+	if !ecdsa.Verify(pk, hsh.Sum(nil), decodedSig.ECC.R, decodedSig.ECC.S) {
+		return rejectionErrorf(ErrAKSignatureInvalid, "could not verify attestation: ECDSA verification failure")
+	}
+	return nil
+}
 
+// isHighS reports whether the S value s of an ECDSA signature is in the
+// high-S form, greater than half the order of curve. Negating S modulo the
+// order yields another valid signature of the same message, in the low-S
+// form.
+func isHighS(curve elliptic.Curve, s *big.Int) bool {
+	return s.Cmp(new(big.Int).Rsh(curve.Params().N, 1)) > 0
+}
+
+// checkEKParameters verifies the EK is large enough to protect the
+// activation challenge.
+func (p *ActivationParameters) checkEKParameters() error {
+	switch ek := p.EK.(type) {
+	case *rsa.PublicKey:
+		minBits := p.MinEKBits
+		if minBits == 0 {
+			minBits = minRSABits
+		}
+		if bits := ek.Size() * 8; bits < minBits {
+			return rejectionErrorf(ErrEKTooSmall, "endorsement key too small: must be at least %d bits but was %d bits", minBits, bits)
+		}
+	case *ecdsa.PublicKey:
+		if bits := ek.Curve.Params().BitSize; bits < minECCBits {
+			return rejectionErrorf(ErrEKTooSmall, "endorsement key too small: must be at least %d bits but was %d bits", minECCBits, bits)
+		}
+	}
+	return nil
+}
+
+// EKAlgorithm returns the algorithm of the EK ek, either RSA for RSA keys
+// or ECDSA for ECC keys, which may be given as *ecdsa.PublicKey or as
+// *ecdh.PublicKey on a NIST curve. Other keys are not supported as EKs.
+func EKAlgorithm(ek crypto.PublicKey) (Algorithm, error) {
+	switch pub := ek.(type) {
+	case *rsa.PublicKey:
+		return RSA, nil
+	case *ecdsa.PublicKey:
+		return ECDSA, nil
+	case *ecdh.PublicKey:
+		switch pub.Curve() {
+		case ecdh.P256(), ecdh.P384(), ecdh.P521():
+			return ECDSA, nil
+		}
+		return "", fmt.Errorf("unsupported EK curve %v", pub.Curve())
+	case nil:
+		return "", errors.New("no EK provided")
+	default:
+		return "", fmt.Errorf("unsupported EK type %T", ek)
+	}
+}
+
+// MatchEKCertificate checks that ek is the public key certified by cert,
+// such as an EK certificate returned by TPM.EKCertificates. Once cert has
+// been verified against the TPM manufacturer's roots, this binds an
+// ActivationParameters.EK to that certificate.
+//
+// RSA keys must have the same modulus and exponent, and ECC keys must be
+// the same point on the same curve.
+func MatchEKCertificate(ek crypto.PublicKey, cert *x509.Certificate) error {
+	if cert == nil {
+		return errors.New("no EK certificate provided")
+	}
+	switch pub := ek.(type) {
+	case *rsa.PublicKey:
+		certPub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("EK is an RSA key, but the certificate holds a %T", cert.PublicKey)
+		}
+		if pub.E != certPub.E || pub.N.Cmp(certPub.N) != 0 {
+			return errors.New("RSA EK does not match the certificate's public key")
+		}
+	case *ecdsa.PublicKey:
+		certPub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("EK is an ECC key, but the certificate holds a %T", cert.PublicKey)
+		}
+		if pub.Curve != certPub.Curve || pub.X.Cmp(certPub.X) != 0 || pub.Y.Cmp(certPub.Y) != 0 {
+			return errors.New("ECC EK does not match the certificate's public key")
+		}
+	default:
+		return fmt.Errorf("unsupported EK type %T", ek)
+	}
 	return nil
 }
 
@@ -193,31 +1056,83 @@ func (p *ActivationParameters) checkTPM20AKParameters() error {
 // is present on the same TPM as the EK.
 //
 // The caller is expected to verify the secret returned from the TPM as
-// as result of calling ActivateCredential() matches the secret returned here.
-// The caller should use subtle.ConstantTimeCompare to avoid potential
-// timing attack vectors.
+// as result of calling ActivateCredential() matches the secret returned here,
+// using VerifySecret to avoid potential timing attack vectors. If
+// RecipientKey is set, the secret is returned sealed in ec.SealedSecret,
+// and the returned secret is nil.
 func (p *ActivationParameters) Generate() (secret []byte, ec *EncryptedCredential, err error) {
-	if err := p.checkAKParameters(); err != nil {
+	if p.EK == nil && p.EKResolver != nil {
+		ek, err := p.EKResolver()
+		if err != nil {
+			return nil, nil, fmt.Errorf("resolving EK: %w", err)
+		}
+		if ek == nil {
+			return nil, nil, errors.New("EK resolver returned no EK")
+		}
+		// Leave p unchanged, so the EK is resolved on every call.
+		resolved := *p
+		resolved.EK = ek
+		p = &resolved
+	}
+	return p.generate(nil, nil)
+}
+
+// generate implements Generate. ek holds the EK dependent state of the
+// challenge if it was prepared in advance by prepareEK, or is nil to
+// prepare it from p.EK. scratch, if not nil, is a pool of
+// *credentialScratch to generate TPM 2.0 challenges with.
+func (p *ActivationParameters) generate(ek *preparedEK, scratch *sync.Pool) (secret []byte, ec *EncryptedCredential, err error) {
+	// The creation attestation of a TPM 2.0 AK is decoded once, while
+	// checking the AK, and reused to generate the challenge.
+	var att *tpm2.AttestationData
+	if p.TPMVersion == TPMVersion20 {
+		att, err = p.checkTPM20AKParameters()
+	} else {
+		err = p.CheckAKParameters()
+	}
+	if err != nil {
 		return nil, nil, err
 	}
 
-	if p.EK == nil {
-		return nil, nil, errors.New("no EK provided")
+	if ek == nil {
+		if ek, err = p.prepareEK(); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	rnd, secret := p.Rand, make([]byte, activationSecretLen)
+	secretLen := p.SecretLen
+	if secretLen == 0 {
+		secretLen = activationSecretLen
+	}
+	rnd := p.Rand
 	if rnd == nil {
 		rnd = rand.Reader
 	}
-	if _, err = io.ReadFull(rnd, secret); err != nil {
-		return nil, nil, fmt.Errorf("error generating activation secret: %v", err)
+	// The challenge protects raw, from which the returned secret is
+	// derived when AAD is set.
+	raw, err := generateActivationSecret(rnd, secretLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	if secret, err = DeriveActivationSecret(raw, p.AAD); err != nil {
+		return nil, nil, err
+	}
+
+	if p.ChallengeGenerator != nil {
+		if ec, err = p.ChallengeGenerator.GenerateChallenge(rnd, p, raw); err != nil {
+			return nil, nil, fmt.Errorf("custom challenge generation failed: %w", err)
+		}
+		if ec == nil {
+			return nil, nil, errors.New("custom challenge generator returned no credential")
+		}
+		return p.sealSecret(rnd, secret, ec)
 	}
 
 	switch p.TPMVersion {
 	case TPMVersion12:
-		ec, err = p.generateChallengeTPM12(rnd, secret)
+		ec, err = p.generateChallengeTPM12(rnd, raw)
 	case TPMVersion20:
-		ec, err = p.generateChallengeTPM20(secret)
+		ec, err = p.generateChallengeTPM20(rnd, att, ek, scratch, raw)
 	default:
 		return nil, nil, fmt.Errorf("unrecognised TPM version: %v", p.TPMVersion)
 	}
@@ -225,52 +1140,560 @@ func (p *ActivationParameters) Generate() (secret []byte, ec *EncryptedCredentia
 	if err != nil {
 		return nil, nil, err
 	}
-	return secret, ec, nil
+	// Catch malformed EKs, which would otherwise only be noticed when the
+	// TPM fails to activate the credential.
+	if p.TPMVersion == TPMVersion20 && len(ec.Secret) != ek.secretSize {
+		return nil, nil, fmt.Errorf("generated encrypted secret is %d bytes, want %d bytes for the EK", len(ec.Secret), ek.secretSize)
+	}
+	return p.sealSecret(rnd, secret, ec)
+}
+
+// preparedEK is the state of generating TPM 2.0 challenges which depends
+// only on the EK, so can be shared by the challenges of many AKs.
+type preparedEK struct {
+	// pub is the EK, converted to an *ecdh.PublicKey for ECC EKs.
+	pub crypto.PublicKey
+	// x is the X coordinate of an ECC EK, which is hashed into every seed.
+	x          []byte
+	nameAlg    tpm2.Algorithm
+	blockSize  int
+	secretSize int
 }
 
-func (p *ActivationParameters) generateChallengeTPM20(secret []byte) (*EncryptedCredential, error) {
-	att, err := tpm2.DecodeAttestationData(p.AK.CreateAttestation)
+// newPreparedEK prepares the state of encrypting credentials to ek, whose
+// name algorithm is nameAlg and whose symmetric cipher uses keys of
+// blockSize bytes.
+func newPreparedEK(ek crypto.PublicKey, nameAlg tpm2.Algorithm, blockSize int) (*preparedEK, error) {
+	prepared := &preparedEK{pub: ek, nameAlg: nameAlg, blockSize: blockSize}
+	switch pub := ek.(type) {
+	case *rsa.PublicKey:
+		return prepared, nil
+	case *ecdsa.PublicKey:
+		ecdhPub, err := pub.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("converting EK to ECDH key: %v", err)
+		}
+		prepared.pub = ecdhPub
+	case *ecdh.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported EK type %T", ek)
+	}
+	x, _, err := ecdhCoordinates(prepared.pub.(*ecdh.PublicKey))
 	if err != nil {
-		return nil, fmt.Errorf("DecodeAttestationData() failed: %v", err)
+		return nil, err
+	}
+	prepared.x = x
+	return prepared, nil
+}
+
+// prepareEK checks p.EK and prepares the state of generating challenges
+// for it. The TPM 2.0 state is only prepared if the built-in scheme is
+// used.
+func (p *ActivationParameters) prepareEK() (*preparedEK, error) {
+	if p.EK == nil {
+		return nil, errors.New("no EK provided")
+	}
+	if err := p.checkEKParameters(); err != nil {
+		return nil, err
+	}
+	if p.TPMVersion != TPMVersion20 || p.ChallengeGenerator != nil {
+		return &preparedEK{pub: p.EK}, nil
+	}
+
+	nameAlg, blockSize, err := p.ekProtection()
+	if err != nil {
+		return nil, err
+	}
+	ek, err := newPreparedEK(p.EK, nameAlg, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	if ek.secretSize, err = p.EncryptedSecretSize(); err != nil {
+		return nil, err
+	}
+	return ek, nil
+}
+
+// sealSecret returns the results of Generate, sealing secret in ec if
+// p.RecipientKey is set.
+func (p *ActivationParameters) sealSecret(rnd io.Reader, secret []byte, ec *EncryptedCredential) ([]byte, *EncryptedCredential, error) {
+	if p.RecipientKey == nil {
+		return secret, ec, nil
+	}
+	sealed, err := sealSecret(rnd, p.RecipientKey, secret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sealing secret: %v", err)
+	}
+	ec.SealedSecret = sealed
+	return nil, ec, nil
+}
+
+// EncryptedSecretSize returns the size in bytes of the Secret of an
+// EncryptedCredential generated for p.EK, so buffers which transport
+// challenges can be sized in advance. It is the size of a
+// TPM2B_ENCRYPTED_SECRET: 2 bytes followed by the RSA modulus of the EK, or
+// by an ECC point whose coordinates are each preceded by 2 bytes.
+//
+// Only TPM 2.0 is supported. TPM 1.2 challenges encrypt to the EK in
+// Credential instead, and their Secret grows with the activation secret.
+func (p *ActivationParameters) EncryptedSecretSize() (int, error) {
+	if p.TPMVersion != TPMVersion20 {
+		return 0, fmt.Errorf("encrypted secret size is only defined for TPM 2.0, not %v", p.TPMVersion)
+	}
+	switch ek := p.EK.(type) {
+	case *rsa.PublicKey:
+		return 2 + ek.Size(), nil
+	case *ecdsa.PublicKey:
+		return 2 + 2*(2+(ek.Curve.Params().BitSize+7)/8), nil
+	case *ecdh.PublicKey:
+		// An uncompressed point: a 0x04 byte followed by both coordinates.
+		return 2 + 2*2 + len(ek.Bytes()) - 1, nil
+	default:
+		return 0, fmt.Errorf("unsupported EK type %T", p.EK)
+	}
+}
+
+// generateActivationSecret reads a secret of n bytes from rnd.
+func generateActivationSecret(rnd io.Reader, n int) ([]byte, error) {
+	if n < minActivationSecretLen || n > maxActivationSecretLen {
+		return nil, fmt.Errorf("unsupported activation secret length %d: must be between %d and %d bytes", n, minActivationSecretLen, maxActivationSecretLen)
+	}
+	secret := make([]byte, n)
+	if got, err := io.ReadFull(rnd, secret); err != nil {
+		return nil, fmt.Errorf("error generating activation secret: random source returned %d of %d bytes: %v", got, n, err)
+	}
+	return secret, nil
+}
+
+// ErrActivationSecretMismatch is returned by Enroll when the device returns
+// a secret other than the one protected by the challenge.
+var ErrActivationSecretMismatch = errors.New("activation secret does not match")
+
+// Enroll runs a credential activation round trip, proving that the AK of p
+// is held by the same TPM as the EK. It checks the AK and generates a
+// challenge as Generate does, then passes the challenge to activate, which
+// has the device activate the credential, for example by sending it to a
+// device calling AK.ActivateCredential, and returns the secret the device
+// recovered. The secret is compared with VerifySecret, and
+// ErrActivationSecretMismatch is returned if it doesn't match. Errors
+// returned by activate are wrapped in the returned error.
+//
+// If AAD is set, the device must derive its secret with
+// DeriveActivationSecret. RecipientKey must not be set, as the secret
+// can't be compared once sealed.
+func (p *ActivationParameters) Enroll(activate func(ec EncryptedCredential) ([]byte, error)) error {
+	if p.RecipientKey != nil {
+		return errors.New("sealing the secret to a RecipientKey is not supported when enrolling")
+	}
+	secret, ec, err := p.Generate()
+	if err != nil {
+		return err
+	}
+	got, err := activate(*ec)
+	if err != nil {
+		return fmt.Errorf("activating credential: %w", err)
+	}
+	if !VerifySecret(secret, got) {
+		return ErrActivationSecretMismatch
+	}
+	return nil
+}
+
+// VerifySecret reports whether the secret returned from the TPM as a result
+// of calling ActivateCredential() matches the expected secret returned by
+// Generate(). The contents of the secrets are compared in constant time.
+// An empty expected secret never matches.
+func VerifySecret(expected, got []byte) bool {
+	if len(expected) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare(expected, got) == 1
+}
+
+// DeriveActivationSecret derives the secret of a credential activation
+// bound to the additional authenticated data aad, as set in
+// ActivationParameters.AAD, from the secret returned by ActivateCredential.
+// The derived secret has the same size as secret, and is computed with
+// HKDF-SHA256 (RFC 5869), using secret as the input keying material, no
+// salt and aad as the info parameter. If aad is empty, secret is returned
+// unchanged.
+func DeriveActivationSecret(secret, aad []byte) ([]byte, error) {
+	if len(aad) == 0 {
+		return secret, nil
+	}
+	out := make([]byte, len(secret))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, aad), out); err != nil {
+		return nil, fmt.Errorf("deriving activation secret: %v", err)
+	}
+	return out, nil
+}
+
+func (p *ActivationParameters) generateChallengeTPM20(rnd io.Reader, att *tpm2.AttestationData, ek *preparedEK, scratch *sync.Pool, secret []byte) (*EncryptedCredential, error) {
+	// The AK checks reject these already, but the challenge must not
+	// depend on them having been run.
+	if att == nil {
+		return nil, errors.New("no creation attestation for the AK")
+	}
+	if att.Type != tpm2.TagAttestCreation {
+		return nil, fmt.Errorf("attestation does not apply to creation data, got tag %x", att.Type)
 	}
 	if att.AttestedCreationInfo == nil {
-		return nil, fmt.Errorf("attestation was not for a creation event")
+		return nil, errors.New("attestation was not for a creation event")
 	}
 	if att.AttestedCreationInfo.Name.Digest == nil {
 		return nil, fmt.Errorf("attestation creation info name has no digest")
 	}
-	cred, encSecret, err := credactivation.Generate(att.AttestedCreationInfo.Name.Digest, p.EK, symBlockSize, secret)
+	name, err := att.AttestedCreationInfo.Name.Digest.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("encoding AK name: %v", err)
+	}
+	s, err := getCredentialScratch(scratch, ek.nameAlg)
 	if err != nil {
-		return nil, fmt.Errorf("credactivation.Generate() failed: %v", err)
+		return nil, err
 	}
+	defer putCredentialScratch(scratch, s)
+	cred, encSecret, params, err := generateCredential20(rnd, s, ek, name, secret)
+	if err != nil {
+		return nil, fmt.Errorf("generating credential failed: %v", err)
+	}
+	params.AKName = name
 
 	return &EncryptedCredential{
 		Credential: cred,
 		Secret:     encSecret,
+		Parameters: params,
 	}, nil
 }
 
-func (p *ActivationParameters) generateChallengeTPM12(rand io.Reader, secret []byte) (*EncryptedCredential, error) {
-	pk, ok := p.EK.(*rsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("got EK of type %T, want an RSA key", p.EK)
+// getCredentialScratch returns a credentialScratch for keys whose name
+// algorithm is nameAlg, taking it from pool if possible. pool may be nil.
+func getCredentialScratch(pool *sync.Pool, nameAlg tpm2.Algorithm) (*credentialScratch, error) {
+	if pool != nil {
+		if s, ok := pool.Get().(*credentialScratch); ok && s.nameAlg == nameAlg {
+			return s, nil
+		}
 	}
+	return newCredentialScratch(nameAlg)
+}
 
-	var (
-		cred, encSecret []byte
-		err             error
-	)
-	if p.AK.UseTCSDActivationFormat {
-		cred, encSecret, err = verification.GenerateChallengeEx(pk, p.AK.Public, secret)
-	} else {
-		cred, encSecret, err = generateChallenge12(rand, pk, p.AK.Public, secret)
+// putCredentialScratch erases s and returns it to pool, if not nil.
+func putCredentialScratch(pool *sync.Pool, s *credentialScratch) {
+	s.clear()
+	if pool != nil {
+		pool.Put(s)
 	}
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("challenge generation failed: %v", err)
+// symmetricBlockSize returns the AES key size to use when generating a
+// TPM 2.0 challenge, applying the default if none was specified.
+func (p *ActivationParameters) symmetricBlockSize() (int, error) {
+	switch p.SymmetricBlockSize {
+	case 0:
+		return symBlockSize, nil
+	case 16, 24, 32:
+		return p.SymmetricBlockSize, nil
+	default:
+		return 0, fmt.Errorf("unsupported symmetric block size %d: must be 16, 24 or 32 bytes", p.SymmetricBlockSize)
 	}
-	return &EncryptedCredential{
-		Credential: cred,
-		Secret:     encSecret,
+}
+
+// CredentialLayout describes the framing of a TPM 2.0 EncryptedCredential,
+// as reported by DecodeEncryptedCredential.
+type CredentialLayout struct {
+	// IntegritySize is the size in bytes of the integrity HMAC of the
+	// TPM2B_ID_OBJECT in Credential.
+	IntegritySize int
+	// IntegrityHash is the hash algorithm whose digest size matches
+	// IntegritySize, which is the name algorithm of the EK.
+	IntegrityHash crypto.Hash
+	// EncryptedIdentitySize is the size in bytes of the encrypted
+	// credential following the integrity HMAC.
+	EncryptedIdentitySize int
+	// SecretSize is the size in bytes of the encrypted seed in Secret, a
+	// TPM2B_ENCRYPTED_SECRET.
+	SecretSize int
+}
+
+// DecodeEncryptedCredential checks the framing of a TPM 2.0
+// EncryptedCredential without decrypting it, and reports the sizes of its
+// fields. This is intended for debugging challenges which fail to
+// activate, for example because a client altered them in transit.
+func DecodeEncryptedCredential(ec *EncryptedCredential) (*CredentialLayout, error) {
+	var idObject, integrity, secret tpmutil.U16Bytes
+	buf := bytes.NewBuffer(ec.Credential)
+	if err := tpmutil.UnpackBuf(buf, &idObject); err != nil {
+		return nil, fmt.Errorf("decoding credential: %v", err)
+	}
+	if buf.Len() != 0 {
+		return nil, fmt.Errorf("credential has %d trailing bytes", buf.Len())
+	}
+	buf = bytes.NewBuffer(idObject)
+	if err := tpmutil.UnpackBuf(buf, &integrity); err != nil {
+		return nil, fmt.Errorf("decoding integrity HMAC: %v", err)
+	}
+	encIdentity := buf.Bytes()
+	if len(encIdentity) == 0 {
+		return nil, errors.New("credential has no encrypted identity")
+	}
+	var hash crypto.Hash
+	for _, h := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		if h.Size() == len(integrity) {
+			hash = h
+			break
+		}
+	}
+	if hash == 0 {
+		return nil, fmt.Errorf("integrity HMAC size %d does not match a supported hash", len(integrity))
+	}
+	buf = bytes.NewBuffer(ec.Secret)
+	if err := tpmutil.UnpackBuf(buf, &secret); err != nil {
+		return nil, fmt.Errorf("decoding secret: %v", err)
+	}
+	if buf.Len() != 0 {
+		return nil, fmt.Errorf("secret has %d trailing bytes", buf.Len())
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+	return &CredentialLayout{
+		IntegritySize:         len(integrity),
+		IntegrityHash:         hash,
+		EncryptedIdentitySize: len(encIdentity),
+		SecretSize:            len(secret),
 	}, nil
 }
+
+// ekProtection returns the name algorithm and AES key size of the EK, which
+// are used to protect TPM 2.0 credentials.
+func (p *ActivationParameters) ekProtection() (tpm2.Algorithm, int, error) {
+	blockSize, err := p.symmetricBlockSize()
+	if err != nil {
+		return 0, 0, err
+	}
+	if p.EKTemplate == nil {
+		return ekNameAlg20, blockSize, nil
+	}
+
+	alg, err := EKAlgorithm(p.EK)
+	if err != nil {
+		return 0, 0, err
+	}
+	var sym *tpm2.SymScheme
+	switch alg {
+	case RSA:
+		if p.EKTemplate.Type != tpm2.AlgRSA || p.EKTemplate.RSAParameters == nil {
+			return 0, 0, errors.New("EK template is not an RSA template, but EK is an RSA key")
+		}
+		sym = p.EKTemplate.RSAParameters.Symmetric
+	case ECDSA:
+		if p.EKTemplate.Type != tpm2.AlgECC || p.EKTemplate.ECCParameters == nil {
+			return 0, 0, errors.New("EK template is not an ECC template, but EK is an ECC key")
+		}
+		sym = p.EKTemplate.ECCParameters.Symmetric
+	}
+	if sym == nil || sym.Alg != tpm2.AlgAES || sym.Mode != tpm2.AlgCFB {
+		return 0, 0, errors.New("EK template must use AES in CFB mode")
+	}
+	switch sym.KeyBits {
+	case 128, 192, 256:
+	default:
+		return 0, 0, fmt.Errorf("unsupported EK template AES key size %d", sym.KeyBits)
+	}
+	if p.SymmetricBlockSize != 0 && p.SymmetricBlockSize != int(sym.KeyBits)/8 {
+		return 0, 0, fmt.Errorf("symmetric block size %d does not match the EK template's %d bit key", p.SymmetricBlockSize, sym.KeyBits)
+	}
+	if _, err := tpmHash(p.EKTemplate.NameAlg); err != nil {
+		return 0, 0, fmt.Errorf("unsupported EK template name algorithm 0x%x: %v", p.EKTemplate.NameAlg, err)
+	}
+	return p.EKTemplate.NameAlg, int(sym.KeyBits) / 8, nil
+}
+
+// checkTPM12RSAKey returns an error if public, a TPM_PUBKEY structure, is
+// not an RSA key. TPM 1.2 does not support ECC attestation keys, and
+// rejecting them here avoids a less helpful error when parsing the key.
+func checkTPM12RSAKey(public []byte) error {
+	if len(public) < 4 {
+		return fmt.Errorf("TPM 1.2 public key is too short: %d bytes", len(public))
+	}
+	// TPM_PUBKEY begins with a TPM_KEY_PARMS structure, whose first field
+	// is the algorithm ID.
+	if alg := binary.BigEndian.Uint32(public); alg != tpm12AlgRSA {
+		return fmt.Errorf("TPM 1.2 supports only RSA attestation keys, got key with algorithm ID 0x%x", alg)
+	}
+	return nil
+}
+
+// ActivatorConfig configures an Activator. Its fields have the same meaning
+// as the corresponding fields of ActivationParameters.
+type ActivatorConfig struct {
+	// TPMVersion holds the version of the TPMs challenges are generated
+	// for, either 1.2 or 2.0.
+	TPMVersion TPMVersion
+	// Rand is a source of randomness to generate seeds and secrets. It
+	// must be safe for concurrent use if the Activator is.
+	//
+	// If nil, this defaults to crypto.Rand.
+	Rand io.Reader
+	// SymmetricBlockSize is the size in bytes of the AES key used to
+	// protect credentials: 16, 24 or 32. If zero, this defaults to 16.
+	// Only used for TPM 2.0.
+	SymmetricBlockSize int
+	// EKTemplate, if set, is the template of the EKs challenges are
+	// encrypted to. Only used for TPM 2.0.
+	EKTemplate *tpm2.Public
+	// MinEKBits is the minimum accepted size in bits of an RSA EK. If
+	// zero, this defaults to 2048.
+	MinEKBits int
+	// MinAKBits and MinAKECCBits are the minimum accepted sizes in bits of
+	// RSA AKs and the curves of ECC AKs. If zero, these default to 2048
+	// and 256 respectively.
+	MinAKBits    int
+	MinAKECCBits int
+	// MaxAKFieldSize is the maximum accepted size in bytes of each field
+	// of an AK's parameters. If zero, this defaults to 16 KiB.
+	MaxAKFieldSize int
+	// SecretLen is the size in bytes of generated secrets, between 16 and
+	// 64 bytes. If zero, this defaults to 32.
+	SecretLen int
+	// Logger, if set, receives debug level events for each step of
+	// checking an AK.
+	Logger *slog.Logger
+	// RequireEndorsementHierarchy rejects TPM 2.0 AKs which were not
+	// created in the endorsement hierarchy.
+	RequireEndorsementHierarchy bool
+	// RequireLowS rejects ECDSA creation signatures of TPM 2.0 AKs in the
+	// high-S form.
+	RequireLowS bool
+	// AllowedAKTemplates, if not empty, restricts TPM 2.0 AKs to those
+	// created from one of the given templates, as digests computed by
+	// AKTemplateDigest. It must not be modified while the Activator is in
+	// use.
+	AllowedAKTemplates [][]byte
+	// ChallengeGenerator, if set, replaces the built-in challenge
+	// generation. It must be safe for concurrent use if the Activator is.
+	ChallengeGenerator ChallengeGenerator
+	// AAD, if set, binds every challenge to additional authenticated
+	// data, from which their secrets are derived. It must not be modified
+	// while the Activator is in use.
+	AAD []byte
+	// RecipientKey, if set, is a public key to which the secrets of
+	// challenges are sealed, in place of returning them.
+	RecipientKey crypto.PublicKey
+}
+
+// Activator generates credential activation challenges for many AKs which
+// share the same configuration, such as in an enrollment service. The
+// configuration is validated once by NewActivator rather than on every
+// challenge, and the hash states and buffers of TPM 2.0 challenges are
+// reused from one challenge to the next. An Activator is safe for
+// concurrent use if its Rand is.
+type Activator struct {
+	params ActivationParameters
+	// scratch pools the *credentialScratch of TPM 2.0 challenges.
+	scratch sync.Pool
+}
+
+// NewActivator validates cfg and returns an Activator using it.
+func NewActivator(cfg ActivatorConfig) (*Activator, error) {
+	p := ActivationParameters{
+		TPMVersion:                  cfg.TPMVersion,
+		Rand:                        cfg.Rand,
+		SymmetricBlockSize:          cfg.SymmetricBlockSize,
+		EKTemplate:                  cfg.EKTemplate,
+		MinEKBits:                   cfg.MinEKBits,
+		MinAKBits:                   cfg.MinAKBits,
+		MinAKECCBits:                cfg.MinAKECCBits,
+		MaxAKFieldSize:              cfg.MaxAKFieldSize,
+		SecretLen:                   cfg.SecretLen,
+		Logger:                      cfg.Logger,
+		RequireEndorsementHierarchy: cfg.RequireEndorsementHierarchy,
+		RequireLowS:                 cfg.RequireLowS,
+		AllowedAKTemplates:          cfg.AllowedAKTemplates,
+		ChallengeGenerator:          cfg.ChallengeGenerator,
+		AAD:                         cfg.AAD,
+		RecipientKey:                cfg.RecipientKey,
+	}
+	switch p.TPMVersion {
+	case TPMVersion12:
+		if !tpm12Built {
+			return nil, ErrTPM12NotBuilt
+		}
+	case TPMVersion20:
+	default:
+		return nil, fmt.Errorf("TPM version %d not supported", p.TPMVersion)
+	}
+	if p.Rand == nil {
+		p.Rand = rand.Reader
+	}
+	blockSize, err := p.symmetricBlockSize()
+	if err != nil {
+		return nil, err
+	}
+	p.SymmetricBlockSize = blockSize
+	if p.MinEKBits < 0 {
+		return nil, fmt.Errorf("invalid minimum EK size %d", p.MinEKBits)
+	}
+	if p.MinAKBits < 0 || p.MinAKECCBits < 0 {
+		return nil, fmt.Errorf("invalid minimum AK sizes %d and %d", p.MinAKBits, p.MinAKECCBits)
+	}
+	if p.MaxAKFieldSize < 0 {
+		return nil, fmt.Errorf("invalid maximum AK field size %d", p.MaxAKFieldSize)
+	}
+	if p.SecretLen == 0 {
+		p.SecretLen = activationSecretLen
+	}
+	if p.SecretLen < minActivationSecretLen || p.SecretLen > maxActivationSecretLen {
+		return nil, fmt.Errorf("unsupported activation secret length %d: must be between %d and %d bytes", p.SecretLen, minActivationSecretLen, maxActivationSecretLen)
+	}
+	return &Activator{params: p}, nil
+}
+
+// Challenge checks the AK described by ak and returns a credential
+// activation challenge for it, encrypted to ek. It behaves like
+// ActivationParameters.Generate.
+func (a *Activator) Challenge(ek crypto.PublicKey, ak AttestationParameters) (secret []byte, ec *EncryptedCredential, err error) {
+	p := a.params
+	p.EK = ek
+	p.AK = ak
+	return p.generate(nil, &a.scratch)
+}
+
+// Enroll runs a credential activation round trip for the AK described by
+// ak and the EK ek, as ActivationParameters.Enroll does.
+func (a *Activator) Enroll(ek crypto.PublicKey, ak AttestationParameters, activate func(ec EncryptedCredential) ([]byte, error)) error {
+	p := a.params
+	p.EK = ek
+	p.AK = ak
+	return p.Enroll(activate)
+}
+
+// EKActivator generates credential activation challenges for many AKs of
+// the same EK, such as when a device re-enrolls. The EK is checked and the
+// state of the challenge depending on it is prepared once, by ForEK,
+// rather than for every challenge. Its challenges are identical to those
+// of Activator.Challenge given the same randomness. An EKActivator is safe
+// for concurrent use if its Activator is.
+type EKActivator struct {
+	params  ActivationParameters
+	ek      *preparedEK
+	scratch *sync.Pool
+}
+
+// ForEK checks ek and returns an EKActivator generating challenges for it.
+func (a *Activator) ForEK(ek crypto.PublicKey) (*EKActivator, error) {
+	p := a.params
+	p.EK = ek
+	prepared, err := p.prepareEK()
+	if err != nil {
+		return nil, err
+	}
+	return &EKActivator{params: p, ek: prepared, scratch: &a.scratch}, nil
+}
+
+// Challenge checks the AK described by ak and returns a credential
+// activation challenge for it, encrypted to the EK of a.
+func (a *EKActivator) Challenge(ak AttestationParameters) (secret []byte, ec *EncryptedCredential, err error) {
+	p := a.params
+	p.AK = ak
+	return p.generate(a.ek, a.scratch)
+}
@@ -2,14 +2,37 @@ package attest
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"math/big"
 	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
 )
 
-func decodeBase10(base10 string, t *testing.T) *big.Int {
+func decodeBase10(base10 string, t testing.TB) *big.Int {
 	i, ok := new(big.Int).SetString(base10, 10)
 	if !ok {
 		t.Fatalf("failed decode of base10: %q", base10)
@@ -17,7 +40,7 @@ func decodeBase10(base10 string, t *testing.T) *big.Int {
 	return i
 }
 
-func decodeBase64(in string, t *testing.T) []byte {
+func decodeBase64(in string, t testing.TB) []byte {
 	out, err := base64.StdEncoding.DecodeString(in)
 	if err != nil {
 		t.Fatal(err)
@@ -25,7 +48,7 @@ func decodeBase64(in string, t *testing.T) []byte {
 	return out
 }
 
-func ekCertSigner(t *testing.T) *rsa.PrivateKey {
+func ekCertSigner(t testing.TB) *rsa.PrivateKey {
 	return &rsa.PrivateKey{
 		PublicKey: rsa.PublicKey{
 			N: decodeBase10("14314132931241006650998084889274020608918049032671858325988396851334124245188214251956198731333464217832226406088020736932173064754214329009979944037640912127943488972644697423190955557435910767690712778463524983667852819010259499695177313115447116110358524558307947613422897787329221478860907963827160223559690523660574329011927531289655711860504630573766609239332569210831325633840174683944553667352219670930408593321661375473885147973879086994006440025257225431977751512374815915392249179976902953721486040787792801849818254465486633791826766873076617116727073077821584676715609985777563958286637185868165868520557", t),
@@ -43,15 +66,9 @@ func TestActivationTPM20(t *testing.T) {
 	priv := ekCertSigner(t)
 	rand := rand.New(rand.NewSource(123456))
 
-	// These parameters represent an AK generated on a real-world, infineon TPM.
 	params := ActivationParameters{
 		TPMVersion: TPMVersion20,
-		AK: AttestationParameters{
-			Public:            decodeBase64("AAEACwAFBHIAIJ3/y/NsODrmmfuYaNxty4nXFTiEvigDkiwSQVi/rSKuABAAFAAECAAAAAAAAQC/08gj/04z4xGMIVTmr02lzhI5epufXgU831xEpf2qpXfvtNGUfqTcgWF2EUux2HDPqgcj59dtXRobQdlr4uCGNzfZIGAej4JusLa4MjpG6W2DtJPot6F1Mry63talzJ36U47niy9Iesd34CO2p9Xk3+86ZmBnQ6PQ2roUNK3l7bKz6cFLM9drOLwCqU0AUl6pHvzYPPz+xXsPl3iaA2cM97oneUiJNmJM7wtR9OcaKyIA4wVlX5TndB9NwWq5Iuj8q2Sp40Dg0noXXGSPliAtVD8flkXtAcuI9UHkQbzu9cGPRdSJPMn743GONg3bYalFtcgh2VpACXkPbXB32J7B", t),
-			CreateData:        decodeBase64("AAAAAAAg47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFUBAAsAIgALWI9hwDRB3zYSkannqM5z0J1coQNA1Jz/oCRxJQwTaNwAIgALmyFYBhHeIU3FUKIAPgXFD3NXyasP3siQviDEyH7avu4AAA==", t),
-			CreateAttestation: decodeBase64("/1RDR4AaACIAC41+jhmEOue1MZhJjIk79ENar6i15rBvamXLpQnGTBCOAAAAAAAAD3GRNfU4syzJ1jQGATDCDteFC5C4ACIAC3ToMYGy9GXxcf8A0HvOuLOHbU7HPEppM47C7CMcU8TtACBDmJFUFO1f5+BYevaYdd3VtfMCsxIuHhoTZJczzLP2BA==", t),
-			CreateSignature:   decodeBase64("ABQABAEALVzJSnKRJU39gHjETaI89/sM1L6HwBPGNekw6NojSW8bwD5/W1cLRDakCsYKUQu68mmbjs8xaIVBRvVM2YWP10tbTWNB0iJc9b8rERhkk3QIIFm/XsiVZsb0mysTxfeh8zygaAKQ/50sYyzp+raD0Ho0mYIRKJOEdQ6chsBflM3eB8mCXGTugUfrET80q3iu0gncaKWbfxQaQUb9ZTPSJrTN64HQ9tlOfnGT+8++WA3hV0NqKMnoAqiI9GZnI5MPXs6XxEncu/GJLJpAYZakBiS74Jvlr34Pur32B4xjm1M25AUGHEIgb6r49S0sV+hzaKu45858lQRMXj01GcyBhw==", t),
-		},
+		AK:         rsaAKParameters(t),
 		EK: &rsa.PublicKey{
 			E: priv.E,
 			N: priv.N,
@@ -67,3 +84,2297 @@ func TestActivationTPM20(t *testing.T) {
 		t.Fatalf("secret = %v, want %v", got, want)
 	}
 }
+
+// eccAKParameters represents an ECC P-256 AK generated on a simulated TPM.
+func eccAKParameters(t testing.TB) AttestationParameters {
+	return AttestationParameters{
+		Public:            decodeBase64("ACMACwAFBHIAAAAQABgACwADABAAICH32wuwznxcDFB2vTdybpAUKOJqMx9HDZoAiAGa0Z8oACA6bv6xsAmlORxJjC39YJxCEFySshTatIKAdsZ2JeVSug==", t),
+		CreateData:        decodeBase64("AAAAAAAg47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFUBAAsAIgALMKqtq/BTMeYZ7y1ZSUg5ziage6hAV0HYVR5OzAggM6sAIgALlK/i5nrG13QBamGaQd3fsNW+QMSr/hieIyXPEQ1zvvAAAA==", t),
+		CreateAttestation: decodeBase64("/1RDR4AaACIAC+crYPWWZvpFFsVFmzM4JLY00mMoy6S48bP4F+Iw0SEgAAAAAAAAAAAAGB7vQOQOQrEfAfvWMFiyr3cAACIAC5BVFAQHl2lFuKVAWun4lwPoA6TZAg5tP9LHPmBCI52rACBLXCKeCuEazU46SWRzu7wh502i9STBVHMydP/Pri0JQA==", t),
+		CreateSignature:   decodeBase64("ABgACwAgVX6zVxjevxBi8GyINuO388DCx0U55N1Q3CUxiJRr6X8AIFOHusgW2RqIV5xn/QnwHfS5hjQyYay/2AHl3e5GRYgQ", t),
+	}
+}
+
+// rsaAKParameters represents an RSA AK generated on a real-world, infineon TPM.
+func rsaAKParameters(t testing.TB) AttestationParameters {
+	return AttestationParameters{
+		Public:            decodeBase64("AAEACwAFBHIAIJ3/y/NsODrmmfuYaNxty4nXFTiEvigDkiwSQVi/rSKuABAAFAAECAAAAAAAAQC/08gj/04z4xGMIVTmr02lzhI5epufXgU831xEpf2qpXfvtNGUfqTcgWF2EUux2HDPqgcj59dtXRobQdlr4uCGNzfZIGAej4JusLa4MjpG6W2DtJPot6F1Mry63talzJ36U47niy9Iesd34CO2p9Xk3+86ZmBnQ6PQ2roUNK3l7bKz6cFLM9drOLwCqU0AUl6pHvzYPPz+xXsPl3iaA2cM97oneUiJNmJM7wtR9OcaKyIA4wVlX5TndB9NwWq5Iuj8q2Sp40Dg0noXXGSPliAtVD8flkXtAcuI9UHkQbzu9cGPRdSJPMn743GONg3bYalFtcgh2VpACXkPbXB32J7B", t),
+		CreateData:        decodeBase64("AAAAAAAg47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFUBAAsAIgALWI9hwDRB3zYSkannqM5z0J1coQNA1Jz/oCRxJQwTaNwAIgALmyFYBhHeIU3FUKIAPgXFD3NXyasP3siQviDEyH7avu4AAA==", t),
+		CreateAttestation: decodeBase64("/1RDR4AaACIAC41+jhmEOue1MZhJjIk79ENar6i15rBvamXLpQnGTBCOAAAAAAAAD3GRNfU4syzJ1jQGATDCDteFC5C4ACIAC3ToMYGy9GXxcf8A0HvOuLOHbU7HPEppM47C7CMcU8TtACBDmJFUFO1f5+BYevaYdd3VtfMCsxIuHhoTZJczzLP2BA==", t),
+		CreateSignature:   decodeBase64("ABQABAEALVzJSnKRJU39gHjETaI89/sM1L6HwBPGNekw6NojSW8bwD5/W1cLRDakCsYKUQu68mmbjs8xaIVBRvVM2YWP10tbTWNB0iJc9b8rERhkk3QIIFm/XsiVZsb0mysTxfeh8zygaAKQ/50sYyzp+raD0Ho0mYIRKJOEdQ6chsBflM3eB8mCXGTugUfrET80q3iu0gncaKWbfxQaQUb9ZTPSJrTN64HQ9tlOfnGT+8++WA3hV0NqKMnoAqiI9GZnI5MPXs6XxEncu/GJLJpAYZakBiS74Jvlr34Pur32B4xjm1M25AUGHEIgb6r49S0sV+hzaKu45858lQRMXj01GcyBhw==", t),
+	}
+}
+
+// softwareRSAAKParameters returns the parameters of an RSA AK of the given
+// size, certified in software. It stands in for TPMs, such as the simulator,
+// which cannot create RSA keys larger than 2048 bits.
+func softwareRSAAKParameters(t testing.TB, bits int) AttestationParameters {
+	t.Helper()
+	priv, err := rsa.GenerateKey(cryptorand.Reader, bits)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() failed: %v", err)
+	}
+	params := *akTemplateRSA.RSAParameters
+	params.KeyBits = uint16(bits)
+	params.ModulusRaw = priv.N.Bytes()
+	pub := akTemplateRSA
+	pub.RSAParameters = &params
+	pubBytes, err := pub.Encode()
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	name, err := pub.Name()
+	if err != nil {
+		t.Fatalf("Name() failed: %v", err)
+	}
+
+	// Reuse the creation data of a real AK, which only depends on its
+	// parent, and attest to the creation of the new key instead.
+	ak := rsaAKParameters(t)
+	att, err := tpm2.DecodeAttestationData(ak.CreateAttestation)
+	if err != nil {
+		t.Fatalf("DecodeAttestationData() failed: %v", err)
+	}
+	att.AttestedCreationInfo.Name = name
+	attBytes, err := att.Encode()
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	digest := sha256.Sum256(attBytes)
+	sig, err := rsa.SignPKCS1v15(cryptorand.Reader, priv, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("SignPKCS1v15() failed: %v", err)
+	}
+	sigBytes, err := (&tpm2.Signature{
+		Alg: tpm2.AlgRSASSA,
+		RSA: &tpm2.SignatureRSA{HashAlg: tpm2.AlgSHA256, Signature: sig},
+	}).Encode()
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	return AttestationParameters{
+		Public:            pubBytes,
+		CreateData:        ak.CreateData,
+		CreateAttestation: attBytes,
+		CreateSignature:   sigBytes,
+	}
+}
+
+func TestActivationTPM20RSA3072(t *testing.T) {
+	priv := ekCertSigner(t)
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         softwareRSAAKParameters(t, 3072),
+		EK: &rsa.PublicKey{
+			E: priv.E,
+			N: priv.N,
+		},
+		MinAKBits: 3072,
+	}
+	if err := params.CheckAKParameters(); err != nil {
+		t.Fatalf("CheckAKParameters() failed: %v", err)
+	}
+	if _, _, err := params.Generate(); err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+}
+
+func TestActivationTPM20ECC(t *testing.T) {
+	priv := ekCertSigner(t)
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         eccAKParameters(t),
+		EK: &rsa.PublicKey{
+			E: priv.E,
+			N: priv.N,
+		},
+		Rand: rand.New(rand.NewSource(123456)),
+	}
+
+	if _, _, err := params.Generate(); err != nil {
+		t.Fatalf("Generate() returned err: %v", err)
+	}
+}
+
+func TestActivationTPM20SymmetricBlockSize(t *testing.T) {
+	priv := ekCertSigner(t)
+
+	for _, test := range []struct {
+		blockSize int
+		wantErr   bool
+	}{
+		{0, false},
+		{16, false},
+		{24, false},
+		{32, false},
+		{8, true},
+		{17, true},
+		{64, true},
+		{-1, true},
+	} {
+		t.Run(fmt.Sprint(test.blockSize), func(t *testing.T) {
+			params := ActivationParameters{
+				TPMVersion: TPMVersion20,
+				AK:         rsaAKParameters(t),
+				EK: &rsa.PublicKey{
+					E: priv.E,
+					N: priv.N,
+				},
+				SymmetricBlockSize: test.blockSize,
+			}
+			_, ec, err := params.Generate()
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("Generate() returned err = %v, wantErr %v", err, test.wantErr)
+			}
+			if err == nil && len(ec.Credential) == 0 {
+				t.Error("Generate() returned an empty credential")
+			}
+		})
+	}
+}
+
+func TestEncryptedSecretSize(t *testing.T) {
+	priv := ekCertSigner(t)
+	rsaEK := &rsa.PublicKey{E: priv.E, N: priv.N}
+	newECDSA := func(c elliptic.Curve) *ecdsa.PublicKey {
+		k, err := ecdsa.GenerateKey(c, cryptorand.Reader)
+		if err != nil {
+			t.Fatalf("ecdsa.GenerateKey() failed: %v", err)
+		}
+		return &k.PublicKey
+	}
+	ecdhP521, err := newECDSA(elliptic.P521()).ECDH()
+	if err != nil {
+		t.Fatalf("ECDH() failed: %v", err)
+	}
+	data, err := os.ReadFile("testdata/linux_tpm12.json")
+	if err != nil {
+		t.Fatalf("reading test data: %v", err)
+	}
+	var dump Dump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		t.Fatalf("parsing test data: %v", err)
+	}
+
+	for _, test := range []struct {
+		name string
+		ek   crypto.PublicKey
+		want int
+	}{
+		{"RSA-2048", rsaEK, 258},
+		{"P-256", newECDSA(elliptic.P256()), 70},
+		{"P-384", newECDSA(elliptic.P384()), 102},
+		{"P-521 ECDH", ecdhP521, 138},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			params := ActivationParameters{
+				TPMVersion: TPMVersion20,
+				AK:         rsaAKParameters(t),
+				EK:         test.ek,
+			}
+			got, err := params.EncryptedSecretSize()
+			if err != nil {
+				t.Fatalf("EncryptedSecretSize() failed: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("EncryptedSecretSize() = %d, want %d", got, test.want)
+			}
+			_, ec, err := params.Generate()
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			if len(ec.Secret) != test.want {
+				t.Errorf("len(Secret) = %d, want %d", len(ec.Secret), test.want)
+			}
+		})
+	}
+
+	skipWithoutTPM12(t)
+	params := ActivationParameters{TPMVersion: TPMVersion12, AK: dump.AK, EK: rsaEK}
+	if _, err := params.EncryptedSecretSize(); err == nil {
+		t.Error("EncryptedSecretSize() succeeded for TPM 1.2, want error")
+	}
+	if _, _, err := params.Generate(); err != nil {
+		t.Errorf("Generate() failed for TPM 1.2: %v", err)
+	}
+}
+
+func TestActivationSecretLen(t *testing.T) {
+	priv := ekCertSigner(t)
+
+	for _, test := range []struct {
+		secretLen int
+		wantLen   int
+		wantErr   bool
+	}{
+		{0, 32, false},
+		{16, 16, false},
+		{48, 48, false},
+		{64, 64, false},
+		{15, 0, true},
+		{65, 0, true},
+		{-1, 0, true},
+	} {
+		t.Run(fmt.Sprint(test.secretLen), func(t *testing.T) {
+			params := ActivationParameters{
+				TPMVersion: TPMVersion20,
+				AK:         rsaAKParameters(t),
+				EK:         &priv.PublicKey,
+				SecretLen:  test.secretLen,
+			}
+			secret, _, err := params.Generate()
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("Generate() returned err = %v, wantErr %v", err, test.wantErr)
+			}
+			if len(secret) != test.wantLen {
+				t.Errorf("len(secret) = %d, want %d", len(secret), test.wantLen)
+			}
+		})
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("entropy source failure")
+}
+
+func TestActivationShortRand(t *testing.T) {
+	priv := ekCertSigner(t)
+
+	for _, test := range []struct {
+		name    string
+		rand    io.Reader
+		wantErr string
+	}{
+		{"short", bytes.NewReader(make([]byte, 10)), "random source returned 10 of 32 bytes"},
+		{"error", errReader{}, "entropy source failure"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			params := ActivationParameters{
+				TPMVersion: TPMVersion20,
+				AK:         rsaAKParameters(t),
+				EK:         &priv.PublicKey,
+				Rand:       test.rand,
+			}
+			_, _, err := params.Generate()
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Generate() returned %v, want error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestActivationTPM20ECCCorruptPublic(t *testing.T) {
+	priv := ekCertSigner(t)
+	ak := eccAKParameters(t)
+	ak.Public = append([]byte(nil), ak.Public...)
+	ak.Public[len(ak.Public)-1] ^= 0xff
+
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         ak,
+		EK: &rsa.PublicKey{
+			E: priv.E,
+			N: priv.N,
+		},
+	}
+	if _, _, err := params.Generate(); err == nil {
+		t.Error("Generate() succeeded with a corrupted AK public blob")
+	}
+}
+
+func TestVerifyECDSASignatureBadKey(t *testing.T) {
+	ak := eccAKParameters(t)
+	for _, test := range []struct {
+		name   string
+		modify func(*tpm2.Public)
+	}{
+		{"unknown curve", func(pub *tpm2.Public) { pub.ECCParameters.CurveID = 0xffff }},
+		{"point not on curve", func(pub *tpm2.Public) { pub.ECCParameters.Point.YRaw[0] ^= 0xff }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pub, err := tpm2.DecodePublic(ak.Public)
+			if err != nil {
+				t.Fatalf("DecodePublic() failed: %v", err)
+			}
+			test.modify(&pub)
+			if err := verifyECDSASignature(pub, ak.CreateAttestation, ak.CreateSignature, false); err == nil {
+				t.Error("verifyECDSASignature() succeeded with an invalid public key")
+			}
+		})
+	}
+}
+
+func TestAttestationParametersJSON(t *testing.T) {
+	priv := ekCertSigner(t)
+	ak := rsaAKParameters(t)
+
+	b, err := json.Marshal(ak)
+	if err != nil {
+		t.Fatalf("json.Marshal() failed: %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v", err)
+	}
+	for _, name := range []string{"Public", "UseTCSDActivationFormat", "CreateData", "CreateAttestation", "CreateSignature"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("JSON encoding %s is missing field %q", b, name)
+		}
+	}
+
+	var got AttestationParameters
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, ak) {
+		t.Fatalf("round-tripped parameters = %+v, want %+v", got, ak)
+	}
+
+	var secrets [][]byte
+	for _, params := range []AttestationParameters{ak, got} {
+		p := ActivationParameters{
+			TPMVersion: TPMVersion20,
+			AK:         params,
+			EK: &rsa.PublicKey{
+				E: priv.E,
+				N: priv.N,
+			},
+			Rand: rand.New(rand.NewSource(123456)),
+		}
+		secret, ec, err := p.Generate()
+		if err != nil {
+			t.Fatalf("Generate() failed: %v", err)
+		}
+		secrets = append(secrets, secret)
+
+		b, err := json.Marshal(ec)
+		if err != nil {
+			t.Fatalf("json.Marshal() failed: %v", err)
+		}
+		var gotEC EncryptedCredential
+		if err := json.Unmarshal(b, &gotEC); err != nil {
+			t.Fatalf("json.Unmarshal() failed: %v", err)
+		}
+		if !bytes.Equal(gotEC.Credential, ec.Credential) || !bytes.Equal(gotEC.Secret, ec.Secret) {
+			t.Errorf("round-tripped credential = %+v, want %+v", gotEC, ec)
+		}
+	}
+	if !bytes.Equal(secrets[0], secrets[1]) {
+		t.Errorf("Generate() secrets differ after round-trip: %x != %x", secrets[0], secrets[1])
+	}
+}
+
+func TestAttestationParametersJSONFieldNames(t *testing.T) {
+	// The JSON encoding is relied upon by existing clients, so the field
+	// names must not change.
+	in := `{"Public":"AQI=","UseTCSDActivationFormat":true,"CreateData":"Aw==","CreateAttestation":null,"CreateSignature":null}`
+	var got AttestationParameters
+	if err := json.Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v", err)
+	}
+	want := AttestationParameters{
+		Public:                  []byte{1, 2},
+		UseTCSDActivationFormat: true,
+		CreateData:              []byte{3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("json.Unmarshal() = %+v, want %+v", got, want)
+	}
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("json.Marshal() failed: %v", err)
+	}
+	if string(b) != in {
+		t.Errorf("json.Marshal() = %s, want %s", b, in)
+	}
+}
+
+func TestAttestationParametersCanonicalBytes(t *testing.T) {
+	params := AttestationParameters{
+		Public:                  []byte{1, 2},
+		UseTCSDActivationFormat: true,
+		CreateData:              []byte{3},
+		CreateSignature:         []byte{4, 5, 6},
+	}
+	// The encoding must not change, so that it can be computed by clients
+	// and servers running different versions of the package.
+	want := append([]byte("go-attestation AttestationParameters v1\x00"),
+		0, 0, 0, 2, 1, 2, // Public
+		1,             // UseTCSDActivationFormat
+		0, 0, 0, 1, 3, // CreateData
+		0, 0, 0, 0, // CreateAttestation
+		0, 0, 0, 3, 4, 5, 6, // CreateSignature
+	)
+	if got := params.CanonicalBytes(); !bytes.Equal(got, want) {
+		t.Errorf("CanonicalBytes() = %x, want %x", got, want)
+	}
+
+	// Moving bytes between fields, or changing any field, changes the
+	// encoding.
+	for _, other := range []AttestationParameters{
+		{Public: []byte{1}, UseTCSDActivationFormat: true, CreateData: []byte{2, 3}, CreateSignature: []byte{4, 5, 6}},
+		{Public: []byte{1, 2}, CreateData: []byte{3}, CreateSignature: []byte{4, 5, 6}},
+		{Public: []byte{1, 2}, UseTCSDActivationFormat: true, CreateData: []byte{3}, CreateAttestation: []byte{4, 5, 6}},
+		{Public: []byte{1, 2}, UseTCSDActivationFormat: true, CreateData: []byte{3}, CreateSignature: []byte{4, 5, 6, 0}},
+	} {
+		if bytes.Equal(other.CanonicalBytes(), want) {
+			t.Errorf("CanonicalBytes() of %+v matches that of %+v", other, params)
+		}
+	}
+
+	// The encoding is unchanged by a JSON round trip.
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatalf("json.Marshal() failed: %v", err)
+	}
+	var decoded AttestationParameters
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v", err)
+	}
+	if !bytes.Equal(decoded.CanonicalBytes(), want) {
+		t.Errorf("CanonicalBytes() after a JSON round trip = %x, want %x", decoded.CanonicalBytes(), want)
+	}
+}
+
+func TestActivationTPM20CredentialParameters(t *testing.T) {
+	priv := ekCertSigner(t)
+
+	var digests [][]byte
+	for _, test := range []struct {
+		blockSize  int
+		wantCipher string
+	}{
+		{0, "AES-128-CFB"},
+		{32, "AES-256-CFB"},
+	} {
+		params := ActivationParameters{
+			TPMVersion: TPMVersion20,
+			AK:         rsaAKParameters(t),
+			EK: &rsa.PublicKey{
+				E: priv.E,
+				N: priv.N,
+			},
+			SymmetricBlockSize: test.blockSize,
+		}
+		secret, ec, err := params.Generate()
+		if err != nil {
+			t.Fatalf("Generate() failed: %v", err)
+		}
+		if ec.Parameters == nil {
+			t.Fatal("Generate() returned no credential parameters")
+		}
+		if got := ec.Parameters.Cipher; got != test.wantCipher {
+			t.Errorf("Cipher = %q, want %q", got, test.wantCipher)
+		}
+		if got, want := ec.Parameters.KDFHash, crypto.SHA256; got != want {
+			t.Errorf("KDFHash = %v, want %v", got, want)
+		}
+		if got := len(ec.Parameters.SeedDigest); got != sha256.Size {
+			t.Errorf("len(SeedDigest) = %d, want %d", got, sha256.Size)
+		}
+		if bytes.Contains(ec.Parameters.SeedDigest, secret) {
+			t.Error("SeedDigest contains the activation secret")
+		}
+		wantName, err := AKFingerprint(params.AK)
+		if err != nil {
+			t.Fatalf("AKFingerprint() failed: %v", err)
+		}
+		if !bytes.Equal(ec.Parameters.AKName, wantName) {
+			t.Errorf("AKName = %x, want %x", ec.Parameters.AKName, wantName)
+		}
+		digests = append(digests, ec.Parameters.SeedDigest)
+	}
+	if bytes.Equal(digests[0], digests[1]) {
+		t.Error("SeedDigest is identical for distinct challenges")
+	}
+}
+
+func TestActivationEKTooSmall(t *testing.T) {
+	priv := ekCertSigner(t)
+	weakPriv, err := rsa.GenerateKey(cryptorand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() failed: %v", err)
+	}
+	weakEK := &weakPriv.PublicKey
+	p224 := elliptic.P224().Params()
+	weakECCEK := &ecdsa.PublicKey{Curve: elliptic.P224(), X: p224.Gx, Y: p224.Gy}
+
+	data, err := os.ReadFile("testdata/linux_tpm12.json")
+	if err != nil {
+		t.Fatalf("reading test data: %v", err)
+	}
+	var dump Dump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		t.Fatalf("parsing test data: %v", err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		version   TPMVersion
+		ak        AttestationParameters
+		ek        crypto.PublicKey
+		minEKBits int
+		wantErr   bool
+	}{
+		{"TPM 2.0 RSA 1024", TPMVersion20, rsaAKParameters(t), weakEK, 0, true},
+		{"TPM 2.0 RSA 1024 allowed", TPMVersion20, rsaAKParameters(t), weakEK, 1024, false},
+		{"TPM 2.0 RSA 2048 with 3072 minimum", TPMVersion20, rsaAKParameters(t), &priv.PublicKey, 3072, true},
+		{"TPM 2.0 ECC P-224", TPMVersion20, rsaAKParameters(t), weakECCEK, 0, true},
+		{"TPM 1.2 RSA 1024", TPMVersion12, dump.AK, weakEK, 0, true},
+		{"TPM 1.2 RSA 2048", TPMVersion12, dump.AK, &priv.PublicKey, 0, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.version == TPMVersion12 {
+				skipWithoutTPM12(t)
+			}
+			params := ActivationParameters{
+				TPMVersion: test.version,
+				AK:         test.ak,
+				EK:         test.ek,
+				MinEKBits:  test.minEKBits,
+			}
+			_, _, err := params.Generate()
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("Generate() returned err = %v, wantErr %v", err, test.wantErr)
+			}
+			if test.wantErr && !errors.Is(err, ErrEKTooSmall) {
+				t.Errorf("Generate() err = %v, want errors.Is(err, ErrEKTooSmall)", err)
+			}
+		})
+	}
+}
+
+func TestCheckAKParametersMinimumSize(t *testing.T) {
+	data, err := os.ReadFile("testdata/linux_tpm12.json")
+	if err != nil {
+		t.Fatalf("reading test data: %v", err)
+	}
+	var dump Dump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		t.Fatalf("parsing test data: %v", err)
+	}
+
+	for _, test := range []struct {
+		name         string
+		version      TPMVersion
+		ak           AttestationParameters
+		minAKBits    int
+		minAKECCBits int
+		wantErr      bool
+	}{
+		{"TPM 2.0 RSA 2048", TPMVersion20, rsaAKParameters(t), 0, 0, false},
+		{"TPM 2.0 RSA 2048 with 3072 minimum", TPMVersion20, rsaAKParameters(t), 3072, 0, true},
+		{"TPM 2.0 ECC P-256", TPMVersion20, eccAKParameters(t), 0, 0, false},
+		{"TPM 2.0 ECC P-256 with 3072 RSA minimum", TPMVersion20, eccAKParameters(t), 3072, 0, false},
+		{"TPM 2.0 ECC P-256 with 384 minimum", TPMVersion20, eccAKParameters(t), 0, 384, true},
+		{"TPM 1.2 RSA 2048 with 3072 minimum", TPMVersion12, dump.AK, 3072, 0, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.version == TPMVersion12 {
+				skipWithoutTPM12(t)
+			}
+			params := ActivationParameters{
+				TPMVersion:   test.version,
+				AK:           test.ak,
+				MinAKBits:    test.minAKBits,
+				MinAKECCBits: test.minAKECCBits,
+			}
+			err := params.CheckAKParameters()
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("CheckAKParameters() returned err = %v, wantErr %v", err, test.wantErr)
+			}
+			if test.wantErr && !errors.Is(err, ErrAKTooSmall) {
+				t.Errorf("CheckAKParameters() err = %v, want errors.Is(err, ErrAKTooSmall)", err)
+			}
+		})
+	}
+}
+
+func TestActivationTPM12NonRSAKeys(t *testing.T) {
+	skipWithoutTPM12(t)
+	priv := ekCertSigner(t)
+	p256 := elliptic.P256().Params()
+	eccEK := &ecdsa.PublicKey{Curve: elliptic.P256(), X: p256.Gx, Y: p256.Gy}
+
+	data, err := os.ReadFile("testdata/linux_tpm12.json")
+	if err != nil {
+		t.Fatalf("reading test data: %v", err)
+	}
+	var dump Dump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		t.Fatalf("parsing test data: %v", err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		ak      AttestationParameters
+		ek      crypto.PublicKey
+		wantErr string
+	}{
+		{"ECC AK", eccAKParameters(t), &priv.PublicKey, "TPM 1.2 supports only RSA attestation keys"},
+		{"ECC EK", dump.AK, eccEK, "TPM 1.2 supports only RSA endorsement keys"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			params := ActivationParameters{
+				TPMVersion: TPMVersion12,
+				AK:         test.ak,
+				EK:         test.ek,
+				MinEKBits:  256,
+			}
+			_, _, err := params.Generate()
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Generate() returned %v, want error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// withAttributes returns a copy of the given AK parameters where the AK's
+// public area has the given object attributes.
+func withAttributes(t *testing.T, ak AttestationParameters, attrs tpm2.KeyProp) AttestationParameters {
+	t.Helper()
+	pub, err := tpm2.DecodePublic(ak.Public)
+	if err != nil {
+		t.Fatalf("DecodePublic() failed: %v", err)
+	}
+	pub.Attributes = attrs
+	if ak.Public, err = pub.Encode(); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	return ak
+}
+
+func TestActivationTPM20BadAttributes(t *testing.T) {
+	for _, ak := range []struct {
+		name   string
+		params func(testing.TB) AttestationParameters
+	}{
+		{"RSA", rsaAKParameters},
+		{"ECC", eccAKParameters},
+	} {
+		for _, test := range []struct {
+			name    string
+			attrs   tpm2.KeyProp
+			wantErr string
+			wantIs  error
+		}{
+			{
+				name:    "exportable",
+				attrs:   tpm2.FlagSignerDefault &^ tpm2.FlagFixedTPM,
+				wantErr: "AK is exportable",
+				wantIs:  ErrAKExportable,
+			},
+			{
+				name:    "not restricted",
+				attrs:   tpm2.FlagSignerDefault &^ tpm2.FlagRestricted,
+				wantErr: "provided key is not limited to attestation",
+				wantIs:  ErrAKNotRestricted,
+			},
+			{
+				name:    "not fixed parent",
+				attrs:   tpm2.FlagSignerDefault &^ tpm2.FlagFixedParent,
+				wantErr: "provided key is not limited to attestation",
+				wantIs:  ErrAKNotRestricted,
+			},
+			{
+				name:    "not sensitive data origin",
+				attrs:   tpm2.FlagSignerDefault &^ tpm2.FlagSensitiveDataOrigin,
+				wantErr: "provided key is not limited to attestation",
+				wantIs:  ErrAKNotRestricted,
+			},
+			{
+				name:    "restricted decrypt",
+				attrs:   tpm2.FlagSignerDefault&^tpm2.FlagSign | tpm2.FlagDecrypt,
+				wantErr: "provided key is not a signing key",
+				wantIs:  ErrAKNotSigning,
+			},
+			{
+				name:    "sign and decrypt",
+				attrs:   tpm2.FlagSignerDefault | tpm2.FlagDecrypt,
+				wantErr: "provided key is not a signing key",
+				wantIs:  ErrAKNotSigning,
+			},
+		} {
+			t.Run(ak.name+"/"+test.name, func(t *testing.T) {
+				priv := ekCertSigner(t)
+				params := ActivationParameters{
+					TPMVersion: TPMVersion20,
+					AK:         withAttributes(t, ak.params(t), test.attrs),
+					EK: &rsa.PublicKey{
+						E: priv.E,
+						N: priv.N,
+					},
+				}
+				_, _, err := params.Generate()
+				if err == nil || err.Error() != test.wantErr {
+					t.Errorf("Generate() err = %v, want %q", err, test.wantErr)
+				}
+				if !errors.Is(err, test.wantIs) {
+					t.Errorf("Generate() err = %v, want errors.Is(err, %v)", err, test.wantIs)
+				}
+			})
+		}
+	}
+}
+
+func TestCheckAKParametersErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		modify func(t *testing.T, ak *AttestationParameters)
+		wantIs error
+	}{
+		{
+			name: "too small",
+			modify: func(t *testing.T, ak *AttestationParameters) {
+				pub, err := tpm2.DecodePublic(ak.Public)
+				if err != nil {
+					t.Fatalf("DecodePublic() failed: %v", err)
+				}
+				pub.RSAParameters.KeyBits = 1024
+				if ak.Public, err = pub.Encode(); err != nil {
+					t.Fatalf("Encode() failed: %v", err)
+				}
+			},
+			wantIs: ErrAKTooSmall,
+		},
+		{
+			name: "wrong magic",
+			modify: func(t *testing.T, ak *AttestationParameters) {
+				ak.CreateAttestation = append([]byte{0, 0, 0, 0}, ak.CreateAttestation[4:]...)
+			},
+			wantIs: ErrAKNotTPMGenerated,
+		},
+		{
+			name: "creation data mismatch",
+			modify: func(t *testing.T, ak *AttestationParameters) {
+				ak.CreateData = append(append([]byte(nil), ak.CreateData...), 0)
+			},
+			wantIs: ErrAKNameMismatch,
+		},
+		{
+			name: "bad signature",
+			modify: func(t *testing.T, ak *AttestationParameters) {
+				ak.CreateSignature = append([]byte(nil), ak.CreateSignature...)
+				ak.CreateSignature[len(ak.CreateSignature)-1] ^= 0xff
+			},
+			wantIs: ErrAKSignatureInvalid,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ak := rsaAKParameters(t)
+			test.modify(t, &ak)
+			params := ActivationParameters{
+				TPMVersion: TPMVersion20,
+				AK:         ak,
+			}
+			if err := params.CheckAKParameters(); !errors.Is(err, test.wantIs) {
+				t.Errorf("CheckAKParameters() err = %v, want errors.Is(err, %v)", err, test.wantIs)
+			}
+		})
+	}
+
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         rsaAKParameters(t),
+	}
+	if err := params.CheckAKParameters(); err != nil {
+		t.Errorf("CheckAKParameters() failed on valid parameters: %v", err)
+	}
+}
+
+func TestCheckAKTemplate(t *testing.T) {
+	// The RSA AK was created with an authPolicy, so its template differs
+	// from the default one.
+	pub, err := tpm2.DecodePublic(rsaAKParameters(t).Public)
+	if err != nil {
+		t.Fatalf("DecodePublic() failed: %v", err)
+	}
+	rsaDigest, err := AKTemplateDigest(pub)
+	if err != nil {
+		t.Fatalf("AKTemplateDigest(RSA) failed: %v", err)
+	}
+	defaultRSADigest, err := AKTemplateDigest(akTemplateRSA)
+	if err != nil {
+		t.Fatalf("AKTemplateDigest(RSA) failed: %v", err)
+	}
+	eccDigest, err := AKTemplateDigest(akTemplateECC)
+	if err != nil {
+		t.Fatalf("AKTemplateDigest(ECC) failed: %v", err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		ak      AttestationParameters
+		allowed [][]byte
+		wantErr bool
+	}{
+		{"RSA allowed", rsaAKParameters(t), [][]byte{eccDigest, rsaDigest}, false},
+		{"ECC allowed", eccAKParameters(t), [][]byte{eccDigest}, false},
+		{"RSA not allowed", rsaAKParameters(t), [][]byte{eccDigest}, true},
+		{"RSA default template", rsaAKParameters(t), [][]byte{defaultRSADigest}, true},
+		{"no templates", rsaAKParameters(t), nil, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p := ActivationParameters{
+				TPMVersion:         TPMVersion20,
+				AK:                 test.ak,
+				AllowedAKTemplates: test.allowed,
+			}
+			err := p.CheckAKParameters()
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("CheckAKParameters() returned err = %v, wantErr %v", err, test.wantErr)
+			}
+			if test.wantErr && !errors.Is(err, ErrAKTemplateNotAllowed) {
+				t.Errorf("CheckAKParameters() err = %v, want errors.Is(err, ErrAKTemplateNotAllowed)", err)
+			}
+		})
+	}
+
+	// A template differing only in its attributes is not allowed.
+	modified := akTemplateRSA
+	modified.Attributes ^= tpm2.FlagNoDA
+	modifiedDigest, err := AKTemplateDigest(modified)
+	if err != nil {
+		t.Fatalf("AKTemplateDigest() failed: %v", err)
+	}
+	if bytes.Equal(modifiedDigest, defaultRSADigest) {
+		t.Error("AKTemplateDigest() ignored a change of attributes")
+	}
+
+	t.Run("TPM 1.2", func(t *testing.T) {
+		skipWithoutTPM12(t)
+		data, err := os.ReadFile("testdata/linux_tpm12.json")
+		if err != nil {
+			t.Fatalf("reading test data: %v", err)
+		}
+		var dump Dump
+		if err := json.Unmarshal(data, &dump); err != nil {
+			t.Fatalf("parsing test data: %v", err)
+		}
+		p := ActivationParameters{TPMVersion: TPMVersion12, AK: dump.AK, AllowedAKTemplates: [][]byte{rsaDigest}}
+		if err := p.CheckAKParameters(); !errors.Is(err, ErrAKTemplateNotAllowed) {
+			t.Errorf("CheckAKParameters() err = %v, want errors.Is(err, ErrAKTemplateNotAllowed)", err)
+		}
+	})
+}
+
+func TestCheckAKParametersVerbose(t *testing.T) {
+	ak := rsaAKParameters(t)
+	pub, err := tpm2.DecodePublic(ak.Public)
+	if err != nil {
+		t.Fatalf("DecodePublic() failed: %v", err)
+	}
+	pub.RSAParameters.KeyBits = 1024
+	pub.Attributes &^= tpm2.FlagFixedTPM
+	if ak.Public, err = pub.Encode(); err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	ak.CreateSignature[len(ak.CreateSignature)-1] ^= 0xff
+
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         ak,
+	}
+	errs := params.CheckAKParametersVerbose()
+	for _, want := range []error{ErrAKExportable, ErrAKTooSmall, ErrAKNameMismatch, ErrAKSignatureInvalid} {
+		found := false
+		for _, err := range errs {
+			found = found || errors.Is(err, want)
+		}
+		if !found {
+			t.Errorf("CheckAKParametersVerbose() = %v, want an error matching %v", errs, want)
+		}
+	}
+	// The first failure is the one reported by CheckAKParameters.
+	if err := params.CheckAKParameters(); len(errs) == 0 || err.Error() != errs[0].Error() {
+		t.Errorf("CheckAKParameters() = %v, want the first of %v", err, errs)
+	}
+
+	for _, ak := range []AttestationParameters{rsaAKParameters(t), eccAKParameters(t)} {
+		params := ActivationParameters{
+			TPMVersion: TPMVersion20,
+			AK:         ak,
+		}
+		if errs := params.CheckAKParametersVerbose(); errs != nil {
+			t.Errorf("CheckAKParametersVerbose() = %v for valid parameters, want nil", errs)
+		}
+	}
+}
+
+func TestCheckedAKAttestation(t *testing.T) {
+	ak := rsaAKParameters(t)
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         ak,
+	}
+	att, err := params.CheckedAKAttestation()
+	if err != nil {
+		t.Fatalf("CheckedAKAttestation() failed: %v", err)
+	}
+	if att.Type != tpm2.TagAttestCreation {
+		t.Errorf("attestation type = %x, want %x", att.Type, tpm2.TagAttestCreation)
+	}
+	pub, err := tpm2.DecodePublic(ak.Public)
+	if err != nil {
+		t.Fatalf("DecodePublic() failed: %v", err)
+	}
+	if match, err := VerifyKeyName(att.AttestedCreationInfo.Name, pub); err != nil || !match {
+		t.Errorf("attested creation name does not match AK: match = %v, err = %v", match, err)
+	}
+
+	params.AK.CreateSignature = append([]byte(nil), ak.CreateSignature...)
+	params.AK.CreateSignature[len(ak.CreateSignature)-1] ^= 0xff
+	if _, err := params.CheckedAKAttestation(); !errors.Is(err, ErrAKSignatureInvalid) {
+		t.Errorf("CheckedAKAttestation() with a bad signature err = %v, want errors.Is(err, %v)", err, ErrAKSignatureInvalid)
+	}
+
+	params.TPMVersion = TPMVersion12
+	if _, err := params.CheckedAKAttestation(); err == nil {
+		t.Error("CheckedAKAttestation() for TPM 1.2 succeeded, want error")
+	}
+}
+
+func TestCheckAKParametersTPM2BAttest(t *testing.T) {
+	for _, ak := range []AttestationParameters{rsaAKParameters(t), eccAKParameters(t)} {
+		raw := ActivationParameters{TPMVersion: TPMVersion20, AK: ak}
+		want, err := raw.CheckedAKAttestation()
+		if err != nil {
+			t.Fatalf("CheckedAKAttestation() with a TPMS_ATTEST failed: %v", err)
+		}
+
+		wrapped := raw
+		wrapped.AK.CreateAttestation = append(binary.BigEndian.AppendUint16(nil, uint16(len(ak.CreateAttestation))), ak.CreateAttestation...)
+		got, err := wrapped.CheckedAKAttestation()
+		if err != nil {
+			t.Fatalf("CheckedAKAttestation() with a TPM2B_ATTEST failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("CheckedAKAttestation() with a TPM2B_ATTEST = %+v, want %+v", got, want)
+		}
+		wantCI, err := raw.AK.CreationClockInfo()
+		if err != nil {
+			t.Fatalf("CreationClockInfo() with a TPMS_ATTEST failed: %v", err)
+		}
+		gotCI, err := wrapped.AK.CreationClockInfo()
+		if err != nil {
+			t.Fatalf("CreationClockInfo() with a TPM2B_ATTEST failed: %v", err)
+		}
+		if *gotCI != *wantCI {
+			t.Errorf("CreationClockInfo() with a TPM2B_ATTEST = %+v, want %+v", gotCI, wantCI)
+		}
+		if !bytes.Equal(wrapped.AK.CanonicalBytes(), raw.AK.CanonicalBytes()) {
+			t.Error("CanonicalBytes() differ between a TPM2B_ATTEST and a TPMS_ATTEST")
+		}
+
+		// A size prefix not matching the structure is not stripped.
+		wrapped.AK.CreateAttestation = append(binary.BigEndian.AppendUint16(nil, uint16(len(ak.CreateAttestation)+1)), ak.CreateAttestation...)
+		if err := wrapped.CheckAKParameters(); err == nil {
+			t.Error("CheckAKParameters() with a mismatched TPM2B_ATTEST size succeeded, want error")
+		}
+	}
+}
+
+func TestCheckAKParametersHighS(t *testing.T) {
+	ak := eccAKParameters(t)
+	sig, err := tpm2.DecodeSignature(bytes.NewBuffer(ak.CreateSignature))
+	if err != nil {
+		t.Fatalf("DecodeSignature() failed: %v", err)
+	}
+	// Negating S yields the other form of the signature.
+	n := elliptic.P256().Params().N
+	other := *sig.ECC
+	other.S = new(big.Int).Sub(n, sig.ECC.S)
+	otherSig := &tpm2.Signature{Alg: sig.Alg, ECC: &other}
+	lowSig, highSig := sig, otherSig
+	if isHighS(elliptic.P256(), sig.ECC.S) {
+		lowSig, highSig = otherSig, sig
+	}
+	low, err := lowSig.Encode()
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	high, err := highSig.Encode()
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+
+	for _, test := range []struct {
+		name        string
+		sig         []byte
+		requireLowS bool
+		wantErr     bool
+	}{
+		{"low-S", low, false, false},
+		{"high-S", high, false, false},
+		{"low-S with RequireLowS", low, true, false},
+		{"high-S with RequireLowS", high, true, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			params := ActivationParameters{TPMVersion: TPMVersion20, AK: ak, RequireLowS: test.requireLowS}
+			params.AK.CreateSignature = test.sig
+			err := params.CheckAKParameters()
+			if !test.wantErr {
+				if err != nil {
+					t.Errorf("CheckAKParameters() failed: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrAKSignatureInvalid) {
+				t.Errorf("CheckAKParameters() = %v, want %v", err, ErrAKSignatureInvalid)
+			}
+		})
+	}
+
+	lowSig.ECC.S = new(big.Int).Add(lowSig.ECC.S, big.NewInt(1))
+	bad, err := lowSig.Encode()
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	params := ActivationParameters{TPMVersion: TPMVersion20, AK: ak}
+	params.AK.CreateSignature = bad
+	if err := params.CheckAKParameters(); !errors.Is(err, ErrAKSignatureInvalid) {
+		t.Errorf("CheckAKParameters() with a modified S = %v, want %v", err, ErrAKSignatureInvalid)
+	}
+}
+
+func TestGenerateNonCreationAttestation(t *testing.T) {
+	priv := ekCertSigner(t)
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         rsaAKParameters(t),
+		EK:         &rsa.PublicKey{E: priv.E, N: priv.N},
+	}
+	att, err := tpm2.DecodeAttestationData(params.AK.CreateAttestation)
+	if err != nil {
+		t.Fatalf("DecodeAttestationData() failed: %v", err)
+	}
+	quote := *att
+	quote.Type = tpm2.TagAttestQuote
+	quote.AttestedCreationInfo = nil
+	quote.AttestedQuoteInfo = &tpm2.QuoteInfo{
+		PCRSelection: tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: []int{0}},
+		PCRDigest:    make([]byte, sha256.Size),
+	}
+	encoded, err := quote.Encode()
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	withQuote := params
+	withQuote.AK.CreateAttestation = encoded
+	if _, _, err := withQuote.Generate(); err == nil {
+		t.Error("Generate() with a quote attestation succeeded, want error")
+	}
+
+	// The challenge itself rejects attestations which aren't of a
+	// creation, without relying on the AK checks.
+	ek, err := params.prepareEK()
+	if err != nil {
+		t.Fatalf("prepareEK() failed: %v", err)
+	}
+	noInfo := *att
+	noInfo.AttestedCreationInfo = nil
+	for _, test := range []struct {
+		name string
+		att  *tpm2.AttestationData
+		want string
+	}{
+		{"no attestation", nil, "no creation attestation"},
+		{"quote", &quote, "does not apply to creation data"},
+		{"no creation info", &noInfo, "not for a creation event"},
+	} {
+		_, err := params.generateChallengeTPM20(cryptorand.Reader, test.att, ek, nil, make([]byte, activationSecretLen))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: generateChallengeTPM20() = %v, want error containing %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestVerifyKeyName(t *testing.T) {
+	for _, alg := range []tpm2.Algorithm{tpm2.AlgSHA1, tpm2.AlgSHA256} {
+		t.Run(fmt.Sprintf("%v", alg), func(t *testing.T) {
+			pub, err := tpm2.DecodePublic(eccAKParameters(t).Public)
+			if err != nil {
+				t.Fatalf("DecodePublic() failed: %v", err)
+			}
+			pub.NameAlg = alg
+			name, err := pub.Name()
+			if err != nil {
+				t.Fatalf("Name() failed: %v", err)
+			}
+			if match, err := VerifyKeyName(name, pub); err != nil || !match {
+				t.Errorf("VerifyKeyName() = %v, %v, want true, nil", match, err)
+			}
+
+			// A name of the same key computed with a different algorithm
+			// must not match.
+			other := pub
+			if alg == tpm2.AlgSHA1 {
+				other.NameAlg = tpm2.AlgSHA256
+			} else {
+				other.NameAlg = tpm2.AlgSHA1
+			}
+			otherName, err := other.Name()
+			if err != nil {
+				t.Fatalf("Name() failed: %v", err)
+			}
+			if match, err := VerifyKeyName(otherName, pub); err != nil || match {
+				t.Errorf("VerifyKeyName() with a %v name = %v, %v, want false, nil", other.NameAlg, match, err)
+			}
+
+			name.Digest.Value = append([]byte(nil), name.Digest.Value...)
+			name.Digest.Value[0] ^= 0xff
+			if match, err := VerifyKeyName(name, pub); err != nil || match {
+				t.Errorf("VerifyKeyName() with a modified name = %v, %v, want false, nil", match, err)
+			}
+		})
+	}
+
+	if _, err := VerifyKeyName(tpm2.Name{Handle: new(tpmutil.Handle)}, tpm2.Public{}); err == nil {
+		t.Error("VerifyKeyName() with a handle name succeeded, want error")
+	}
+}
+
+func TestMatchEKCertificate(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	rsaCert := newTestEKCertificate(t, ca, caKey, marshalTestSAN(t, testEKCertSAN, false))
+	otherECC, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		ek      crypto.PublicKey
+		cert    *x509.Certificate
+		wantErr bool
+	}{
+		{"RSA", testRSAKey, rsaCert, false},
+		{"RSA mismatch", &ekCertSigner(t).PublicKey, rsaCert, true},
+		{"RSA exponent mismatch", &rsa.PublicKey{N: testRSAKey.N, E: 3}, rsaCert, true},
+		{"RSA EK with ECC certificate", testRSAKey, ca, true},
+		// The CA certificate stands in for an ECC EK certificate.
+		{"ECC", &caKey.PublicKey, ca, false},
+		{"ECC mismatch", &otherECC.PublicKey, ca, true},
+		{"ECC EK with RSA certificate", &caKey.PublicKey, rsaCert, true},
+		{"no certificate", testRSAKey, nil, true},
+		{"unsupported EK", []byte("ek"), rsaCert, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := MatchEKCertificate(test.ek, test.cert)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("MatchEKCertificate() returned err = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestAKFieldTooLarge(t *testing.T) {
+	ak := rsaAKParameters(t)
+	ak.Public = append(ak.Public, make([]byte, defaultMaxAKFieldSize)...)
+	for _, v := range []TPMVersion{TPMVersion12, TPMVersion20} {
+		if v == TPMVersion12 && !tpm12Built {
+			continue
+		}
+		params := ActivationParameters{
+			TPMVersion: v,
+			AK:         ak,
+		}
+		if err := params.CheckAKParameters(); !errors.Is(err, ErrAKFieldTooLarge) {
+			t.Errorf("CheckAKParameters() with version %v = %v, want %v", v, err, ErrAKFieldTooLarge)
+		}
+	}
+
+	// The limit is configurable.
+	params := ActivationParameters{
+		TPMVersion:     TPMVersion20,
+		AK:             rsaAKParameters(t),
+		MaxAKFieldSize: 64,
+	}
+	if err := params.CheckAKParameters(); !errors.Is(err, ErrAKFieldTooLarge) {
+		t.Errorf("CheckAKParameters() with MaxAKFieldSize 64 = %v, want %v", err, ErrAKFieldTooLarge)
+	}
+	params.MaxAKFieldSize = 4096
+	if err := params.CheckAKParameters(); err != nil {
+		t.Errorf("CheckAKParameters() with MaxAKFieldSize 4096 failed: %v", err)
+	}
+}
+
+func TestEKAlgorithm(t *testing.T) {
+	eccKey, err := ecdsa.GenerateKey(elliptic.P384(), cryptorand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+	ecdhKey, err := eccKey.PublicKey.ECDH()
+	if err != nil {
+		t.Fatalf("ECDH() failed: %v", err)
+	}
+	x25519Key, err := ecdh.X25519().GenerateKey(cryptorand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		ek      crypto.PublicKey
+		want    Algorithm
+		wantErr bool
+	}{
+		{"RSA", testRSAKey, RSA, false},
+		{"ECDSA", &eccKey.PublicKey, ECDSA, false},
+		{"ECDH", ecdhKey, ECDSA, false},
+		{"X25519", x25519Key.PublicKey(), "", true},
+		{"nil", nil, "", true},
+		{"unsupported", []byte("ek"), "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := EKAlgorithm(test.ek)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("EKAlgorithm() returned err = %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("EKAlgorithm() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifySecret(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	for _, test := range []struct {
+		name     string
+		expected []byte
+		got      []byte
+		want     bool
+	}{
+		{"match", secret, append([]byte(nil), secret...), true},
+		{"mismatch", secret, []byte("0123456789abcdef0123456789abcdeX"), false},
+		{"short", secret, secret[:16], false},
+		{"long", secret, append(append([]byte(nil), secret...), 0), false},
+		{"got empty", secret, nil, false},
+		{"expected empty", nil, secret, false},
+		{"both empty", nil, nil, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := VerifySecret(test.expected, test.got); got != test.want {
+				t.Errorf("VerifySecret() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestActivator(t *testing.T) {
+	priv := ekCertSigner(t)
+	ek := &rsa.PublicKey{E: priv.E, N: priv.N}
+
+	a, err := NewActivator(ActivatorConfig{
+		TPMVersion: TPMVersion20,
+		Rand:       rand.New(rand.NewSource(123456)),
+	})
+	if err != nil {
+		t.Fatalf("NewActivator() failed: %v", err)
+	}
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         rsaAKParameters(t),
+		EK:         ek,
+		Rand:       rand.New(rand.NewSource(123456)),
+	}
+	wantSecret, wantEC, err := params.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	secret, ec, err := a.Challenge(ek, rsaAKParameters(t))
+	if err != nil {
+		t.Fatalf("Challenge() failed: %v", err)
+	}
+	if !bytes.Equal(secret, wantSecret) {
+		t.Errorf("Challenge() secret = %x, want %x", secret, wantSecret)
+	}
+	if !reflect.DeepEqual(ec, wantEC) {
+		t.Errorf("Challenge() credential = %+v, want %+v", ec, wantEC)
+	}
+
+	if _, _, err := a.Challenge(ek, eccAKParameters(t)); err != nil {
+		t.Errorf("Challenge() with ECC AK failed: %v", err)
+	}
+	bad := rsaAKParameters(t)
+	bad.CreateSignature[len(bad.CreateSignature)-1] ^= 1
+	if _, _, err := a.Challenge(ek, bad); !errors.Is(err, ErrAKSignatureInvalid) {
+		t.Errorf("Challenge() with bad signature err = %v, want %v", err, ErrAKSignatureInvalid)
+	}
+}
+
+// activateCredential20 recovers the secret of a TPM 2.0 credential
+// encrypted to the RSA EK ek, as TPM2_ActivateCredential does.
+func activateCredential20(ek *rsa.PrivateKey, ec *EncryptedCredential) ([]byte, error) {
+	var encSeed, idObject, integrity tpmutil.U16Bytes
+	if err := tpmutil.UnpackBuf(bytes.NewBuffer(ec.Secret), &encSeed); err != nil {
+		return nil, fmt.Errorf("decoding secret: %v", err)
+	}
+	seed, err := rsa.DecryptOAEP(sha256.New(), nil, ek, encSeed, []byte(labelIdentity+"\x00"))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting seed: %v", err)
+	}
+	if err := tpmutil.UnpackBuf(bytes.NewBuffer(ec.Credential), &idObject); err != nil {
+		return nil, fmt.Errorf("decoding credential: %v", err)
+	}
+	buf := bytes.NewBuffer(idObject)
+	if err := tpmutil.UnpackBuf(buf, &integrity); err != nil {
+		return nil, fmt.Errorf("decoding integrity HMAC: %v", err)
+	}
+	encIdentity := buf.Bytes()
+
+	name := ec.Parameters.AKName
+	macKey, err := tpm2.KDFa(tpm2.AlgSHA256, seed, labelIntegrity, nil, nil, 256)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(encIdentity)
+	mac.Write(name)
+	if !hmac.Equal(mac.Sum(nil), integrity) {
+		return nil, errors.New("integrity HMAC does not match")
+	}
+	symKey, err := tpm2.KDFa(tpm2.AlgSHA256, seed, labelStorage, name, nil, 128)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(symKey)
+	if err != nil {
+		return nil, err
+	}
+	cv := make([]byte, len(encIdentity))
+	cipher.NewCFBDecrypter(block, make([]byte, aes.BlockSize)).XORKeyStream(cv, encIdentity)
+	var secret tpmutil.U16Bytes
+	if err := tpmutil.UnpackBuf(bytes.NewBuffer(cv), &secret); err != nil {
+		return nil, fmt.Errorf("decoding secret: %v", err)
+	}
+	return secret, nil
+}
+
+func TestActivatorConcurrent(t *testing.T) {
+	priv := ekCertSigner(t)
+	ek := &rsa.PublicKey{E: priv.E, N: priv.N}
+	a, err := NewActivator(ActivatorConfig{TPMVersion: TPMVersion20})
+	if err != nil {
+		t.Fatalf("NewActivator() failed: %v", err)
+	}
+	bound, err := a.ForEK(ek)
+	if err != nil {
+		t.Fatalf("ForEK() failed: %v", err)
+	}
+	ak := rsaAKParameters(t)
+
+	// Challenges share the hash states and buffers of the Activator, which
+	// must not leak from one challenge into another.
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				secret, ec, err := a.Challenge(ek, ak)
+				if j%2 == 1 {
+					secret, ec, err = bound.Challenge(ak)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				got, err := activateCredential20(priv, ec)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(got, secret) {
+					errs <- fmt.Errorf("activated secret %x, want %x", got, secret)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Challenge() failed: %v", err)
+	}
+}
+
+func TestActivatorPolicies(t *testing.T) {
+	priv := ekCertSigner(t)
+	ek := &rsa.PublicKey{E: priv.E, N: priv.N}
+	eccDigest, err := AKTemplateDigest(akTemplateECC)
+	if err != nil {
+		t.Fatalf("AKTemplateDigest(ECC) failed: %v", err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		cfg     ActivatorConfig
+		wantErr error
+	}{
+		{"AllowedAKTemplates", ActivatorConfig{AllowedAKTemplates: [][]byte{eccDigest}}, ErrAKTemplateNotAllowed},
+		{"MaxAKFieldSize", ActivatorConfig{MaxAKFieldSize: 64}, ErrAKFieldTooLarge},
+		{"RequireEndorsementHierarchy", ActivatorConfig{RequireEndorsementHierarchy: true}, ErrAKNotInEndorsementHierarchy},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.cfg.TPMVersion = TPMVersion20
+			a, err := NewActivator(test.cfg)
+			if err != nil {
+				t.Fatalf("NewActivator() failed: %v", err)
+			}
+			if _, _, err := a.Challenge(ek, rsaAKParameters(t)); !errors.Is(err, test.wantErr) {
+				t.Errorf("Challenge() err = %v, want %v", err, test.wantErr)
+			}
+			bound, err := a.ForEK(ek)
+			if err != nil {
+				t.Fatalf("ForEK() failed: %v", err)
+			}
+			if _, _, err := bound.Challenge(rsaAKParameters(t)); !errors.Is(err, test.wantErr) {
+				t.Errorf("EKActivator.Challenge() err = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+
+	aad := []byte("session")
+	a, err := NewActivator(ActivatorConfig{
+		TPMVersion: TPMVersion20,
+		Rand:       rand.New(rand.NewSource(123456)),
+		AAD:        aad,
+	})
+	if err != nil {
+		t.Fatalf("NewActivator() failed: %v", err)
+	}
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         rsaAKParameters(t),
+		EK:         ek,
+		Rand:       rand.New(rand.NewSource(123456)),
+		AAD:        aad,
+	}
+	wantSecret, _, err := params.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	secret, _, err := a.Challenge(ek, rsaAKParameters(t))
+	if err != nil {
+		t.Fatalf("Challenge() failed: %v", err)
+	}
+	if !bytes.Equal(secret, wantSecret) {
+		t.Errorf("Challenge() with AAD secret = %x, want %x", secret, wantSecret)
+	}
+
+	a, err = NewActivator(ActivatorConfig{TPMVersion: TPMVersion20, RecipientKey: &priv.PublicKey})
+	if err != nil {
+		t.Fatalf("NewActivator() failed: %v", err)
+	}
+	secret, ec, err := a.Challenge(ek, rsaAKParameters(t))
+	if err != nil {
+		t.Fatalf("Challenge() failed: %v", err)
+	}
+	if secret != nil || len(ec.SealedSecret) == 0 {
+		t.Errorf("Challenge() with RecipientKey = %x, sealed secret %x, want nil secret and a sealed secret", secret, ec.SealedSecret)
+	}
+}
+
+func TestEKActivator(t *testing.T) {
+	priv := ekCertSigner(t)
+	eccPriv, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+	for _, ek := range []crypto.PublicKey{&rsa.PublicKey{E: priv.E, N: priv.N}, &eccPriv.PublicKey} {
+		a, err := NewActivator(ActivatorConfig{
+			TPMVersion: TPMVersion20,
+			Rand:       rand.New(rand.NewSource(123456)),
+		})
+		if err != nil {
+			t.Fatalf("NewActivator() failed: %v", err)
+		}
+		bound, err := a.ForEK(ek)
+		if err != nil {
+			t.Fatalf("ForEK(%T) failed: %v", ek, err)
+		}
+		params := ActivationParameters{
+			TPMVersion: TPMVersion20,
+			EK:         ek,
+			Rand:       rand.New(rand.NewSource(123456)),
+		}
+		for _, ak := range []AttestationParameters{rsaAKParameters(t), eccAKParameters(t)} {
+			params.AK = ak
+			wantSecret, wantEC, err := params.Generate()
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			secret, ec, err := bound.Challenge(ak)
+			if err != nil {
+				t.Fatalf("Challenge() failed: %v", err)
+			}
+			if !bytes.Equal(secret, wantSecret) || !reflect.DeepEqual(ec, wantEC) {
+				t.Errorf("Challenge() with %T EK = %x, %+v, want %x, %+v", ek, secret, ec, wantSecret, wantEC)
+			}
+		}
+		bad := rsaAKParameters(t)
+		bad.CreateSignature[len(bad.CreateSignature)-1] ^= 1
+		if _, _, err := bound.Challenge(bad); !errors.Is(err, ErrAKSignatureInvalid) {
+			t.Errorf("Challenge() with bad signature err = %v, want %v", err, ErrAKSignatureInvalid)
+		}
+	}
+
+	a, err := NewActivator(ActivatorConfig{TPMVersion: TPMVersion20})
+	if err != nil {
+		t.Fatalf("NewActivator() failed: %v", err)
+	}
+	small, err := rsa.GenerateKey(cryptorand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+	if _, err := a.ForEK(small.Public()); !errors.Is(err, ErrEKTooSmall) {
+		t.Errorf("ForEK() with a 1024 bit EK err = %v, want %v", err, ErrEKTooSmall)
+	}
+	if _, err := a.ForEK(nil); err == nil {
+		t.Error("ForEK(nil) succeeded, want error")
+	}
+}
+
+// xorChallengeGenerator is a ChallengeGenerator for tests, which "encrypts"
+// secrets by XORing them with a fixed key.
+type xorChallengeGenerator struct {
+	calls int
+	err   error
+}
+
+func (g *xorChallengeGenerator) GenerateChallenge(rnd io.Reader, p *ActivationParameters, secret []byte) (*EncryptedCredential, error) {
+	g.calls++
+	if g.err != nil {
+		return nil, g.err
+	}
+	enc := make([]byte, len(secret))
+	for i, b := range secret {
+		enc[i] = b ^ 0x5a
+	}
+	return &EncryptedCredential{Credential: enc, Secret: p.AK.Public}, nil
+}
+
+func TestActivationChallengeGenerator(t *testing.T) {
+	priv := ekCertSigner(t)
+	ek := &rsa.PublicKey{E: priv.E, N: priv.N}
+
+	g := &xorChallengeGenerator{}
+	params := ActivationParameters{
+		TPMVersion:         TPMVersion20,
+		AK:                 rsaAKParameters(t),
+		EK:                 ek,
+		ChallengeGenerator: g,
+	}
+	secret, ec, err := params.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	for i := range secret {
+		if ec.Credential[i] != secret[i]^0x5a {
+			t.Fatalf("Generate() credential = %x, want secret %x XOR 0x5a", ec.Credential, secret)
+		}
+	}
+	if !bytes.Equal(ec.Secret, params.AK.Public) {
+		t.Errorf("Generate() did not return the custom credential unchanged")
+	}
+
+	// The AK is still checked before the generator is called.
+	bad := rsaAKParameters(t)
+	bad.CreateSignature[len(bad.CreateSignature)-1] ^= 1
+	params.AK = bad
+	if _, _, err := params.Generate(); !errors.Is(err, ErrAKSignatureInvalid) {
+		t.Errorf("Generate() with bad signature err = %v, want %v", err, ErrAKSignatureInvalid)
+	}
+	if g.calls != 1 {
+		t.Errorf("generator called %d times, want 1", g.calls)
+	}
+
+	errCustom := errors.New("custom failure")
+	a, err := NewActivator(ActivatorConfig{
+		TPMVersion:         TPMVersion20,
+		ChallengeGenerator: &xorChallengeGenerator{err: errCustom},
+	})
+	if err != nil {
+		t.Fatalf("NewActivator() failed: %v", err)
+	}
+	if _, _, err := a.Challenge(ek, rsaAKParameters(t)); !errors.Is(err, errCustom) {
+		t.Errorf("Challenge() err = %v, want %v", err, errCustom)
+	}
+}
+
+func TestActivationAAD(t *testing.T) {
+	priv := ekCertSigner(t)
+	params := ActivationParameters{
+		TPMVersion:         TPMVersion20,
+		AK:                 rsaAKParameters(t),
+		EK:                 &rsa.PublicKey{E: priv.E, N: priv.N},
+		ChallengeGenerator: &xorChallengeGenerator{},
+		AAD:                []byte("session 1"),
+	}
+	secret, ec, err := params.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	// Recover the secret the device would obtain from the TPM.
+	activated := make([]byte, len(ec.Credential))
+	for i, b := range ec.Credential {
+		activated[i] = b ^ 0x5a
+	}
+	if VerifySecret(secret, activated) {
+		t.Fatal("secret bound to AAD matches the activated secret without derivation")
+	}
+
+	for _, test := range []struct {
+		aad  []byte
+		want bool
+	}{
+		{[]byte("session 1"), true},
+		{[]byte("session 2"), false},
+		{nil, false},
+	} {
+		got, err := DeriveActivationSecret(activated, test.aad)
+		if err != nil {
+			t.Fatalf("DeriveActivationSecret(%q) failed: %v", test.aad, err)
+		}
+		if len(got) != len(secret) {
+			t.Errorf("DeriveActivationSecret(%q) returned %d bytes, want %d", test.aad, len(got), len(secret))
+		}
+		if VerifySecret(secret, got) != test.want {
+			t.Errorf("VerifySecret() with AAD %q = %v, want %v", test.aad, !test.want, test.want)
+		}
+	}
+}
+
+func TestActivationEKResolver(t *testing.T) {
+	priv := ekCertSigner(t)
+	calls := 0
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         rsaAKParameters(t),
+		EKResolver: func() (crypto.PublicKey, error) {
+			calls++
+			return &rsa.PublicKey{E: priv.E, N: priv.N}, nil
+		},
+	}
+	if _, _, err := params.Generate(); err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("EK resolver called %d times, want 1", calls)
+	}
+	if params.EK != nil {
+		t.Error("Generate() set EK from the resolver")
+	}
+
+	// The resolver is not called when an EK is provided.
+	params.EK = &rsa.PublicKey{E: priv.E, N: priv.N}
+	if _, _, err := params.Generate(); err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("EK resolver called %d times with an EK provided, want 1", calls)
+	}
+
+	errLookup := errors.New("device not found")
+	params.EK = nil
+	params.EKResolver = func() (crypto.PublicKey, error) { return nil, errLookup }
+	if _, _, err := params.Generate(); !errors.Is(err, errLookup) {
+		t.Errorf("Generate() err = %v, want %v", err, errLookup)
+	}
+	params.EKResolver = func() (crypto.PublicKey, error) { return nil, nil }
+	if _, _, err := params.Generate(); err == nil {
+		t.Error("Generate() with a resolver returning no EK succeeded, want error")
+	}
+}
+
+func TestActivationRecipientKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+	eccKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+	ecdhKey, err := ecdh.P384().GenerateKey(cryptorand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+	priv := ekCertSigner(t)
+
+	for _, test := range []struct {
+		name      string
+		recipient crypto.PublicKey
+		priv      crypto.PrivateKey
+	}{
+		{"RSA", &rsaKey.PublicKey, rsaKey},
+		{"ECDSA", &eccKey.PublicKey, eccKey},
+		{"ECDH", ecdhKey.PublicKey(), ecdhKey},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			params := ActivationParameters{
+				TPMVersion:         TPMVersion20,
+				AK:                 rsaAKParameters(t),
+				EK:                 &rsa.PublicKey{E: priv.E, N: priv.N},
+				ChallengeGenerator: &xorChallengeGenerator{},
+				RecipientKey:       test.recipient,
+			}
+			secret, ec, err := params.Generate()
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			if secret != nil {
+				t.Errorf("Generate() returned a plaintext secret with a recipient key")
+			}
+			got, err := OpenSealedSecret(test.priv, ec.SealedSecret)
+			if err != nil {
+				t.Fatalf("OpenSealedSecret() failed: %v", err)
+			}
+			// Recover the secret the device would obtain from the TPM.
+			for i := range got {
+				if ec.Credential[i] != got[i]^0x5a {
+					t.Fatalf("OpenSealedSecret() = %x, want the activated secret", got)
+				}
+			}
+
+			tampered := append([]byte(nil), ec.SealedSecret...)
+			tampered[len(tampered)-1] ^= 1
+			if _, err := OpenSealedSecret(test.priv, tampered); err == nil {
+				t.Error("OpenSealedSecret() of a tampered secret succeeded, want error")
+			}
+		})
+	}
+
+	params := ActivationParameters{
+		TPMVersion:   TPMVersion20,
+		AK:           rsaAKParameters(t),
+		EK:           &rsa.PublicKey{E: priv.E, N: priv.N},
+		RecipientKey: []byte("key"),
+	}
+	if _, _, err := params.Generate(); err == nil {
+		t.Error("Generate() with an unsupported recipient key succeeded, want error")
+	}
+}
+
+func TestNewActivatorInvalidConfig(t *testing.T) {
+	for _, cfg := range []ActivatorConfig{
+		{},
+		{TPMVersion: TPMVersion20, SymmetricBlockSize: 8},
+		{TPMVersion: TPMVersion20, SecretLen: 8},
+		{TPMVersion: TPMVersion20, SecretLen: 128},
+		{TPMVersion: TPMVersion20, MinEKBits: -1},
+		{TPMVersion: TPMVersion20, MinAKBits: -1},
+		{TPMVersion: TPMVersion20, MaxAKFieldSize: -1},
+	} {
+		if _, err := NewActivator(cfg); err == nil {
+			t.Errorf("NewActivator(%+v) succeeded, want error", cfg)
+		}
+	}
+}
+
+// countAttestationDecodes counts calls to decodeAttestationData until the
+// test ends.
+func countAttestationDecodes(tb testing.TB) *int {
+	var n int
+	orig := decodeAttestationData
+	decodeAttestationData = func(in []byte) (*tpm2.AttestationData, error) {
+		n++
+		return orig(in)
+	}
+	tb.Cleanup(func() { decodeAttestationData = orig })
+	return &n
+}
+
+func TestGenerateDecodesAttestationOnce(t *testing.T) {
+	priv := ekCertSigner(t)
+	decodes := countAttestationDecodes(t)
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         rsaAKParameters(t),
+		EK:         &rsa.PublicKey{E: priv.E, N: priv.N},
+	}
+	if _, _, err := params.Generate(); err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	if *decodes != 1 {
+		t.Errorf("Generate() decoded the creation attestation %d times, want 1", *decodes)
+	}
+}
+
+func BenchmarkActivationParametersGenerate(b *testing.B) {
+	priv := ekCertSigner(b)
+	ek := &rsa.PublicKey{E: priv.E, N: priv.N}
+	ak := rsaAKParameters(b)
+	decodes := countAttestationDecodes(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		params := ActivationParameters{
+			TPMVersion: TPMVersion20,
+			AK:         ak,
+			EK:         ek,
+		}
+		if _, _, err := params.Generate(); err != nil {
+			b.Fatalf("Generate() failed: %v", err)
+		}
+	}
+	b.ReportMetric(float64(*decodes)/float64(b.N), "decodes/op")
+}
+
+func BenchmarkActivatorChallenge(b *testing.B) {
+	priv := ekCertSigner(b)
+	ek := &rsa.PublicKey{E: priv.E, N: priv.N}
+	ak := rsaAKParameters(b)
+	a, err := NewActivator(ActivatorConfig{TPMVersion: TPMVersion20})
+	if err != nil {
+		b.Fatalf("NewActivator() failed: %v", err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := a.Challenge(ek, ak); err != nil {
+			b.Fatalf("Challenge() failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkEKActivator compares generating challenges for the same EK with
+// ActivationParameters.Generate and with an EKActivator, which prepares the
+// EK once.
+func BenchmarkEKActivator(b *testing.B) {
+	priv := ekCertSigner(b)
+	eccPriv, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		b.Fatalf("GenerateKey() failed: %v", err)
+	}
+	ak := rsaAKParameters(b)
+	for _, test := range []struct {
+		name string
+		ek   crypto.PublicKey
+	}{
+		{"RSA", &rsa.PublicKey{E: priv.E, N: priv.N}},
+		{"ECC", &eccPriv.PublicKey},
+	} {
+		b.Run(test.name+"/Generate", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				params := ActivationParameters{
+					TPMVersion: TPMVersion20,
+					AK:         ak,
+					EK:         test.ek,
+				}
+				if _, _, err := params.Generate(); err != nil {
+					b.Fatalf("Generate() failed: %v", err)
+				}
+			}
+		})
+		b.Run(test.name+"/EKActivator", func(b *testing.B) {
+			a, err := NewActivator(ActivatorConfig{TPMVersion: TPMVersion20})
+			if err != nil {
+				b.Fatalf("NewActivator() failed: %v", err)
+			}
+			bound, err := a.ForEK(test.ek)
+			if err != nil {
+				b.Fatalf("ForEK() failed: %v", err)
+			}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := bound.Challenge(ak); err != nil {
+					b.Fatalf("Challenge() failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckAKParametersLogger(t *testing.T) {
+	var buf bytes.Buffer
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         rsaAKParameters(t),
+		Logger:     slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+	if err := params.CheckAKParameters(); err != nil {
+		t.Fatalf("CheckAKParameters() failed: %v", err)
+	}
+	for _, msg := range []string{
+		"AK public area decoded",
+		"AK creation attestation decoded",
+		"AK attributes checked",
+		"AK creation data verified",
+		"AK name verified",
+		"AK creation signature verified",
+	} {
+		if !strings.Contains(buf.String(), msg) {
+			t.Errorf("log does not contain %q:\n%s", msg, buf.String())
+		}
+	}
+
+	// A failure is logged after the steps which succeeded.
+	buf.Reset()
+	params.AK.CreateSignature = append([]byte(nil), params.AK.CreateSignature...)
+	params.AK.CreateSignature[len(params.AK.CreateSignature)-1] ^= 0xff
+	if err := params.CheckAKParameters(); err == nil {
+		t.Fatal("CheckAKParameters() with a bad signature succeeded, want error")
+	}
+	if !strings.Contains(buf.String(), "AK name verified") || !strings.Contains(buf.String(), "AK check failed") {
+		t.Errorf("log does not report the failed step:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "AK creation signature verified") {
+		t.Errorf("log reports a step which failed:\n%s", buf.String())
+	}
+}
+
+// stubHashUnavailable simulates a build which excludes the hash h.
+func stubHashUnavailable(t *testing.T, h crypto.Hash) {
+	t.Helper()
+	orig := hashAvailable
+	hashAvailable = func(x crypto.Hash) bool { return x != h && orig(x) }
+	t.Cleanup(func() { hashAvailable = orig })
+}
+
+func TestCheckAKParametersHashUnavailable(t *testing.T) {
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         rsaAKParameters(t),
+	}
+	stubHashUnavailable(t, crypto.SHA256)
+	err := params.CheckAKParameters()
+	if err == nil {
+		t.Fatal("CheckAKParameters() succeeded without SHA-256, want error")
+	}
+	if !strings.Contains(err.Error(), "not available in this build") {
+		t.Errorf("CheckAKParameters() error = %v, want hash availability error", err)
+	}
+	if _, _, err := params.Generate(); err == nil {
+		t.Error("Generate() succeeded without SHA-256, want error")
+	}
+}
+
+func TestCheckAKPublic20ECCCurves(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		curve   tpm2.EllipticCurve
+		size    int
+		wantErr error
+	}{
+		{name: "P256", curve: tpm2.CurveNISTP256, size: 32},
+		{name: "P384", curve: tpm2.CurveNISTP384, size: 48},
+		{name: "P521", curve: tpm2.CurveNISTP521, size: 66},
+		// Leading zeros of a coordinate may be trimmed.
+		{name: "P256 trimmed", curve: tpm2.CurveNISTP256, size: 31},
+		{name: "P521 trimmed", curve: tpm2.CurveNISTP521, size: 65},
+		// Padding a coordinate doesn't make the key larger.
+		{name: "P224 padded", curve: tpm2.CurveNISTP224, size: 32, wantErr: ErrAKTooSmall},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pub := tpm2.Public{
+				Type:       tpm2.AlgECC,
+				NameAlg:    tpm2.AlgSHA256,
+				Attributes: tpm2.FlagSignerDefault,
+				ECCParameters: &tpm2.ECCParams{
+					Sign:    &tpm2.SigScheme{Alg: tpm2.AlgECDSA, Hash: tpm2.AlgSHA256},
+					CurveID: test.curve,
+					Point: tpm2.ECPoint{
+						XRaw: bytes.Repeat([]byte{1}, test.size),
+						YRaw: bytes.Repeat([]byte{1}, test.size),
+					},
+				},
+			}
+			err := checkAKPublic20(pub, minRSABits, minECCBits)
+			if test.wantErr == nil && err != nil {
+				t.Errorf("checkAKPublic20() failed: %v", err)
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("checkAKPublic20() err = %v, want errors.Is(err, %v)", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckAKPublic20Scheme(t *testing.T) {
+	rsaPub := func(scheme *tpm2.SigScheme) tpm2.Public {
+		pub := akTemplateRSA
+		params := *pub.RSAParameters
+		params.Sign = scheme
+		pub.RSAParameters = &params
+		return pub
+	}
+	eccPub := func(scheme *tpm2.SigScheme) tpm2.Public {
+		pub := akTemplateECC
+		params := *pub.ECCParameters
+		params.Sign = scheme
+		pub.ECCParameters = &params
+		return pub
+	}
+	for _, test := range []struct {
+		name    string
+		pub     tpm2.Public
+		wantErr bool
+	}{
+		{"RSASSA", rsaPub(&tpm2.SigScheme{Alg: tpm2.AlgRSASSA, Hash: tpm2.AlgSHA256}), false},
+		{"RSAPSS", rsaPub(&tpm2.SigScheme{Alg: tpm2.AlgRSAPSS, Hash: tpm2.AlgSHA256}), false},
+		{"RSA no scheme", rsaPub(nil), true},
+		{"RSA null scheme", rsaPub(&tpm2.SigScheme{Alg: tpm2.AlgNull}), true},
+		{"RSA OAEP", rsaPub(&tpm2.SigScheme{Alg: tpm2.AlgOAEP, Hash: tpm2.AlgSHA256}), true},
+		{"ECDSA", eccPub(&tpm2.SigScheme{Alg: tpm2.AlgECDSA, Hash: tpm2.AlgSHA256}), false},
+		{"ECC no scheme", eccPub(nil), true},
+		{"ECDH", eccPub(&tpm2.SigScheme{Alg: tpm2.AlgECDH, Hash: tpm2.AlgSHA256}), true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkAKPublic20(test.pub, minRSABits, minECCBits)
+			if test.wantErr && !errors.Is(err, ErrAKNotSigning) {
+				t.Errorf("checkAKPublic20() err = %v, want errors.Is(err, %v)", err, ErrAKNotSigning)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("checkAKPublic20() failed: %v", err)
+			}
+		})
+	}
+
+	// AKs created by Windows, which are not created by this package,
+	// must still be accepted.
+	data, err := os.ReadFile("testdata/windows_gcp_shielded_vm.json")
+	if err != nil {
+		t.Fatalf("reading test data: %v", err)
+	}
+	var dump Dump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		t.Fatalf("parsing test data: %v", err)
+	}
+	pub, err := tpm2.DecodePublic(dump.AK.Public)
+	if err != nil {
+		t.Fatalf("DecodePublic() failed: %v", err)
+	}
+	if err := checkAKPublic20(pub, minRSABits, minECCBits); err != nil {
+		t.Errorf("checkAKPublic20() failed for a Windows AK: %v", err)
+	}
+}
+
+func TestDecodeEncryptedCredential(t *testing.T) {
+	priv := ekCertSigner(t)
+	params := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         rsaAKParameters(t),
+		EK:         &priv.PublicKey,
+		Rand:       rand.New(rand.NewSource(123456)),
+	}
+	_, ec, err := params.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	got, err := DecodeEncryptedCredential(ec)
+	if err != nil {
+		t.Fatalf("DecodeEncryptedCredential() failed: %v", err)
+	}
+	want := &CredentialLayout{
+		IntegritySize:         32,
+		IntegrityHash:         crypto.SHA256,
+		EncryptedIdentitySize: 2 + 32,
+		SecretSize:            priv.Size(),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeEncryptedCredential() = %+v, want %+v", got, want)
+	}
+
+	for _, test := range []struct {
+		name string
+		ec   EncryptedCredential
+	}{
+		{"unframed credential", EncryptedCredential{Credential: ec.Credential[2:], Secret: ec.Secret}},
+		{"trailing credential", EncryptedCredential{Credential: append(append([]byte(nil), ec.Credential...), 0), Secret: ec.Secret}},
+		{"unframed secret", EncryptedCredential{Credential: ec.Credential, Secret: ec.Secret[2:]}},
+		{"truncated secret", EncryptedCredential{Credential: ec.Credential, Secret: ec.Secret[:len(ec.Secret)-1]}},
+	} {
+		if _, err := DecodeEncryptedCredential(&test.ec); err == nil {
+			t.Errorf("DecodeEncryptedCredential() with %s succeeded, want error", test.name)
+		}
+	}
+}
+
+// TestActivationTPM20Golden checks that challenges only depend on Rand, so
+// a deterministic Rand produces byte-identical challenges.
+func TestActivationTPM20Golden(t *testing.T) {
+	eccPriv, err := ecdh.P256().NewPrivateKey(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := eccPriv.PublicKey().Bytes()
+	eccEK := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(point[1:33]),
+		Y:     new(big.Int).SetBytes(point[33:]),
+	}
+
+	for _, test := range []struct {
+		name           string
+		ek             crypto.PublicKey
+		wantCredential string
+		wantSecret     string
+	}{
+		{
+			name:           "RSA",
+			ek:             &ekCertSigner(t).PublicKey,
+			wantCredential: "AEQAIKaztaYD+t2+YrjDIAvDejh8SXdTCnoOq+Mkhe6kDENLOb4NvPWKxtOXMRjd09sMiGcqj8eTFU5BoR38pdYEBNf0fw==",
+			wantSecret:     "AQBAiFsk855bPaEJHPx8tQMiZoSPwe8YMFqf4RZCbPEAf4HadOHH8V7BNHOlNbbGKrPFRJg4KAijLwGFaNpUiJXOVDNWggnI6G1BoZzaFDP16kIF+t4IgxPIGYw3fY8/ljG0D5I2p/7gzJFlk8zxD9/8+LZp6L5rAOS/vPqKa8XRVR7AhG9KXNpKJw3mbSKAn3/bkqikkw6SpqAostW6zQAjlwZ6WIp2m+3ex+OrhVHhB72Bjx8+OtKKluAacNrrUQI5SzoDt802/lqhk2Z4iDxMrX6Dv6HN0gKApjkiWsRcbO/3vzxN0MURlPloH2LdG3A9CD2nN6etM1PtU8JVZUsL",
+		},
+		{
+			name:           "ECC",
+			ek:             eccEK,
+			wantCredential: "AEQAIArqhl9hFy3Ra71na0NH78jZhmvQYLuCtIx+FSHtjQxm9BuOCVH1zBsYYK84DKGVX2p+XpqALYSRDza4sKhjprqFZQ==",
+			wantSecret:     "AEQAICUsawLnwcnHR004TSsv/SOTeiS0k8Uthq1CRzztgs/lACC4CsLOmErYyJuORj+J30DWFBNbe+SHHaOXtlKp/XNbQg==",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			params := ActivationParameters{
+				TPMVersion: TPMVersion20,
+				AK:         rsaAKParameters(t),
+				EK:         test.ek,
+				Rand:       rand.New(rand.NewSource(123456)),
+			}
+			secret, ec, err := params.Generate()
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			if got, want := secret, decodeBase64("0vhS7HtORX9uf/iyQ8Sf9WkpJuoJ1olCfTjSZuyNNxY=", t); !bytes.Equal(got, want) {
+				t.Errorf("secret = %x, want %x", got, want)
+			}
+			if got, want := ec.Credential, decodeBase64(test.wantCredential, t); !bytes.Equal(got, want) {
+				t.Errorf("Credential = %x, want %x", got, want)
+			}
+			if got, want := ec.Secret, decodeBase64(test.wantSecret, t); !bytes.Equal(got, want) {
+				t.Errorf("Secret = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestAKFingerprint(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		ak   AttestationParameters
+	}{
+		{"RSA", rsaAKParameters(t)},
+		{"ECC", eccAKParameters(t)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := AKFingerprint(test.ak)
+			if err != nil {
+				t.Fatalf("AKFingerprint() failed: %v", err)
+			}
+			att, err := tpm2.DecodeAttestationData(test.ak.CreateAttestation)
+			if err != nil {
+				t.Fatalf("DecodeAttestationData() failed: %v", err)
+			}
+			want, err := att.AttestedCreationInfo.Name.Digest.Encode()
+			if err != nil {
+				t.Fatalf("Encode() failed: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("AKFingerprint() = %x, want attested name %x", got, want)
+			}
+		})
+	}
+
+	if _, err := AKFingerprint(AttestationParameters{Public: []byte{1, 2, 3}}); err == nil {
+		t.Error("AKFingerprint() with an invalid public area succeeded, want error")
+	}
+}
+
+func TestActivationEKTemplate(t *testing.T) {
+	priv := ekCertSigner(t)
+	rsaTemplate := func(sym *tpm2.SymScheme) *tpm2.Public {
+		return &tpm2.Public{
+			Type:          tpm2.AlgRSA,
+			NameAlg:       tpm2.AlgSHA384,
+			RSAParameters: &tpm2.RSAParams{Symmetric: sym, KeyBits: 2048},
+		}
+	}
+	aes256 := &tpm2.SymScheme{Alg: tpm2.AlgAES, KeyBits: 256, Mode: tpm2.AlgCFB}
+
+	for _, test := range []struct {
+		name       string
+		template   *tpm2.Public
+		blockSize  int
+		wantCipher string
+		wantHash   crypto.Hash
+		wantErr    bool
+	}{
+		{name: "default", wantCipher: "AES-128-CFB", wantHash: crypto.SHA256},
+		{name: "SHA-384 AES-256", template: rsaTemplate(aes256), wantCipher: "AES-256-CFB", wantHash: crypto.SHA384},
+		{name: "matching block size", template: rsaTemplate(aes256), blockSize: 32, wantCipher: "AES-256-CFB", wantHash: crypto.SHA384},
+		{name: "conflicting block size", template: rsaTemplate(aes256), blockSize: 16, wantErr: true},
+		{name: "no symmetric", template: rsaTemplate(nil), wantErr: true},
+		{name: "CBC", template: rsaTemplate(&tpm2.SymScheme{Alg: tpm2.AlgAES, KeyBits: 128, Mode: tpm2.AlgCBC}), wantErr: true},
+		{name: "ECC template", template: &tpm2.Public{Type: tpm2.AlgECC, NameAlg: tpm2.AlgSHA256, ECCParameters: &tpm2.ECCParams{Symmetric: aes256}}, wantErr: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			params := ActivationParameters{
+				TPMVersion:         TPMVersion20,
+				AK:                 rsaAKParameters(t),
+				EK:                 &priv.PublicKey,
+				EKTemplate:         test.template,
+				SymmetricBlockSize: test.blockSize,
+			}
+			_, ec, err := params.Generate()
+			if test.wantErr {
+				if err == nil {
+					t.Error("Generate() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			if ec.Parameters.Cipher != test.wantCipher || ec.Parameters.KDFHash != test.wantHash {
+				t.Errorf("Parameters = %+v, want cipher %s and KDF hash %v", ec.Parameters, test.wantCipher, test.wantHash)
+			}
+		})
+	}
+}
+
+func TestCheckCreationData20(t *testing.T) {
+	ak := rsaAKParameters(t)
+	pub, err := tpm2.DecodePublic(ak.Public)
+	if err != nil {
+		t.Fatalf("DecodePublic() failed: %v", err)
+	}
+	decode := func() *tpm2.CreationData {
+		cd, err := tpm2.DecodeCreationData(ak.CreateData)
+		if err != nil {
+			t.Fatalf("DecodeCreationData() failed: %v", err)
+		}
+		return cd
+	}
+	if err := checkCreationData20(decode(), pub); err != nil {
+		t.Fatalf("checkCreationData20() failed: %v", err)
+	}
+
+	hierarchy := tpmutil.Handle(tpm2.HandleOwner)
+	for _, test := range []struct {
+		name   string
+		modify func(cd *tpm2.CreationData)
+	}{
+		{"no locality", func(cd *tpm2.CreationData) { cd.Locality = 0 }},
+		{"short PCR digest", func(cd *tpm2.CreationData) { cd.PCRDigest = cd.PCRDigest[:20] }},
+		{"wrong empty PCR digest", func(cd *tpm2.CreationData) { cd.PCRDigest = make([]byte, 32) }},
+		{"parent name algorithm mismatch", func(cd *tpm2.CreationData) { cd.ParentNameAlg = tpm2.AlgSHA1 }},
+		{"qualified name algorithm mismatch", func(cd *tpm2.CreationData) {
+			cd.ParentQualifiedName.Digest = &tpm2.HashValue{Alg: tpm2.AlgSHA384, Value: make([]byte, 48)}
+		}},
+		{"hierarchy parent with name algorithm", func(cd *tpm2.CreationData) {
+			cd.ParentName = tpm2.Name{Handle: &hierarchy}
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cd := decode()
+			test.modify(cd)
+			if err := checkCreationData20(cd, pub); !errors.Is(err, ErrAKCreationDataInvalid) {
+				t.Errorf("checkCreationData20() = %v, want errors.Is(err, %v)", err, ErrAKCreationDataInvalid)
+			}
+		})
+	}
+}
@@ -17,9 +17,17 @@ package attest
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
 )
 
 type key interface {
@@ -27,8 +35,10 @@ type key interface {
 	marshal() ([]byte, error)
 	certificationParameters() CertificationParameters
 	sign(tpmBase, []byte, crypto.PublicKey, crypto.SignerOpts) ([]byte, error)
-	decrypt(tpmBase, []byte) ([]byte, error)
+	decrypt(tpmBase, []byte, crypto.DecrypterOpts) ([]byte, error)
 	blobs() ([]byte, []byte, error)
+	evict(t tpmBase, handle tpmutil.Handle, force bool) error
+	delete(tpmBase) error
 }
 
 // Key represents a key which can be used for signing and decrypting
@@ -46,7 +56,28 @@ type signer struct {
 	tpm tpmBase
 }
 
+// RawECDSAOptions may be passed as the crypto.SignerOpts when signing with
+// an ECDSA key, to produce a signature in the fixed-size r || s form used
+// by formats such as JWS, rather than ASN.1 DER.
+type RawECDSAOptions struct {
+	// Hash is the hash function used to compute the digest.
+	Hash crypto.Hash
+}
+
+// HashFunc implements crypto.SignerOpts.
+func (o *RawECDSAOptions) HashFunc() crypto.Hash {
+	return o.Hash
+}
+
 // Sign signs digest with the TPM-stored private signing key.
+//
+// For RSA keys, passing *rsa.PSSOptions as opts produces an RSASSA-PSS
+// signature, with a salt length equal to the length of the digest. Any
+// other opts produce an RSASSA-PKCS1-v1_5 signature.
+//
+// ECDSA signatures are ASN.1 DER encoded, as expected by ecdsa.VerifyASN1.
+// Passing *RawECDSAOptions as opts instead produces r and s, each padded
+// to the byte length of the curve order, concatenated together.
 func (s *signer) Sign(r io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
 	return s.key.sign(s.tpm, digest, s.pub, opts)
 }
@@ -56,6 +87,28 @@ func (s *signer) Public() crypto.PublicKey {
 	return s.pub
 }
 
+// decrypter implements crypto.Signer and crypto.Decrypter, and is returned
+// by Key.Private() for RSA keys created for decryption.
+type decrypter struct {
+	signer
+}
+
+// Decrypt decrypts msg with the TPM-stored private key. See Key.Decrypt
+// for the supported options.
+func (d *decrypter) Decrypt(r io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return d.key.decrypt(d.tpm, msg, opts)
+}
+
+// canDecrypt reports whether k was created with the decrypt attribute.
+func canDecrypt(k key) bool {
+	public, _, err := k.blobs()
+	if err != nil {
+		return false
+	}
+	pub, err := tpm2.DecodePublic(public)
+	return err == nil && pub.Attributes&tpm2.FlagDecrypt != 0
+}
+
 // Algorithm indicates an asymmetric algorithm to be used.
 type Algorithm string
 
@@ -70,12 +123,80 @@ type KeyConfig struct {
 	// Algorithm to be used, either RSA or ECDSA.
 	Algorithm Algorithm
 	// Size is used to specify the bit size of the key or elliptic curve. For
-	// example, '256' is used to specify curve P-256.
+	// example, '256' is used to specify curve P-256. RSA keys default to
+	// 2048 bits if unset; 3072 and 4096 are also commonly supported.
 	Size int
 	// Parent describes the Storage Root Key that will be used as a parent.
 	// If nil, the default SRK (i.e. RSA with handle 0x81000001) is assumed.
 	// Supported only by TPM 2.0 on Linux.
 	Parent *ParentKeyConfig
+	// Decrypt creates a key which can also be used to decrypt data with
+	// Key.Decrypt. Supported only for RSA keys.
+	Decrypt bool
+	// QualifyingData is included in the key's certification by the AK,
+	// and can be checked with VerifyOpts.QualifyingData. It is typically
+	// a nonce provided by the verifier. Supported only by TPM 2.0.
+	QualifyingData []byte
+	// PersistentHandle, if set, makes the key persistent at the given
+	// handle once it has been created, as with Key.Evict. Otherwise, the
+	// key is transient and is lost when the TPM is reset.
+	// Supported only by TPM 2.0.
+	PersistentHandle tpmutil.Handle
+	// Auth, if set, is the authorization value (password) the key is
+	// created with. A copy is kept until the key is closed, and used to
+	// authorize signing, decrypting and its certification by the AK. It
+	// is not included in the output of Marshal, so keys which are loaded
+	// again need it to be provided with Key.SetAuth.
+	// Supported only by TPM 2.0.
+	Auth []byte
+	// Duplicable creates a key which can be moved under another parent
+	// with Key.Rewrap. Its authorization policy permits TPM2_Duplicate,
+	// and it is fixed to neither the TPM nor its parent, so its
+	// certification is rejected by CertificationParameters.Verify.
+	// Supported only by TPM 2.0.
+	Duplicable bool
+	// Usage is a hint restricting the operations the key may be used for.
+	// It is only honored by keys created through the Windows Platform
+	// Crypto Provider, where it sets the key's NCRYPT_KEY_USAGE_PROPERTY,
+	// and is ignored on other platforms, where Decrypt applies instead.
+	Usage KeyUsage
+	// Exportable is a hint that the key may be exported from the Windows
+	// Platform Crypto Provider, where it sets NCRYPT_ALLOW_EXPORT_FLAG in
+	// the key's NCRYPT_EXPORT_POLICY_PROPERTY. Keys are non-exportable
+	// otherwise. Providers may refuse to create exportable keys. It is
+	// ignored on other platforms.
+	Exportable bool
+}
+
+// KeyUsage describes the operations a key may be used for.
+type KeyUsage uint8
+
+// Key usages supported.
+const (
+	// KeyUsageSign restricts the key to signing. It is the default.
+	KeyUsageSign KeyUsage = iota
+	// KeyUsageAll allows the key to be used for any operation supported
+	// by its algorithm.
+	KeyUsageAll
+)
+
+// Curve returns the elliptic curve of the ECDSA keys described by c, and
+// whether c describes ECDSA keys on a supported curve. The Algorithm alone
+// doesn't determine the curve, which is selected by Size.
+func (c *KeyConfig) Curve() (elliptic.Curve, bool) {
+	if c.Algorithm != ECDSA {
+		return nil, false
+	}
+	switch c.Size {
+	case 256:
+		return elliptic.P256(), true
+	case 384:
+		return elliptic.P384(), true
+	case 521:
+		return elliptic.P521(), true
+	default:
+		return nil, false
+	}
 }
 
 // defaultConfig is used when no other configuration is specified.
@@ -89,14 +210,139 @@ func (k *Key) Public() crypto.PublicKey {
 	return k.pub
 }
 
+// PublicDER returns the public key as a DER-encoded SubjectPublicKeyInfo,
+// as produced by x509.MarshalPKIXPublicKey.
+func (k *Key) PublicDER() ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(k.pub)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling public key: %v", err)
+	}
+	return der, nil
+}
+
+// PublicPEM returns the public key as a PEM block of type "PUBLIC KEY",
+// holding the output of PublicDER.
+func (k *Key) PublicPEM() ([]byte, error) {
+	der, err := k.PublicDER()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// CertificateRequest creates a PKCS #10 certificate signing request for the
+// key, signed by the TPM-backed private key, and returns it in DER form.
+//
+// If template.SignatureAlgorithm is unset, the signature algorithm is chosen
+// to match the signing scheme of the key: RSASSA-PKCS1-v1_5 or RSASSA-PSS
+// with the key's hash for RSA keys created with a fixed scheme,
+// SHA256WithRSA for RSA keys without one, and ECDSA with the key's hash
+// for ECDSA keys. Otherwise, the requested algorithm must be one the key
+// can produce.
+func (k *Key) CertificateRequest(template *x509.CertificateRequest) ([]byte, error) {
+	if template == nil {
+		return nil, errors.New("no certificate request template provided")
+	}
+	public, _, err := k.key.blobs()
+	if err != nil {
+		return nil, fmt.Errorf("reading key blobs: %v", err)
+	}
+	pub, err := tpm2.DecodePublic(public)
+	if err != nil {
+		return nil, fmt.Errorf("decode public key: %v", err)
+	}
+	sigAlg, err := signatureAlgorithmForKey(pub, template.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := k.Private(k.pub)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := *template
+	tmpl.SignatureAlgorithm = sigAlg
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &tmpl, priv)
+	if err != nil {
+		return nil, fmt.Errorf("creating certificate request: %v", err)
+	}
+	return csr, nil
+}
+
+// x509SignatureAlgorithms maps TPM signing schemes and hashes onto the
+// equivalent x509 signature algorithms.
+var x509SignatureAlgorithms = map[tpm2.Algorithm]map[tpm2.Algorithm]x509.SignatureAlgorithm{
+	tpm2.AlgRSASSA: {
+		tpm2.AlgSHA256: x509.SHA256WithRSA,
+		tpm2.AlgSHA384: x509.SHA384WithRSA,
+		tpm2.AlgSHA512: x509.SHA512WithRSA,
+	},
+	tpm2.AlgRSAPSS: {
+		tpm2.AlgSHA256: x509.SHA256WithRSAPSS,
+		tpm2.AlgSHA384: x509.SHA384WithRSAPSS,
+		tpm2.AlgSHA512: x509.SHA512WithRSAPSS,
+	},
+	tpm2.AlgECDSA: {
+		tpm2.AlgSHA256: x509.ECDSAWithSHA256,
+		tpm2.AlgSHA384: x509.ECDSAWithSHA384,
+		tpm2.AlgSHA512: x509.ECDSAWithSHA512,
+	},
+}
+
+// signatureAlgorithmForKey returns the x509 signature algorithm to use for
+// signing with the key described by pub. If requested is set, it is returned
+// if the key can produce it, and an error otherwise.
+func signatureAlgorithmForKey(pub tpm2.Public, requested x509.SignatureAlgorithm) (x509.SignatureAlgorithm, error) {
+	var scheme *tpm2.SigScheme
+	switch pub.Type {
+	case tpm2.AlgRSA:
+		if pub.RSAParameters != nil {
+			scheme = pub.RSAParameters.Sign
+		}
+		if scheme == nil || scheme.Alg == tpm2.AlgNull {
+			// Keys without a fixed scheme can sign with any scheme and hash
+			// supported by signRSA.
+			switch requested {
+			case x509.UnknownSignatureAlgorithm:
+				return x509.SHA256WithRSA, nil
+			case x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+				x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+				return requested, nil
+			}
+			return x509.UnknownSignatureAlgorithm, fmt.Errorf("signature algorithm %v is not supported by RSA keys", requested)
+		}
+	case tpm2.AlgECC:
+		if pub.ECCParameters != nil {
+			scheme = pub.ECCParameters.Sign
+		}
+		if scheme == nil || scheme.Alg == tpm2.AlgNull {
+			return x509.UnknownSignatureAlgorithm, errors.New("ECC key has no signing scheme")
+		}
+	default:
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported key type: 0x%x", pub.Type)
+	}
+
+	alg, ok := x509SignatureAlgorithms[scheme.Alg][scheme.Hash]
+	if !ok {
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported signing scheme 0x%x with hash 0x%x", scheme.Alg, scheme.Hash)
+	}
+	if requested != x509.UnknownSignatureAlgorithm && requested != alg {
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("signature algorithm %v requested, but the key only signs with %v", requested, alg)
+	}
+	return alg, nil
+}
+
 // Private returns an object allowing to use the TPM-backed private key.
-// For now it implements only crypto.Signer.
+// It implements crypto.Signer and, for RSA keys created with
+// KeyConfig.Decrypt set, crypto.Decrypter.
 func (k *Key) Private(pub crypto.PublicKey) (crypto.PrivateKey, error) {
 	switch pub.(type) {
 	case *rsa.PublicKey:
 		if _, ok := k.pub.(*rsa.PublicKey); !ok {
 			return nil, fmt.Errorf("incompatible public key types: %T != %T", pub, k.pub)
 		}
+		if canDecrypt(k.key) {
+			return &decrypter{signer{k.key, k.pub, k.tpm}}, nil
+		}
 	case *ecdsa.PublicKey:
 		if _, ok := k.pub.(*ecdsa.PublicKey); !ok {
 			return nil, fmt.Errorf("incompatible public key types: %T != %T", pub, k.pub)
@@ -107,11 +353,92 @@ func (k *Key) Private(pub crypto.PublicKey) (crypto.PrivateKey, error) {
 	return &signer{k.key, k.pub, k.tpm}, nil
 }
 
-// Close unloads the key from the system.
+// Decrypt decrypts msg with the TPM-stored private key. Only RSA keys
+// created with KeyConfig.Decrypt set can be used.
+//
+// If opts is nil or *rsa.PKCS1v15DecryptOptions, msg is decrypted using
+// PKCS #1 v1.5 padding. If opts is *rsa.OAEPOptions, msg is decrypted using
+// OAEP with the given hash, which must also be the MGF1 hash. The TPM
+// requires a non-empty OAEP label to end with a zero byte. rand is unused.
+func (k *Key) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return k.key.decrypt(k.tpm, msg, opts)
+}
+
+// SetAuth sets the authorization value (password) used for operations with
+// the key, which is required for keys created with KeyConfig.Auth and
+// loaded again. A copy of auth is kept, so the caller may zero auth
+// afterwards. The copy is zeroed when the key is closed.
+func (k *Key) SetAuth(auth []byte) error {
+	w, ok := k.key.(*wrappedKey20)
+	if !ok {
+		return errors.New("authorization values are not supported for this key")
+	}
+	w.setAuth(auth)
+	return nil
+}
+
+// Rewrap duplicates the key and imports it under newParent, returning the
+// key as a new Key loaded under newParent, for example to move keys to a
+// new Storage Root Key before the current one is lost. If newParent is
+// nil, the default SRK is used. The key must still be loaded under its
+// current parent, and k is left loaded; Marshal the returned Key and load
+// it with TPM.LoadKeyWithParent from then on.
+//
+// Only keys created with KeyConfig.Duplicable can be rewrapped. Other keys
+// are rejected with ErrKeyNotDuplicable. Supported only by TPM 2.0.
+func (k *Key) Rewrap(newParent *ParentKeyConfig) (*Key, error) {
+	w, ok := k.key.(*wrappedKey20)
+	if !ok {
+		return nil, errors.New("rewrapping is not supported for this key")
+	}
+	t, ok := k.tpm.(*wrappedTPM20)
+	if !ok {
+		return nil, fmt.Errorf("expected *wrappedTPM20, got: %T", k.tpm)
+	}
+	parent := defaultParentConfig
+	if newParent != nil {
+		parent = *newParent
+	}
+	return t.rewrapKey(w, k.pub, parent)
+}
+
+// Close unloads the key from the system. Persistent keys are left in the
+// TPM's non-volatile memory; use Delete to remove them.
+//
+// Keys which are not closed occupy one of the TPM's few slots for loaded
+// objects until the TPM is reset, even once the Key is garbage collected.
+// Once the slots are exhausted, loading or creating keys fails with
+// ErrTPMObjectMemory.
 func (k *Key) Close() error {
 	return k.key.close(k.tpm)
 }
 
+// Evict makes a transient key persistent at handle, which must be a
+// persistent handle in the owner hierarchy (0x81000000 to 0x817fffff) not
+// already in use. The key then survives TPM resets, and the output of
+// Marshal can be passed to LoadKey to use it again without reloading it.
+// Supported only by TPM 2.0.
+//
+// If another key, such as the EK or SRK, is persistent at handle, an error
+// wrapping ErrHandleInUse is returned.
+func (k *Key) Evict(handle tpmutil.Handle) error {
+	return k.key.evict(k.tpm, handle, false)
+}
+
+// EvictForce is like Evict, but first deletes any other key persistent at
+// handle. The deleted key cannot be recovered, so this must not be used
+// with handles which may hold keys such as the EK or SRK.
+func (k *Key) EvictForce(handle tpmutil.Handle) error {
+	return k.key.evict(k.tpm, handle, true)
+}
+
+// Delete removes a persistent key from the TPM's non-volatile memory. The
+// key cannot be used afterwards, and Close does nothing. Supported only by
+// TPM 2.0.
+func (k *Key) Delete() error {
+	return k.key.delete(k.tpm)
+}
+
 // Marshal encodes the key in a format that can be loaded with tpm.LoadKey().
 // This method exists to allow consumers to store the key persistently and load
 // it as a later time. Users SHOULD NOT attempt to interpret or extract values
@@ -23,15 +23,27 @@ package attest
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
-	"encoding/asn1"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
 	"math/big"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
 )
 
 func TestSimTPM20KeyCreateAndLoad(t *testing.T) {
@@ -79,6 +91,15 @@ func testKeyCreateAndLoad(t *testing.T, tpm *TPM) {
 				Size:      384,
 			},
 		},
+		{
+			name: "ECDSAP384-NonExportable",
+			opts: &KeyConfig{
+				Algorithm:  ECDSA,
+				Size:       384,
+				Usage:      KeyUsageSign,
+				Exportable: false,
+			},
+		},
 		{
 			name: "ECDSAP521-SHA512",
 			opts: &KeyConfig{
@@ -122,11 +143,37 @@ func testKeyCreateAndLoad(t *testing.T, tpm *TPM) {
 			}
 			defer loaded.Close()
 
-			k1, k2 := sk.key.(*wrappedKey20), loaded.key.(*wrappedKey20)
-			if !bytes.Equal(k1.public, k2.public) {
+			p1, p2 := sk.CertificationParameters().Public, loaded.CertificationParameters().Public
+			if !bytes.Equal(p1, p2) {
 				t.Error("Original & loaded Key public blobs did not match.")
-				t.Logf("Original = %v", k1.public)
-				t.Logf("Loaded   = %v", k2.public)
+				t.Logf("Original = %v", p1)
+				t.Logf("Loaded   = %v", p2)
+			}
+
+			pub1, err := sk.PublicDER()
+			if err != nil {
+				t.Fatalf("sk.PublicDER() failed: %v", err)
+			}
+			pub2, err := loaded.PublicDER()
+			if err != nil {
+				t.Fatalf("loaded.PublicDER() failed: %v", err)
+			}
+			if !bytes.Equal(pub1, pub2) {
+				t.Error("Original & loaded Key Public() did not match.")
+			}
+			if want, err := x509.MarshalPKIXPublicKey(loaded.Public()); err != nil || !bytes.Equal(pub2, want) {
+				t.Errorf("PublicDER() did not match x509.MarshalPKIXPublicKey(Public()): %v", err)
+			}
+			pemBytes, err := loaded.PublicPEM()
+			if err != nil {
+				t.Fatalf("loaded.PublicPEM() failed: %v", err)
+			}
+			block, rest := pem.Decode(pemBytes)
+			if block == nil || len(rest) != 0 {
+				t.Fatalf("PublicPEM() returned invalid PEM: %q", pemBytes)
+			}
+			if block.Type != "PUBLIC KEY" || !bytes.Equal(block.Bytes, pub2) {
+				t.Errorf("PublicPEM() = %s block holding %x, want PUBLIC KEY block holding %x", block.Type, block.Bytes, pub2)
 			}
 
 			priv1, err := sk.Private(sk.Public())
@@ -288,6 +335,30 @@ func testKeySign(t *testing.T, tpm *TPM) {
 			},
 			digest: []byte("1234567890123456789012345678901212345678901234567890123456789012"),
 		},
+		{
+			name: "RSA2048-PSS-SHA256, salt len equals hash",
+			keyOpts: &KeyConfig{
+				Algorithm: RSA,
+				Size:      2048,
+			},
+			signOpts: &rsa.PSSOptions{
+				SaltLength: rsa.PSSSaltLengthEqualsHash,
+				Hash:       crypto.SHA256,
+			},
+			digest: []byte("12345678901234567890123456789012"),
+		},
+		{
+			name: "RSA2048-PSS-SHA512, salt len equals hash",
+			keyOpts: &KeyConfig{
+				Algorithm: RSA,
+				Size:      2048,
+			},
+			signOpts: &rsa.PSSOptions{
+				SaltLength: rsa.PSSSaltLengthEqualsHash,
+				Hash:       crypto.SHA512,
+			},
+			digest: []byte("1234567890123456789012345678901212345678901234567890123456789012"),
+		},
 		{
 			name: "RSA2048-PSS-SHA256, explicit salt len",
 			keyOpts: &KeyConfig{
@@ -324,6 +395,24 @@ func testKeySign(t *testing.T, tpm *TPM) {
 			},
 			digest: []byte("1234567890123456789012345678901212345678901234567890123456789012"),
 		},
+		{
+			name: "ECDSAP256-SHA256-raw",
+			keyOpts: &KeyConfig{
+				Algorithm: ECDSA,
+				Size:      256,
+			},
+			signOpts: &RawECDSAOptions{Hash: crypto.SHA256},
+			digest:   []byte("12345678901234567890123456789012"),
+		},
+		{
+			name: "ECDSAP521-SHA512-raw",
+			keyOpts: &KeyConfig{
+				Algorithm: ECDSA,
+				Size:      521,
+			},
+			signOpts: &RawECDSAOptions{Hash: crypto.SHA512},
+			digest:   []byte("1234567890123456789012345678901212345678901234567890123456789012"),
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			sk, err := tpm.NewKey(ak, test.keyOpts)
@@ -346,7 +435,9 @@ func testKeySign(t *testing.T, tpm *TPM) {
 				t.Fatalf("signer.Sign() failed: %v", err)
 			}
 
-			if test.keyOpts == nil || test.keyOpts.Algorithm == ECDSA {
+			if _, ok := test.signOpts.(*RawECDSAOptions); ok {
+				verifyRawECDSA(t, pub, test.digest, sig)
+			} else if test.keyOpts == nil || test.keyOpts.Algorithm == ECDSA {
 				verifyECDSA(t, pub, test.digest, sig)
 			} else {
 				verifyRSA(t, pub, test.digest, sig, test.signOpts)
@@ -357,16 +448,28 @@ func testKeySign(t *testing.T, tpm *TPM) {
 
 func verifyECDSA(t *testing.T, pub crypto.PublicKey, digest, sig []byte) {
 	t.Helper()
-	parsed := struct{ R, S *big.Int }{}
-	_, err := asn1.Unmarshal(sig, &parsed)
-	if err != nil {
-		t.Fatalf("signature parsing failed: %v", err)
+	pubECDSA, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		t.Fatalf("want *ecdsa.PublicKey, got %T", pub)
 	}
+	if !ecdsa.VerifyASN1(pubECDSA, digest, sig) {
+		t.Fatalf("ecdsa.VerifyASN1() failed")
+	}
+}
+
+// verifyRawECDSA verifies a signature made with RawECDSAOptions.
+func verifyRawECDSA(t *testing.T, pub crypto.PublicKey, digest, sig []byte) {
+	t.Helper()
 	pubECDSA, ok := pub.(*ecdsa.PublicKey)
 	if !ok {
 		t.Fatalf("want *ecdsa.PublicKey, got %T", pub)
 	}
-	if !ecdsa.Verify(pubECDSA, digest[:], parsed.R, parsed.S) {
+	size := (pubECDSA.Curve.Params().N.BitLen() + 7) / 8
+	if len(sig) != 2*size {
+		t.Fatalf("raw signature is %d bytes, want %d", len(sig), 2*size)
+	}
+	r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+	if !ecdsa.Verify(pubECDSA, digest, r, s) {
 		t.Fatalf("ecdsa.Verify() failed")
 	}
 }
@@ -388,141 +491,1171 @@ func verifyRSA(t *testing.T, pub crypto.PublicKey, digest, sig []byte, opts cryp
 	}
 }
 
-func TestSimTPM20KeyOpts(t *testing.T) {
+func TestSimTPM20KeyECCParent(t *testing.T) {
 	sim, tpm := setupSimulatedTPM(t)
 	defer sim.Close()
-	testKeyOpts(t, tpm)
+
+	parent := ParentKeyConfig{Algorithm: ECDSA, Handle: 0x81000010}
+	ak, err := tpm.NewAK(&AKConfig{Parent: &parent})
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	sk, err := tpm.NewKey(ak, &KeyConfig{Algorithm: ECDSA, Size: 256, Parent: &parent})
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	enc, err := sk.Marshal()
+	if err != nil {
+		t.Fatalf("sk.Marshal() failed: %v", err)
+	}
+	if err := sk.Close(); err != nil {
+		t.Fatalf("sk.Close() failed: %v", err)
+	}
+
+	loaded, err := tpm.LoadKeyWithParent(enc, parent)
+	if err != nil {
+		t.Fatalf("LoadKeyWithParent() failed: %v", err)
+	}
+	defer loaded.Close()
+
+	pub := loaded.Public()
+	priv, err := loaded.Private(pub)
+	if err != nil {
+		t.Fatalf("loaded.Private() failed: %v", err)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		t.Fatalf("want crypto.Signer, got %T", priv)
+	}
+	digest := []byte("12345678901234567890123456789012")
+	sig, err := signer.Sign(rand.Reader, digest, nil)
+	if err != nil {
+		t.Fatalf("signer.Sign() failed: %v", err)
+	}
+	verifyECDSA(t, pub, digest, sig)
+
+	for _, test := range []struct {
+		name   string
+		parent ParentKeyConfig
+	}{
+		{"wrong algorithm", ParentKeyConfig{Algorithm: RSA, Handle: parent.Handle}},
+		{"no handle", ParentKeyConfig{Algorithm: ECDSA}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := tpm.NewKey(ak, &KeyConfig{Algorithm: ECDSA, Size: 256, Parent: &test.parent}); err == nil {
+				t.Error("NewKey() succeeded, want error")
+			}
+			if _, err := tpm.LoadKeyWithParent(enc, test.parent); err == nil {
+				t.Error("LoadKeyWithParent() succeeded, want error")
+			}
+		})
+	}
 }
 
-func TestTPM20KeyOpts(t *testing.T) {
-	if !*testLocal {
-		t.SkipNow()
+func TestSimTPM20KeyImport(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() failed: %v", err)
 	}
-	tpm, err := OpenTPM(nil)
+	ecdsaPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
-		t.Fatalf("OpenTPM() failed: %v", err)
+		t.Fatalf("ecdsa.GenerateKey() failed: %v", err)
+	}
+	pkcs8 := func(priv crypto.PrivateKey) []byte {
+		t.Helper()
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			t.Fatalf("MarshalPKCS8PrivateKey() failed: %v", err)
+		}
+		return der
+	}
+	eccParent := &ParentKeyConfig{Algorithm: ECDSA, Handle: 0x81000010}
+
+	for _, test := range []struct {
+		name   string
+		priv   crypto.Signer
+		parent *ParentKeyConfig
+		opts   crypto.SignerOpts
+	}{
+		{"RSA", rsaPriv, nil, crypto.SHA256},
+		{"RSA-PSS", rsaPriv, nil, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}},
+		{"ECDSA", ecdsaPriv, nil, crypto.SHA256},
+		{"ECDSA, ECC parent", ecdsaPriv, eccParent, crypto.SHA256},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			k, err := tpm.ImportKey(test.priv.Public(), pkcs8(test.priv), test.parent)
+			if err != nil {
+				t.Fatalf("ImportKey() failed: %v", err)
+			}
+			enc, err := k.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			if err := k.Close(); err != nil {
+				t.Fatalf("Close() failed: %v", err)
+			}
+			parent := defaultParentConfig
+			if test.parent != nil {
+				parent = *test.parent
+			}
+			loaded, err := tpm.LoadKeyWithParent(enc, parent)
+			if err != nil {
+				t.Fatalf("LoadKeyWithParent() failed: %v", err)
+			}
+			defer loaded.Close()
+
+			pub := loaded.Public()
+			if !test.priv.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+				t.Fatalf("imported key has public key %v, want %v", pub, test.priv.Public())
+			}
+			priv, err := loaded.Private(pub)
+			if err != nil {
+				t.Fatalf("Private() failed: %v", err)
+			}
+			digest := []byte("12345678901234567890123456789012")
+			sig, err := priv.(crypto.Signer).Sign(rand.Reader, digest, test.opts)
+			if err != nil {
+				t.Fatalf("Sign() failed: %v", err)
+			}
+			switch pub.(type) {
+			case *rsa.PublicKey:
+				verifyRSA(t, pub, digest, sig, test.opts)
+			case *ecdsa.PublicKey:
+				verifyECDSA(t, pub, digest, sig)
+			}
+		})
+	}
+
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() failed: %v", err)
+	}
+	for _, test := range []struct {
+		name      string
+		pub       crypto.PublicKey
+		sensitive []byte
+		parent    *ParentKeyConfig
+	}{
+		{"algorithm mismatch", &rsaPriv.PublicKey, pkcs8(ecdsaPriv), nil},
+		{"key mismatch", &rsaPriv.PublicKey, pkcs8(otherRSA), nil},
+		{"invalid sensitive", &rsaPriv.PublicKey, []byte("not a key"), nil},
+		{"unsuitable parent", &rsaPriv.PublicKey, pkcs8(rsaPriv), &ParentKeyConfig{Algorithm: ECDSA, Handle: defaultParentConfig.Handle}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if k, err := tpm.ImportKey(test.pub, test.sensitive, test.parent); err == nil {
+				k.Close()
+				t.Error("ImportKey() succeeded, want error")
+			}
+		})
 	}
-	defer tpm.Close()
-	testKeyOpts(t, tpm)
 }
 
-func testKeyOpts(t *testing.T, tpm *TPM) {
+func TestSimTPM20KeyPersistent(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
 	ak, err := tpm.NewAK(nil)
 	if err != nil {
 		t.Fatalf("NewAK() failed: %v", err)
 	}
+	akBlob, err := ak.Marshal()
+	if err != nil {
+		t.Fatalf("ak.Marshal() failed: %v", err)
+	}
+	ak.Close(tpm)
+
 	for _, test := range []struct {
-		name string
-		opts *KeyConfig
-		err  bool
+		name   string
+		handle tpmutil.Handle
+		evict  bool
 	}{
-		{
-			name: "wrong alg",
-			opts: &KeyConfig{
-				Algorithm: "fake alg",
-			},
-			err: true,
-		},
-		{
-			name: "wrong size",
-			opts: &KeyConfig{
-				Algorithm: ECDSA,
-				Size:      1234,
-			},
-			err: true,
-		},
-		{
-			name: "default",
-			opts: nil,
-			err:  false,
-		},
-		{
-			name: "ECDSAP256",
-			opts: &KeyConfig{
-				Algorithm: ECDSA,
-				Size:      256,
-			},
-			err: false,
-		},
-		{
-			name: "ECDSAP384",
-			opts: &KeyConfig{
-				Algorithm: ECDSA,
-				Size:      384,
-			},
-			err: false,
-		},
-		{
-			name: "ECDSAP521",
-			opts: &KeyConfig{
-				Algorithm: ECDSA,
-				Size:      521,
-			},
-			err: false,
-		},
-		{
-			name: "RSA-1024",
-			opts: &KeyConfig{
-				Algorithm: RSA,
-				Size:      1024,
-			},
-			err: false,
-		},
-		{
-			name: "RSA-2048",
-			opts: &KeyConfig{
-				Algorithm: RSA,
-				Size:      2048,
-			},
-			err: false,
-		},
+		{"KeyConfig", 0x81000020, false},
+		{"Evict", 0x81000021, true},
 	} {
 		t.Run(test.name, func(t *testing.T) {
-			sk, err := tpm.NewKey(ak, test.opts)
-			if !test.err && err != nil {
-				t.Fatalf("NewKey() failed: %v", err)
+			ak, err := tpm.LoadAK(akBlob)
+			if err != nil {
+				t.Fatalf("LoadAK() failed: %v", err)
 			}
-			if test.err {
-				if err == nil {
-					sk.Close()
-					t.Fatalf("NewKey(): expected err != nil")
-				}
-				return
+			cfg := &KeyConfig{Algorithm: ECDSA, Size: 256}
+			if !test.evict {
+				cfg.PersistentHandle = test.handle
 			}
-			defer sk.Close()
-
-			expected := test.opts
-			if expected == nil {
-				expected = defaultConfig
+			k, err := tpm.NewKey(ak, cfg)
+			ak.Close(tpm)
+			if err != nil {
+				t.Fatalf("NewKey() failed: %v", err)
 			}
-
-			switch pub := sk.Public().(type) {
-			case *ecdsa.PublicKey:
-				if expected.Algorithm != ECDSA {
-					t.Errorf("incorrect key type generated, expected %q, got EC", expected.Algorithm)
-				}
-				sizeToCurve := map[int]elliptic.Curve{
-					256: elliptic.P256(),
-					384: elliptic.P384(),
-					521: elliptic.P521(),
-				}
-				expectedCurve, ok := sizeToCurve[expected.Size]
-				if !ok {
-					t.Fatalf("cannot match curve to key size %d", expected.Size)
-				}
-				if expectedCurve != pub.Curve {
-					t.Errorf("incorrect curve, expected %v, got %v", expectedCurve, pub.Curve)
+			if test.evict {
+				if err := k.Delete(); err == nil {
+					t.Error("Delete() of a transient key succeeded, want error")
 				}
-			case *rsa.PublicKey:
-				if expected.Algorithm != RSA {
-					t.Errorf("incorrect key type, expected %q, got RSA", expected.Algorithm)
+				if err := k.Evict(0x80000001); err == nil {
+					t.Error("Evict() to a transient handle succeeded, want error")
 				}
-				if pub.Size()*8 != expected.Size {
-					t.Errorf("incorrect key size, expected %d, got %d", expected.Size, pub.Size()*8)
+				if err := k.Evict(test.handle); err != nil {
+					t.Fatalf("Evict() failed: %v", err)
 				}
-			default:
-				t.Errorf("unsupported key type: %T", pub)
 			}
-		})
+			if err := k.Evict(test.handle + 1); err == nil {
+				t.Error("Evict() of a persistent key succeeded, want error")
+			}
+			enc, err := k.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			if err := k.Close(); err != nil {
+				t.Fatalf("Close() failed: %v", err)
+			}
+
+			// The key must survive both Close and a TPM reset.
+			if err := sim.Reset(); err != nil {
+				t.Fatalf("Reset() failed: %v", err)
+			}
+			loaded, err := tpm.LoadKey(enc)
+			if err != nil {
+				t.Fatalf("LoadKey() after reset failed: %v", err)
+			}
+			pub := loaded.Public()
+			priv, err := loaded.Private(pub)
+			if err != nil {
+				t.Fatalf("Private() failed: %v", err)
+			}
+			digest := []byte("12345678901234567890123456789012")
+			sig, err := priv.(crypto.Signer).Sign(rand.Reader, digest, nil)
+			if err != nil {
+				t.Fatalf("Sign() failed: %v", err)
+			}
+			verifyECDSA(t, pub, digest, sig)
+
+			public := loaded.CertificationParameters().Public
+			fromHandle, err := tpm.LoadKeyFromHandle(test.handle, public)
+			if err != nil {
+				t.Fatalf("LoadKeyFromHandle() failed: %v", err)
+			}
+			if !fromHandle.Public().(*ecdsa.PublicKey).Equal(pub) {
+				t.Error("LoadKeyFromHandle() returned a different public key")
+			}
+			if _, err := tpm.LoadAKFromHandle(test.handle, public); err == nil {
+				t.Error("LoadAKFromHandle() of an application key succeeded, want error")
+			}
+
+			if err := loaded.Delete(); err != nil {
+				t.Fatalf("Delete() failed: %v", err)
+			}
+			if err := loaded.Close(); err != nil {
+				t.Errorf("Close() after Delete() failed: %v", err)
+			}
+			if _, err := tpm.LoadKey(enc); err == nil {
+				t.Error("LoadKey() of a deleted key succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestSimTPM20KeyEvictCollision(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	const handle = 0x81000040
+	first, err := tpm.NewKey(ak, &KeyConfig{Algorithm: ECDSA, Size: 256, PersistentHandle: handle})
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	defer first.Close()
+	if _, err := tpm.NewKey(ak, &KeyConfig{Algorithm: ECDSA, Size: 256, PersistentHandle: handle}); !errors.Is(err, ErrHandleInUse) {
+		t.Errorf("NewKey() with an occupied persistent handle err = %v, want errors.Is(err, ErrHandleInUse)", err)
+	}
+	second, err := tpm.NewKey(ak, &KeyConfig{Algorithm: ECDSA, Size: 256})
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	defer second.Close()
+
+	if err := second.Evict(handle); !errors.Is(err, ErrHandleInUse) {
+		t.Errorf("Evict() to an occupied handle err = %v, want errors.Is(err, ErrHandleInUse)", err)
+	}
+	// The SRK is created at its handle when the AK is.
+	err = second.Evict(defaultParentConfig.Handle)
+	if !errors.Is(err, ErrHandleInUse) || !strings.Contains(err.Error(), "SRK") {
+		t.Errorf("Evict() to the SRK handle err = %v, want ErrHandleInUse naming the SRK", err)
+	}
+
+	// The first key is left in place by the failed attempts.
+	firstPublic := first.CertificationParameters().Public
+	if _, err := tpm.LoadKeyFromHandle(handle, firstPublic); err != nil {
+		t.Fatalf("LoadKeyFromHandle() of the first key failed: %v", err)
+	}
+
+	if err := second.EvictForce(handle); err != nil {
+		t.Fatalf("EvictForce() failed: %v", err)
+	}
+	if _, err := tpm.LoadKeyFromHandle(handle, firstPublic); err == nil {
+		t.Error("LoadKeyFromHandle() of the overwritten key succeeded, want error")
+	}
+	if _, err := tpm.LoadKeyFromHandle(handle, second.CertificationParameters().Public); err != nil {
+		t.Errorf("LoadKeyFromHandle() of the second key failed: %v", err)
+	}
+	if err := second.Delete(); err != nil {
+		t.Errorf("Delete() failed: %v", err)
+	}
+}
+
+func TestSimTPM20KeyDecrypt(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	sk, err := tpm.NewKey(ak, &KeyConfig{Algorithm: RSA, Size: 2048, Decrypt: true})
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	pub := sk.Public().(*rsa.PublicKey)
+	msg := []byte("attack at dawn")
+
+	pkcs1, err := rsa.EncryptPKCS1v15(rand.Reader, pub, msg)
+	if err != nil {
+		t.Fatalf("EncryptPKCS1v15() failed: %v", err)
+	}
+	oaep, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, pub, msg, nil)
+	if err != nil {
+		t.Fatalf("EncryptOAEP() failed: %v", err)
+	}
+	label := []byte("kms\x00")
+	oaepLabel, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, pub, msg, label)
+	if err != nil {
+		t.Fatalf("EncryptOAEP() failed: %v", err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		ctxt    []byte
+		opts    crypto.DecrypterOpts
+		wantErr bool
+	}{
+		{"PKCS1v15 nil opts", pkcs1, nil, false},
+		{"PKCS1v15", pkcs1, &rsa.PKCS1v15DecryptOptions{}, false},
+		{"OAEP", oaep, &rsa.OAEPOptions{Hash: crypto.SHA256}, false},
+		{"OAEP label", oaepLabel, &rsa.OAEPOptions{Hash: crypto.SHA256, Label: label}, false},
+		{"OAEP wrong label", oaepLabel, &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("other\x00")}, true},
+		{"OAEP unterminated label", oaepLabel, &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("kms")}, true},
+		{"OAEP MGF hash mismatch", oaep, &rsa.OAEPOptions{Hash: crypto.SHA256, MGFHash: crypto.SHA1}, true},
+		{"OAEP as PKCS1v15", oaep, nil, true},
+		{"PKCS1v15 as OAEP", pkcs1, &rsa.OAEPOptions{Hash: crypto.SHA256}, true},
+		{"PKCS1v15 session key", pkcs1, &rsa.PKCS1v15DecryptOptions{SessionKeyLen: 16}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pt, err := sk.Decrypt(nil, test.ctxt, test.opts)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("Decrypt() = %q, want error", pt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decrypt() failed: %v", err)
+			}
+			if !bytes.Equal(pt, msg) {
+				t.Errorf("Decrypt() = %q, want %q", pt, msg)
+			}
+		})
+	}
+
+	priv, err := sk.Private(pub)
+	if err != nil {
+		t.Fatalf("sk.Private() failed: %v", err)
+	}
+	dec, ok := priv.(crypto.Decrypter)
+	if !ok {
+		t.Fatalf("sk.Private() returned %T, want crypto.Decrypter", priv)
+	}
+	if pt, err := dec.Decrypt(rand.Reader, oaep, &rsa.OAEPOptions{Hash: crypto.SHA256}); err != nil || !bytes.Equal(pt, msg) {
+		t.Errorf("Decrypter.Decrypt() = %q, %v, want %q", pt, err, msg)
+	}
+	if _, ok := priv.(crypto.Signer); !ok {
+		t.Errorf("sk.Private() returned %T, want crypto.Signer", priv)
+	}
+
+	if err := sk.Close(); err != nil {
+		t.Fatalf("sk.Close() failed: %v", err)
+	}
+
+	signOnly, err := tpm.NewKey(ak, &KeyConfig{Algorithm: RSA, Size: 2048})
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	defer signOnly.Close()
+	ctxt, err := rsa.EncryptPKCS1v15(rand.Reader, signOnly.Public().(*rsa.PublicKey), msg)
+	if err != nil {
+		t.Fatalf("EncryptPKCS1v15() failed: %v", err)
+	}
+	if _, err := signOnly.Decrypt(nil, ctxt, nil); err == nil {
+		t.Error("Decrypt() with a signing-only key succeeded, want error")
+	}
+	if priv, err := signOnly.Private(signOnly.Public()); err != nil {
+		t.Errorf("signOnly.Private() failed: %v", err)
+	} else if _, ok := priv.(crypto.Decrypter); ok {
+		t.Error("signOnly.Private() implements crypto.Decrypter for a signing-only key")
+	}
+
+	if _, err := tpm.NewKey(ak, &KeyConfig{Algorithm: ECDSA, Size: 256, Decrypt: true}); err == nil {
+		t.Error("NewKey() for an ECDSA decryption key succeeded, want error")
+	}
+}
+
+func TestSimTPM20KeyAuth(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	akAuth, keyAuth := []byte("ak password"), []byte("key password")
+	ak, err := tpm.NewAK(&AKConfig{Auth: akAuth})
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	if _, err := ak.Quote(tpm, []byte("nonce"), HashSHA256); err != nil {
+		t.Errorf("Quote() failed: %v", err)
+	}
+	eks, err := tpm.EKs()
+	if err != nil {
+		t.Fatalf("EKs() failed: %v", err)
+	}
+	ap := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         ak.AttestationParameters(),
+		EK:         chooseEK(t, eks).Public,
+	}
+	secret, challenge, err := ap.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	if got, err := ak.ActivateCredential(tpm, *challenge); err != nil {
+		t.Errorf("ActivateCredential() failed: %v", err)
+	} else if !bytes.Equal(got, secret) {
+		t.Error("ActivateCredential() returned the wrong secret")
+	}
+
+	k, err := tpm.NewKey(ak, &KeyConfig{Algorithm: ECDSA, Size: 256, Auth: keyAuth})
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	sign := func(k *Key) error {
+		priv, err := k.Private(k.Public())
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256([]byte("hello"))
+		_, err = priv.(crypto.Signer).Sign(rand.Reader, digest[:], crypto.SHA256)
+		return err
+	}
+	if err := sign(k); err != nil {
+		t.Errorf("Sign() failed: %v", err)
+	}
+	blob, err := k.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	k.Close()
+
+	// The auth value is not marshaled, so must be set after loading.
+	k, err = tpm.LoadKey(blob)
+	if err != nil {
+		t.Fatalf("LoadKey() failed: %v", err)
+	}
+	if err := sign(k); err == nil {
+		t.Error("Sign() without the auth value succeeded, want error")
+	}
+	if err := k.SetAuth([]byte("wrong password")); err != nil {
+		t.Fatalf("SetAuth() failed: %v", err)
+	}
+	if err := sign(k); err == nil {
+		t.Error("Sign() with the wrong auth value succeeded, want error")
+	}
+	if err := k.SetAuth(keyAuth); err != nil {
+		t.Fatalf("SetAuth() failed: %v", err)
+	}
+	if err := sign(k); err != nil {
+		t.Errorf("Sign() with the auth value failed: %v", err)
+	}
+	k.Close()
+
+	akBlob, err := ak.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	loadedAK, err := tpm.LoadAK(akBlob)
+	if err != nil {
+		t.Fatalf("LoadAK() failed: %v", err)
+	}
+	defer loadedAK.Close(tpm)
+	if _, err := loadedAK.Quote(tpm, []byte("nonce"), HashSHA256); err == nil {
+		t.Error("Quote() without the auth value succeeded, want error")
+	}
+	if err := loadedAK.SetAuth(akAuth); err != nil {
+		t.Fatalf("SetAuth() failed: %v", err)
+	}
+	if _, err := loadedAK.Quote(tpm, []byte("nonce"), HashSHA256); err != nil {
+		t.Errorf("Quote() with the auth value failed: %v", err)
+	}
+}
+
+func TestSimTPM20KeyObjectMemory(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	// Leak keys until the TPM runs out of slots for loaded objects. The
+	// simulator has only a few, so this happens quickly.
+	var keys []*Key
+	defer func() {
+		for _, k := range keys {
+			k.Close()
+		}
+	}()
+	for i := 0; ; i++ {
+		if i == 16 {
+			t.Fatalf("created %d keys without exhausting the TPM's memory", i)
+		}
+		k, err := tpm.NewKey(ak, nil)
+		if err != nil {
+			if !errors.Is(err, ErrTPMObjectMemory) {
+				t.Fatalf("NewKey() err = %v, want errors.Is(err, ErrTPMObjectMemory)", err)
+			}
+			break
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		t.Fatal("NewKey() failed with no keys loaded")
+	}
+
+	blob, err := keys[0].Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if _, err := tpm.LoadKey(blob); !errors.Is(err, ErrTPMObjectMemory) {
+		t.Errorf("LoadKey() err = %v, want errors.Is(err, ErrTPMObjectMemory)", err)
+	}
+
+	// Closing a key frees its slot.
+	if err := keys[0].Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	keys = keys[1:]
+	k, err := tpm.LoadKey(blob)
+	if err != nil {
+		t.Fatalf("LoadKey() after closing a key failed: %v", err)
+	}
+	keys = append(keys, k)
+}
+
+func TestSimTPM20KeyRewrap(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	// The simulator has room for few loaded keys, so the keys are closed
+	// and loaded again once the AK is closed.
+	var blobs [][]byte
+	for _, duplicable := range []bool{false, true} {
+		k, err := tpm.NewKey(ak, &KeyConfig{Algorithm: ECDSA, Size: 256, Duplicable: duplicable, Auth: []byte("secret")})
+		if err != nil {
+			t.Fatalf("NewKey() failed: %v", err)
+		}
+		blob, err := k.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal() failed: %v", err)
+		}
+		k.Close()
+		blobs = append(blobs, blob)
+	}
+	ak.Close(tpm)
+
+	fixed, err := tpm.LoadKey(blobs[0])
+	if err != nil {
+		t.Fatalf("LoadKey() failed: %v", err)
+	}
+	if _, err := fixed.Rewrap(nil); !errors.Is(err, ErrKeyNotDuplicable) {
+		t.Errorf("Rewrap() of a non-duplicable key err = %v, want %v", err, ErrKeyNotDuplicable)
+	}
+	fixed.Close()
+
+	sk, err := tpm.LoadKey(blobs[1])
+	if err != nil {
+		t.Fatalf("LoadKey() failed: %v", err)
+	}
+	parent := ParentKeyConfig{Algorithm: ECDSA, Handle: 0x81000010}
+	rewrapped, err := sk.Rewrap(&parent)
+	if err != nil {
+		t.Fatalf("Rewrap() failed: %v", err)
+	}
+	sk.Close()
+	enc, err := rewrapped.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	rewrapped.Close()
+
+	// The key now only loads under the new parent.
+	if k, err := tpm.LoadKey(enc); err == nil {
+		k.Close()
+		t.Error("LoadKey() under the old parent succeeded, want error")
+	}
+	loaded, err := tpm.LoadKeyWithParent(enc, parent)
+	if err != nil {
+		t.Fatalf("LoadKeyWithParent() failed: %v", err)
+	}
+	defer loaded.Close()
+	if err := loaded.SetAuth([]byte("secret")); err != nil {
+		t.Fatalf("SetAuth() failed: %v", err)
+	}
+	if !loaded.Public().(*ecdsa.PublicKey).Equal(sk.Public()) {
+		t.Error("rewrapped key has a different public key")
+	}
+	priv, err := loaded.Private(loaded.Public())
+	if err != nil {
+		t.Fatalf("Private() failed: %v", err)
+	}
+	digest := sha256.Sum256([]byte("rewrapped"))
+	sig, err := priv.(crypto.Signer).Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Sign() failed: %v", err)
+	}
+	if !ecdsa.VerifyASN1(loaded.Public().(*ecdsa.PublicKey), digest[:], sig) {
+		t.Error("signature of the rewrapped key is invalid")
+	}
+}
+
+func TestSimTPM20KeyConcurrentSign(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		retry *RetryPolicy
+	}{
+		{"no retry", nil},
+		// Keys created with a retry policy share its command channel.
+		{"retry", &RetryPolicy{InitialBackoff: time.Millisecond}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sim, err := simulator.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sim.Close()
+			tpm, err := OpenTPM(&OpenConfig{CommandChannel: &fakeCmdChannel{sim}, Retry: test.retry})
+			if err != nil {
+				t.Fatalf("OpenTPM() failed: %v", err)
+			}
+			testConcurrentSign(t, tpm)
+		})
+	}
+}
+
+// testConcurrentSign signs with keys of tpm from multiple goroutines.
+func testConcurrentSign(t *testing.T, tpm *TPM) {
+	t.Helper()
+	// The simulator has room for few loaded keys, so the ECDSA key is
+	// loaded again once the AK is closed. The RSA key is used as created,
+	// so that its goroutines share the command channel of NewKey.
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	k, err := tpm.NewKey(ak, &KeyConfig{Algorithm: ECDSA, Size: 256})
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	blob, err := k.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	k.Close()
+	created, err := tpm.NewKey(ak, nil)
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	defer created.Close()
+	ak.Close(tpm)
+	loaded, err := tpm.LoadKey(blob)
+	if err != nil {
+		t.Fatalf("LoadKey() failed: %v", err)
+	}
+	defer loaded.Close()
+	keys := []*Key{created, loaded}
+
+	const goroutines, signs = 8, 10
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			k := keys[g%len(keys)]
+			signer, err := k.Private(k.Public())
+			if err != nil {
+				errs <- err
+				return
+			}
+			for i := 0; i < signs; i++ {
+				digest := sha256.Sum256([]byte{byte(g), byte(i)})
+				sig, err := signer.(crypto.Signer).Sign(rand.Reader, digest[:], crypto.SHA256)
+				if err != nil {
+					errs <- fmt.Errorf("goroutine %d: Sign() failed: %v", g, err)
+					return
+				}
+				switch pub := k.Public().(type) {
+				case *rsa.PublicKey:
+					err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+				case *ecdsa.PublicKey:
+					if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+						err = errors.New("invalid ECDSA signature")
+					}
+				}
+				if err != nil {
+					errs <- fmt.Errorf("goroutine %d: signature %d: %v", g, i, err)
+					return
+				}
+			}
+			errs <- nil
+		}(g)
+	}
+	for g := 0; g < goroutines; g++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// cancellingCmdChannel cancels a context once a number of commands have
+// been sent.
+type cancellingCmdChannel struct {
+	CommandChannelTPM20
+	cancel    context.CancelFunc
+	remaining int
+}
+
+func (c *cancellingCmdChannel) Write(cmd []byte) (int, error) {
+	if c.remaining--; c.remaining == 0 {
+		c.cancel()
+	}
+	return c.CommandChannelTPM20.Write(cmd)
+}
+
+func TestSimTPM20KeyContext(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+	w := tpm.tpm.(*wrappedTPM20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tpm.NewAKContext(ctx, nil); err != context.Canceled {
+		t.Errorf("NewAKContext() with cancelled context returned %v, want %v", err, context.Canceled)
+	}
+
+	ak, err := tpm.NewAKContext(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("NewAKContext() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	if _, err := tpm.NewKeyContext(ctx, ak, nil); err != context.Canceled {
+		t.Errorf("NewKeyContext() with cancelled context returned %v, want %v", err, context.Canceled)
+	}
+
+	transientHandles := func() int {
+		t.Helper()
+		handles, _, err := tpm2.GetCapability(w.rwc, tpm2.CapabilityHandles, 16, uint32(tpm2.HandleTypeTransient)<<24)
+		if err != nil {
+			t.Fatalf("GetCapability() failed: %v", err)
+		}
+		return len(handles)
+	}
+	before := transientHandles()
+
+	// Cancel part way through creating the key, after it has been loaded.
+	for _, n := range []int{1, 3, 4} {
+		ctx, cancel := context.WithCancel(context.Background())
+		w.rwc = &cancellingCmdChannel{CommandChannelTPM20: w.rwc, cancel: cancel, remaining: n}
+		_, err := tpm.NewKeyContext(ctx, ak, nil)
+		w.rwc = w.rwc.(*cancellingCmdChannel).CommandChannelTPM20
+		cancel()
+		if err != context.Canceled {
+			t.Errorf("NewKeyContext() cancelled after %d commands returned %v, want %v", n, err, context.Canceled)
+		}
+	}
+	if after := transientHandles(); after != before {
+		t.Errorf("cancelled NewKeyContext() leaked handles: got %d transient handles, want %d", after, before)
+	}
+
+	sk, err := tpm.NewKeyContext(context.Background(), ak, nil)
+	if err != nil {
+		t.Fatalf("NewKeyContext() failed: %v", err)
+	}
+	defer sk.Close()
+	if sk.tpm != tpm.tpm {
+		t.Error("key created by NewKeyContext() retains context")
+	}
+	if _, err := ak.CertifyContext(ctx, tpm, sk.key.(*wrappedKey20).hnd); err != context.Canceled {
+		t.Errorf("CertifyContext() with cancelled context returned %v, want %v", err, context.Canceled)
+	}
+	if _, err := ak.CertifyContext(context.Background(), tpm, sk.key.(*wrappedKey20).hnd); err != nil {
+		t.Errorf("CertifyContext() failed: %v", err)
+	}
+}
+
+func TestSimTPM20KeyOpts(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+	testKeyOpts(t, tpm)
+}
+
+func TestTPM20KeyOpts(t *testing.T) {
+	if !*testLocal {
+		t.SkipNow()
+	}
+	tpm, err := OpenTPM(nil)
+	if err != nil {
+		t.Fatalf("OpenTPM() failed: %v", err)
+	}
+	defer tpm.Close()
+	testKeyOpts(t, tpm)
+}
+
+func testKeyOpts(t *testing.T, tpm *TPM) {
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	for _, test := range []struct {
+		name string
+		opts *KeyConfig
+		err  bool
+	}{
+		{
+			name: "wrong alg",
+			opts: &KeyConfig{
+				Algorithm: "fake alg",
+			},
+			err: true,
+		},
+		{
+			name: "wrong size",
+			opts: &KeyConfig{
+				Algorithm: ECDSA,
+				Size:      1234,
+			},
+			err: true,
+		},
+		{
+			name: "default",
+			opts: nil,
+			err:  false,
+		},
+		{
+			name: "ECDSAP256",
+			opts: &KeyConfig{
+				Algorithm: ECDSA,
+				Size:      256,
+			},
+			err: false,
+		},
+		{
+			name: "ECDSAP384",
+			opts: &KeyConfig{
+				Algorithm: ECDSA,
+				Size:      384,
+			},
+			err: false,
+		},
+		{
+			name: "ECDSAP521",
+			opts: &KeyConfig{
+				Algorithm: ECDSA,
+				Size:      521,
+			},
+			err: false,
+		},
+		{
+			name: "RSA-1024",
+			opts: &KeyConfig{
+				Algorithm: RSA,
+				Size:      1024,
+			},
+			err: false,
+		},
+		{
+			name: "RSA-2048",
+			opts: &KeyConfig{
+				Algorithm: RSA,
+				Size:      2048,
+			},
+			err: false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sk, err := tpm.NewKey(ak, test.opts)
+			if !test.err && err != nil {
+				t.Fatalf("NewKey() failed: %v", err)
+			}
+			if test.err {
+				if err == nil {
+					sk.Close()
+					t.Fatalf("NewKey(): expected err != nil")
+				}
+				return
+			}
+			defer sk.Close()
+
+			expected := test.opts
+			if expected == nil {
+				expected = defaultConfig
+			}
+
+			switch pub := sk.Public().(type) {
+			case *ecdsa.PublicKey:
+				if expected.Algorithm != ECDSA {
+					t.Errorf("incorrect key type generated, expected %q, got EC", expected.Algorithm)
+				}
+				sizeToCurve := map[int]elliptic.Curve{
+					256: elliptic.P256(),
+					384: elliptic.P384(),
+					521: elliptic.P521(),
+				}
+				expectedCurve, ok := sizeToCurve[expected.Size]
+				if !ok {
+					t.Fatalf("cannot match curve to key size %d", expected.Size)
+				}
+				if expectedCurve != pub.Curve {
+					t.Errorf("incorrect curve, expected %v, got %v", expectedCurve, pub.Curve)
+				}
+			case *rsa.PublicKey:
+				if expected.Algorithm != RSA {
+					t.Errorf("incorrect key type, expected %q, got RSA", expected.Algorithm)
+				}
+				if pub.Size()*8 != expected.Size {
+					t.Errorf("incorrect key size, expected %d, got %d", expected.Size, pub.Size()*8)
+				}
+			default:
+				t.Errorf("unsupported key type: %T", pub)
+			}
+		})
+	}
+}
+
+func TestSimTPM20KeyCertificateRequest(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	for _, test := range []struct {
+		name      string
+		keyOpts   *KeyConfig
+		requested x509.SignatureAlgorithm
+		want      x509.SignatureAlgorithm
+		wantErr   bool
+	}{
+		{
+			name:    "ECDSAP256",
+			keyOpts: &KeyConfig{Algorithm: ECDSA, Size: 256},
+			want:    x509.ECDSAWithSHA256,
+		},
+		{
+			name:    "ECDSAP384",
+			keyOpts: &KeyConfig{Algorithm: ECDSA, Size: 384},
+			want:    x509.ECDSAWithSHA384,
+		},
+		{
+			name:      "ECDSAP256, mismatched hash",
+			keyOpts:   &KeyConfig{Algorithm: ECDSA, Size: 256},
+			requested: x509.ECDSAWithSHA512,
+			wantErr:   true,
+		},
+		{
+			name:    "RSA2048",
+			keyOpts: &KeyConfig{Algorithm: RSA, Size: 2048},
+			want:    x509.SHA256WithRSA,
+		},
+		{
+			name:      "RSA2048-PSS-SHA384",
+			keyOpts:   &KeyConfig{Algorithm: RSA, Size: 2048},
+			requested: x509.SHA384WithRSAPSS,
+			want:      x509.SHA384WithRSAPSS,
+		},
+		{
+			name:      "RSA2048, ECDSA requested",
+			keyOpts:   &KeyConfig{Algorithm: RSA, Size: 2048},
+			requested: x509.ECDSAWithSHA256,
+			wantErr:   true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sk, err := tpm.NewKey(ak, test.keyOpts)
+			if err != nil {
+				t.Fatalf("NewKey() failed: %v", err)
+			}
+			defer sk.Close()
+
+			der, err := sk.CertificateRequest(&x509.CertificateRequest{
+				Subject:            pkix.Name{CommonName: "test"},
+				SignatureAlgorithm: test.requested,
+			})
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("CertificateRequest() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CertificateRequest() failed: %v", err)
+			}
+			csr, err := x509.ParseCertificateRequest(der)
+			if err != nil {
+				t.Fatalf("ParseCertificateRequest() failed: %v", err)
+			}
+			if err := csr.CheckSignature(); err != nil {
+				t.Errorf("CheckSignature() failed: %v", err)
+			}
+			if csr.SignatureAlgorithm != test.want {
+				t.Errorf("SignatureAlgorithm = %v, want %v", csr.SignatureAlgorithm, test.want)
+			}
+			if csr.Subject.CommonName != "test" {
+				t.Errorf("Subject.CommonName = %q, want %q", csr.Subject.CommonName, "test")
+			}
+			got, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+			if err != nil {
+				t.Fatalf("MarshalPKIXPublicKey() failed: %v", err)
+			}
+			want, err := sk.PublicDER()
+			if err != nil {
+				t.Fatalf("PublicDER() failed: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Error("CSR public key does not match the key's public key")
+			}
+		})
+	}
+}
+
+func TestKeyConfigCurve(t *testing.T) {
+	tpmCurves := map[tpm2.EllipticCurve]elliptic.Curve{
+		tpm2.CurveNISTP256: elliptic.P256(),
+		tpm2.CurveNISTP384: elliptic.P384(),
+		tpm2.CurveNISTP521: elliptic.P521(),
+	}
+	for _, test := range []struct {
+		cfg  KeyConfig
+		want elliptic.Curve
+	}{
+		{KeyConfig{Algorithm: ECDSA, Size: 256}, elliptic.P256()},
+		{KeyConfig{Algorithm: ECDSA, Size: 384}, elliptic.P384()},
+		{KeyConfig{Algorithm: ECDSA, Size: 521}, elliptic.P521()},
+		{KeyConfig{Algorithm: ECDSA, Size: 224}, nil},
+		{KeyConfig{Algorithm: RSA, Size: 256}, nil},
+	} {
+		got, ok := test.cfg.Curve()
+		if got != test.want || ok != (test.want != nil) {
+			t.Errorf("%s %d: Curve() = %v, %v, want %v", test.cfg.Algorithm, test.cfg.Size, got, ok, test.want)
+		}
+		if !ok {
+			continue
+		}
+		// The curve must agree with the template used to create keys.
+		tmpl, err := templateFromConfig(&test.cfg)
+		if err != nil {
+			t.Fatalf("templateFromConfig() failed: %v", err)
+		}
+		if tpmCurve := tpmCurves[tmpl.ECCParameters.CurveID]; tpmCurve != got {
+			t.Errorf("%s %d: template curve %v, want %v", test.cfg.Algorithm, test.cfg.Size, tpmCurve, got)
+		}
+	}
+}
+
+func TestTemplateFromConfigRSASize(t *testing.T) {
+	for _, test := range []struct {
+		size int
+		want uint16
+	}{
+		{0, 2048},
+		{2048, 2048},
+		{3072, 3072},
+		{4096, 4096},
+	} {
+		tmpl, err := templateFromConfig(&KeyConfig{Algorithm: RSA, Size: test.size})
+		if err != nil {
+			t.Fatalf("templateFromConfig(%d) failed: %v", test.size, err)
+		}
+		if got := tmpl.RSAParameters.KeyBits; got != test.want {
+			t.Errorf("templateFromConfig(%d) key bits = %d, want %d", test.size, got, test.want)
+		}
+	}
+	// Templates are built from shared defaults, which must not change.
+	if _, err := templateFromConfig(&KeyConfig{Algorithm: ECDSA, Size: 384}); err != nil {
+		t.Fatalf("templateFromConfig() failed: %v", err)
+	}
+	if rsaKeyTemplate.RSAParameters.KeyBits != 0 {
+		t.Errorf("rsaKeyTemplate modified: key bits = %d", rsaKeyTemplate.RSAParameters.KeyBits)
+	}
+	if ecdsaKeyTemplate.ECCParameters.CurveID != 0 || ecdsaKeyTemplate.ECCParameters.Sign.Hash != 0 {
+		t.Errorf("ecdsaKeyTemplate modified: %+v", ecdsaKeyTemplate.ECCParameters)
+	}
+}
+
+func TestCheckAKConfigSize(t *testing.T) {
+	for _, test := range []struct {
+		cfg     *AKConfig
+		wantErr bool
+	}{
+		{nil, false},
+		{&AKConfig{}, false},
+		{&AKConfig{Size: 3072}, false},
+		{&AKConfig{Algorithm: RSA, Size: 4096}, false},
+		{&AKConfig{Size: 1024}, true},
+		{&AKConfig{Algorithm: ECDSA, Size: 384}, true},
+	} {
+		if err := checkAKConfigSize(test.cfg); (err != nil) != test.wantErr {
+			t.Errorf("checkAKConfigSize(%+v) = %v, wantErr %v", test.cfg, err, test.wantErr)
+		}
+	}
+}
+
+func TestCheckAKConfigNoAuth(t *testing.T) {
+	for _, test := range []struct {
+		cfg     *AKConfig
+		wantErr bool
+	}{
+		{nil, false},
+		{&AKConfig{Size: 2048}, false},
+		{&AKConfig{Auth: []byte{}}, true},
+		{&AKConfig{AuthPolicy: make([]byte, 32)}, true},
+		{&AKConfig{PolicySession: func(io.ReadWriter, tpmutil.Handle) error { return nil }}, true},
+		{&AKConfig{EK: &EK{}}, true},
+	} {
+		if err := checkAKConfigNoAuth(test.cfg); (err != nil) != test.wantErr {
+			t.Errorf("checkAKConfigNoAuth(%+v) = %v, wantErr %v", test.cfg, err, test.wantErr)
+		}
 	}
 }
@@ -16,6 +16,7 @@ package eventlog
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"testing"
 
@@ -34,6 +35,9 @@ func parseEvents(t *testing.T, testdata string) []attest.Event {
 	}
 
 	ak, err := attest.ParseAKPublic(dump.Static.TPMVersion, dump.AK.Public)
+	if errors.Is(err, attest.ErrTPM12NotBuilt) {
+		t.Skipf("parsing AK: %v", err)
+	}
 	if err != nil {
 		t.Fatalf("parsing AK: %v", err)
 	}
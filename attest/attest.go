@@ -16,15 +16,21 @@
 package attest
 
 import (
+	"bytes"
+	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/x509"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/google/go-tpm/legacy/tpm2"
-	"github.com/google/go-tpm/tpm"
 	"github.com/google/go-tpm/tpmutil"
 )
 
@@ -68,6 +74,79 @@ type OpenConfig struct {
 	// CommandChannel provides a TPM 2.0 command channel, which can be
 	// used in-lieu of any TPM present on the platform.
 	CommandChannel CommandChannelTPM20
+
+	// Retry, if set, resends TPM 2.0 commands which fail with a transient
+	// response code while creating keys and signing. If nil, those errors
+	// are returned to the caller.
+	Retry *RetryPolicy
+
+	// Audit, if set, is called after every TPM 2.0 command with its
+	// command code and the response code returned by the TPM, including
+	// commands which are resent under the Retry policy. It is not called
+	// if the command couldn't be sent or the response couldn't be read.
+	//
+	// Auditing is not supported for TPMs accessed through the Windows
+	// Platform Crypto Provider, nor for TPM 1.2 devices.
+	Audit func(cmd tpmutil.Command, rc tpmutil.ResponseCode)
+
+	// DevicePath, if set, is the path of the TPM 2.0 character device to
+	// open on Linux, such as /dev/tpmrm0 or /dev/tpm1, instead of probing
+	// for a TPM. An error wrapping fs.ErrNotExist is returned if the
+	// device doesn't exist.
+	//
+	// Windows exposes a single TPM through TBS, which is always used, so
+	// DevicePath is ignored there.
+	DevicePath string
+
+	// PreferResourceManager opens the in-kernel resource manager of the
+	// raw TPM device given by DevicePath, such as /dev/tpmrm0 for
+	// /dev/tpm0, if the kernel provides one. TPMs found by probing always
+	// use the resource manager when available.
+	PreferResourceManager bool
+}
+
+// RetryPolicy configures how TPM 2.0 commands are retried when the TPM
+// reports a transient condition: TPM_RC_YIELDED, TPM_RC_TESTING,
+// TPM_RC_NV_RATE or TPM_RC_NV_UNAVAILABLE. Other response codes,
+// including TPM_RC_LOCKOUT, are returned without retrying.
+//
+// TPM_RC_RETRY is already retried with a backoff by go-tpm for every
+// command, and is not covered by the policy.
+//
+// Retries compose with concurrent use of the TPM and its keys: a command
+// is only ever resent by the goroutine which sent it, although commands
+// of other goroutines may run between its attempts.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a command is sent,
+	// including the first attempt. If zero, this defaults to 5.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, which doubles
+	// after every attempt. If zero, this defaults to 10ms.
+	InitialBackoff time.Duration
+	// MaxBackoff bounds the delay between attempts. If zero, this
+	// defaults to 1s.
+	MaxBackoff time.Duration
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts == 0 {
+		return 5
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) initialBackoff() time.Duration {
+	if p.InitialBackoff == 0 {
+		return 10 * time.Millisecond
+	}
+	return p.InitialBackoff
+}
+
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff == 0 {
+		return time.Second
+	}
+	return p.MaxBackoff
 }
 
 // keyEncoding indicates how an exported TPM key is represented.
@@ -101,9 +180,15 @@ const (
 
 // ParentKeyConfig describes the Storage Root Key that is used
 // as a parent for new keys.
+//
+// If a key already exists at Handle, it must be a storage key of the
+// given Algorithm. Otherwise, a Storage Root Key of that algorithm is
+// created and persisted at Handle.
 type ParentKeyConfig struct {
+	// Algorithm is the algorithm of the parent key, either RSA or ECDSA.
 	Algorithm Algorithm
-	Handle    tpmutil.Handle
+	// Handle is the persistent handle of the parent key. It must be set.
+	Handle tpmutil.Handle
 }
 
 var defaultParentConfig = ParentKeyConfig{
@@ -117,6 +202,7 @@ type ak interface {
 	activateCredential(tpm tpmBase, in EncryptedCredential, ek *EK) ([]byte, error)
 	quote(t tpmBase, nonce []byte, alg HashAlg, selectedPCRs []int) (*Quote, error)
 	attestationParameters() AttestationParameters
+	tpmVersion() TPMVersion
 	certify(tb tpmBase, handle interface{}) (*CertificationParameters, error)
 }
 
@@ -130,6 +216,41 @@ func (k *AK) Close(t *TPM) error {
 	return k.ak.close(t.tpm)
 }
 
+// SetAuth sets the authorization value (password) used for operations with
+// the AK, which is required for AKs created with AKConfig.Auth and loaded
+// again. A copy of auth is kept, so the caller may zero auth afterwards.
+// The copy is zeroed when the AK is closed.
+//
+// This is only supported on TPM 2.0.
+func (k *AK) SetAuth(auth []byte) error {
+	w, ok := k.ak.(*wrappedKey20)
+	if !ok {
+		return errors.New("authorization values are not supported for this AK")
+	}
+	w.setAuth(auth)
+	return nil
+}
+
+// Public returns the public key of the AK, or nil if its public area can't
+// be parsed. It is the key returned by ParseAKPublic for the AK's
+// AttestationParameters.
+func (k *AK) Public() crypto.PublicKey {
+	pub, err := ParseAKPublic(k.ak.tpmVersion(), k.ak.attestationParameters().Public)
+	if err != nil {
+		return nil
+	}
+	return pub.Public
+}
+
+// Signer always returns an error. AKs are restricted signing keys, which
+// the TPM only allows to sign structures it generated itself, such as
+// quotes and certifications, so they can't implement crypto.Signer over
+// arbitrary digests. Use Quote or Certify to sign with an AK, or create a
+// Key with NewKey for general purpose signing.
+func (k *AK) Signer() (crypto.Signer, error) {
+	return nil, errors.New("AKs are restricted keys and can't sign arbitrary digests, use a Key instead")
+}
+
 // Marshal encodes the AK in a format that can be reloaded with tpm.LoadAK().
 // This method exists to allow consumers to store the key persistently and load
 // it as a later time. Users SHOULD NOT attempt to interpret or extract values
@@ -174,6 +295,54 @@ func (k *AK) QuotePCRs(tpm *TPM, nonce []byte, alg HashAlg, pcrs []int) (*Quote,
 	return k.ak.quote(tpm.tpm, nonce, alg, pcrs)
 }
 
+// QuoteSelection is like QuotePCRs, but quotes the PCRs of the bank
+// selected by sel, which is validated first.
+func (k *AK) QuoteSelection(tpm *TPM, nonce []byte, sel PCRSelection) (*Quote, error) {
+	if err := sel.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid PCR selection: %v", err)
+	}
+	return k.ak.quote(tpm.tpm, nonce, sel.Hash, sel.sorted())
+}
+
+// QuoteWithPCRs is like QuotePCRs, but also returns the values of the
+// quoted PCRs keyed by PCR index, as expected by VerifyQuote. The nonce
+// is included in the quote as its extraData.
+//
+// The quote is verified against the PCR values before being returned, so
+// an error is returned if a quoted PCR changed while the quote was being
+// generated.
+//
+// This is only supported on TPM 2.0.
+func (k *AK) QuoteWithPCRs(tpm *TPM, nonce []byte, alg HashAlg, pcrs []int) (*Quote, map[int][]byte, error) {
+	if tpm.Version() != TPMVersion20 {
+		return nil, nil, fmt.Errorf("QuoteWithPCRs is only supported on TPM 2.0, got version %d", tpm.Version())
+	}
+	all, err := tpm.PCRs(alg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %v PCRs: %v", alg, err)
+	}
+	values := make(map[int][]byte, len(pcrs))
+	for _, pcr := range pcrs {
+		if pcr < 0 || pcr >= len(all) {
+			return nil, nil, fmt.Errorf("invalid PCR index %d", pcr)
+		}
+		values[pcr] = all[pcr].Digest
+	}
+
+	quote, err := k.ak.quote(tpm.tpm, nonce, alg, pcrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, err := ParseAKPublic(TPMVersion20, k.AttestationParameters().Public)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse AK public: %v", err)
+	}
+	if err := VerifyQuote(pub.Public, quote.Quote, quote.Signature, nonce, values); err != nil {
+		return nil, nil, fmt.Errorf("local quote verification failed: %v", err)
+	}
+	return quote, values, nil
+}
+
 // AttestationParameters returns information about the AK, typically used to
 // generate a credential activation challenge.
 func (k *AK) AttestationParameters() AttestationParameters {
@@ -188,19 +357,144 @@ func (k *AK) Certify(tpm *TPM, handle interface{}) (*CertificationParameters, er
 	return k.ak.certify(tpm.tpm, handle)
 }
 
+// CertifyContext is like Certify, but returns ctx.Err() if ctx is done
+// before the key has been certified. See TPM.NewAKContext for how ctx is
+// honored.
+func (k *AK) CertifyContext(ctx context.Context, tpm *TPM, handle interface{}) (*CertificationParameters, error) {
+	tb, err := tpm.withContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p, err := k.ak.certify(tb, handle)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return p, err
+}
+
 // AKConfig encapsulates parameters for minting keys.
 type AKConfig struct {
+	// Algorithm to be used, either RSA or ECDSA. If unset, an RSA key is
+	// created. Supported only by TPM 2.0 on Linux.
+	Algorithm Algorithm
 	// Parent describes the Storage Root Key that will be used as a parent.
 	// If nil, the default SRK (i.e. RSA with handle 0x81000001) is assumed.
 	// Supported only by TPM 2.0 on Linux.
 	Parent *ParentKeyConfig
+	// AuthPolicy, if set, is the policy digest placed in the authPolicy of
+	// the AK. The AK is then created without the userWithAuth attribute,
+	// so it can only sign within a policy session satisfying the digest
+	// (for example one built with TPM2_PolicyPCR, whose digest is computed
+	// by ComputePolicyPCRDigest). The digest must be computed with SHA256.
+	// Supported only by TPM 2.0 on Linux: other TPMs fail to create the AK.
+	AuthPolicy []byte
+	// PolicySession satisfies AuthPolicy by running policy commands on the
+	// given session. It must be set if and only if AuthPolicy is. It is
+	// called to authorize the AK to certify its creation, and to quote and
+	// certify keys until the AK is closed. AKs which are loaded again
+	// can't quote or certify keys, as they authorize with a password.
+	PolicySession func(rw io.ReadWriter, session tpmutil.Handle) error
+	// Auth, if set, is the authorization value (password) the AK is
+	// created with. A copy is kept until the AK is closed, and used to
+	// authorize certifying its creation, activating credentials, quoting
+	// and certifying keys. It is not included in the output of Marshal,
+	// so AKs which are loaded again need it to be provided with SetAuth.
+	// Supported only by TPM 2.0 on Linux: other TPMs fail to create the AK.
+	Auth []byte
+	// Size is the size in bits of an RSA AK: 2048, 3072 or 4096. If unset,
+	// a 2048-bit key is created. Larger keys are slower to create and
+	// are not implemented by every TPM. It must be unset for ECDSA AKs.
+	// Supported only by TPM 2.0 on Linux.
+	Size int
+	// EK, if set, creates the AK as a child of the given EK rather than of
+	// the SRK, so the AK is in the endorsement hierarchy. Its quotes then
+	// carry the firmware version of the TPM, and its creation passes
+	// CheckAKParameters with RequireEndorsementHierarchy set, as required
+	// by AKPublic.VerifyWithFirmware. The EK is made persistent at its
+	// usual handle if it isn't already. It must not be set with Parent.
+	// Supported only by TPM 2.0 on Linux: other TPMs fail to create the AK.
+	EK *EK
+}
+
+// checkAKConfigNoAuth returns an error if opts sets any of the
+// authorization options of AKConfig, or EK, whose parent is authorized
+// with a policy session, for TPM implementations which can't create AKs
+// with them.
+func checkAKConfigNoAuth(opts *AKConfig) error {
+	switch {
+	case opts == nil:
+		return nil
+	case opts.Auth != nil:
+		return errors.New("AKConfig.Auth is not supported by this TPM")
+	case opts.AuthPolicy != nil:
+		return errors.New("AKConfig.AuthPolicy is not supported by this TPM")
+	case opts.PolicySession != nil:
+		return errors.New("AKConfig.PolicySession is not supported by this TPM")
+	case opts.EK != nil:
+		return errors.New("AKConfig.EK is not supported by this TPM")
+	}
+	return nil
+}
+
+// checkAKConfigSize validates the Size of an AKConfig.
+func checkAKConfigSize(opts *AKConfig) error {
+	if opts == nil || opts.Size == 0 {
+		return nil
+	}
+	if opts.Algorithm == ECDSA {
+		return fmt.Errorf("key size is not configurable for %v AKs", opts.Algorithm)
+	}
+	switch opts.Size {
+	case 2048, 3072, 4096:
+		return nil
+	}
+	return fmt.Errorf("unsupported RSA AK size: %d", opts.Size)
 }
 
 // EncryptedCredential represents encrypted parameters which must be activated
 // against a key.
+//
+// When encoded as JSON, Credential and Secret are base64-encoded strings
+// named after the fields.
 type EncryptedCredential struct {
 	Credential []byte
-	Secret     []byte
+	// Secret is, for TPM 2.0, the seed protecting Credential, encrypted
+	// to the EK. Its size depends on the type and size of the EK, and is
+	// reported by ActivationParameters.EncryptedSecretSize: for example
+	// 258 bytes for an RSA-2048 EK, or 70 bytes for a P-256 EK.
+	Secret []byte
+
+	// Parameters describes how the credential was protected. It is
+	// populated by Generate for auditing, and is not needed to activate
+	// the credential. It is not included in the JSON encoding.
+	Parameters *CredentialParameters `json:"-"`
+
+	// SealedSecret is the activation secret encrypted to
+	// ActivationParameters.RecipientKey, if set. It is for the holder of
+	// that key rather than the TPM, so is not included in the JSON
+	// encoding.
+	SealedSecret []byte `json:"-"`
+}
+
+// CredentialParameters describes the cryptographic parameters used to
+// protect an EncryptedCredential. It contains no value from which the
+// activation secret can be recovered, so may be recorded in audit logs.
+type CredentialParameters struct {
+	// Cipher is the symmetric cipher and mode which encrypts the
+	// activation secret, such as "AES-128-CFB".
+	Cipher string
+	// KDFHash is the hash algorithm used to derive the encryption and
+	// integrity keys from the seed. Only set for TPM 2.0.
+	KDFHash crypto.Hash
+	// SeedDigest is the SHA-256 digest of the seed from which the
+	// encryption and integrity keys were derived. It uniquely identifies
+	// a challenge. Only set for TPM 2.0.
+	SeedDigest []byte
+	// AKName is the name of the AK the credential was bound to, as
+	// returned by AKFingerprint: the name the TPM checks when activating
+	// the credential. It can be stored as the identifier of the AK to
+	// correlate the device's response. Only set for TPM 2.0.
+	AKName []byte
 }
 
 // Quote encapsulates the results of a Quote operation against the TPM,
@@ -211,6 +505,73 @@ type Quote struct {
 	Signature []byte
 }
 
+// ClockInfo returns the state of the TPM's clock when a TPM 2.0 quote was
+// taken. The clock information is only authentic once the quote has been
+// verified, for example by AKPublic.Verify.
+func (q *Quote) ClockInfo() (*ClockInfo, error) {
+	if q.Version != TPMVersion20 {
+		return nil, fmt.Errorf("clock information is only available for TPM 2.0 quotes, got version %d", q.Version)
+	}
+	att, err := q.attestationData20()
+	if err != nil {
+		return nil, err
+	}
+	ci := newClockInfo(att.ClockInfo)
+	return &ci, nil
+}
+
+// FirmwareVersion returns the vendor-specific firmware version of the TPM
+// which took a TPM 2.0 quote. Like the clock information, it is only
+// authentic once the quote has been verified.
+//
+// TPMs obfuscate the firmware version of quotes signed by keys outside the
+// endorsement hierarchy, such as AKs created under the SRK by TPM.NewAK
+// without AKConfig.EK, so for those AKs the value is pseudo-random rather
+// than a version. Use
+// AKPublic.VerifyWithFirmware, which checks the AK is in the endorsement
+// hierarchy, to enforce a minimum version.
+func (q *Quote) FirmwareVersion() (uint64, error) {
+	if q.Version != TPMVersion20 {
+		return 0, fmt.Errorf("firmware version is only available for TPM 2.0 quotes, got version %d", q.Version)
+	}
+	att, err := q.attestationData20()
+	if err != nil {
+		return 0, err
+	}
+	return att.FirmwareVersion, nil
+}
+
+// PCRSelections returns the PCRs covered by a TPM 2.0 quote, one selection
+// per PCR bank, so verifiers can check the quote covers the PCRs they
+// expect. Like the clock information, they are only authentic once the
+// quote has been verified.
+func (q *Quote) PCRSelections() ([]PCRSelection, error) {
+	if q.Version != TPMVersion20 {
+		return nil, fmt.Errorf("PCR selections are only available for TPM 2.0 quotes, got version %d", q.Version)
+	}
+	_, sels, _, err := decodeQuote20(q.Quote)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PCRSelection, 0, len(sels))
+	for _, sel := range sels {
+		out = append(out, PCRSelection{Hash: HashAlg(sel.Hash), PCRs: sel.PCRs})
+	}
+	return out, nil
+}
+
+// attestationData20 decodes the attestation of a TPM 2.0 quote.
+func (q *Quote) attestationData20() (*tpm2.AttestationData, error) {
+	att, err := tpm2.DecodeAttestationData(q.Quote)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeAttestationData() failed: %v", err)
+	}
+	if att.Type != tpm2.TagAttestQuote {
+		return nil, fmt.Errorf("attestation isn't a quote, tag of type 0x%x", att.Type)
+	}
+	return att, nil
+}
+
 // PCR encapsulates the value of a PCR at a point in time.
 type PCR struct {
 	Index     int
@@ -248,6 +609,9 @@ type EK struct {
 
 // AttestationParameters describes information about a key which is necessary
 // for verifying its properties remotely.
+//
+// When encoded as JSON, fields are named after the fields of the struct,
+// and byte fields are base64-encoded strings.
 type AttestationParameters struct {
 	// Public represents the AK's canonical encoding. This blob includes the
 	// public key, as well as signing parameters such as the hash algorithm
@@ -263,6 +627,11 @@ type AttestationParameters struct {
 	// UseTCSDActivationFormat is set when tcsd (trousers daemon) is operating
 	// as an intermediary between this library and the TPM. A value of true
 	// indicates that activation challenges should use the TCSD-specific format.
+	//
+	// The format can't be inferred from the other fields, so verifiers must
+	// carry this value over from the client. Windows clients accept
+	// challenges in either format, while tcsd clients report a challenge in
+	// the wrong format with a descriptive error.
 	UseTCSDActivationFormat bool
 
 	// Subsequent fields are only populated for AKs generated on a TPM
@@ -275,13 +644,135 @@ type AttestationParameters struct {
 	// as a TPMS_CREATION_DATA structure.
 	CreateData []byte
 	// CreateAttestation represents an assertion as to the details of the key.
-	// It is encoded as a TPMS_ATTEST structure.
+	// It is encoded as a TPMS_ATTEST structure. A TPM2B_ATTEST, holding
+	// the structure behind a size prefix, is also accepted, and treated as
+	// the structure it holds.
 	CreateAttestation []byte
 	// CreateSignature represents a signature of the CreateAttestation structure.
 	// It is encoded as a TPMT_SIGNATURE structure.
 	CreateSignature []byte
 }
 
+// ClockInfo describes the state of the TPM's clock when an attestation
+// structure was produced.
+type ClockInfo struct {
+	// Clock is the time in milliseconds during which the TPM has been
+	// powered. It is not reset by TPM2_Startup.
+	Clock uint64
+	// ResetCount is incremented on each TPM reset, such as a reboot of
+	// the machine.
+	//
+	// For attestations signed by keys outside the endorsement and platform
+	// hierarchies, which includes AKs created by this package, the TPM
+	// obfuscates ResetCount and RestartCount with a value derived from the
+	// signing key. They can then only be compared between attestations
+	// signed by the same key.
+	ResetCount uint32
+	// RestartCount is incremented on each TPM restart or resume from
+	// hibernation since the last reset.
+	RestartCount uint32
+	// Safe reports whether Clock has not been reported with a lower
+	// value since it was last advanced.
+	Safe bool
+}
+
+// CreationClockInfo returns the clock information from the creation
+// attestation of a TPM 2.0 AK, so that it can be logged or compared with
+// the clock information of later attestations.
+//
+// The clock information is only authentic once the parameters have been
+// checked by ActivationParameters.CheckAKParameters or Generate.
+func (p *AttestationParameters) CreationClockInfo() (*ClockInfo, error) {
+	if len(p.CreateAttestation) == 0 {
+		return nil, errors.New("no creation attestation present")
+	}
+	att, err := tpm2.DecodeAttestationData(stripTPM2BAttest(p.CreateAttestation))
+	if err != nil {
+		return nil, fmt.Errorf("DecodeAttestationData() failed: %v", err)
+	}
+	if att.Type != tpm2.TagAttestCreation {
+		return nil, fmt.Errorf("attestation does not apply to creation data, got tag %x", att.Type)
+	}
+	ci := newClockInfo(att.ClockInfo)
+	return &ci, nil
+}
+
+func newClockInfo(ci tpm2.ClockInfo) ClockInfo {
+	return ClockInfo{
+		Clock:        ci.Clock,
+		ResetCount:   ci.ResetCount,
+		RestartCount: ci.RestartCount,
+		Safe:         ci.Safe != 0,
+	}
+}
+
+// ClockWindow bounds the TPM clock of a quote relative to an earlier
+// reading of the same TPM's clock, so that stale quotes are rejected even
+// if their nonce is reused, for example by verifiers which can't issue
+// fresh nonces.
+//
+// The TPM's clock only advances while the TPM is powered, so quotes taken
+// after the device was powered off since the reference reading fall
+// behind the window and are rejected.
+type ClockWindow struct {
+	// Reference is an earlier reading of the TPM's clock, for example from
+	// TPM.ReadClock or a previously verified quote.
+	Reference ClockInfo
+	// Elapsed is the time the verifier measured since Reference was read.
+	Elapsed time.Duration
+	// MaxSkew is how far the clock of the quote may be from Reference.Clock
+	// advanced by Elapsed, in either direction.
+	MaxSkew time.Duration
+}
+
+// Check returns an error if ci is outside the window.
+func (w ClockWindow) Check(ci ClockInfo) error {
+	if w.Elapsed < 0 || w.MaxSkew < 0 {
+		return fmt.Errorf("invalid clock window: elapsed %v, max skew %v", w.Elapsed, w.MaxSkew)
+	}
+	want := time.Duration(w.Reference.Clock)*time.Millisecond + w.Elapsed
+	got := time.Duration(ci.Clock) * time.Millisecond
+	if skew := got - want; skew > w.MaxSkew || -skew > w.MaxSkew {
+		return fmt.Errorf("TPM clock %v is %v from the expected %v, more than the maximum skew of %v", got, skew, want, w.MaxSkew)
+	}
+	return nil
+}
+
+// canonicalAttestationParametersV1 prefixes version 1 of the encoding
+// produced by AttestationParameters.CanonicalBytes.
+const canonicalAttestationParametersV1 = "go-attestation AttestationParameters v1\x00"
+
+// CanonicalBytes returns a deterministic encoding of the parameters, which
+// a client and server can both compute, for example to sign the parameters
+// a server based a decision on.
+//
+// The encoding is versioned, and each version is stable. Version 1 is the
+// NUL-terminated string "go-attestation AttestationParameters v1",
+// followed by Public, a byte which is 1 if UseTCSDActivationFormat is set
+// and 0 otherwise, CreateData, CreateAttestation and CreateSignature. Each
+// byte slice is prefixed with its length as a big-endian uint32. A
+// CreateAttestation encoded as a TPM2B_ATTEST is encoded as the structure
+// it holds, so both framings have the same encoding. If fields
+// are added to AttestationParameters, they will be covered by a new
+// version with a different prefix.
+func (p *AttestationParameters) CanonicalBytes() []byte {
+	out := []byte(canonicalAttestationParametersV1)
+	appendField := func(b []byte) {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(b)))
+		out = append(out, b...)
+	}
+	appendField(p.Public)
+	if p.UseTCSDActivationFormat {
+		out = append(out, 1)
+	} else {
+		out = append(out, 0)
+	}
+	appendField(p.CreateData)
+	appendField(stripTPM2BAttest(p.CreateAttestation))
+	appendField(p.CreateSignature)
+	return out
+}
+
 // AKPublic holds structured information about an AK's public key.
 type AKPublic struct {
 	// Public is the public part of the AK. This can either be an *rsa.PublicKey or
@@ -296,9 +787,9 @@ type AKPublic struct {
 func ParseAKPublic(version TPMVersion, public []byte) (*AKPublic, error) {
 	switch version {
 	case TPMVersion12:
-		rsaPub, err := tpm.UnmarshalPubRSAPublicKey(public)
+		rsaPub, err := parsePublic12(public)
 		if err != nil {
-			return nil, fmt.Errorf("parsing public key: %v", err)
+			return nil, fmt.Errorf("parsing public key: %w", err)
 		}
 		return &AKPublic{Public: rsaPub, Hash: crypto.SHA1}, nil
 	case TPMVersion20:
@@ -323,9 +814,9 @@ func ParseAKPublic(version TPMVersion, public []byte) (*AKPublic, error) {
 		var h crypto.Hash
 		switch pub.Type {
 		case tpm2.AlgRSA:
-			h, err = pub.RSAParameters.Sign.Hash.Hash()
+			h, err = tpmHash(pub.RSAParameters.Sign.Hash)
 		case tpm2.AlgECC:
-			h, err = pub.ECCParameters.Sign.Hash.Hash()
+			h, err = tpmHash(pub.ECCParameters.Sign.Hash)
 		default:
 			return nil, fmt.Errorf("unsupported public key type 0x%x", pub.Type)
 		}
@@ -338,6 +829,75 @@ func ParseAKPublic(version TPMVersion, public []byte) (*AKPublic, error) {
 	}
 }
 
+// KeyProperties describes the properties of a TPM key, as encoded in its
+// public area.
+type KeyProperties struct {
+	// Algorithm is the asymmetric algorithm of the key, either RSA or ECDSA.
+	Algorithm Algorithm
+	// Bits is the size of the key in bits. For ECC keys, this is the bit
+	// size of the curve.
+	Bits int
+
+	// The following fields describe the object attributes of the key, and
+	// are only populated for keys from a TPM implementing version 2.0 of
+	// the specification.
+
+	// Restricted is set if the key can only operate on TPM-generated
+	// structures.
+	Restricted bool
+	// FixedTPM is set if the key cannot be duplicated to another TPM.
+	FixedTPM bool
+	// FixedParent is set if the key cannot be duplicated to another parent.
+	FixedParent bool
+	// SensitiveDataOrigin is set if the private part of the key was
+	// generated by the TPM.
+	SensitiveDataOrigin bool
+}
+
+// ParsePublic decodes a public blob, such as AttestationParameters.Public,
+// returning the public key and a description of its properties.
+//
+// For TPM 1.2 devices, the blob is a TPM_PUBKEY structure. For TPM 2.0
+// devices, the blob is a TPMT_PUBLIC structure.
+func ParsePublic(version TPMVersion, public []byte) (crypto.PublicKey, *KeyProperties, error) {
+	switch version {
+	case TPMVersion12:
+		pub, err := parsePublic12(public)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing public key: %w", err)
+		}
+		return pub, &KeyProperties{Algorithm: RSA, Bits: pub.Size() * 8}, nil
+	case TPMVersion20:
+		pub, err := tpm2.DecodePublic(public)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing TPM public key structure: %v", err)
+		}
+		pubKey, err := pub.Key()
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing public key: %v", err)
+		}
+		props := &KeyProperties{
+			Restricted:          pub.Attributes&tpm2.FlagRestricted != 0,
+			FixedTPM:            pub.Attributes&tpm2.FlagFixedTPM != 0,
+			FixedParent:         pub.Attributes&tpm2.FlagFixedParent != 0,
+			SensitiveDataOrigin: pub.Attributes&tpm2.FlagSensitiveDataOrigin != 0,
+		}
+		switch k := pubKey.(type) {
+		case *rsa.PublicKey:
+			props.Algorithm = RSA
+			props.Bits = k.Size() * 8
+		case *ecdsa.PublicKey:
+			props.Algorithm = ECDSA
+			props.Bits = k.Curve.Params().BitSize
+		default:
+			return nil, nil, fmt.Errorf("unsupported public key type %T", pubKey)
+		}
+		return pubKey, props, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown tpm version 0x%x", version)
+	}
+}
+
 // Verify is used to prove authenticity of the PCR measurements. It ensures that
 // the quote was signed by the AK, and that its contents matches the PCR and
 // nonce combination. An error is returned if a provided PCR index was not part
@@ -362,6 +922,66 @@ func (a *AKPublic) Verify(quote Quote, pcrs []PCR, nonce []byte) error {
 	}
 }
 
+// VerifyWithClock is like Verify, but if window is not nil additionally
+// checks that the TPM clock of the quote falls within it. Only supported
+// for TPM 2.0 quotes when window is set.
+func (a *AKPublic) VerifyWithClock(quote Quote, pcrs []PCR, nonce []byte, window *ClockWindow) error {
+	if err := a.Verify(quote, pcrs, nonce); err != nil {
+		return err
+	}
+	if window == nil {
+		return nil
+	}
+	ci, err := quote.ClockInfo()
+	if err != nil {
+		return err
+	}
+	return window.Check(*ci)
+}
+
+// VerifyWithFirmware is like Verify, but additionally checks that the
+// firmware version of the TPM which took the quote is at least minVersion,
+// returning ErrFirmwareVersionTooLow otherwise, for example to reject TPMs
+// whose firmware was rolled back. Only supported for TPM 2.0 quotes.
+//
+// The firmware version is obfuscated unless the AK is in the endorsement
+// hierarchy, as described on Quote.FirmwareVersion, so creation must prove
+// that it is: its AK must be the AttestationParameters of a, and its EK
+// and EKTemplate those of the TPM. They are checked as by
+// CheckAKParameters with RequireEndorsementHierarchy set, and AKs outside
+// the endorsement hierarchy are rejected with
+// ErrAKNotInEndorsementHierarchy. TPM.NewAK creates AKs in the endorsement
+// hierarchy when AKConfig.EK is set.
+//
+// Firmware versions are vendor-specific, and are compared as integers.
+// Most vendors encode the major version in the most significant bits, so
+// later versions compare greater.
+func (a *AKPublic) VerifyWithFirmware(quote Quote, pcrs []PCR, nonce []byte, minVersion uint64, creation ActivationParameters) error {
+	creation.TPMVersion = TPMVersion20
+	creation.RequireEndorsementHierarchy = true
+	if err := creation.CheckAKParameters(); err != nil {
+		return err
+	}
+	pub, err := ParseAKPublic(TPMVersion20, creation.AK.Public)
+	if err != nil {
+		return err
+	}
+	if k, ok := a.Public.(interface{ Equal(crypto.PublicKey) bool }); !ok || !k.Equal(pub.Public) {
+		return errors.New("AK of the creation parameters does not match the AK of the quote")
+	}
+	if err := a.Verify(quote, pcrs, nonce); err != nil {
+		return err
+	}
+	v, err := quote.FirmwareVersion()
+	if err != nil {
+		return err
+	}
+	if v < minVersion {
+		return fmt.Errorf("%w: 0x%x is below 0x%x", ErrFirmwareVersionTooLow, v, minVersion)
+	}
+	return nil
+}
+
 // VerifyAll uses multiple quotes to verify the authenticity of all PCR
 // measurements. See documentation on Verify() for semantics.
 //
@@ -393,6 +1013,254 @@ func (a *AKPublic) VerifyAll(quotes []Quote, pcrs []PCR, nonce []byte) error {
 	return nil
 }
 
+// VerifyQuote verifies a TPM 2.0 quote against a trusted AK public key and
+// an expected set of PCR values, keyed by PCR index. The quote is a
+// TPMS_ATTEST structure and sig a TPMT_SIGNATURE structure, as returned in
+// the Quote and Signature fields of a Quote.
+//
+// VerifyQuote checks that the quote was signed by the AK, that it includes
+// the provided nonce, and that its PCR digest matches the provided PCR values.
+// The PCR values must be from the bank selected by the quote, and must cover
+// exactly the PCRs included in the quote.
+func VerifyQuote(akPub crypto.PublicKey, quote, sig, nonce []byte, pcrs map[int][]byte) error {
+	if len(nonce) == 0 {
+		return errors.New("no nonce was provided")
+	}
+	s, err := tpm2.DecodeSignature(bytes.NewBuffer(sig))
+	if err != nil {
+		return fmt.Errorf("parse quote signature: %v", err)
+	}
+	var sigHashAlg tpm2.Algorithm
+	switch {
+	case s.RSA != nil:
+		sigHashAlg = s.RSA.HashAlg
+	case s.ECC != nil:
+		sigHashAlg = s.ECC.HashAlg
+	default:
+		return fmt.Errorf("unsupported quote signature algorithm 0x%x", s.Alg)
+	}
+	sigHash, err := tpmHash(sigHashAlg)
+	if err != nil {
+		return fmt.Errorf("quote signature hash: %v", err)
+	}
+
+	att, err := tpm2.DecodeAttestationData(quote)
+	if err != nil {
+		return fmt.Errorf("parsing quote: %v", err)
+	}
+	if att.Type != tpm2.TagAttestQuote {
+		return fmt.Errorf("attestation isn't a quote, tag of type 0x%x", att.Type)
+	}
+	pcrDigestAlg := HashAlg(att.AttestedQuoteInfo.PCRSelection.Hash).cryptoHash()
+	if pcrDigestAlg == 0 {
+		return fmt.Errorf("unsupported quote PCR bank 0x%x", att.AttestedQuoteInfo.PCRSelection.Hash)
+	}
+
+	pcrList := make([]PCR, 0, len(pcrs))
+	for index, digest := range pcrs {
+		pcrList = append(pcrList, PCR{Index: index, Digest: digest, DigestAlg: pcrDigestAlg})
+	}
+	a := AKPublic{Public: akPub, Hash: sigHash}
+	return a.validate20Quote(Quote{Version: TPMVersion20, Quote: quote, Signature: sig}, pcrList, nonce)
+}
+
+// VerifyMultiBankQuote is like VerifyQuote, but verifies a quote whose PCR
+// selection may span several PCR banks. pcrs holds the expected PCR values
+// for each bank, keyed by digest algorithm and then PCR index, and must
+// cover exactly the PCRs included in the quote.
+//
+// An empty PCR value is treated as a PCR which has not been extended, whose
+// value is all zeros.
+func VerifyMultiBankQuote(akPub crypto.PublicKey, quote, sig, nonce []byte, pcrs map[HashAlg]map[int][]byte) error {
+	if len(nonce) == 0 {
+		return errors.New("no nonce was provided")
+	}
+	cp := CertificationParameters{CreateSignature: sig}
+	_, sigHash, err := cp.SignatureScheme()
+	if err != nil {
+		return fmt.Errorf("parse quote signature: %v", err)
+	}
+	if err := verifyAttestationSignature(akPub, sigHash, quote, sig); err != nil {
+		return fmt.Errorf("invalid quote signature: %v", err)
+	}
+
+	extraData, sels, pcrDigest, err := decodeQuote20(quote)
+	if err != nil {
+		return fmt.Errorf("parsing quote: %v", err)
+	}
+	if !bytes.Equal(extraData, nonce) {
+		return fmt.Errorf("nonce = %#v, want %#v", extraData, nonce)
+	}
+
+	// The PCR digest is computed over the PCR values in the order they
+	// are selected, using the hash algorithm of the signature.
+	h := sigHash.New()
+	covered := make(map[HashAlg]int)
+	for _, sel := range sels {
+		alg := HashAlg(sel.Hash)
+		digestAlg := alg.cryptoHash()
+		if digestAlg == 0 {
+			return fmt.Errorf("unsupported quote PCR bank 0x%x", sel.Hash)
+		}
+		for _, index := range sel.PCRs {
+			digest, ok := pcrs[alg][index]
+			if !ok {
+				return fmt.Errorf("quote was over PCR %d (%v) which wasn't provided", index, alg)
+			}
+			if len(digest) == 0 {
+				digest = make([]byte, digestAlg.Size())
+			}
+			if len(digest) != digestAlg.Size() {
+				return fmt.Errorf("PCR %d (%v) has a %d byte value, want %d bytes", index, alg, len(digest), digestAlg.Size())
+			}
+			h.Write(digest)
+		}
+		covered[alg] += len(sel.PCRs)
+	}
+	for alg, bank := range pcrs {
+		if len(bank) != covered[alg] {
+			return fmt.Errorf("some provided %v PCRs were not included in quote", alg)
+		}
+	}
+	if !bytes.Equal(h.Sum(nil), pcrDigest) {
+		return errors.New("quote digest didn't match pcrs provided")
+	}
+	return nil
+}
+
+// VerifyQuoteSelection is like VerifyMultiBankQuote, but takes the PCRs
+// the quote was requested over as selections, one per bank, and checks
+// that the quote selects exactly those PCRs, so a quote over other PCRs
+// than the verifier asked for is rejected before any value is compared.
+//
+// pcrs holds the expected PCR values, and may include PCRs outside of
+// sels, which are ignored, such as all the PCRs returned by TPM.PCRs. Each
+// selected PCR must be provided once. The selected PCRs are marked as
+// verified, as reported by PCR.QuoteVerified, if the quote verifies.
+func VerifyQuoteSelection(akPub crypto.PublicKey, quote, sig, nonce []byte, sels []PCRSelection, pcrs []PCR) error {
+	if len(sels) == 0 {
+		return errors.New("no PCR selection was provided")
+	}
+	want := make(map[HashAlg][]int, len(sels))
+	for _, sel := range sels {
+		if err := sel.Validate(); err != nil {
+			return fmt.Errorf("invalid PCR selection: %v", err)
+		}
+		if _, ok := want[sel.Hash]; ok {
+			return fmt.Errorf("PCR bank %v selected more than once", sel.Hash)
+		}
+		want[sel.Hash] = sel.sorted()
+	}
+
+	_, quoted, _, err := decodeQuote20(quote)
+	if err != nil {
+		return fmt.Errorf("parsing quote: %v", err)
+	}
+	if len(quoted) != len(want) {
+		return fmt.Errorf("quote selects %d PCR banks, want %d", len(quoted), len(want))
+	}
+	for _, sel := range quoted {
+		alg := HashAlg(sel.Hash)
+		indices, ok := want[alg]
+		if !ok {
+			return fmt.Errorf("quote selects PCR bank 0x%x, which wasn't requested", sel.Hash)
+		}
+		if !slices.Equal(sel.PCRs, indices) {
+			return fmt.Errorf("quote selects %v PCRs %v, want %v", alg, sel.PCRs, indices)
+		}
+	}
+
+	values := make(map[HashAlg]map[int][]byte, len(sels))
+	var selected []int
+	for _, sel := range sels {
+		bank := make(map[int][]byte, len(sel.PCRs))
+		for i, p := range pcrs {
+			if p.DigestAlg != sel.Hash.cryptoHash() || !sel.selects(p.Index) {
+				continue
+			}
+			if _, ok := bank[p.Index]; ok {
+				return fmt.Errorf("PCR %d (%v) was provided more than once", p.Index, sel.Hash)
+			}
+			bank[p.Index] = p.Digest
+			selected = append(selected, i)
+		}
+		values[sel.Hash] = bank
+	}
+	if err := VerifyMultiBankQuote(akPub, quote, sig, nonce, values); err != nil {
+		return err
+	}
+	for _, i := range selected {
+		pcrs[i].quoteVerified = true
+	}
+	return nil
+}
+
+// decodeQuote20 decodes a TPMS_ATTEST structure describing a quote, which
+// may select PCRs from several banks.
+func decodeQuote20(quote []byte) (extraData []byte, sels []tpm2.PCRSelection, pcrDigest []byte, err error) {
+	buf := bytes.NewBuffer(quote)
+	var (
+		magic     uint32
+		typ       tpmutil.Tag
+		extra     tpmutil.U16Bytes
+		clockInfo tpm2.ClockInfo
+		fwVersion uint64
+	)
+	if err := tpmutil.UnpackBuf(buf, &magic, &typ); err != nil {
+		return nil, nil, nil, fmt.Errorf("decoding magic/type: %v", err)
+	}
+	if magic != tpm20GeneratedMagic {
+		return nil, nil, nil, fmt.Errorf("incorrect magic value: %x", magic)
+	}
+	if typ != tpm2.TagAttestQuote {
+		return nil, nil, nil, fmt.Errorf("attestation isn't a quote, tag of type 0x%x", typ)
+	}
+	if _, err := tpm2.DecodeName(buf); err != nil {
+		return nil, nil, nil, fmt.Errorf("decoding qualified signer: %v", err)
+	}
+	if err := tpmutil.UnpackBuf(buf, &extra, &clockInfo, &fwVersion); err != nil {
+		return nil, nil, nil, fmt.Errorf("decoding extra data/clock info/firmware version: %v", err)
+	}
+
+	var count uint32
+	if err := tpmutil.UnpackBuf(buf, &count); err != nil {
+		return nil, nil, nil, fmt.Errorf("decoding PCR selection count: %v", err)
+	}
+	seen := make(map[tpm2.Algorithm]bool)
+	for i := uint32(0); i < count; i++ {
+		var (
+			hash tpm2.Algorithm
+			size uint8
+		)
+		if err := tpmutil.UnpackBuf(buf, &hash, &size); err != nil {
+			return nil, nil, nil, fmt.Errorf("decoding PCR selection %d: %v", i, err)
+		}
+		bitmap := buf.Next(int(size))
+		if len(bitmap) != int(size) {
+			return nil, nil, nil, fmt.Errorf("decoding PCR selection %d: %v", i, io.ErrUnexpectedEOF)
+		}
+		if seen[hash] {
+			return nil, nil, nil, fmt.Errorf("PCR bank 0x%x selected more than once", hash)
+		}
+		seen[hash] = true
+		sel := tpm2.PCRSelection{Hash: hash}
+		for j, b := range bitmap {
+			for k := 0; k < 8; k++ {
+				if b&(1<<k) != 0 {
+					sel.PCRs = append(sel.PCRs, 8*j+k)
+				}
+			}
+		}
+		sels = append(sels, sel)
+	}
+
+	var digest tpmutil.U16Bytes
+	if err := tpmutil.UnpackBuf(buf, &digest); err != nil {
+		return nil, nil, nil, fmt.Errorf("decoding PCR digest: %v", err)
+	}
+	return extra, sels, digest, nil
+}
+
 // HashAlg identifies a hashing Algorithm.
 type HashAlg uint8
 
@@ -400,6 +1268,8 @@ type HashAlg uint8
 var (
 	HashSHA1   = HashAlg(tpm2.AlgSHA1)
 	HashSHA256 = HashAlg(tpm2.AlgSHA256)
+	HashSHA384 = HashAlg(tpm2.AlgSHA384)
+	HashSHA512 = HashAlg(tpm2.AlgSHA512)
 )
 
 func (a HashAlg) cryptoHash() crypto.Hash {
@@ -408,16 +1278,66 @@ func (a HashAlg) cryptoHash() crypto.Hash {
 		return crypto.SHA1
 	case HashSHA256:
 		return crypto.SHA256
+	case HashSHA384:
+		return crypto.SHA384
+	case HashSHA512:
+		return crypto.SHA512
 	}
 	return 0
 }
 
+// hashAvailable reports whether a hash function is linked into the binary.
+// It is a variable so tests can simulate builds excluding a hash.
+var hashAvailable = crypto.Hash.Available
+
+// checkHashAvailable returns an error if h can't be used, rather than
+// letting h.New() panic. This is the case for hashes excluded from the
+// build, such as SHA-1 in some hardened binaries.
+func checkHashAvailable(h crypto.Hash) error {
+	if h == 0 {
+		return errors.New("no hash algorithm specified")
+	}
+	if !hashAvailable(h) {
+		return fmt.Errorf("hash algorithm %v not available in this build", h)
+	}
+	return nil
+}
+
+// tpmHashes maps TPM 2.0 hash algorithms to their Go implementations.
+var tpmHashes = map[tpm2.Algorithm]crypto.Hash{
+	tpm2.AlgSHA1:     crypto.SHA1,
+	tpm2.AlgSHA256:   crypto.SHA256,
+	tpm2.AlgSHA384:   crypto.SHA384,
+	tpm2.AlgSHA512:   crypto.SHA512,
+	tpm2.AlgSHA3_256: crypto.SHA3_256,
+	tpm2.AlgSHA3_384: crypto.SHA3_384,
+	tpm2.AlgSHA3_512: crypto.SHA3_512,
+}
+
+// tpmHash returns the hash function for a TPM 2.0 hash algorithm. Unlike
+// tpm2.Algorithm.Hash, the error names a hash which is supported but not
+// available in this build.
+func tpmHash(alg tpm2.Algorithm) (crypto.Hash, error) {
+	h, ok := tpmHashes[alg]
+	if !ok {
+		return 0, fmt.Errorf("hash algorithm not supported: 0x%x", alg)
+	}
+	if err := checkHashAvailable(h); err != nil {
+		return 0, err
+	}
+	return h, nil
+}
+
 func (a HashAlg) goTPMAlg() tpm2.Algorithm {
 	switch a {
 	case HashSHA1:
 		return tpm2.AlgSHA1
 	case HashSHA256:
 		return tpm2.AlgSHA256
+	case HashSHA384:
+		return tpm2.AlgSHA384
+	case HashSHA512:
+		return tpm2.AlgSHA512
 	}
 	return 0
 }
@@ -429,6 +1349,10 @@ func (a HashAlg) String() string {
 		return "SHA1"
 	case HashSHA256:
 		return "SHA256"
+	case HashSHA384:
+		return "SHA384"
+	case HashSHA512:
+		return "SHA512"
 	}
 	return fmt.Sprintf("HashAlg<%d>", int(a))
 }
@@ -468,6 +1392,24 @@ var (
 	// need to interact with the TPM1.2 device in ways that have not
 	// yet been implemented.
 	ErrTPM12NotImplemented = errors.New("TPM 1.2 support not yet implemented")
+	// ErrTPMObjectMemory is returned when a key cannot be created or
+	// loaded because the TPM has no free slots for transient objects.
+	// TPMs hold only a few keys at once, and this usually means Keys or
+	// AKs were not closed after use.
+	ErrTPMObjectMemory = errors.New("TPM is out of memory for loaded keys (are unused keys being closed?)")
+	// ErrHandleInUse is returned when making a key persistent at a handle
+	// which already holds another key.
+	ErrHandleInUse = errors.New("persistent handle already in use")
+	// ErrKeyNotDuplicable is returned by Key.Rewrap for keys which cannot
+	// be duplicated, such as keys not created with KeyConfig.Duplicable.
+	ErrKeyNotDuplicable = errors.New("key is not duplicable")
+	// ErrFirmwareVersionTooLow is returned by AKPublic.VerifyWithFirmware
+	// when the quote was taken by a TPM with firmware older than required.
+	ErrFirmwareVersionTooLow = errors.New("TPM firmware version too low")
+	// ErrQuoteBindingHashMismatch is returned by AKPublic.VerifyWithData
+	// when the quote binds its data with a different hash algorithm than
+	// expected.
+	ErrQuoteBindingHashMismatch = errors.New("quote data binding uses a different hash algorithm")
 )
 
 // TPMInfo contains information about the version & interface
@@ -510,10 +1452,23 @@ func OpenTPM(config *OpenConfig) (*TPM, error) {
 		if config.TPMVersion > TPMVersionAgnostic && config.TPMVersion != TPMVersion20 {
 			return nil, errors.New("command channel can only be used as a TPM 2.0 device")
 		}
-		return &TPM{&wrappedTPM20{
+		w := &wrappedTPM20{
 			interf: TPMInterfaceCommandChannel,
 			rwc:    config.CommandChannel,
-		}}, nil
+			retry:  config.Retry,
+		}
+		w.withAudit(config.Audit)
+		w.serialize()
+		return &TPM{w}, nil
+	}
+
+	if config.DevicePath != "" {
+		t, err := openTPMDevice(*config)
+		if err != nil {
+			return nil, err
+		}
+		t.applyConfig(config)
+		return t, nil
 	}
 
 	candidateTPMs, err := probeSystemTPMs()
@@ -523,13 +1478,28 @@ func OpenTPM(config *OpenConfig) (*TPM, error) {
 
 	for _, tpm := range candidateTPMs {
 		if tpm.MatchesConfig(*config) {
-			return openTPM(tpm)
+			t, err := openTPM(tpm)
+			if err != nil {
+				return nil, err
+			}
+			t.applyConfig(config)
+			return t, nil
 		}
 	}
 
 	return nil, ErrTPMNotAvailable
 }
 
+// applyConfig applies the options of config which apply to TPMs opened
+// from the system.
+func (t *TPM) applyConfig(config *OpenConfig) {
+	if w, ok := t.tpm.(*wrappedTPM20); ok {
+		w.retry = config.Retry
+		w.withAudit(config.Audit)
+		w.serialize()
+	}
+}
+
 // AvailableTPMs returns information about available TPMs matching
 // the given config, without opening the devices.
 func AvailableTPMs(config *OpenConfig) ([]TPMInfo, error) {
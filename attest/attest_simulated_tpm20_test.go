@@ -24,9 +24,21 @@ package attest
 import (
 	"bytes"
 	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/legacy/tpm2"
+	tpm2direct "github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
 )
 
 func setupSimulatedTPM(t *testing.T) (*simulator.Simulator, *TPM) {
@@ -55,6 +67,53 @@ func TestSimTPM20EK(t *testing.T) {
 	}
 }
 
+func TestSimTPM20EKCertificates(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	// The simulator has no EK certificates provisioned.
+	certs, err := tpm.EKCertificates()
+	if err != nil {
+		t.Fatalf("EKCertificates() failed: %v", err)
+	}
+	if len(certs) != 0 {
+		t.Fatalf("EKCertificates() returned %d certificates, want 0", len(certs))
+	}
+
+	ca, caKey := newTestCA(t)
+	cert := newTestEKCertificate(t, ca, caKey, marshalTestSAN(t, testEKCertSAN, false))
+
+	rwc := tpm.tpm.(*wrappedTPM20).rwc
+	attrs := tpm2.AttrOwnerWrite | tpm2.AttrOwnerRead | tpm2.AttrAuthRead | tpm2.AttrPPRead | tpm2.AttrNoDA
+	if err := tpm2.NVDefineSpace(rwc, tpm2.HandleOwner, nvramRSACertIndex, "", "", nil, attrs, uint16(len(cert.Raw))); err != nil {
+		t.Fatalf("NVDefineSpace() failed: %v", err)
+	}
+	for off := 0; off < len(cert.Raw); off += 512 {
+		end := off + 512
+		if end > len(cert.Raw) {
+			end = len(cert.Raw)
+		}
+		if err := tpm2.NVWrite(rwc, tpm2.HandleOwner, nvramRSACertIndex, "", cert.Raw[off:end], uint16(off)); err != nil {
+			t.Fatalf("NVWrite() failed: %v", err)
+		}
+	}
+
+	// The RSA certificate is returned, and the absent ECC one is skipped.
+	certs, err = tpm.EKCertificates()
+	if err != nil {
+		t.Fatalf("EKCertificates() failed: %v", err)
+	}
+	if len(certs) != 1 {
+		t.Fatalf("EKCertificates() returned %d certificates, want 1", len(certs))
+	}
+	if !bytes.Equal(certs[0].Certificate.Raw, cert.Raw) {
+		t.Error("EKCertificates() returned a different certificate")
+	}
+	if !testRSAKey.Equal(certs[0].Public) {
+		t.Error("EKCertificates() returned a different public key")
+	}
+}
+
 func TestSimTPM20Info(t *testing.T) {
 	sim, tpm := setupSimulatedTPM(t)
 	defer sim.Close()
@@ -64,6 +123,26 @@ func TestSimTPM20Info(t *testing.T) {
 	}
 }
 
+func TestSimTPM20SupportedAlgorithms(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	algs, err := tpm.SupportedAlgorithms()
+	if err != nil {
+		t.Fatalf("SupportedAlgorithms() failed: %v", err)
+	}
+	// The simulator supports both RSA and ECDSA.
+	want := []Algorithm{RSA, ECDSA}
+	if len(algs) != len(want) {
+		t.Fatalf("SupportedAlgorithms() = %v, want %v", algs, want)
+	}
+	for i := range want {
+		if algs[i] != want[i] {
+			t.Errorf("SupportedAlgorithms() = %v, want %v", algs, want)
+		}
+	}
+}
+
 func TestSimTPM20AKCreateAndLoad(t *testing.T) {
 	sim, tpm := setupSimulatedTPM(t)
 	defer sim.Close()
@@ -97,6 +176,131 @@ func TestSimTPM20AKCreateAndLoad(t *testing.T) {
 	}
 }
 
+func TestSimTPM20AKPublic(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	for _, alg := range []Algorithm{RSA, ECDSA} {
+		ak, err := tpm.NewAK(&AKConfig{Algorithm: alg})
+		if err != nil {
+			t.Fatalf("NewAK(%v) failed: %v", alg, err)
+		}
+		pub, err := ParseAKPublic(tpm.Version(), ak.AttestationParameters().Public)
+		if err != nil {
+			t.Fatalf("ParseAKPublic() failed: %v", err)
+		}
+		got, ok := ak.Public().(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !got.Equal(pub.Public) {
+			t.Errorf("%v AK Public() = %v, want %v", alg, ak.Public(), pub.Public)
+		}
+		if _, err := ak.Signer(); err == nil {
+			t.Errorf("%v AK Signer() succeeded, want error for a restricted key", alg)
+		}
+		ak.Close(tpm)
+	}
+}
+
+func TestSimTPM20AKLoadFromHandle(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	const handle = 0x81000030
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	public := ak.AttestationParameters().Public
+	if err := ak.ak.(*wrappedKey20).evict(tpm.tpm, handle, false); err != nil {
+		t.Fatalf("evict() failed: %v", err)
+	}
+	ak.Close(tpm)
+
+	other, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	otherPublic := other.AttestationParameters().Public
+	other.Close(tpm)
+
+	// The AK must be usable after a reset, without its creation blob.
+	if err := sim.Reset(); err != nil {
+		t.Fatalf("Reset() failed: %v", err)
+	}
+	if _, err := tpm.LoadAKFromHandle(handle, otherPublic); err == nil {
+		t.Error("LoadAKFromHandle() with a mismatched public area succeeded, want error")
+	}
+	if _, err := tpm.LoadAKFromHandle(0x80000001, public); err == nil {
+		t.Error("LoadAKFromHandle() with a transient handle succeeded, want error")
+	}
+	loaded, err := tpm.LoadAKFromHandle(handle, public)
+	if err != nil {
+		t.Fatalf("LoadAKFromHandle() failed: %v", err)
+	}
+	defer loaded.ak.(*wrappedKey20).delete(tpm.tpm)
+
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	quote, err := loaded.Quote(tpm, nonce, HashSHA256)
+	if err != nil {
+		t.Fatalf("Quote() failed: %v", err)
+	}
+	pcrs, err := tpm.PCRs(HashSHA256)
+	if err != nil {
+		t.Fatalf("PCRs() failed: %v", err)
+	}
+	pub, err := ParseAKPublic(tpm.Version(), loaded.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+	if err := pub.Verify(*quote, pcrs, nonce); err != nil {
+		t.Errorf("quote verification failed: %v", err)
+	}
+
+	// Close must leave the persistent AK in place.
+	if err := loaded.Close(tpm); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, err := tpm.LoadAKFromHandle(handle, public); err != nil {
+		t.Errorf("LoadAKFromHandle() after Close() failed: %v", err)
+	}
+}
+
+func TestSimTPM20PersistentHandles(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	handles := []tpmutil.Handle{0x81000021, 0x81000020}
+	for _, h := range handles {
+		ak, err := tpm.NewAK(nil)
+		if err != nil {
+			t.Fatalf("NewAK() failed: %v", err)
+		}
+		if err := ak.ak.(*wrappedKey20).evict(tpm.tpm, h, false); err != nil {
+			t.Fatalf("evict() failed: %v", err)
+		}
+		ak.Close(tpm)
+	}
+
+	got, err := tpm.PersistentHandles()
+	if err != nil {
+		t.Fatalf("PersistentHandles() failed: %v", err)
+	}
+	// The storage root key is also made persistent by NewAK.
+	for _, h := range handles {
+		found := false
+		for _, g := range got {
+			found = found || g == h
+		}
+		if !found {
+			t.Errorf("PersistentHandles() = %#x, want it to include %#x", got, h)
+		}
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Errorf("PersistentHandles() = %#x, want increasing handles", got)
+		}
+	}
+}
+
 func TestSimTPM20ActivateCredential(t *testing.T) {
 	testActivateCredential(t, false)
 }
@@ -140,190 +344,1630 @@ func testActivateCredential(t *testing.T, useEK bool) {
 	if err != nil {
 		t.Errorf("ak.ActivateCredential() failed: %v", err)
 	}
-	if !bytes.Equal(secret, decryptedSecret) {
+	if !VerifySecret(secret, decryptedSecret) {
 		t.Error("secret does not match decrypted secret")
 		t.Logf("Secret = %v", secret)
 		t.Logf("Decrypted secret = %v", decryptedSecret)
 	}
 }
 
-func TestParseAKPublic20(t *testing.T) {
+func TestSimTPM20Enroll(t *testing.T) {
 	sim, tpm := setupSimulatedTPM(t)
 	defer sim.Close()
 
-	ak, err := tpm.NewAK(nil)
+	EKs, err := tpm.EKs()
 	if err != nil {
-		t.Fatalf("NewAK() failed: %v", err)
-	}
-	defer ak.Close(tpm)
-	params := ak.AttestationParameters()
-	if _, err := ParseAKPublic(TPMVersion20, params.Public); err != nil {
-		t.Errorf("parsing AK public blob: %v", err)
+		t.Fatalf("EKs() failed: %v", err)
 	}
-}
-
-func TestSimTPM20QuoteAndVerifyAll(t *testing.T) {
-	sim, tpm := setupSimulatedTPM(t)
-	defer sim.Close()
-
+	ek := chooseEK(t, EKs)
 	ak, err := tpm.NewAK(nil)
 	if err != nil {
 		t.Fatalf("NewAK() failed: %v", err)
 	}
 	defer ak.Close(tpm)
 
-	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
-	quote256, err := ak.Quote(tpm, nonce, HashSHA256)
-	if err != nil {
-		t.Fatalf("ak.Quote(SHA256) failed: %v", err)
+	activate := func(ec EncryptedCredential) ([]byte, error) {
+		return ak.ActivateCredential(tpm, ec)
 	}
-	quote1, err := ak.Quote(tpm, nonce, HashSHA1)
+	ap := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         ak.AttestationParameters(),
+		EK:         ek.Public,
+	}
+	if err := ap.Enroll(activate); err != nil {
+		t.Errorf("Enroll() failed: %v", err)
+	}
+	a, err := NewActivator(ActivatorConfig{TPMVersion: TPMVersion20})
 	if err != nil {
-		t.Fatalf("ak.Quote(SHA1) failed: %v", err)
+		t.Fatalf("NewActivator() failed: %v", err)
+	}
+	if err := a.Enroll(ek.Public, ak.AttestationParameters(), activate); err != nil {
+		t.Errorf("Activator.Enroll() failed: %v", err)
 	}
 
-	// Providing both PCR banks to AKPublic.Verify() ensures we can handle
-	// the case where extra PCRs of a different digest algorithm are provided.
-	var pcrs []PCR
-	for _, alg := range []HashAlg{HashSHA256, HashSHA1} {
-		p, err := tpm.PCRs(alg)
+	// A device returning the wrong secret fails enrollment.
+	err = ap.Enroll(func(ec EncryptedCredential) ([]byte, error) {
+		secret, err := activate(ec)
 		if err != nil {
-			t.Fatalf("tpm.PCRs(%v) failed: %v", alg, err)
+			return nil, err
 		}
-		pcrs = append(pcrs, p...)
-	}
-
-	pub, err := ParseAKPublic(tpm.Version(), ak.AttestationParameters().Public)
-	if err != nil {
-		t.Fatalf("ParseAKPublic() failed: %v", err)
+		secret[0] ^= 1
+		return secret, nil
+	})
+	if !errors.Is(err, ErrActivationSecretMismatch) {
+		t.Errorf("Enroll() with a wrong secret = %v, want %v", err, ErrActivationSecretMismatch)
 	}
-
-	// Ensure VerifyAll fails if a quote is missing and hence not all PCR
-	// banks are covered.
-	if err := pub.VerifyAll([]Quote{*quote256}, pcrs, nonce); err == nil {
-		t.Error("VerifyAll().err returned nil, expected failure")
+	errDevice := errors.New("device unreachable")
+	if err := ap.Enroll(func(EncryptedCredential) ([]byte, error) { return nil, errDevice }); !errors.Is(err, errDevice) {
+		t.Errorf("Enroll() with a failing device = %v, want %v", err, errDevice)
 	}
 
-	if err := pub.VerifyAll([]Quote{*quote256, *quote1}, pcrs, nonce); err != nil {
-		t.Errorf("quote verification failed: %v", err)
+	// The device isn't contacted for a rejected AK.
+	bad := ap
+	bad.AK.CreateSignature = append([]byte(nil), bad.AK.CreateSignature...)
+	bad.AK.CreateSignature[len(bad.AK.CreateSignature)-1] ^= 1
+	called := false
+	err = bad.Enroll(func(ec EncryptedCredential) ([]byte, error) {
+		called = true
+		return activate(ec)
+	})
+	if !errors.Is(err, ErrAKSignatureInvalid) || called {
+		t.Errorf("Enroll() with a bad AK = %v and called the device: %v, want %v", err, called, ErrAKSignatureInvalid)
 	}
 }
 
-func TestSimTPM20AttestPlatform(t *testing.T) {
+func TestSimTPM20ActivateCredentialAAD(t *testing.T) {
 	sim, tpm := setupSimulatedTPM(t)
 	defer sim.Close()
 
+	EKs, err := tpm.EKs()
+	if err != nil {
+		t.Fatalf("EKs() failed: %v", err)
+	}
+	ek := chooseEK(t, EKs)
 	ak, err := tpm.NewAK(nil)
 	if err != nil {
 		t.Fatalf("NewAK() failed: %v", err)
 	}
 	defer ak.Close(tpm)
 
-	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
-	attestation, err := tpm.attestPlatform(ak, nonce, nil)
-	if err != nil {
-		t.Fatalf("AttestPlatform() failed: %v", err)
+	ap := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         ak.AttestationParameters(),
+		EK:         ek.Public,
+		AAD:        []byte("session"),
 	}
-
-	pub, err := ParseAKPublic(attestation.TPMVersion, attestation.Public)
+	secret, challenge, err := ap.Generate()
 	if err != nil {
-		t.Fatalf("ParseAKPublic() failed: %v", err)
+		t.Fatalf("Generate() failed: %v", err)
 	}
-	if err := pub.VerifyAll(attestation.Quotes, attestation.PCRs, nonce); err != nil {
-		t.Errorf("quote verification failed: %v", err)
+	activated, err := ak.ActivateCredential(tpm, *challenge)
+	if err != nil {
+		t.Fatalf("ActivateCredential() failed: %v", err)
 	}
-}
-
-func TestSimTPM20PCRs(t *testing.T) {
-	sim, tpm := setupSimulatedTPM(t)
-	defer sim.Close()
-
-	PCRs, err := tpm.PCRs(HashSHA256)
+	derived, err := DeriveActivationSecret(activated, ap.AAD)
 	if err != nil {
-		t.Fatalf("PCRs() failed: %v", err)
+		t.Fatalf("DeriveActivationSecret() failed: %v", err)
 	}
-	if len(PCRs) != 24 {
-		t.Errorf("len(PCRs) = %d, want %d", len(PCRs), 24)
+	if !VerifySecret(secret, derived) {
+		t.Error("secret does not match the secret derived with the same AAD")
 	}
-
-	for i, pcr := range PCRs {
-		if len(pcr.Digest) != pcr.DigestAlg.Size() {
-			t.Errorf("PCR %d len(digest) = %d, expected match with digest algorithm size (%d)", pcr.Index, len(pcr.Digest), pcr.DigestAlg.Size())
-		}
-		if pcr.Index != i {
-			t.Errorf("PCR index %d does not match map index %d", pcr.Index, i)
-		}
-		if pcr.DigestAlg != crypto.SHA256 {
-			t.Errorf("pcr.DigestAlg = %v, expected crypto.SHA256", pcr.DigestAlg)
-		}
+	if derived, err = DeriveActivationSecret(activated, []byte("other session")); err != nil {
+		t.Fatalf("DeriveActivationSecret() failed: %v", err)
 	}
-}
-
-func TestSimTPM20PersistenceSRK(t *testing.T) {
-	testPersistenceSRK(t, defaultParentConfig)
-}
-
-func TestSimTPM20PersistenceECCSRK(t *testing.T) {
-	parentConfig := ParentKeyConfig{
-		Algorithm: ECDSA,
-		Handle:    0x81000002,
+	if VerifySecret(secret, derived) {
+		t.Error("secret matches the secret derived with a different AAD")
 	}
-	testPersistenceSRK(t, parentConfig)
 }
 
-func testPersistenceSRK(t *testing.T, parentConfig ParentKeyConfig) {
+func TestSimTPM20ActivateCredentialRSAPSSAK(t *testing.T) {
 	sim, tpm := setupSimulatedTPM(t)
 	defer sim.Close()
+	w := tpm.tpm.(*wrappedTPM20)
 
-	srkHnd, _, err := tpm.tpm.(*wrappedTPM20).getStorageRootKeyHandle(parentConfig)
+	srk, _, err := w.getStorageRootKeyHandle(defaultParentConfig)
 	if err != nil {
 		t.Fatalf("getStorageRootKeyHandle() failed: %v", err)
 	}
-	if srkHnd != parentConfig.Handle {
-		t.Fatalf("bad SRK-equivalent handle: got 0x%x, wanted 0x%x", srkHnd, parentConfig.Handle)
+	tmpl := akTemplateRSA
+	params := *tmpl.RSAParameters
+	params.Sign = &tpm2.SigScheme{Alg: tpm2.AlgRSAPSS, Hash: tpm2.AlgSHA256}
+	tmpl.RSAParameters = &params
+	blob, pub, creationData, creationHash, ticket, err := tpm2.CreateKey(w.rwc, srk, tpm2.PCRSelection{}, "", "", tmpl)
+	if err != nil {
+		t.Fatalf("CreateKey() failed: %v", err)
+	}
+	hnd, _, err := tpm2.Load(w.rwc, srk, "", pub, blob)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	attestation, sig, err := tpm2.CertifyCreation(w.rwc, "", hnd, hnd, nil, creationHash, *params.Sign, ticket)
+	if err != nil {
+		t.Fatalf("CertifyCreation() failed: %v", err)
 	}
+	ak := &AK{ak: newWrappedAK20(hnd, blob, pub, creationData, attestation, sig)}
+	defer ak.Close(tpm)
 
-	srkHnd, p, err := tpm.tpm.(*wrappedTPM20).getStorageRootKeyHandle(parentConfig)
+	eks, err := tpm.EKs()
 	if err != nil {
-		t.Fatalf("second getStorageRootKeyHandle() failed: %v", err)
+		t.Fatalf("EKs() failed: %v", err)
 	}
-	if srkHnd != parentConfig.Handle {
-		t.Fatalf("bad SRK-equivalent handle: got 0x%x, wanted 0x%x", srkHnd, parentConfig.Handle)
+	ek := chooseEK(t, eks)
+	ap := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         ak.AttestationParameters(),
+		EK:         ek.Public,
 	}
-	if p {
-		t.Fatalf("generated a new key the second time; that shouldn't happen")
+	secret, challenge, err := ap.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	decrypted, err := ak.ActivateCredential(tpm, *challenge)
+	if err != nil {
+		t.Fatalf("ActivateCredential() failed: %v", err)
+	}
+	if !VerifySecret(secret, decrypted) {
+		t.Error("secret does not match decrypted secret")
 	}
 }
 
-func TestSimTPM20PersistenceEK(t *testing.T) {
+func TestSimTPM20ActivateCredentialAES256EK(t *testing.T) {
 	sim, tpm := setupSimulatedTPM(t)
 	defer sim.Close()
+	rwc := tpm.tpm.(*wrappedTPM20).rwc
 
-	eks, err := tpm.EKs()
+	template := defaultRSAEKTemplate
+	rsaParams := *template.RSAParameters
+	rsaParams.Symmetric = &tpm2.SymScheme{Alg: tpm2.AlgAES, KeyBits: 256, Mode: tpm2.AlgCFB}
+	rsaParams.ModulusRaw = make([]byte, 256)
+	template.RSAParameters = &rsaParams
+
+	hnd, pub, err := tpm2.CreatePrimary(rwc, tpm2.HandleEndorsement, tpm2.PCRSelection{}, "", "", template)
 	if err != nil {
-		t.Errorf("EKs() failed: %v", err)
+		t.Fatalf("CreatePrimary() failed: %v", err)
 	}
-	if len(eks) == 0 || (eks[0].Public == nil) {
-		t.Errorf("EKs() = %v, want at least 1 EK with populated fields", eks)
+	defer tpm2.FlushContext(rwc, hnd)
+	const ekHandle = 0x81010010
+	if err := tpm2.EvictControl(rwc, "", tpm2.HandleOwner, hnd, ekHandle); err != nil {
+		t.Fatalf("EvictControl() failed: %v", err)
 	}
+	ek := EK{Public: pub, handle: ekHandle}
 
-	ek := eks[0]
-	ekHnd, _, err := tpm.tpm.(*wrappedTPM20).getEndorsementKeyHandle(&ek)
+	ak, err := tpm.NewAK(nil)
 	if err != nil {
-		t.Fatalf("getStorageRootKeyHandle() failed: %v", err)
-	}
-	if ekHnd != ek.handle {
-		t.Fatalf("bad EK-equivalent handle: got 0x%x, wanted 0x%x", ekHnd, ek.handle)
+		t.Fatalf("NewAK() failed: %v", err)
 	}
+	defer ak.Close(tpm)
 
-	ekHnd, p, err := tpm.tpm.(*wrappedTPM20).getEndorsementKeyHandle(&ek)
+	ap := ActivationParameters{
+		TPMVersion:         TPMVersion20,
+		AK:                 ak.AttestationParameters(),
+		EK:                 ek.Public,
+		SymmetricBlockSize: 32,
+	}
+	secret, challenge, err := ap.Generate()
 	if err != nil {
-		t.Fatalf("second getEndorsementKeyHandle() failed: %v", err)
+		t.Fatalf("Generate() failed: %v", err)
 	}
-	if ekHnd != ek.handle {
-		t.Fatalf("bad EK-equivalent handle: got 0x%x, wanted 0x%x", ekHnd, ek.handle)
+	decryptedSecret, err := ak.ActivateCredentialWithEK(tpm, *challenge, ek)
+	if err != nil {
+		t.Fatalf("ak.ActivateCredentialWithEK() failed: %v", err)
 	}
-	if p {
-		t.Fatalf("generated a new key the second time; that shouldn't happen")
+	if !VerifySecret(secret, decryptedSecret) {
+		t.Error("secret does not match decrypted secret")
+	}
+
+	// A challenge generated for the default AES-128 EK must not activate.
+	ap.SymmetricBlockSize = 0
+	if _, challenge, err = ap.Generate(); err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	if _, err := ak.ActivateCredentialWithEK(tpm, *challenge, ek); err == nil {
+		t.Error("ak.ActivateCredentialWithEK() succeeded with mismatched symmetric block size")
+	}
+}
+
+func TestSimTPM20ActivateCredentialECCEK(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+	rwc := tpm.tpm.(*wrappedTPM20).rwc
+
+	// The EK is created from the default template on first use, at the
+	// usual handle for ECC EKs.
+	hnd, pub, err := tpm2.CreatePrimary(rwc, tpm2.HandleEndorsement, tpm2.PCRSelection{}, "", "", defaultECCEKTemplate)
+	if err != nil {
+		t.Fatalf("CreatePrimary() failed: %v", err)
+	}
+	tpm2.FlushContext(rwc, hnd)
+	testSimActivateCredentialEK(t, tpm, EK{Public: pub}, nil)
+}
+
+func TestSimTPM20ActivateCredentialEKTemplate(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+	rwc := tpm.tpm.(*wrappedTPM20).rwc
+
+	// Compute TPM2_PolicySecret with the endorsement hierarchy using
+	// SHA-384, the name algorithm of the EK.
+	session, _, err := tpm2.StartAuthSession(rwc, tpm2.HandleNull, tpm2.HandleNull, make([]byte, 16), nil, tpm2.SessionTrial, tpm2.AlgNull, tpm2.AlgSHA384)
+	if err != nil {
+		t.Fatalf("StartAuthSession() failed: %v", err)
+	}
+	if _, _, err := tpm2.PolicySecret(rwc, tpm2.HandleEndorsement, tpm2.AuthCommand{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession}, session, nil, nil, nil, 0); err != nil {
+		t.Fatalf("PolicySecret() failed: %v", err)
+	}
+	policy, err := tpm2.PolicyGetDigest(rwc, session)
+	if err != nil {
+		t.Fatalf("PolicyGetDigest() failed: %v", err)
+	}
+	tpm2.FlushContext(rwc, session)
+
+	template := tpm2.Public{
+		Type:       tpm2.AlgECC,
+		NameAlg:    tpm2.AlgSHA384,
+		Attributes: defaultECCEKTemplate.Attributes,
+		AuthPolicy: policy,
+		ECCParameters: &tpm2.ECCParams{
+			Symmetric: &tpm2.SymScheme{Alg: tpm2.AlgAES, KeyBits: 256, Mode: tpm2.AlgCFB},
+			CurveID:   tpm2.CurveNISTP384,
+		},
+	}
+	hnd, pub, err := tpm2.CreatePrimary(rwc, tpm2.HandleEndorsement, tpm2.PCRSelection{}, "", "", template)
+	if err != nil {
+		t.Fatalf("CreatePrimary() failed: %v", err)
+	}
+	defer tpm2.FlushContext(rwc, hnd)
+	const ekHandle = 0x81010020
+	if err := tpm2.EvictControl(rwc, "", tpm2.HandleOwner, hnd, ekHandle); err != nil {
+		t.Fatalf("EvictControl() failed: %v", err)
+	}
+	ek := EK{Public: pub, handle: ekHandle}
+	testSimActivateCredentialEK(t, tpm, ek, &template)
+
+	// A challenge generated for the default template must not activate.
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	ap := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         ak.AttestationParameters(),
+		EK:         ek.Public,
+	}
+	_, challenge, err := ap.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	if _, err := ak.ActivateCredentialWithEK(tpm, *challenge, ek); err == nil {
+		t.Error("ak.ActivateCredentialWithEK() succeeded without the EK template")
+	}
+}
+
+func testSimActivateCredentialEK(t *testing.T, tpm *TPM, ek EK, template *tpm2.Public) {
+	t.Helper()
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	ap := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         ak.AttestationParameters(),
+		EK:         ek.Public,
+		EKTemplate: template,
+	}
+	secret, challenge, err := ap.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	decryptedSecret, err := ak.ActivateCredentialWithEK(tpm, *challenge, ek)
+	if err != nil {
+		t.Fatalf("ak.ActivateCredentialWithEK() failed: %v", err)
+	}
+	if !VerifySecret(secret, decryptedSecret) {
+		t.Error("secret does not match decrypted secret")
+	}
+}
+
+func TestSimTPM20CreationClockInfo(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	creationClockInfo := func() *ClockInfo {
+		t.Helper()
+		ak, err := tpm.NewAK(nil)
+		if err != nil {
+			t.Fatalf("NewAK() failed: %v", err)
+		}
+		defer ak.Close(tpm)
+		params := ak.AttestationParameters()
+		ci, err := params.CreationClockInfo()
+		if err != nil {
+			t.Fatalf("CreationClockInfo() failed: %v", err)
+		}
+		return ci
+	}
+
+	// ResetCount and RestartCount are obfuscated differently for each AK,
+	// but Clock is not, and keeps advancing across resets.
+	before := creationClockInfo()
+	if err := sim.Reset(); err != nil {
+		t.Fatalf("Reset() failed: %v", err)
+	}
+	after := creationClockInfo()
+	if after.Clock < before.Clock {
+		t.Errorf("Clock after reset = %d, want at least %d", after.Clock, before.Clock)
+	}
+
+	var params AttestationParameters
+	if _, err := params.CreationClockInfo(); err == nil {
+		t.Error("CreationClockInfo() on empty parameters succeeded, want error")
+	}
+}
+
+func TestSimTPM20ActivateCredentialSHA384AK(t *testing.T) {
+	testSimActivateCredentialAKAlgs(t, tpm2.AlgSHA384, tpm2.AlgSHA384)
+}
+
+// TestSimTPM20ActivateCredentialMixedAlgAK checks that an AK whose name
+// algorithm differs from the hash of its signing scheme can be enrolled.
+func TestSimTPM20ActivateCredentialMixedAlgAK(t *testing.T) {
+	testSimActivateCredentialAKAlgs(t, tpm2.AlgSHA1, tpm2.AlgSHA256)
+}
+
+func testSimActivateCredentialAKAlgs(t *testing.T, nameAlg, signHash tpm2.Algorithm) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+	w := tpm.tpm.(*wrappedTPM20)
+
+	EKs, err := tpm.EKs()
+	if err != nil {
+		t.Fatalf("EKs() failed: %v", err)
+	}
+	ek := chooseEK(t, EKs)
+
+	srk, _, err := w.getStorageRootKeyHandle(defaultParentConfig)
+	if err != nil {
+		t.Fatalf("getStorageRootKeyHandle() failed: %v", err)
+	}
+	template := akTemplateRSA
+	template.NameAlg = nameAlg
+	rsaParams := *template.RSAParameters
+	rsaParams.Sign = &tpm2.SigScheme{Alg: tpm2.AlgRSASSA, Hash: signHash}
+	template.RSAParameters = &rsaParams
+	blob, pub, creationData, creationHash, tix, err := tpm2.CreateKey(w.rwc, srk, tpm2.PCRSelection{}, "", "", template)
+	if err != nil {
+		t.Fatalf("CreateKey() failed: %v", err)
+	}
+	hnd, _, err := tpm2.Load(w.rwc, srk, "", pub, blob)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	attestation, sig, err := tpm2.CertifyCreation(w.rwc, "", hnd, hnd, nil, creationHash, *rsaParams.Sign, tix)
+	if err != nil {
+		tpm2.FlushContext(w.rwc, hnd)
+		t.Fatalf("CertifyCreation() failed: %v", err)
+	}
+	ak := &AK{ak: newWrappedAK20(hnd, blob, pub, creationData, attestation, sig)}
+	defer ak.Close(tpm)
+
+	ap := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         ak.AttestationParameters(),
+		EK:         ek.Public,
+	}
+	secret, challenge, err := ap.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	decryptedSecret, err := ak.ActivateCredential(tpm, *challenge)
+	if err != nil {
+		t.Fatalf("ak.ActivateCredential() failed: %v", err)
+	}
+	if !VerifySecret(secret, decryptedSecret) {
+		t.Error("secret does not match decrypted secret")
+	}
+}
+
+func TestSimTPM20ECCAK(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	EKs, err := tpm.EKs()
+	if err != nil {
+		t.Fatalf("EKs() failed: %v", err)
+	}
+	ek := chooseEK(t, EKs)
+
+	ak, err := tpm.NewAK(&AKConfig{Algorithm: ECDSA})
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	ap := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         ak.AttestationParameters(),
+		EK:         ek.Public,
+	}
+	secret, challenge, err := ap.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	decryptedSecret, err := ak.ActivateCredential(tpm, *challenge)
+	if err != nil {
+		t.Fatalf("ak.ActivateCredential() failed: %v", err)
+	}
+	if !bytes.Equal(secret, decryptedSecret) {
+		t.Error("secret does not match decrypted secret")
+	}
+
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	attestation, err := tpm.attestPlatform(ak, nonce, nil)
+	if err != nil {
+		t.Fatalf("AttestPlatform() failed: %v", err)
+	}
+	pub, err := ParseAKPublic(attestation.TPMVersion, attestation.Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+	if err := pub.VerifyAll(attestation.Quotes, attestation.PCRs, nonce); err != nil {
+		t.Errorf("quote verification failed: %v", err)
+	}
+}
+
+func TestParseAKPublic20(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	params := ak.AttestationParameters()
+	if _, err := ParseAKPublic(TPMVersion20, params.Public); err != nil {
+		t.Errorf("parsing AK public blob: %v", err)
+	}
+}
+
+func TestSimTPM20QuoteAndVerifyAll(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	quote256, err := ak.Quote(tpm, nonce, HashSHA256)
+	if err != nil {
+		t.Fatalf("ak.Quote(SHA256) failed: %v", err)
+	}
+	quote1, err := ak.Quote(tpm, nonce, HashSHA1)
+	if err != nil {
+		t.Fatalf("ak.Quote(SHA1) failed: %v", err)
+	}
+
+	// Providing both PCR banks to AKPublic.Verify() ensures we can handle
+	// the case where extra PCRs of a different digest algorithm are provided.
+	var pcrs []PCR
+	for _, alg := range []HashAlg{HashSHA256, HashSHA1} {
+		p, err := tpm.PCRs(alg)
+		if err != nil {
+			t.Fatalf("tpm.PCRs(%v) failed: %v", alg, err)
+		}
+		pcrs = append(pcrs, p...)
+	}
+
+	pub, err := ParseAKPublic(tpm.Version(), ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+
+	// Ensure VerifyAll fails if a quote is missing and hence not all PCR
+	// banks are covered.
+	if err := pub.VerifyAll([]Quote{*quote256}, pcrs, nonce); err == nil {
+		t.Error("VerifyAll().err returned nil, expected failure")
+	}
+
+	if err := pub.VerifyAll([]Quote{*quote256, *quote1}, pcrs, nonce); err != nil {
+		t.Errorf("quote verification failed: %v", err)
+	}
+}
+
+func TestSimTPM20QuoteSHA384(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	pub, err := ParseAKPublic(tpm.Version(), ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	quote, err := ak.Quote(tpm, nonce, HashSHA384)
+	if err != nil {
+		t.Fatalf("ak.Quote() failed: %v", err)
+	}
+	pcrs, err := tpm.PCRs(HashSHA384)
+	if err != nil {
+		t.Fatalf("tpm.PCRs() failed: %v", err)
+	}
+	for _, pcr := range pcrs {
+		if pcr.DigestAlg != crypto.SHA384 || len(pcr.Digest) != crypto.SHA384.Size() {
+			t.Fatalf("PCR %d has digest %x (%v), want a SHA-384 digest", pcr.Index, pcr.Digest, pcr.DigestAlg)
+		}
+	}
+	if err := pub.Verify(*quote, pcrs, nonce); err != nil {
+		t.Errorf("quote verification failed: %v", err)
+	}
+}
+
+func TestSimTPM20VerifyMultiBankQuote(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+	rwc := tpm.tpm.(*wrappedTPM20).rwc
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	pub, err := ParseAKPublic(tpm.Version(), ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+
+	// Extend PCR 16 so the banks hold a mix of zero and non-zero values.
+	if err := tpm2.PCREvent(rwc, 16, []byte("event")); err != nil {
+		t.Fatalf("PCREvent() failed: %v", err)
+	}
+
+	// The legacy API can only quote a single bank, so use the direct API.
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	rsp, err := tpm2direct.Quote{
+		SignHandle: tpm2direct.AuthHandle{
+			Handle: tpm2direct.TPMHandle(ak.ak.(*wrappedKey20).hnd),
+			Auth:   tpm2direct.PasswordAuth(nil),
+		},
+		QualifyingData: tpm2direct.TPM2BData{Buffer: nonce},
+		InScheme:       tpm2direct.TPMTSigScheme{Scheme: tpm2direct.TPMAlgNull},
+		PCRSelect: tpm2direct.TPMLPCRSelection{
+			PCRSelections: []tpm2direct.TPMSPCRSelection{
+				{Hash: tpm2direct.TPMAlgSHA1, PCRSelect: tpm2direct.PCClientCompatible.PCRs(0, 16)},
+				{Hash: tpm2direct.TPMAlgSHA256, PCRSelect: tpm2direct.PCClientCompatible.PCRs(7, 16)},
+			},
+		},
+	}.Execute(transport.FromReadWriter(rwc))
+	if err != nil {
+		t.Fatalf("Quote() failed: %v", err)
+	}
+	quote := rsp.Quoted.Bytes()
+	sig := tpm2direct.Marshal(rsp.Signature)
+
+	expected := func() map[HashAlg]map[int][]byte {
+		out := make(map[HashAlg]map[int][]byte)
+		for alg, indexes := range map[HashAlg][]int{HashSHA1: {0, 16}, HashSHA256: {7, 16}} {
+			vals, err := tpm2.ReadPCRs(rwc, tpm2.PCRSelection{Hash: alg.goTPMAlg(), PCRs: indexes})
+			if err != nil {
+				t.Fatalf("ReadPCRs() failed: %v", err)
+			}
+			out[alg] = vals
+		}
+		return out
+	}
+	if err := VerifyMultiBankQuote(pub.Public, quote, sig, nonce, expected()); err != nil {
+		t.Fatalf("VerifyMultiBankQuote() failed: %v", err)
+	}
+
+	// PCR 0 has not been extended, so an empty value is equivalent.
+	empty := expected()
+	empty[HashSHA1][0] = nil
+	if err := VerifyMultiBankQuote(pub.Public, quote, sig, nonce, empty); err != nil {
+		t.Errorf("VerifyMultiBankQuote() with empty PCR value failed: %v", err)
+	}
+
+	tampered := expected()
+	tampered[HashSHA256][16][0] ^= 0xff
+	missing := expected()
+	delete(missing[HashSHA256], 7)
+	extra := expected()
+	extra[HashSHA256][8] = make([]byte, 32)
+	emptyExtended := expected()
+	emptyExtended[HashSHA1][16] = nil
+
+	for _, test := range []struct {
+		name  string
+		nonce []byte
+		pcrs  map[HashAlg]map[int][]byte
+	}{
+		{"wrong nonce", []byte{8, 7, 6, 5, 4, 3, 2, 1}, expected()},
+		{"tampered PCR", nonce, tampered},
+		{"missing PCR", nonce, missing},
+		{"extra PCR", nonce, extra},
+		{"empty extended PCR", nonce, emptyExtended},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := VerifyMultiBankQuote(pub.Public, quote, sig, test.nonce, test.pcrs); err == nil {
+				t.Error("VerifyMultiBankQuote() succeeded, want error")
+			}
+		})
+	}
+
+	// Single bank quotes are also accepted.
+	single, err := ak.Quote(tpm, nonce, HashSHA256)
+	if err != nil {
+		t.Fatalf("ak.Quote() failed: %v", err)
+	}
+	pcrs, err := tpm.PCRs(HashSHA256)
+	if err != nil {
+		t.Fatalf("tpm.PCRs() failed: %v", err)
+	}
+	bank := make(map[int][]byte)
+	for _, pcr := range pcrs {
+		bank[pcr.Index] = pcr.Digest
+	}
+	if err := VerifyMultiBankQuote(pub.Public, single.Quote, single.Signature, nonce, map[HashAlg]map[int][]byte{HashSHA256: bank}); err != nil {
+		t.Errorf("VerifyMultiBankQuote() failed for single bank quote: %v", err)
+	}
+}
+
+func TestSimTPM20QuoteWithPCRs(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	selection := []int{0, 4, 7}
+	quote, pcrs, err := ak.QuoteWithPCRs(tpm, nonce, HashSHA256, selection)
+	if err != nil {
+		t.Fatalf("QuoteWithPCRs() failed: %v", err)
+	}
+	if len(pcrs) != len(selection) {
+		t.Errorf("QuoteWithPCRs() returned %d PCRs, want %d", len(pcrs), len(selection))
+	}
+	pub, err := ParseAKPublic(tpm.Version(), ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+	if err := VerifyQuote(pub.Public, quote.Quote, quote.Signature, nonce, pcrs); err != nil {
+		t.Errorf("VerifyQuote() failed: %v", err)
+	}
+
+	att, err := tpm2.DecodeAttestationData(quote.Quote)
+	if err != nil {
+		t.Fatalf("DecodeAttestationData() failed: %v", err)
+	}
+	if !bytes.Equal(att.ExtraData, nonce) {
+		t.Errorf("quote extraData = %x, want nonce %x", att.ExtraData, nonce)
+	}
+
+	if _, _, err := ak.QuoteWithPCRs(tpm, nonce, HashSHA256, []int{24}); err == nil {
+		t.Error("QuoteWithPCRs() with an invalid PCR index succeeded, want error")
+	}
+}
+
+func TestSimTPM20VerifyQuote(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	quote, err := ak.Quote(tpm, nonce, HashSHA256)
+	if err != nil {
+		t.Fatalf("ak.Quote() failed: %v", err)
+	}
+	pcrs, err := tpm.PCRs(HashSHA256)
+	if err != nil {
+		t.Fatalf("tpm.PCRs() failed: %v", err)
+	}
+	pub, err := ParseAKPublic(tpm.Version(), ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+
+	expected := func() map[int][]byte {
+		m := make(map[int][]byte, len(pcrs))
+		for _, pcr := range pcrs {
+			m[pcr.Index] = append([]byte(nil), pcr.Digest...)
+		}
+		return m
+	}
+	if err := VerifyQuote(pub.Public, quote.Quote, quote.Signature, nonce, expected()); err != nil {
+		t.Fatalf("VerifyQuote() failed: %v", err)
+	}
+
+	tampered := expected()
+	tampered[0][0] ^= 0xff
+	missing := expected()
+	delete(missing, 0)
+	badSig := append([]byte(nil), quote.Signature...)
+	badSig[len(badSig)-1] ^= 0xff
+
+	for _, test := range []struct {
+		name  string
+		sig   []byte
+		nonce []byte
+		pcrs  map[int][]byte
+	}{
+		{"wrong nonce", quote.Signature, []byte{8, 7, 6, 5, 4, 3, 2, 1}, expected()},
+		{"no nonce", quote.Signature, nil, expected()},
+		{"tampered PCR", quote.Signature, nonce, tampered},
+		{"missing PCR", quote.Signature, nonce, missing},
+		{"bad signature", badSig, nonce, expected()},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := VerifyQuote(pub.Public, quote.Quote, test.sig, test.nonce, test.pcrs); err == nil {
+				t.Error("VerifyQuote() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestSimTPM20AttestPlatform(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	attestation, err := tpm.attestPlatform(ak, nonce, nil)
+	if err != nil {
+		t.Fatalf("AttestPlatform() failed: %v", err)
+	}
+
+	pub, err := ParseAKPublic(attestation.TPMVersion, attestation.Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+	if err := pub.VerifyAll(attestation.Quotes, attestation.PCRs, nonce); err != nil {
+		t.Errorf("quote verification failed: %v", err)
+	}
+}
+
+// flakyCmdChannel responds to the first failures commands with one of the
+// given command codes with the response code rc, without sending them to
+// the TPM.
+type flakyCmdChannel struct {
+	CommandChannelTPM20
+	cmds     map[tpmutil.Command]bool
+	rc       uint32
+	failures int
+	pending  bool
+}
+
+func (c *flakyCmdChannel) Write(cmd []byte) (int, error) {
+	if c.failures > 0 && len(cmd) >= 10 && c.cmds[tpmutil.Command(binary.BigEndian.Uint32(cmd[6:10]))] {
+		c.failures--
+		c.pending = true
+		return len(cmd), nil
+	}
+	return c.CommandChannelTPM20.Write(cmd)
+}
+
+func (c *flakyCmdChannel) Read(p []byte) (int, error) {
+	if !c.pending {
+		return c.CommandChannelTPM20.Read(p)
+	}
+	c.pending = false
+	rsp := make([]byte, 10)
+	binary.BigEndian.PutUint16(rsp[0:2], uint16(tpm2.TagNoSessions))
+	binary.BigEndian.PutUint32(rsp[2:6], uint32(len(rsp)))
+	binary.BigEndian.PutUint32(rsp[6:10], c.rc)
+	return copy(p, rsp), nil
+}
+
+func TestSimTPM20Retry(t *testing.T) {
+	sim, err := simulator.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	const (
+		rcTesting = 0x90a
+		rcLockout = 0x921
+	)
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	for _, test := range []struct {
+		name     string
+		policy   *RetryPolicy
+		rc       uint32
+		failures int
+		// wantFailures is the number of injected failures left afterwards.
+		wantFailures int
+		wantErr      bool
+	}{
+		{"transient", policy, rcTesting, 2, 0, false},
+		{"no policy", nil, rcTesting, 1, 0, true},
+		{"attempts exhausted", policy, rcTesting, 3, 0, true},
+		{"fatal", policy, rcLockout, 2, 1, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, cmd := range []tpmutil.Command{tpm2.CmdCreate, tpm2.CmdSign} {
+				cc := &flakyCmdChannel{
+					CommandChannelTPM20: &fakeCmdChannel{sim},
+					cmds:                map[tpmutil.Command]bool{cmd: true},
+					rc:                  test.rc,
+				}
+				tpm, err := OpenTPM(&OpenConfig{CommandChannel: cc, Retry: test.policy})
+				if err != nil {
+					t.Fatalf("OpenTPM() failed: %v", err)
+				}
+				ak, err := tpm.NewAK(nil)
+				if err != nil {
+					t.Fatalf("NewAK() failed: %v", err)
+				}
+				// Only fail commands once the AK exists, so that key
+				// creation and signing are exercised separately.
+				cc.failures = test.failures
+				err = func() error {
+					k, err := tpm.NewKey(ak, nil)
+					if err != nil {
+						return err
+					}
+					defer k.Close()
+					priv, err := k.Private(k.Public())
+					if err != nil {
+						return err
+					}
+					digest := sha256.Sum256([]byte("hello"))
+					_, err = priv.(crypto.Signer).Sign(rand.Reader, digest[:], crypto.SHA256)
+					return err
+				}()
+				ak.Close(tpm)
+				if gotErr := err != nil; gotErr != test.wantErr {
+					t.Errorf("failing %v: got err = %v, wantErr %v", cmd, err, test.wantErr)
+				}
+				if cc.failures != test.wantFailures {
+					t.Errorf("failing %v: %d injected failures left, want %d", cmd, cc.failures, test.wantFailures)
+				}
+			}
+		})
+	}
+}
+
+func TestSimTPM20Audit(t *testing.T) {
+	sim, err := simulator.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	type auditRecord struct {
+		cmd tpmutil.Command
+		rc  tpmutil.ResponseCode
+	}
+	var records []auditRecord
+	cc := &flakyCmdChannel{
+		CommandChannelTPM20: &fakeCmdChannel{sim},
+		cmds:                map[tpmutil.Command]bool{tpm2.CmdCreate: true},
+		rc:                  0x90a, // TPM_RC_TESTING
+	}
+	tpm, err := OpenTPM(&OpenConfig{
+		CommandChannel: cc,
+		Retry:          &RetryPolicy{InitialBackoff: time.Millisecond},
+		Audit: func(cmd tpmutil.Command, rc tpmutil.ResponseCode) {
+			records = append(records, auditRecord{cmd, rc})
+		},
+	})
+	if err != nil {
+		t.Fatalf("OpenTPM() failed: %v", err)
+	}
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	records = nil
+	cc.failures = 1
+	k, err := tpm.NewKey(ak, nil)
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	k.Close()
+
+	want := []auditRecord{
+		{tpm2.CmdReadPublic, 0},
+		{tpm2.CmdCreate, 0x90a},
+		// The retried command is audited again.
+		{tpm2.CmdCreate, 0},
+		{tpm2.CmdLoad, 0},
+		{tpm2.CmdReadPublic, 0},
+		{tpm2.CmdCertify, 0},
+		{tpm2.CmdFlushContext, 0},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("audited commands = %x, want %x", records, want)
+	}
+
+	// Without an audit callback, the command channel is used directly.
+	tpm, err = OpenTPM(&OpenConfig{CommandChannel: &fakeCmdChannel{sim}})
+	if err != nil {
+		t.Fatalf("OpenTPM() failed: %v", err)
+	}
+	if _, ok := tpm.tpm.(*wrappedTPM20).rwc.(*auditCmdChannel); ok {
+		t.Error("command channel is audited without an audit callback")
+	}
+}
+
+func TestSimTPM20PCRs(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	PCRs, err := tpm.PCRs(HashSHA256)
+	if err != nil {
+		t.Fatalf("PCRs() failed: %v", err)
+	}
+	if len(PCRs) != 24 {
+		t.Errorf("len(PCRs) = %d, want %d", len(PCRs), 24)
+	}
+
+	for i, pcr := range PCRs {
+		if len(pcr.Digest) != pcr.DigestAlg.Size() {
+			t.Errorf("PCR %d len(digest) = %d, expected match with digest algorithm size (%d)", pcr.Index, len(pcr.Digest), pcr.DigestAlg.Size())
+		}
+		if pcr.Index != i {
+			t.Errorf("PCR index %d does not match map index %d", pcr.Index, i)
+		}
+		if pcr.DigestAlg != crypto.SHA256 {
+			t.Errorf("pcr.DigestAlg = %v, expected crypto.SHA256", pcr.DigestAlg)
+		}
+	}
+}
+
+func TestSimTPM20PersistenceSRK(t *testing.T) {
+	testPersistenceSRK(t, defaultParentConfig)
+}
+
+func TestSimTPM20PersistenceECCSRK(t *testing.T) {
+	parentConfig := ParentKeyConfig{
+		Algorithm: ECDSA,
+		Handle:    0x81000002,
+	}
+	testPersistenceSRK(t, parentConfig)
+}
+
+func testPersistenceSRK(t *testing.T, parentConfig ParentKeyConfig) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	srkHnd, _, err := tpm.tpm.(*wrappedTPM20).getStorageRootKeyHandle(parentConfig)
+	if err != nil {
+		t.Fatalf("getStorageRootKeyHandle() failed: %v", err)
+	}
+	if srkHnd != parentConfig.Handle {
+		t.Fatalf("bad SRK-equivalent handle: got 0x%x, wanted 0x%x", srkHnd, parentConfig.Handle)
+	}
+
+	srkHnd, p, err := tpm.tpm.(*wrappedTPM20).getStorageRootKeyHandle(parentConfig)
+	if err != nil {
+		t.Fatalf("second getStorageRootKeyHandle() failed: %v", err)
+	}
+	if srkHnd != parentConfig.Handle {
+		t.Fatalf("bad SRK-equivalent handle: got 0x%x, wanted 0x%x", srkHnd, parentConfig.Handle)
+	}
+	if p {
+		t.Fatalf("generated a new key the second time; that shouldn't happen")
+	}
+}
+
+func TestSimTPM20PersistenceEK(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	eks, err := tpm.EKs()
+	if err != nil {
+		t.Errorf("EKs() failed: %v", err)
+	}
+	if len(eks) == 0 || (eks[0].Public == nil) {
+		t.Errorf("EKs() = %v, want at least 1 EK with populated fields", eks)
+	}
+
+	ek := eks[0]
+	ekHnd, _, err := tpm.tpm.(*wrappedTPM20).getEndorsementKeyHandle(&ek)
+	if err != nil {
+		t.Fatalf("getStorageRootKeyHandle() failed: %v", err)
+	}
+	if ekHnd != ek.handle {
+		t.Fatalf("bad EK-equivalent handle: got 0x%x, wanted 0x%x", ekHnd, ek.handle)
+	}
+
+	ekHnd, p, err := tpm.tpm.(*wrappedTPM20).getEndorsementKeyHandle(&ek)
+	if err != nil {
+		t.Fatalf("second getEndorsementKeyHandle() failed: %v", err)
+	}
+	if ekHnd != ek.handle {
+		t.Fatalf("bad EK-equivalent handle: got 0x%x, wanted 0x%x", ekHnd, ek.handle)
+	}
+	if p {
+		t.Fatalf("generated a new key the second time; that shouldn't happen")
+	}
+}
+
+func TestSimTPM20AKPolicy(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+	rwc := tpm.tpm.(*wrappedTPM20).rwc
+
+	sel := tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: []int{7}}
+	policyPCR := func(rw io.ReadWriter, session tpmutil.Handle) error {
+		return tpm2.PolicyPCR(rw, session, nil, sel)
+	}
+
+	// Compute the policy digest with a trial session.
+	session, _, err := tpm2.StartAuthSession(rwc, tpm2.HandleNull, tpm2.HandleNull, make([]byte, 16), nil, tpm2.SessionTrial, tpm2.AlgNull, tpm2.AlgSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession() failed: %v", err)
+	}
+	if err := policyPCR(rwc, session); err != nil {
+		t.Fatalf("PolicyPCR() failed: %v", err)
+	}
+	digest, err := tpm2.PolicyGetDigest(rwc, session)
+	if err != nil {
+		t.Fatalf("PolicyGetDigest() failed: %v", err)
+	}
+	tpm2.FlushContext(rwc, session)
+
+	if _, err := tpm.NewAK(&AKConfig{AuthPolicy: digest}); err == nil {
+		t.Error("NewAK() with AuthPolicy but no PolicySession succeeded, want error")
+	}
+	if _, err := tpm.NewAK(&AKConfig{PolicySession: policyPCR}); err == nil {
+		t.Error("NewAK() with PolicySession but no AuthPolicy succeeded, want error")
+	}
+
+	ak, err := tpm.NewAK(&AKConfig{AuthPolicy: digest, PolicySession: policyPCR})
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	pub, err := tpm2.DecodePublic(ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("DecodePublic() failed: %v", err)
+	}
+	if !bytes.Equal(pub.AuthPolicy, digest) {
+		t.Errorf("AK authPolicy = %x, want %x", pub.AuthPolicy, digest)
+	}
+	if pub.Attributes&tpm2.FlagUserWithAuth != 0 {
+		t.Error("AK has userWithAuth set, want it cleared")
+	}
+
+	ek, err := tpm.EKs()
+	if err != nil {
+		t.Fatalf("EKs() failed: %v", err)
+	}
+	ap := ActivationParameters{
+		TPMVersion: TPMVersion20,
+		AK:         ak.AttestationParameters(),
+		EK:         ek[0].Public,
+	}
+	if err := ap.CheckAKParameters(); err != nil {
+		t.Fatalf("CheckAKParameters() failed: %v", err)
+	}
+
+	akPub, err := ParseAKPublic(TPMVersion20, ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+	nonce := []byte{1, 2, 3, 4}
+	quote, err := ak.Quote(tpm, nonce, HashSHA256)
+	if err != nil {
+		t.Fatalf("Quote() failed: %v", err)
+	}
+	pcrs, err := tpm.PCRs(HashSHA256)
+	if err != nil {
+		t.Fatalf("PCRs() failed: %v", err)
+	}
+	if err := akPub.Verify(*quote, pcrs, nonce); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+
+	key, err := tpm.NewKey(ak, &KeyConfig{Algorithm: ECDSA, Size: 256})
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	defer key.Close()
+	cp := key.CertificationParameters()
+	if err := cp.Verify(VerifyOpts{Public: akPub.Public, Hash: crypto.SHA256}); err != nil {
+		t.Errorf("CertificationParameters.Verify() failed: %v", err)
+	}
+
+	// The policy no longer holds once PCR 7 changes.
+	if err := tpm2.PCRExtend(rwc, 7, tpm2.AlgSHA256, make([]byte, 32), ""); err != nil {
+		t.Fatalf("PCRExtend() failed: %v", err)
+	}
+	if _, err := ak.Quote(tpm, nonce, HashSHA256); err == nil {
+		t.Error("Quote() after changing PCR 7 succeeded, want error")
+	}
+}
+
+func TestSimTPM20ActivationEndorsementHierarchy(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+	rwc := tpm.tpm.(*wrappedTPM20).rwc
+
+	ekHnd, ekPub, err := tpm2.CreatePrimary(rwc, tpm2.HandleEndorsement, tpm2.PCRSelection{}, "", "", defaultRSAEKTemplate)
+	if err != nil {
+		t.Fatalf("CreatePrimary() failed: %v", err)
+	}
+	defer tpm2.FlushContext(rwc, ekHnd)
+
+	// The EK requires TPM2_PolicySecret with the endorsement hierarchy.
+	ekAuth := func() tpm2.AuthCommand {
+		t.Helper()
+		session, _, err := tpm2.StartAuthSession(rwc, tpm2.HandleNull, tpm2.HandleNull, make([]byte, 16), nil, tpm2.SessionPolicy, tpm2.AlgNull, tpm2.AlgSHA256)
+		if err != nil {
+			t.Fatalf("StartAuthSession() failed: %v", err)
+		}
+		t.Cleanup(func() { tpm2.FlushContext(rwc, session) })
+		if _, _, err := tpm2.PolicySecret(rwc, tpm2.HandleEndorsement, tpm2.AuthCommand{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession}, session, nil, nil, nil, 0); err != nil {
+			t.Fatalf("PolicySecret() failed: %v", err)
+		}
+		return tpm2.AuthCommand{Session: session, Attributes: tpm2.AttrContinueSession}
+	}
+
+	// certify returns the parameters of an AK loaded at hnd, certifying its
+	// creation with itself.
+	certify := func(hnd tpmutil.Handle, pub, creationData, creationHash []byte, ticket tpm2.Ticket) AttestationParameters {
+		t.Helper()
+		attestation, sig, err := tpm2.CertifyCreation(rwc, "", hnd, hnd, nil, creationHash, tpm2.SigScheme{Alg: tpm2.AlgRSASSA, Hash: tpm2.AlgSHA256}, ticket)
+		if err != nil {
+			t.Fatalf("CertifyCreation() failed: %v", err)
+		}
+		return AttestationParameters{
+			Public:            pub,
+			CreateData:        creationData,
+			CreateAttestation: attestation,
+			CreateSignature:   sig,
+		}
+	}
+
+	primary := func() AttestationParameters {
+		hnd, _, creationData, creationHash, ticket, _, err := tpm2.CreatePrimaryEx(rwc, tpm2.HandleEndorsement, tpm2.PCRSelection{}, "", "", akTemplateRSA)
+		if err != nil {
+			t.Fatalf("CreatePrimaryEx() failed: %v", err)
+		}
+		defer tpm2.FlushContext(rwc, hnd)
+		pub, _, _, err := tpm2.ReadPublic(rwc, hnd)
+		if err != nil {
+			t.Fatalf("ReadPublic() failed: %v", err)
+		}
+		encPub, err := pub.Encode()
+		if err != nil {
+			t.Fatalf("Encode() failed: %v", err)
+		}
+		return certify(hnd, encPub, creationData, creationHash, ticket)
+	}
+
+	ekChild := func() AttestationParameters {
+		priv, pub, creationData, creationHash, ticket, err := tpm2.CreateKeyUsingAuth(rwc, ekHnd, tpm2.PCRSelection{}, ekAuth(), "", akTemplateRSA)
+		if err != nil {
+			t.Fatalf("CreateKeyUsingAuth() failed: %v", err)
+		}
+		hnd, _, err := tpm2.LoadUsingAuth(rwc, ekHnd, ekAuth(), pub, priv)
+		if err != nil {
+			t.Fatalf("LoadUsingAuth() failed: %v", err)
+		}
+		defer tpm2.FlushContext(rwc, hnd)
+		return certify(hnd, pub, creationData, creationHash, ticket)
+	}
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+
+	for _, test := range []struct {
+		name    string
+		ak      AttestationParameters
+		wantErr bool
+	}{
+		{"endorsement primary", primary(), false},
+		{"EK child", ekChild(), false},
+		{"SRK child", ak.AttestationParameters(), true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p := ActivationParameters{
+				TPMVersion:                  TPMVersion20,
+				AK:                          test.ak,
+				EK:                          ekPub,
+				RequireEndorsementHierarchy: true,
+			}
+			err := p.CheckAKParameters()
+			if gotErr := errors.Is(err, ErrAKNotInEndorsementHierarchy); gotErr != test.wantErr {
+				t.Errorf("CheckAKParameters() = %v, want ErrAKNotInEndorsementHierarchy %v", err, test.wantErr)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("CheckAKParameters() failed: %v", err)
+			}
+
+			p.RequireEndorsementHierarchy = false
+			if err := p.CheckAKParameters(); err != nil {
+				t.Errorf("CheckAKParameters() without RequireEndorsementHierarchy failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestSimTPM20ComputePolicyPCRDigest(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+	rwc := tpm.tpm.(*wrappedTPM20).rwc
+
+	if err := tpm2.PCREvent(rwc, 16, []byte("event")); err != nil {
+		t.Fatalf("PCREvent() failed: %v", err)
+	}
+
+	for _, test := range []struct {
+		bank, alg HashAlg
+	}{
+		{HashSHA256, HashSHA256},
+		{HashSHA1, HashSHA256},
+		{HashSHA256, HashSHA1},
+	} {
+		t.Run(fmt.Sprintf("%v bank %v policy", test.bank, test.alg), func(t *testing.T) {
+			pcrs, err := tpm.PCRs(test.bank)
+			if err != nil {
+				t.Fatalf("PCRs() failed: %v", err)
+			}
+			values := map[int][]byte{}
+			for _, pcr := range pcrs {
+				values[pcr.Index] = pcr.Digest
+			}
+			// Selected out of order, to check the digest doesn't depend on it.
+			sel := PCRSelection{Hash: test.bank, PCRs: []int{16, 0, 7}}
+			got, err := ComputePolicyPCRDigest(sel, values, test.alg)
+			if err != nil {
+				t.Fatalf("ComputePolicyPCRDigest() failed: %v", err)
+			}
+
+			session, _, err := tpm2.StartAuthSession(rwc, tpm2.HandleNull, tpm2.HandleNull, make([]byte, 16), nil, tpm2.SessionTrial, tpm2.AlgNull, test.alg.goTPMAlg())
+			if err != nil {
+				t.Fatalf("StartAuthSession() failed: %v", err)
+			}
+			defer tpm2.FlushContext(rwc, session)
+			if err := tpm2.PolicyPCR(rwc, session, nil, tpm2.PCRSelection{Hash: test.bank.goTPMAlg(), PCRs: sel.PCRs}); err != nil {
+				t.Fatalf("PolicyPCR() failed: %v", err)
+			}
+			want, err := tpm2.PolicyGetDigest(rwc, session)
+			if err != nil {
+				t.Fatalf("PolicyGetDigest() failed: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("ComputePolicyPCRDigest() = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestSimTPM20QuoteClock(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	pub, err := ParseAKPublic(tpm.Version(), ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+
+	ref, err := tpm.ReadClock()
+	if err != nil {
+		t.Fatalf("ReadClock() failed: %v", err)
+	}
+	start := time.Now()
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	quote, err := ak.Quote(tpm, nonce, HashSHA256)
+	if err != nil {
+		t.Fatalf("ak.Quote() failed: %v", err)
+	}
+	elapsed := time.Since(start)
+	pcrs, err := tpm.PCRs(HashSHA256)
+	if err != nil {
+		t.Fatalf("tpm.PCRs() failed: %v", err)
+	}
+
+	ci, err := quote.ClockInfo()
+	if err != nil {
+		t.Fatalf("quote.ClockInfo() failed: %v", err)
+	}
+	if ci.Clock < ref.Clock {
+		t.Errorf("quote clock %d is before the reference clock %d", ci.Clock, ref.Clock)
+	}
+
+	if err := pub.VerifyWithClock(*quote, pcrs, nonce, nil); err != nil {
+		t.Errorf("VerifyWithClock() without a window failed: %v", err)
+	}
+	window := &ClockWindow{Reference: ref, Elapsed: elapsed, MaxSkew: 5 * time.Second}
+	if err := pub.VerifyWithClock(*quote, pcrs, nonce, window); err != nil {
+		t.Errorf("VerifyWithClock() failed: %v", err)
+	}
+	// A quote taken an hour before the verifier expects is stale.
+	window.Elapsed += time.Hour
+	if err := pub.VerifyWithClock(*quote, pcrs, nonce, window); err == nil {
+		t.Error("VerifyWithClock() succeeded for a stale quote")
+	}
+}
+
+func TestSimTPM20AKTemplateDigest(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	for _, test := range []struct {
+		alg  Algorithm
+		tmpl tpm2.Public
+	}{
+		{RSA, akTemplateRSA},
+		{ECDSA, akTemplateECC},
+	} {
+		ak, err := tpm.NewAK(&AKConfig{Algorithm: test.alg})
+		if err != nil {
+			t.Fatalf("NewAK(%v) failed: %v", test.alg, err)
+		}
+		digest, err := AKTemplateDigest(test.tmpl)
+		if err != nil {
+			t.Fatalf("AKTemplateDigest(%v) failed: %v", test.alg, err)
+		}
+		p := ActivationParameters{
+			TPMVersion:         TPMVersion20,
+			AK:                 ak.AttestationParameters(),
+			AllowedAKTemplates: [][]byte{digest},
+		}
+		if err := p.CheckAKParameters(); err != nil {
+			t.Errorf("CheckAKParameters() failed for %v AK: %v", test.alg, err)
+		}
+		ak.Close(tpm)
+	}
+}
+
+func TestSimTPM20QuoteFirmwareVersion(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	eks, err := tpm.EKs()
+	if err != nil {
+		t.Fatalf("EKs() failed: %v", err)
+	}
+	ek := chooseEK(t, eks)
+
+	// An AK which is a child of the EK, whose quotes carry the TPM's
+	// firmware version.
+	ak, err := tpm.NewAK(&AKConfig{EK: &ek})
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	creation := ActivationParameters{AK: ak.AttestationParameters(), EK: ek.Public}
+	pub, err := ParseAKPublic(tpm.Version(), creation.AK.Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	quote, err := ak.Quote(tpm, nonce, HashSHA256)
+	if err != nil {
+		t.Fatalf("ak.Quote() failed: %v", err)
+	}
+	pcrs, err := tpm.PCRs(HashSHA256)
+	if err != nil {
+		t.Fatalf("tpm.PCRs() failed: %v", err)
+	}
+
+	v, err := quote.FirmwareVersion()
+	if err != nil {
+		t.Fatalf("quote.FirmwareVersion() failed: %v", err)
+	}
+	info, err := tpm.Info()
+	if err != nil {
+		t.Fatalf("Info() failed: %v", err)
+	}
+	if want := uint64(info.FirmwareVersionMajor<<16 | info.FirmwareVersionMinor); v>>32 != want {
+		t.Errorf("quote.FirmwareVersion() = 0x%x, want 0x%x in the upper 32 bits", v, want)
+	}
+	if err := pub.VerifyWithFirmware(*quote, pcrs, nonce, v, creation); err != nil {
+		t.Errorf("VerifyWithFirmware() at the TPM's version failed: %v", err)
+	}
+	if err := pub.VerifyWithFirmware(*quote, pcrs, nonce, v+1, creation); !errors.Is(err, ErrFirmwareVersionTooLow) {
+		t.Errorf("VerifyWithFirmware() above the TPM's version err = %v, want %v", err, ErrFirmwareVersionTooLow)
+	}
+	// The signature is checked before the firmware version.
+	bad := *quote
+	bad.Signature = append([]byte(nil), quote.Signature...)
+	bad.Signature[len(bad.Signature)-1] ^= 1
+	if err := pub.VerifyWithFirmware(bad, pcrs, nonce, 0, creation); err == nil {
+		t.Error("VerifyWithFirmware() succeeded with a bad signature")
+	}
+
+	// The AK is loaded again under the EK.
+	blob, err := ak.Marshal()
+	if err != nil {
+		t.Fatalf("ak.Marshal() failed: %v", err)
+	}
+	if err := ak.Close(tpm); err != nil {
+		t.Fatalf("ak.Close() failed: %v", err)
+	}
+	if ak, err = tpm.LoadAK(blob); err != nil {
+		t.Fatalf("LoadAK() failed: %v", err)
+	}
+	if quote, err = ak.Quote(tpm, nonce, HashSHA256); err != nil {
+		t.Fatalf("ak.Quote() of the loaded AK failed: %v", err)
+	}
+	if err := pub.VerifyWithFirmware(*quote, pcrs, nonce, v, creation); err != nil {
+		t.Errorf("VerifyWithFirmware() of the loaded AK failed: %v", err)
+	}
+	ak.Close(tpm)
+
+	if _, err := tpm.NewAK(&AKConfig{EK: &ek, Parent: &defaultParentConfig}); err == nil {
+		t.Error("NewAK() with both EK and Parent succeeded")
+	}
+
+	// The firmware version of an AK under the SRK is obfuscated, so its
+	// quotes are rejected whatever their version.
+	srkAK, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer srkAK.Close(tpm)
+	srkPub, err := ParseAKPublic(tpm.Version(), srkAK.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+	srkQuote, err := srkAK.Quote(tpm, nonce, HashSHA256)
+	if err != nil {
+		t.Fatalf("srkAK.Quote() failed: %v", err)
+	}
+	srkCreation := ActivationParameters{AK: srkAK.AttestationParameters(), EK: ek.Public}
+	if err := srkPub.VerifyWithFirmware(*srkQuote, pcrs, nonce, 0, srkCreation); !errors.Is(err, ErrAKNotInEndorsementHierarchy) {
+		t.Errorf("VerifyWithFirmware() for an AK under the SRK err = %v, want %v", err, ErrAKNotInEndorsementHierarchy)
+	}
+	// Creation parameters of another AK prove nothing about the quote's AK.
+	if err := srkPub.VerifyWithFirmware(*srkQuote, pcrs, nonce, 0, creation); err == nil {
+		t.Error("VerifyWithFirmware() with the creation parameters of another AK succeeded")
+	}
+}
+
+func TestSimTPM20QuoteSelection(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	pub, err := ParseAKPublic(tpm.Version(), ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+
+	sel, err := PCRSelection{Hash: HashSHA256, PCRs: []int{7}}.Merge(PCRSelection{Hash: HashSHA256, PCRs: []int{0, 4}})
+	if err != nil {
+		t.Fatalf("Merge() failed: %v", err)
+	}
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	quote, err := ak.QuoteSelection(tpm, nonce, sel)
+	if err != nil {
+		t.Fatalf("QuoteSelection() failed: %v", err)
+	}
+	all, err := tpm.PCRs(HashSHA256)
+	if err != nil {
+		t.Fatalf("PCRs() failed: %v", err)
+	}
+	var pcrs []PCR
+	for _, idx := range sel.PCRs {
+		pcrs = append(pcrs, all[idx])
+	}
+	if err := pub.Verify(*quote, pcrs, nonce); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+	got, err := quote.PCRSelections()
+	if err != nil {
+		t.Fatalf("PCRSelections() failed: %v", err)
+	}
+	if want := []PCRSelection{sel}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PCRSelections() = %+v, want %+v", got, want)
+	}
+
+	if err := VerifyQuoteSelection(pub.Public, quote.Quote, quote.Signature, nonce, []PCRSelection{sel}, all); err != nil {
+		t.Errorf("VerifyQuoteSelection() failed: %v", err)
+	}
+	for _, p := range all {
+		if got, want := p.QuoteVerified(), sel.selects(p.Index); got != want {
+			t.Errorf("PCR %d QuoteVerified() = %v, want %v", p.Index, got, want)
+		}
+	}
+	for _, test := range []struct {
+		name string
+		sels []PCRSelection
+		pcrs []PCR
+	}{
+		{"fewer PCRs", []PCRSelection{{Hash: HashSHA256, PCRs: []int{0, 4}}}, all},
+		{"more PCRs", []PCRSelection{{Hash: HashSHA256, PCRs: []int{0, 4, 7, 8}}}, all},
+		{"other bank", []PCRSelection{{Hash: HashSHA1, PCRs: sel.PCRs}}, all},
+		{"extra bank", []PCRSelection{sel, {Hash: HashSHA1, PCRs: sel.PCRs}}, all},
+		{"no selection", nil, all},
+		{"missing value", []PCRSelection{sel}, all[:5]},
+		{"duplicate value", []PCRSelection{sel}, append(all[:8:8], all[7])},
+	} {
+		if err := VerifyQuoteSelection(pub.Public, quote.Quote, quote.Signature, nonce, test.sels, test.pcrs); err == nil {
+			t.Errorf("VerifyQuoteSelection() with %s succeeded, want error", test.name)
+		}
+	}
+
+	if _, err := ak.QuoteSelection(tpm, nonce, PCRSelection{Hash: HashSHA256, PCRs: []int{24}}); err == nil {
+		t.Error("QuoteSelection() with an out of range PCR succeeded, want error")
+	}
+}
+
+func TestSimTPM20QuoteWithData(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	pub, err := ParseAKPublic(tpm.Version(), ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	data := []byte("enrollment session 42")
+	for _, alg := range []HashAlg{HashSHA256, HashSHA384} {
+		sel := PCRSelection{Hash: alg, PCRs: []int{0, 7}}
+		quote, err := ak.QuoteWithData(tpm, nonce, data, alg, sel)
+		if err != nil {
+			t.Fatalf("QuoteWithData(%v) failed: %v", alg, err)
+		}
+		all, err := tpm.PCRs(alg)
+		if err != nil {
+			t.Fatalf("PCRs(%v) failed: %v", alg, err)
+		}
+		pcrs := []PCR{all[0], all[7]}
+		if err := pub.VerifyWithData(*quote, pcrs, nonce, data, alg); err != nil {
+			t.Errorf("VerifyWithData(%v) failed: %v", alg, err)
+		}
+		if err := pub.VerifyWithData(*quote, pcrs, nonce, []byte("other data"), alg); err == nil {
+			t.Errorf("VerifyWithData(%v) with other data succeeded, want error", alg)
+		}
+
+		other := HashSHA384
+		if alg == HashSHA384 {
+			other = HashSHA256
+		}
+		if err := pub.VerifyWithData(*quote, pcrs, nonce, data, other); !errors.Is(err, ErrQuoteBindingHashMismatch) {
+			t.Errorf("VerifyWithData() of a %v binding with %v = %v, want %v", alg, other, err, ErrQuoteBindingHashMismatch)
+		}
 	}
 }
@@ -16,10 +16,13 @@ package attest
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var (
@@ -174,3 +177,123 @@ func TestBug142(t *testing.T) {
 		t.Errorf("ParseEKCertificate() = %v, want %v", err, wantErr)
 	}
 }
+
+// loadTPM12Dump loads a TPM 1.2 AK and EK from testdata.
+func loadTPM12Dump(t *testing.T) Dump {
+	t.Helper()
+	data, err := os.ReadFile("testdata/linux_tpm12.json")
+	if err != nil {
+		t.Fatalf("reading test data: %v", err)
+	}
+	var dump Dump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		t.Fatalf("parsing test data: %v", err)
+	}
+	return dump
+}
+
+// skipWithoutTPM12 skips a test of TPM 1.2 keys when the package is built
+// with the notpm12 build tag.
+func skipWithoutTPM12(t *testing.T) {
+	t.Helper()
+	if !tpm12Built {
+		t.Skip("TPM 1.2 support not built")
+	}
+}
+
+func TestParsePublic(t *testing.T) {
+	data, err := os.ReadFile("testdata/linux_tpm12.json")
+	if err != nil {
+		t.Fatalf("reading test data: %v", err)
+	}
+	var dump Dump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		t.Fatalf("parsing test data: %v", err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		version TPMVersion
+		public  []byte
+		want    KeyProperties
+	}{
+		{
+			name:    "TPM 1.2 RSA",
+			version: TPMVersion12,
+			public:  dump.AK.Public,
+			want:    KeyProperties{Algorithm: RSA, Bits: 2048},
+		},
+		{
+			name:    "TPM 2.0 RSA",
+			version: TPMVersion20,
+			public:  rsaAKParameters(t).Public,
+			want: KeyProperties{
+				Algorithm:           RSA,
+				Bits:                2048,
+				Restricted:          true,
+				FixedTPM:            true,
+				FixedParent:         true,
+				SensitiveDataOrigin: true,
+			},
+		},
+		{
+			name:    "TPM 2.0 ECC",
+			version: TPMVersion20,
+			public:  eccAKParameters(t).Public,
+			want: KeyProperties{
+				Algorithm:           ECDSA,
+				Bits:                256,
+				Restricted:          true,
+				FixedTPM:            true,
+				FixedParent:         true,
+				SensitiveDataOrigin: true,
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.version == TPMVersion12 {
+				skipWithoutTPM12(t)
+			}
+			pub, props, err := ParsePublic(test.version, test.public)
+			if err != nil {
+				t.Fatalf("ParsePublic() failed: %v", err)
+			}
+			if pub == nil {
+				t.Error("ParsePublic() returned nil public key")
+			}
+			if *props != test.want {
+				t.Errorf("ParsePublic() properties = %+v, want %+v", *props, test.want)
+			}
+		})
+	}
+
+	if _, _, err := ParsePublic(TPMVersion20, []byte{1, 2, 3}); err == nil {
+		t.Error("ParsePublic() on malformed blob returned nil error")
+	}
+}
+
+func TestClockWindow(t *testing.T) {
+	ref := ClockInfo{Clock: 10000}
+	for _, test := range []struct {
+		name    string
+		window  ClockWindow
+		clock   uint64
+		wantErr bool
+	}{
+		{"exact", ClockWindow{Reference: ref, Elapsed: time.Second}, 11000, false},
+		{"ahead within skew", ClockWindow{Reference: ref, Elapsed: time.Second, MaxSkew: time.Second}, 12000, false},
+		{"behind within skew", ClockWindow{Reference: ref, Elapsed: time.Second, MaxSkew: time.Second}, 10000, false},
+		{"ahead", ClockWindow{Reference: ref, Elapsed: time.Second, MaxSkew: time.Second}, 12001, true},
+		{"stale", ClockWindow{Reference: ref, Elapsed: time.Second, MaxSkew: time.Second}, 9999, true},
+		{"negative elapsed", ClockWindow{Reference: ref, Elapsed: -time.Second, MaxSkew: time.Hour}, 10000, true},
+	} {
+		err := test.window.Check(ClockInfo{Clock: test.clock})
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("%s: Check() returned err = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+	}
+
+	if _, err := (&Quote{Version: TPMVersion12}).ClockInfo(); err == nil {
+		t.Error("ClockInfo() succeeded for a TPM 1.2 quote")
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package attestproto maps types of the attest package to the protocol
+// buffer messages described in attest.proto, for services such as gRPC
+// servers which exchange them.
+//
+// The messages are encoded and decoded in the protocol buffer wire format
+// without depending on a protobuf runtime, so the bytes produced by Marshal
+// can be parsed by code generated from attest.proto, and vice versa.
+// Alternatively, the fields can be copied to and from generated messages,
+// using the field numbers documented on each field.
+package attestproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-attestation/attest"
+)
+
+// AttestationParameters is the goattestation.attest.AttestationParameters
+// message, which carries an attest.AttestationParameters.
+type AttestationParameters struct {
+	Public                  []byte // Field 1.
+	UseTCSDActivationFormat bool   // Field 2.
+	CreateData              []byte // Field 3.
+	CreateAttestation       []byte // Field 4.
+	CreateSignature         []byte // Field 5.
+}
+
+// FromAttestationParameters returns the message carrying p.
+func FromAttestationParameters(p attest.AttestationParameters) *AttestationParameters {
+	return &AttestationParameters{
+		Public:                  p.Public,
+		UseTCSDActivationFormat: p.UseTCSDActivationFormat,
+		CreateData:              p.CreateData,
+		CreateAttestation:       p.CreateAttestation,
+		CreateSignature:         p.CreateSignature,
+	}
+}
+
+// AttestationParameters returns the attest.AttestationParameters carried
+// by m.
+func (m *AttestationParameters) AttestationParameters() attest.AttestationParameters {
+	return attest.AttestationParameters{
+		Public:                  m.Public,
+		UseTCSDActivationFormat: m.UseTCSDActivationFormat,
+		CreateData:              m.CreateData,
+		CreateAttestation:       m.CreateAttestation,
+		CreateSignature:         m.CreateSignature,
+	}
+}
+
+// Marshal encodes m in the protocol buffer wire format.
+func (m *AttestationParameters) Marshal() []byte {
+	var b []byte
+	b = appendBytes(b, 1, m.Public)
+	b = appendBool(b, 2, m.UseTCSDActivationFormat)
+	b = appendBytes(b, 3, m.CreateData)
+	b = appendBytes(b, 4, m.CreateAttestation)
+	b = appendBytes(b, 5, m.CreateSignature)
+	return b
+}
+
+// Unmarshal decodes m from the protocol buffer wire format. Unknown fields
+// are ignored.
+func (m *AttestationParameters) Unmarshal(b []byte) error {
+	*m = AttestationParameters{}
+	return decodeFields(b, func(f field) error {
+		var err error
+		switch f.num {
+		case 1:
+			m.Public, err = f.bytesValue()
+		case 2:
+			m.UseTCSDActivationFormat, err = f.boolValue()
+		case 3:
+			m.CreateData, err = f.bytesValue()
+		case 4:
+			m.CreateAttestation, err = f.bytesValue()
+		case 5:
+			m.CreateSignature, err = f.bytesValue()
+		}
+		return err
+	})
+}
+
+// EncryptedCredential is the goattestation.attest.EncryptedCredential
+// message, which carries an attest.EncryptedCredential. The Parameters of
+// the credential, which are only used for auditing, and its SealedSecret,
+// which is not for the TPM, are not carried.
+type EncryptedCredential struct {
+	Credential []byte // Field 1.
+	Secret     []byte // Field 2.
+}
+
+// FromEncryptedCredential returns the message carrying ec.
+func FromEncryptedCredential(ec attest.EncryptedCredential) *EncryptedCredential {
+	return &EncryptedCredential{
+		Credential: ec.Credential,
+		Secret:     ec.Secret,
+	}
+}
+
+// EncryptedCredential returns the attest.EncryptedCredential carried by m.
+func (m *EncryptedCredential) EncryptedCredential() attest.EncryptedCredential {
+	return attest.EncryptedCredential{
+		Credential: m.Credential,
+		Secret:     m.Secret,
+	}
+}
+
+// Marshal encodes m in the protocol buffer wire format.
+func (m *EncryptedCredential) Marshal() []byte {
+	var b []byte
+	b = appendBytes(b, 1, m.Credential)
+	b = appendBytes(b, 2, m.Secret)
+	return b
+}
+
+// Unmarshal decodes m from the protocol buffer wire format. Unknown fields
+// are ignored.
+func (m *EncryptedCredential) Unmarshal(b []byte) error {
+	*m = EncryptedCredential{}
+	return decodeFields(b, func(f field) error {
+		var err error
+		switch f.num {
+		case 1:
+			m.Credential, err = f.bytesValue()
+		case 2:
+			m.Secret, err = f.bytesValue()
+		}
+		return err
+	})
+}
+
+// Wire types, as defined by the protocol buffer encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// maxFieldNumber is the largest valid field number.
+const maxFieldNumber = 1<<29 - 1
+
+// appendBytes appends a bytes field, omitting it if empty as proto3 does.
+func appendBytes(b []byte, num int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(num)<<3|wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendBool appends a bool field, omitting it if false as proto3 does.
+func appendBool(b []byte, num int, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(num)<<3|wireVarint)
+	return append(b, 1)
+}
+
+// field is a decoded field of a message.
+type field struct {
+	num    int
+	typ    int
+	varint uint64
+	bytes  []byte
+}
+
+func (f field) bytesValue() ([]byte, error) {
+	if f.typ != wireBytes {
+		return nil, fmt.Errorf("field %d has wire type %d, want %d", f.num, f.typ, wireBytes)
+	}
+	return append([]byte(nil), f.bytes...), nil
+}
+
+func (f field) boolValue() (bool, error) {
+	if f.typ != wireVarint {
+		return false, fmt.Errorf("field %d has wire type %d, want %d", f.num, f.typ, wireVarint)
+	}
+	return f.varint != 0, nil
+}
+
+// decodeFields calls fn with each field of the encoded message b.
+func decodeFields(b []byte, fn func(field) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("invalid field tag")
+		}
+		b = b[n:]
+		f := field{num: int(tag >> 3), typ: int(tag & 7)}
+		if tag>>3 == 0 || tag>>3 > maxFieldNumber {
+			return fmt.Errorf("invalid field number %d", tag>>3)
+		}
+		switch f.typ {
+		case wireVarint:
+			if f.varint, n = binary.Uvarint(b); n <= 0 {
+				return fmt.Errorf("field %d: invalid varint", f.num)
+			}
+			b = b[n:]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if f.typ == wireFixed32 {
+				size = 4
+			}
+			if len(b) < size {
+				return fmt.Errorf("field %d: truncated", f.num)
+			}
+			b = b[size:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("field %d: invalid length", f.num)
+			}
+			b = b[n:]
+			if l > uint64(len(b)) {
+				return fmt.Errorf("field %d: length %d exceeds remaining %d bytes", f.num, l, len(b))
+			}
+			f.bytes, b = b[:l], b[l:]
+		default:
+			return fmt.Errorf("field %d: unsupported wire type %d", f.num, f.typ)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package attestproto
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-attestation/attest"
+)
+
+// mappedFields lists the fields of each attest type carried by a message,
+// or deliberately left out of it. Adding a field to one of the types fails
+// the test until the mapping is updated.
+var mappedFields = map[reflect.Type][]string{
+	reflect.TypeOf(attest.AttestationParameters{}): {"Public", "UseTCSDActivationFormat", "CreateData", "CreateAttestation", "CreateSignature"},
+	// Parameters is only used for auditing, and SealedSecret is not for the
+	// TPM, so neither is carried.
+	reflect.TypeOf(attest.EncryptedCredential{}): {"Credential", "Secret", "Parameters", "SealedSecret"},
+}
+
+func TestFieldsMapped(t *testing.T) {
+	for typ, want := range mappedFields {
+		var got []string
+		for i := 0; i < typ.NumField(); i++ {
+			got = append(got, typ.Field(i).Name)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v has fields %v, but the proto mapping covers %v", typ, got, want)
+		}
+	}
+}
+
+func TestAttestationParametersRoundTrip(t *testing.T) {
+	for _, p := range []attest.AttestationParameters{
+		{},
+		{
+			Public:                  []byte("public"),
+			UseTCSDActivationFormat: true,
+			CreateData:              []byte("create data"),
+			CreateAttestation:       []byte("create attestation"),
+			CreateSignature:         bytes.Repeat([]byte{0xa5}, 300),
+		},
+	} {
+		var m AttestationParameters
+		if err := m.Unmarshal(FromAttestationParameters(p).Marshal()); err != nil {
+			t.Fatalf("Unmarshal() failed: %v", err)
+		}
+		got := m.AttestationParameters()
+		if !bytes.Equal(got.Public, p.Public) || got.UseTCSDActivationFormat != p.UseTCSDActivationFormat ||
+			!bytes.Equal(got.CreateData, p.CreateData) || !bytes.Equal(got.CreateAttestation, p.CreateAttestation) ||
+			!bytes.Equal(got.CreateSignature, p.CreateSignature) {
+			t.Errorf("round trip = %+v, want %+v", got, p)
+		}
+	}
+}
+
+func TestEncryptedCredentialRoundTrip(t *testing.T) {
+	ec := attest.EncryptedCredential{
+		Credential: []byte("credential"),
+		Secret:     []byte("secret"),
+	}
+	var m EncryptedCredential
+	if err := m.Unmarshal(FromEncryptedCredential(ec).Marshal()); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if got := m.EncryptedCredential(); !reflect.DeepEqual(got, ec) {
+		t.Errorf("round trip = %+v, want %+v", got, ec)
+	}
+}
+
+func TestMarshalWireFormat(t *testing.T) {
+	m := &AttestationParameters{
+		Public:                  []byte{1, 2},
+		UseTCSDActivationFormat: true,
+		CreateSignature:         []byte{3},
+	}
+	// Field 1 (bytes), field 2 (varint) and field 5 (bytes).
+	want := []byte{0x0a, 2, 1, 2, 0x10, 1, 0x2a, 1, 3}
+	if got := m.Marshal(); !bytes.Equal(got, want) {
+		t.Errorf("Marshal() = %x, want %x", got, want)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	// Unknown fields of every wire type are skipped.
+	in := []byte{
+		0x0a, 1, 0xff, // Field 1: public.
+		0x30, 0x96, 0x01, // Field 6: varint 150.
+		0x39, 0, 0, 0, 0, 0, 0, 0, 0, // Field 7: fixed64.
+		0x42, 2, 'h', 'i', // Field 8: bytes.
+		0x4d, 0, 0, 0, 0, // Field 9: fixed32.
+		0x10, 1, // Field 2: use_tcsd_activation_format.
+	}
+	var m AttestationParameters
+	if err := m.Unmarshal(in); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if !bytes.Equal(m.Public, []byte{0xff}) || !m.UseTCSDActivationFormat {
+		t.Errorf("Unmarshal() = %+v, want public ff and TCSD format", m)
+	}
+
+	for _, test := range []struct {
+		name string
+		in   []byte
+	}{
+		{"truncated bytes", []byte{0x0a, 5, 1}},
+		{"truncated varint", []byte{0x10, 0x80}},
+		{"wrong wire type", []byte{0x08, 1}},
+		{"field zero", []byte{0x02, 0}},
+		{"group", []byte{0x0b}},
+	} {
+		if err := m.Unmarshal(test.in); err == nil {
+			t.Errorf("%s: Unmarshal(%x) succeeded, want error", test.name, test.in)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build cgo
+// +build cgo
+
+package attesttest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm-tools/simulator"
+)
+
+// simulatorChannel implements attest.CommandChannelTPM20 over an in-process
+// TPM 2.0 simulator.
+type simulatorChannel struct {
+	*simulator.Simulator
+}
+
+// MeasurementLog implements attest.CommandChannelTPM20.
+func (c *simulatorChannel) MeasurementLog() ([]byte, error) {
+	return nil, errors.New("simulated TPM does not have a measurement log")
+}
+
+// OpenSimulatedTPM opens an in-process TPM 2.0 simulator, which behaves like
+// a TPM opened with attest.OpenTPM. Closing the returned TPM shuts down the
+// simulator.
+//
+// The simulator is a global resource, so only one may be open at a time:
+// subsequent calls block until the TPM returned by a previous call is
+// closed. The simulator has no measurement log, so an event log must be
+// provided to TPM.AttestPlatform using PlatformAttestConfig.EventLog.
+func OpenSimulatedTPM() (*attest.TPM, error) {
+	sim, err := simulator.Get()
+	if err != nil {
+		return nil, fmt.Errorf("starting simulator: %v", err)
+	}
+	tpm, err := attest.OpenTPM(&attest.OpenConfig{
+		TPMVersion:     attest.TPMVersion20,
+		CommandChannel: &simulatorChannel{sim},
+	})
+	if err != nil {
+		sim.Close()
+		return nil, fmt.Errorf("opening simulated TPM: %v", err)
+	}
+	return tpm, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build cgo
+// +build cgo
+
+package attesttest
+
+import (
+	"testing"
+
+	"github.com/google/go-attestation/attest"
+)
+
+func TestOpenSimulatedTPM(t *testing.T) {
+	tpm, err := OpenSimulatedTPM()
+	if err != nil {
+		t.Fatalf("OpenSimulatedTPM() failed: %v", err)
+	}
+	defer tpm.Close()
+
+	if v := tpm.Version(); v != attest.TPMVersion20 {
+		t.Errorf("tpm.Version() = %v, want %v", v, attest.TPMVersion20)
+	}
+	eks, err := tpm.EKs()
+	if err != nil {
+		t.Fatalf("EKs() failed: %v", err)
+	}
+	if len(eks) == 0 {
+		t.Fatal("EKs() returned no EKs")
+	}
+
+	// Enroll an AK.
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	ap := attest.ActivationParameters{
+		TPMVersion: tpm.Version(),
+		AK:         ak.AttestationParameters(),
+		EK:         eks[0].Public,
+	}
+	secret, challenge, err := ap.Generate()
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	got, err := ak.ActivateCredentialWithEK(tpm, *challenge, eks[0])
+	if err != nil {
+		t.Fatalf("ActivateCredentialWithEK() failed: %v", err)
+	}
+	if !attest.VerifySecret(secret, got) {
+		t.Error("activated secret does not match")
+	}
+
+	// Create and certify an application key.
+	key, err := tpm.NewKey(ak, nil)
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	defer key.Close()
+	akPub, err := attest.ParseAKPublic(tpm.Version(), ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+	cp := key.CertificationParameters()
+	if err := cp.Verify(attest.VerifyOpts{Public: akPub.Public, Hash: akPub.Hash}); err != nil {
+		t.Errorf("CertificationParameters.Verify() failed: %v", err)
+	}
+
+	if _, err := tpm.MeasurementLog(); err == nil {
+		t.Error("MeasurementLog() succeeded, want error")
+	}
+}
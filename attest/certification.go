@@ -17,14 +17,17 @@ package attest
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 
 	"github.com/google/go-tpm/legacy/tpm2"
-	"github.com/google/go-tpm/legacy/tpm2/credactivation"
+	tpm2direct "github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
 	"github.com/google/go-tpm/tpmutil"
 )
 
@@ -62,8 +65,17 @@ type VerifyOpts struct {
 	// Hash is the hash function used for signature verification. It can be
 	// extracted from the properties of the certifying key.
 	Hash crypto.Hash
+	// QualifyingData is the data expected to be included in the
+	// certification, as passed in KeyConfig.QualifyingData. If the
+	// certification includes different qualifying data, Verify returns
+	// ErrQualifyingDataMismatch.
+	QualifyingData []byte
 }
 
+// ErrQualifyingDataMismatch is returned by CertificationParameters.Verify
+// when the certification does not include the expected qualifying data.
+var ErrQualifyingDataMismatch = errors.New("certification does not include the expected qualifying data")
+
 // ActivateOpts specifies options for the key certification's challenge generation.
 type ActivateOpts struct {
 	// EK, the endorsement key, describes an asymmetric key whose
@@ -102,7 +114,8 @@ func NewActivateOpts(verifierPubKey tpm2.Public, ek crypto.PublicKey) (*Activate
 // - the key was TPM-generated and resides within TPM
 // - the key can sign/decrypt outside-TPM objects
 // - the signature is successfuly verified against the passed public key
-// For now, it accepts only RSA verification keys.
+// - the certification includes the expected qualifying data
+// It accepts RSA and ECDSA verification keys.
 func (p *CertificationParameters) Verify(opts VerifyOpts) error {
 	pub, err := tpm2.DecodePublic(p.Public)
 	if err != nil {
@@ -155,7 +168,7 @@ func (p *CertificationParameters) Verify(opts VerifyOpts) error {
 
 	// Verify the attested creation name matches what is computed from
 	// the public key.
-	match, err := att.AttestedCertifyInfo.Name.MatchesPublic(pub)
+	match, err := VerifyKeyName(att.AttestedCertifyInfo.Name, pub)
 	if err != nil {
 		return err
 	}
@@ -164,30 +177,155 @@ func (p *CertificationParameters) Verify(opts VerifyOpts) error {
 	}
 
 	// Check the signature over the attestation data verifies correctly.
-	// TODO: Support ECC certifying keys
-	pk, ok := opts.Public.(*rsa.PublicKey)
-	if !ok {
-		return fmt.Errorf("only RSA verification keys are supported")
+	if err := verifyAttestationSignature(opts.Public, opts.Hash, p.CreateAttestation, p.CreateSignature); err != nil {
+		return err
 	}
-	if !opts.Hash.Available() {
-		return fmt.Errorf("hash function is unavailable")
+
+	if !bytes.Equal(att.ExtraData, opts.QualifyingData) {
+		return ErrQualifyingDataMismatch
 	}
-	hsh := opts.Hash.New()
-	hsh.Write(p.CreateAttestation)
+	return nil
+}
 
-	if len(p.CreateSignature) < 8 {
-		return fmt.Errorf("signature invalid: length of %d is shorter than 8", len(p.CreateSignature))
+// verifyAttestationSignature verifies a TPMT_SIGNATURE made by signer over
+// a TPMS_ATTEST structure.
+func verifyAttestationSignature(signer crypto.PublicKey, hash crypto.Hash, attestation, signature []byte) error {
+	if err := checkHashAvailable(hash); err != nil {
+		return err
 	}
+	hsh := hash.New()
+	hsh.Write(attestation)
 
-	sig, err := tpm2.DecodeSignature(bytes.NewBuffer(p.CreateSignature))
+	if len(signature) < 8 {
+		return fmt.Errorf("signature invalid: length of %d is shorter than 8", len(signature))
+	}
+
+	sig, err := tpm2.DecodeSignature(bytes.NewBuffer(signature))
 	if err != nil {
 		return fmt.Errorf("DecodeSignature() failed: %v", err)
 	}
 
-	if err := rsa.VerifyPKCS1v15(pk, opts.Hash, hsh.Sum(nil), sig.RSA.Signature); err != nil {
-		return fmt.Errorf("could not verify attestation: %v", err)
+	switch pk := signer.(type) {
+	case *rsa.PublicKey:
+		if sig.RSA == nil {
+			return fmt.Errorf("expected RSA signature, got alg 0x%x", sig.Alg)
+		}
+		if sig.Alg == tpm2.AlgRSAPSS {
+			err = rsa.VerifyPSS(pk, hash, hsh.Sum(nil), sig.RSA.Signature, nil)
+		} else {
+			err = rsa.VerifyPKCS1v15(pk, hash, hsh.Sum(nil), sig.RSA.Signature)
+		}
+		if err != nil {
+			return fmt.Errorf("could not verify attestation: %v", err)
+		}
+	case *ecdsa.PublicKey:
+		if sig.ECC == nil {
+			return fmt.Errorf("expected ECC signature, got alg 0x%x", sig.Alg)
+		}
+		if !ecdsa.Verify(pk, hsh.Sum(nil), sig.ECC.R, sig.ECC.S) {
+			return errors.New("could not verify attestation: ECDSA verification failure")
+		}
+	default:
+		return fmt.Errorf("unsupported verification key type %T", signer)
 	}
+	return nil
+}
 
+// SignatureScheme returns the signature scheme and hash algorithm used by
+// the certifying key to sign CreateAttestation, as recorded in
+// CreateSignature. The scheme is one of tpm2.AlgRSASSA, tpm2.AlgRSAPSS or
+// tpm2.AlgECDSA. The hash can be used as VerifyOpts.Hash.
+func (p *CertificationParameters) SignatureScheme() (tpm2.Algorithm, crypto.Hash, error) {
+	sig, err := tpm2.DecodeSignature(bytes.NewBuffer(p.CreateSignature))
+	if err != nil {
+		return 0, 0, fmt.Errorf("DecodeSignature() failed: %v", err)
+	}
+	var hashAlg tpm2.Algorithm
+	switch {
+	case sig.RSA != nil:
+		hashAlg = sig.RSA.HashAlg
+	case sig.ECC != nil:
+		hashAlg = sig.ECC.HashAlg
+	default:
+		return 0, 0, fmt.Errorf("unsupported signature algorithm 0x%x", sig.Alg)
+	}
+	hash, err := tpmHash(hashAlg)
+	if err != nil {
+		return 0, 0, err
+	}
+	return sig.Alg, hash, nil
+}
+
+// VerifyAKCertification verifies that the AK described by akParams was
+// certified by a trusted key, such as one held by a CA, rather than by
+// itself. The CreateAttestation and CreateSignature fields of akParams must
+// hold the result of a TPM2_Certify command over the AK, signed by the key
+// whose public part is certifierPub. CreateData is not used.
+//
+// VerifyAKCertification checks that the AK is a TPM-resident, restricted key
+// of a secure size, and that the certification refers to it and was signed
+// by certifierPub. Unlike ActivationParameters.Generate, it does not check
+// that the AK resides on the same TPM as a particular EK.
+//
+// RSA AKs must be at least 2048 bits and ECC AKs use a curve of at least
+// 256 bits. Use ActivationParameters.VerifyAKCertification to configure
+// these minimums.
+func VerifyAKCertification(akParams AttestationParameters, certifierPub crypto.PublicKey) error {
+	return (&ActivationParameters{AK: akParams}).VerifyAKCertification(certifierPub)
+}
+
+// VerifyAKCertification is like the package-level VerifyAKCertification,
+// verifying the certification of p.AK, but enforces the MinAKBits and
+// MinAKECCBits of p, as CheckAKParameters and Generate do. The other
+// fields of p are not used.
+func (p *ActivationParameters) VerifyAKCertification(certifierPub crypto.PublicKey) error {
+	if p.MinAKBits < 0 || p.MinAKECCBits < 0 {
+		return fmt.Errorf("invalid minimum AK sizes %d and %d", p.MinAKBits, p.MinAKECCBits)
+	}
+	akParams := p.AK
+	switch certifierPub.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	case nil:
+		return errors.New("no certifying key provided")
+	default:
+		return fmt.Errorf("unsupported certifying key type %T", certifierPub)
+	}
+
+	pub, err := tpm2.DecodePublic(akParams.Public)
+	if err != nil {
+		return fmt.Errorf("DecodePublic() failed: %v", err)
+	}
+	certification := stripTPM2BAttest(akParams.CreateAttestation)
+	if len(certification) >= 4 && binary.BigEndian.Uint32(certification) != tpm20GeneratedMagic {
+		return rejectionErrorf(ErrAKNotTPMGenerated, "certification was not produced by a TPM")
+	}
+	att, err := tpm2.DecodeAttestationData(certification)
+	if err != nil {
+		return fmt.Errorf("DecodeAttestationData() failed: %v", err)
+	}
+	if att.Type != tpm2.TagAttestCertify {
+		return fmt.Errorf("attestation does not apply to certification data, got tag %x", att.Type)
+	}
+	if err := checkAKPublic20(pub, p.minAKBits(), p.minAKECCBits()); err != nil {
+		return err
+	}
+
+	match, err := VerifyKeyName(att.AttestedCertifyInfo.Name, pub)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return rejectionErrorf(ErrAKNameMismatch, "certification refers to a different key")
+	}
+
+	cp := CertificationParameters{CreateSignature: akParams.CreateSignature}
+	_, hash, err := cp.SignatureScheme()
+	if err != nil {
+		return rejectionErrorf(ErrAKSignatureInvalid, "invalid certification signature: %v", err)
+	}
+	if err := verifyAttestationSignature(certifierPub, hash, certification, akParams.CreateSignature); err != nil {
+		return rejectionErrorf(ErrAKSignatureInvalid, "%v", err)
+	}
 	return nil
 }
 
@@ -196,9 +334,8 @@ func (p *CertificationParameters) Verify(opts VerifyOpts) error {
 // is present on the same TPM as the EK.
 //
 // The caller is expected to verify the secret returned from the TPM as
-// as result of calling ActivateCredential() matches the secret returned here.
-// The caller should use subtle.ConstantTimeCompare to avoid potential
-// timing attack vectors.
+// as result of calling ActivateCredential() matches the secret returned here,
+// using VerifySecret to avoid potential timing attack vectors.
 func (p *CertificationParameters) Generate(rnd io.Reader, verifyOpts VerifyOpts, activateOpts ActivateOpts) (secret []byte, ec *EncryptedCredential, err error) {
 	if err := p.Verify(verifyOpts); err != nil {
 		return nil, nil, err
@@ -208,12 +345,11 @@ func (p *CertificationParameters) Generate(rnd io.Reader, verifyOpts VerifyOpts,
 		return nil, nil, errors.New("no EK provided")
 	}
 
-	secret = make([]byte, activationSecretLen)
 	if rnd == nil {
 		rnd = rand.Reader
 	}
-	if _, err = io.ReadFull(rnd, secret); err != nil {
-		return nil, nil, fmt.Errorf("error generating activation secret: %v", err)
+	if secret, err = generateActivationSecret(rnd, activationSecretLen); err != nil {
+		return nil, nil, err
 	}
 
 	att, err := tpm2.DecodeAttestationData(p.CreateAttestation)
@@ -225,20 +361,33 @@ func (p *CertificationParameters) Generate(rnd io.Reader, verifyOpts VerifyOpts,
 		return nil, nil, fmt.Errorf("attestation does not apply to certify data, got %x", att.Type)
 	}
 
-	cred, encSecret, err := credactivation.Generate(activateOpts.VerifierKeyNameDigest, activateOpts.EK, symBlockSize, secret)
+	ek, err := newPreparedEK(activateOpts.EK, ekNameAlg20, symBlockSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	name, err := activateOpts.VerifierKeyNameDigest.Encode()
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding name: %v", err)
+	}
+	s, err := newCredentialScratch(ekNameAlg20)
+	if err != nil {
+		return nil, nil, err
+	}
+	cred, encSecret, params, err := generateCredential20(rnd, s, ek, name, secret)
 	if err != nil {
-		return nil, nil, fmt.Errorf("credactivation.Generate() failed: %v", err)
+		return nil, nil, fmt.Errorf("generating credential failed: %v", err)
 	}
 
 	return secret, &EncryptedCredential{
 		Credential: cred,
 		Secret:     encSecret,
+		Parameters: params,
 	}, nil
 }
 
 // certify uses AK's handle and the passed signature scheme to certify the key
 // with the `hnd` handle.
-func certify(tpm io.ReadWriteCloser, hnd, akHnd tpmutil.Handle, scheme tpm2.SigScheme) (*CertificationParameters, error) {
+func certify(tpm io.ReadWriteCloser, hnd, akHnd tpmutil.Handle, auth, akAuth string, qualifyingData []byte, scheme tpm2.SigScheme) (*CertificationParameters, error) {
 	pub, _, _, err := tpm2.ReadPublic(tpm, hnd)
 	if err != nil {
 		return nil, fmt.Errorf("tpm2.ReadPublic() failed: %v", err)
@@ -247,7 +396,7 @@ func certify(tpm io.ReadWriteCloser, hnd, akHnd tpmutil.Handle, scheme tpm2.SigS
 	if err != nil {
 		return nil, fmt.Errorf("could not encode public key: %v", err)
 	}
-	att, sig, err := tpm2.CertifyEx(tpm, "", "", hnd, akHnd, nil, scheme)
+	att, sig, err := tpm2.CertifyEx(tpm, auth, akAuth, hnd, akHnd, qualifyingData, scheme)
 	if err != nil {
 		return nil, fmt.Errorf("tpm2.Certify() failed: %v", err)
 	}
@@ -257,3 +406,42 @@ func certify(tpm io.ReadWriteCloser, hnd, akHnd tpmutil.Handle, scheme tpm2.SigS
 		CreateSignature:   sig,
 	}, nil
 }
+
+// certifyWithPolicy is like certify, for an AK whose user role can only be
+// authorized with a policy session.
+func certifyWithPolicy(tpm io.ReadWriteCloser, hnd, akHnd tpmutil.Handle, akPublic []byte, auth string, qualifyingData []byte, policy func(io.ReadWriter, tpmutil.Handle) error) (*CertificationParameters, error) {
+	pub, _, _, err := tpm2.ReadPublic(tpm, hnd)
+	if err != nil {
+		return nil, fmt.Errorf("tpm2.ReadPublic() failed: %v", err)
+	}
+	public, err := pub.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("could not encode public key: %v", err)
+	}
+	name, err := encodedName(public)
+	if err != nil {
+		return nil, err
+	}
+	signHandle, err := policyAuthHandle(tpm, akHnd, akPublic, policy)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := tpm2direct.Certify{
+		ObjectHandle: tpm2direct.AuthHandle{
+			Handle: tpm2direct.TPMHandle(hnd),
+			Name:   tpm2direct.TPM2BName{Buffer: name},
+			Auth:   tpm2direct.PasswordAuth([]byte(auth)),
+		},
+		SignHandle:     signHandle,
+		QualifyingData: tpm2direct.TPM2BData{Buffer: qualifyingData},
+		InScheme:       tpm2direct.TPMTSigScheme{Scheme: tpm2direct.TPMAlgNull},
+	}.Execute(transport.FromReadWriter(tpm))
+	if err != nil {
+		return nil, fmt.Errorf("tpm2.Certify() failed: %v", err)
+	}
+	return &CertificationParameters{
+		Public:            public,
+		CreateAttestation: rsp.CertifyInfo.Bytes(),
+		CreateSignature:   tpm2direct.Marshal(rsp.Signature),
+	}, nil
+}
@@ -24,6 +24,8 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/binary"
+	"errors"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -276,6 +278,207 @@ func testKeyCertification(t *testing.T, tpm *TPM) {
 	}
 }
 
+func TestSimTPM20CertifyKey(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	akPub, err := ParseAKPublic(TPMVersion20, ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+
+	// The default algorithm is used, and the other options are kept.
+	qualifyingData := []byte("nonce")
+	sk, p, err := tpm.CertifyKey(ak, KeyConfig{QualifyingData: qualifyingData})
+	if err != nil {
+		t.Fatalf("CertifyKey() failed: %v", err)
+	}
+	defer sk.Close()
+	if err := p.Verify(VerifyOpts{Public: akPub.Public, Hash: akPub.Hash, QualifyingData: qualifyingData}); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+	pub, err := tpm2.DecodePublic(p.Public)
+	if err != nil {
+		t.Fatalf("DecodePublic() failed: %v", err)
+	}
+	certified, err := pub.Key()
+	if err != nil {
+		t.Fatalf("Key() failed: %v", err)
+	}
+	if eq, ok := sk.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !eq.Equal(certified) {
+		t.Errorf("certified key %v, want the created key %v", certified, sk.Public())
+	}
+
+	if _, _, err := tpm.CertifyKey(nil, KeyConfig{}); err == nil {
+		t.Error("CertifyKey() without an AK succeeded, want error")
+	}
+}
+
+func TestSimTPM20CertificationSignatureScheme(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	for _, test := range []struct {
+		name       string
+		akConfig   *AKConfig
+		wantScheme tpm2.Algorithm
+	}{
+		{"RSA", nil, tpm2.AlgRSASSA},
+		{"ECDSA", &AKConfig{Algorithm: ECDSA}, tpm2.AlgECDSA},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ak, err := tpm.NewAK(test.akConfig)
+			if err != nil {
+				t.Fatalf("NewAK() failed: %v", err)
+			}
+			defer ak.Close(tpm)
+			akPub, err := ParseAKPublic(TPMVersion20, ak.AttestationParameters().Public)
+			if err != nil {
+				t.Fatalf("ParseAKPublic() failed: %v", err)
+			}
+
+			sk, err := tpm.NewKey(ak, nil)
+			if err != nil {
+				t.Fatalf("NewKey() failed: %v", err)
+			}
+			defer sk.Close()
+			p := sk.CertificationParameters()
+
+			scheme, hash, err := p.SignatureScheme()
+			if err != nil {
+				t.Fatalf("SignatureScheme() failed: %v", err)
+			}
+			if scheme != test.wantScheme {
+				t.Errorf("SignatureScheme() scheme = 0x%x, want 0x%x", scheme, test.wantScheme)
+			}
+			if hash != crypto.SHA256 {
+				t.Errorf("SignatureScheme() hash = %v, want %v", hash, crypto.SHA256)
+			}
+			if err := p.Verify(VerifyOpts{Public: akPub.Public, Hash: hash}); err != nil {
+				t.Errorf("p.Verify() failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestSimTPM20CertificationQualifyingData(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	akPub, err := ParseAKPublic(TPMVersion20, ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+
+	qualifyingData := []byte("verifier nonce")
+	sk, err := tpm.NewKey(ak, &KeyConfig{Algorithm: ECDSA, Size: 256, QualifyingData: qualifyingData})
+	if err != nil {
+		t.Fatalf("NewKey() failed: %v", err)
+	}
+	defer sk.Close()
+	p := sk.CertificationParameters()
+
+	for _, test := range []struct {
+		name           string
+		qualifyingData []byte
+		wantErr        error
+	}{
+		{"match", qualifyingData, nil},
+		{"mismatch", []byte("other nonce"), ErrQualifyingDataMismatch},
+		{"missing", nil, ErrQualifyingDataMismatch},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := p.Verify(VerifyOpts{Public: akPub.Public, Hash: akPub.Hash, QualifyingData: test.qualifyingData})
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("p.Verify() err = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSimTPM20VerifyAKCertification(t *testing.T) {
+	sim, tpm := setupSimulatedTPM(t)
+	defer sim.Close()
+
+	certifier, err := tpm.NewAK(&AKConfig{Algorithm: ECDSA})
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer certifier.Close(tpm)
+	certifierPub, err := ParseAKPublic(TPMVersion20, certifier.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		t.Fatalf("NewAK() failed: %v", err)
+	}
+	defer ak.Close(tpm)
+	akPub, err := ParseAKPublic(TPMVersion20, ak.AttestationParameters().Public)
+	if err != nil {
+		t.Fatalf("ParseAKPublic() failed: %v", err)
+	}
+
+	cp, err := certifier.Certify(tpm, ak.ak.(*wrappedKey20).hnd)
+	if err != nil {
+		t.Fatalf("Certify() failed: %v", err)
+	}
+	params := AttestationParameters{
+		Public:            ak.AttestationParameters().Public,
+		CreateAttestation: cp.CreateAttestation,
+		CreateSignature:   cp.CreateSignature,
+	}
+	if err := VerifyAKCertification(params, certifierPub.Public); err != nil {
+		t.Fatalf("VerifyAKCertification() failed: %v", err)
+	}
+	wrapped := params
+	wrapped.CreateAttestation = append(binary.BigEndian.AppendUint16(nil, uint16(len(cp.CreateAttestation))), cp.CreateAttestation...)
+	if err := VerifyAKCertification(wrapped, certifierPub.Public); err != nil {
+		t.Errorf("VerifyAKCertification() with a TPM2B_ATTEST failed: %v", err)
+	}
+
+	// The AK's own creation attestation is not a certification.
+	if err := VerifyAKCertification(ak.AttestationParameters(), akPub.Public); err == nil {
+		t.Error("VerifyAKCertification() succeeded for a self-certified AK, want error")
+	}
+	if err := VerifyAKCertification(params, akPub.Public); !errors.Is(err, ErrAKSignatureInvalid) {
+		t.Errorf("VerifyAKCertification() with wrong certifier returned %v, want %v", err, ErrAKSignatureInvalid)
+	}
+	other := params
+	other.Public = certifier.AttestationParameters().Public
+	if err := VerifyAKCertification(other, certifierPub.Public); !errors.Is(err, ErrAKNameMismatch) {
+		t.Errorf("VerifyAKCertification() for a different AK returned %v, want %v", err, ErrAKNameMismatch)
+	}
+	if err := VerifyAKCertification(params, nil); err == nil {
+		t.Error("VerifyAKCertification() succeeded without a certifying key, want error")
+	}
+
+	// The minimum AK sizes of ActivationParameters apply to the
+	// certification as they do to activation.
+	p := ActivationParameters{AK: params, MinAKBits: 2048}
+	if err := p.VerifyAKCertification(certifierPub.Public); err != nil {
+		t.Errorf("VerifyAKCertification() with MinAKBits 2048 failed: %v", err)
+	}
+	p.MinAKBits = 3072
+	if err := p.VerifyAKCertification(certifierPub.Public); !errors.Is(err, ErrAKTooSmall) {
+		t.Errorf("VerifyAKCertification() with MinAKBits 3072 returned %v, want %v", err, ErrAKTooSmall)
+	}
+	p.MinAKBits = -1
+	if err := p.VerifyAKCertification(certifierPub.Public); err == nil {
+		t.Error("VerifyAKCertification() with negative MinAKBits succeeded, want error")
+	}
+}
+
 func TestKeyActivationTPM20(t *testing.T) {
 	sim, tpm := setupSimulatedTPM(t)
 	defer sim.Close()
@@ -2,13 +2,24 @@ package attest
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"slices"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
 )
 
 const (
@@ -19,6 +30,27 @@ const (
 	algXOR = 0x0000000a
 
 	schemeESNone = 0x0001
+
+	symModeCBC = 0x00000002
+	algAES128  = 0x00000006
+
+	labelIdentity  = "IDENTITY"
+	labelDuplicate = "DUPLICATE"
+	labelStorage   = "STORAGE"
+	labelIntegrity = "INTEGRITY"
+
+	// ekNameAlg20 is the name algorithm of EKs created from the default
+	// templates in the TCG EK Credential Profile.
+	ekNameAlg20 = tpm2.AlgSHA256
+)
+
+// The labels of seeds and of the keys derived from them, including their
+// terminating zero byte, as used in RSA-OAEP and the KDFs.
+var (
+	identityLabel  = []byte(labelIdentity + "\x00")
+	duplicateLabel = []byte(labelDuplicate + "\x00")
+	storageLabel   = []byte(labelStorage + "\x00")
+	integrityLabel = []byte(labelIntegrity + "\x00")
 )
 
 type symKeyHeader struct {
@@ -138,3 +170,412 @@ func generateChallenge12(rand io.Reader, pubkey *rsa.PublicKey, akpub, secret []
 
 	return asymenc, symOut.Bytes(), nil
 }
+
+// parseSymCredential12 returns the IV and ciphertext of the symmetrically
+// encrypted half of a TPM 1.2 challenge. Both the layout produced by
+// generateChallenge12 and the TPM_SYM_CA_ATTESTATION structure produced by
+// verification.GenerateChallengeEx, for use with tcsd, are accepted. tcsd
+// reports which layout was found.
+func parseSymCredential12(blob []byte) (iv, cipherText []byte, tcsd bool, err error) {
+	if len(blob) < 4 {
+		return nil, nil, false, errors.New("credential too short")
+	}
+	// The TPM_SYM_CA_ATTESTATION structure starts with the size of the
+	// credential, followed by a 24 byte TPM_KEY_PARMS structure, which
+	// can't be confused with the leading TPM_SYM_MODE_CBC.
+	offset := 4
+	if size := binary.BigEndian.Uint32(blob); size != symModeCBC {
+		if len(blob) < 28 || int(size) != len(blob)-28 {
+			return nil, nil, false, fmt.Errorf("unknown credential format (leading value 0x%x)", size)
+		}
+		if alg := binary.BigEndian.Uint32(blob[4:]); alg != algAES128 {
+			return nil, nil, false, fmt.Errorf("unsupported credential algorithm 0x%x", alg)
+		}
+		offset, tcsd = 28, true
+	}
+	if len(blob) < offset+2*aes.BlockSize || (len(blob)-offset)%aes.BlockSize != 0 {
+		return nil, nil, false, fmt.Errorf("invalid credential length %d", len(blob))
+	}
+	iv = blob[offset : offset+aes.BlockSize]
+	return iv, blob[offset+aes.BlockSize:], tcsd, nil
+}
+
+// generateCredential20 generates a TPM2B_ID_OBJECT and TPM2B_ENCRYPTED_SECRET
+// for use with TPM2_ActivateCredential on a TPM 2.0 device. This process is
+// defined in section 24 of the TPM 2.0 specification, part 1.
+//
+// This mirrors credactivation.Generate, but supports EKs whose symmetric
+// cipher uses keys larger than 128 bits, draws all randomness from rnd, and
+// protects the credential using the EK's name algorithm rather than that of
+// the AK, so AKs with any name algorithm may be used. name is the encoded
+// name of the key the credential is bound to, and s must use the name
+// algorithm of ek. The parameters of the credential are also returned.
+func generateCredential20(rnd io.Reader, s *credentialScratch, ek *preparedEK, name, secret []byte) (idObject, encSecret []byte, params *CredentialParameters, err error) {
+	var seed []byte
+	switch pub := ek.pub.(type) {
+	case *rsa.PublicKey:
+		seed, encSecret, err = createRSASeed20(rnd, s, identityLabel, pub, ek.blockSize)
+	case *ecdh.PublicKey:
+		seed, encSecret, err = createECCSeed20(rnd, s, identityLabel, pub, ek.x)
+	default:
+		return nil, nil, nil, fmt.Errorf("unsupported EK type %T", ek.pub)
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("creating seed: %v", err)
+	}
+	if len(secret) > maxActivationSecretLen {
+		return nil, nil, nil, fmt.Errorf("secret of %d bytes is too large", len(secret))
+	}
+
+	// The credential is protected as a TPM2B_DIGEST.
+	cv := binary.BigEndian.AppendUint16(s.plaintext[:0], uint16(len(secret)))
+	cv = append(cv, secret...)
+	// The size of the TPM2B_ID_OBJECT is filled in once its contents have
+	// been appended.
+	idObject = make([]byte, 2, 2+2+s.hash.Size()+len(cv))
+	if idObject, err = protectWithSeed20(idObject, s, seed, name, ek.blockSize, cv); err != nil {
+		return nil, nil, nil, err
+	}
+	binary.BigEndian.PutUint16(idObject, uint16(len(idObject)-2))
+
+	seedDigest := sha256.Sum256(seed)
+	return idObject, encSecret, &CredentialParameters{
+		Cipher:     aesCFBName(ek.blockSize),
+		KDFHash:    s.hash,
+		SeedDigest: seedDigest[:],
+	}, nil
+}
+
+// aesCFBName returns the name of AES in CFB mode with keys of keySize
+// bytes, as reported in CredentialParameters.Cipher.
+func aesCFBName(keySize int) string {
+	switch keySize {
+	case 16:
+		return "AES-128-CFB"
+	case 24:
+		return "AES-192-CFB"
+	case 32:
+		return "AES-256-CFB"
+	default:
+		return fmt.Sprintf("AES-%d-CFB", keySize*8)
+	}
+}
+
+// protectWithSeed20 encrypts plaintext with a key derived from seed and
+// name, the encoded name of the protected object, and protects it with an
+// HMAC, appending the resulting TPMS_ID_OBJECT to dst. s uses the name
+// algorithm of the key protecting the seed. The same construction protects
+// both credentials and the sensitive area of duplicated objects; see
+// sections 24.4 and 24.5 of the TPM 2.0 specification, part 1.
+func protectWithSeed20(dst []byte, s *credentialScratch, seed, name []byte, symKeySize int, plaintext []byte) ([]byte, error) {
+	if symKeySize > len(s.symKey) {
+		return nil, fmt.Errorf("unsupported symmetric key size %d", symKeySize)
+	}
+	s.setKey(seed)
+	symKey := s.kdfa(s.symKey[:symKeySize], storageLabel, name, nil)
+	macKey := s.kdfa(s.macKey[:s.hash.Size()], integrityLabel, nil, nil)
+	block, err := aes.NewCipher(symKey)
+	if err != nil {
+		return nil, fmt.Errorf("symmetric cipher setup: %v", err)
+	}
+
+	// The TPMS_ID_OBJECT holds the integrity HMAC as a TPM2B_DIGEST,
+	// followed by the encrypted plaintext.
+	dst = binary.BigEndian.AppendUint16(dst, uint16(s.hash.Size()))
+	macStart := len(dst)
+	dst = slices.Grow(dst, s.hash.Size()+len(plaintext))
+	dst = dst[:macStart+s.hash.Size()+len(plaintext)]
+	ciphertext := dst[macStart+s.hash.Size():]
+	// The IV is all zero bytes.
+	cipher.NewCFBEncrypter(block, s.iv[:]).XORKeyStream(ciphertext, plaintext)
+
+	s.setKey(macKey)
+	copy(dst[macStart:], s.mac(ciphertext, name))
+	return dst, nil
+}
+
+// duplicateForImport20 wraps sensitive, the sensitive area of the object
+// described by pub, to the storage key parent. It returns the duplicate and
+// encrypted seed to pass to TPM2_Import. See section 23.3 of the TPM 2.0
+// specification, part 1.
+func duplicateForImport20(rnd io.Reader, parent, pub tpm2.Public, sensitive tpm2.Private) (duplicate, encSeed []byte, err error) {
+	var sym *tpm2.SymScheme
+	switch parent.Type {
+	case tpm2.AlgRSA:
+		sym = parent.RSAParameters.Symmetric
+	case tpm2.AlgECC:
+		sym = parent.ECCParameters.Symmetric
+	default:
+		return nil, nil, fmt.Errorf("unsupported parent key type 0x%x", parent.Type)
+	}
+	if sym == nil || sym.Alg != tpm2.AlgAES || sym.Mode != tpm2.AlgCFB {
+		return nil, nil, errors.New("parent key does not protect its children with AES-CFB")
+	}
+	symKeySize := int(sym.KeyBits) / 8
+
+	parentPub, err := parent.Key()
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing parent public key: %v", err)
+	}
+	s, err := newCredentialScratch(parent.NameAlg)
+	if err != nil {
+		return nil, nil, err
+	}
+	var seed []byte
+	switch pk := parentPub.(type) {
+	case *rsa.PublicKey:
+		seed, encSeed, err = createRSASeed20(rnd, s, duplicateLabel, pk, symKeySize)
+	case *ecdsa.PublicKey:
+		var ecdhPub *ecdh.PublicKey
+		if ecdhPub, err = pk.ECDH(); err != nil {
+			return nil, nil, fmt.Errorf("converting parent key to ECDH key: %v", err)
+		}
+		seed, encSeed, err = createECCSeed20(rnd, s, duplicateLabel, ecdhPub, nil)
+	default:
+		return nil, nil, fmt.Errorf("unsupported parent key type %T", parentPub)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating seed: %v", err)
+	}
+
+	name, err := pub.Name()
+	if err != nil {
+		return nil, nil, fmt.Errorf("computing name: %v", err)
+	}
+	encodedName, err := name.Digest.Encode()
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding name: %v", err)
+	}
+	encoded, err := sensitive.Encode()
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding sensitive area: %v", err)
+	}
+	plaintext, err := tpmutil.Pack(tpmutil.U16Bytes(encoded))
+	if err != nil {
+		return nil, nil, fmt.Errorf("packing sensitive area: %v", err)
+	}
+	if duplicate, err = protectWithSeed20(nil, s, seed, encodedName, symKeySize, plaintext); err != nil {
+		return nil, nil, err
+	}
+	// TPM2_Import takes the encrypted seed without its size.
+	return duplicate, encSeed[2:], nil
+}
+
+// createRSASeed20 generates a seed and encrypts it to an RSA EK, as
+// described in annex B, section 10.4 of the TPM 2.0 specification, part 1.
+// label is identityLabel for credentials, or duplicateLabel for duplicated
+// objects. The seed is held by s, and the encrypted seed is returned as a
+// TPM2B_ENCRYPTED_SECRET.
+func createRSASeed20(rnd io.Reader, s *credentialScratch, label []byte, ek *rsa.PublicKey, symKeySize int) (seed, encSeed []byte, err error) {
+	// The seed length matches the key size of the EK's symmetric cipher.
+	// See section 2.1.5.1 of the TCG EK Credential Profile, revision 14.
+	if symKeySize > len(s.seed) {
+		return nil, nil, fmt.Errorf("unsupported symmetric key size %d", symKeySize)
+	}
+	seed = s.seed[:symKeySize]
+	if _, err := io.ReadFull(rnd, seed); err != nil {
+		return nil, nil, fmt.Errorf("generating seed: %v", err)
+	}
+	// EncryptOAEP resets the hash when it is done, so it can be reused.
+	encrypted, err := rsa.EncryptOAEP(s.h, rnd, ek, seed, label)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encrypting seed: %v", err)
+	}
+	encSeed = make([]byte, 0, 2+len(encrypted))
+	encSeed = binary.BigEndian.AppendUint16(encSeed, uint16(len(encrypted)))
+	return seed, append(encSeed, encrypted...), nil
+}
+
+// createECCSeed20 derives a seed from an ephemeral ECDH exchange with an
+// ECC EK, as described in annex C, section 6.1 of the TPM 2.0
+// specification, part 1. label is used as for createRSASeed20. ekX is the
+// X coordinate of the EK, or nil to compute it. The seed is held by s,
+// and the ephemeral public key is returned as a TPM2B_ENCRYPTED_SECRET.
+func createECCSeed20(rnd io.Reader, s *credentialScratch, label []byte, ek *ecdh.PublicKey, ekX []byte) (seed, encSeed []byte, err error) {
+	priv, err := generateECDHKey(rnd, ek.Curve())
+	if err != nil {
+		return nil, nil, err
+	}
+	z, err := priv.ECDH(ek)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ephX, ephY, err := ecdhCoordinates(priv.PublicKey())
+	if err != nil {
+		return nil, nil, err
+	}
+	if ekX == nil {
+		if ekX, _, err = ecdhCoordinates(ek); err != nil {
+			return nil, nil, err
+		}
+	}
+	seed = s.kdfe(s.seed[:s.hash.Size()], z, label, ephX, ekX)
+
+	// The TPM2B_ENCRYPTED_SECRET holds a TPMS_ECC_POINT.
+	encSeed = make([]byte, 0, 2+2+len(ephX)+2+len(ephY))
+	encSeed = binary.BigEndian.AppendUint16(encSeed, uint16(cap(encSeed)-2))
+	encSeed = binary.BigEndian.AppendUint16(encSeed, uint16(len(ephX)))
+	encSeed = append(encSeed, ephX...)
+	encSeed = binary.BigEndian.AppendUint16(encSeed, uint16(len(ephY)))
+	return seed, append(encSeed, ephY...), nil
+}
+
+// credentialScratch holds the state of protecting a TPM 2.0 credential or
+// duplicate which can be reused by the next one: the hash states computing
+// the KDFs, HMACs and RSA-OAEP of the name algorithm of the protecting key,
+// and buffers for the seed and the keys derived from it. It is not safe
+// for concurrent use. Activators keep them in a pool, rather than
+// allocating them for every challenge.
+type credentialScratch struct {
+	nameAlg tpm2.Algorithm
+	hash    crypto.Hash
+	// h computes KDFe and RSA-OAEP, and inner and outer compute HMACs
+	// keyed by setKey.
+	h, inner, outer hash.Hash
+	ipad, opad      []byte
+	sum             []byte
+	counter, bits   [4]byte
+
+	seed      [sha512.Size]byte
+	symKey    [32]byte
+	macKey    [sha512.Size]byte
+	plaintext [2 + maxActivationSecretLen]byte
+	iv        [aes.BlockSize]byte
+}
+
+// newCredentialScratch returns a credentialScratch for keys whose name
+// algorithm is nameAlg.
+func newCredentialScratch(nameAlg tpm2.Algorithm) (*credentialScratch, error) {
+	h, err := tpmHash(nameAlg)
+	if err != nil {
+		return nil, err
+	}
+	s := &credentialScratch{nameAlg: nameAlg, hash: h, h: h.New(), inner: h.New(), outer: h.New()}
+	s.ipad = make([]byte, s.inner.BlockSize())
+	s.opad = make([]byte, s.outer.BlockSize())
+	s.sum = make([]byte, 0, h.Size())
+	return s, nil
+}
+
+// setKey sets the key of the HMACs computed by mac and kdfa.
+func (s *credentialScratch) setKey(key []byte) {
+	if len(key) > len(s.ipad) {
+		s.h.Reset()
+		s.h.Write(key)
+		key = s.h.Sum(s.sum[:0])
+	}
+	clear(s.ipad)
+	copy(s.ipad, key)
+	copy(s.opad, s.ipad)
+	for i := range s.ipad {
+		s.ipad[i] ^= 0x36
+		s.opad[i] ^= 0x5c
+	}
+}
+
+// mac returns the HMAC of the concatenation of data. The result is only
+// valid until s is used again.
+func (s *credentialScratch) mac(data ...[]byte) []byte {
+	s.inner.Reset()
+	s.inner.Write(s.ipad)
+	for _, d := range data {
+		s.inner.Write(d)
+	}
+	s.sum = s.inner.Sum(s.sum[:0])
+	s.outer.Reset()
+	s.outer.Write(s.opad)
+	s.outer.Write(s.sum)
+	s.sum = s.outer.Sum(s.sum[:0])
+	return s.sum
+}
+
+// kdfa fills out with the output of KDFa, as defined in section 11.4.9.2 of
+// the TPM 2.0 specification, part 1, keyed by setKey. label includes its
+// terminating zero byte. out is returned.
+func (s *credentialScratch) kdfa(out, label, contextU, contextV []byte) []byte {
+	binary.BigEndian.PutUint32(s.bits[:], uint32(len(out)*8))
+	for i, n := 1, 0; n < len(out); i++ {
+		binary.BigEndian.PutUint32(s.counter[:], uint32(i))
+		n += copy(out[n:], s.mac(s.counter[:], label, contextU, contextV, s.bits[:]))
+	}
+	return out
+}
+
+// kdfe fills out with the output of KDFe, as defined in section 11.4.9.3 of
+// the TPM 2.0 specification, part 1, from the shared secret z. label
+// includes its terminating zero byte. out is returned.
+func (s *credentialScratch) kdfe(out, z, label, partyU, partyV []byte) []byte {
+	for i, n := 1, 0; n < len(out); i++ {
+		binary.BigEndian.PutUint32(s.counter[:], uint32(i))
+		s.h.Reset()
+		s.h.Write(s.counter[:])
+		s.h.Write(z)
+		s.h.Write(label)
+		s.h.Write(partyU)
+		s.h.Write(partyV)
+		s.sum = s.h.Sum(s.sum[:0])
+		n += copy(out[n:], s.sum)
+	}
+	return out
+}
+
+// clear erases the seed and keys held by s.
+func (s *credentialScratch) clear() {
+	s.h.Reset()
+	s.inner.Reset()
+	s.outer.Reset()
+	clear(s.ipad)
+	clear(s.opad)
+	clear(s.sum[:cap(s.sum)])
+	clear(s.seed[:])
+	clear(s.symKey[:])
+	clear(s.macKey[:])
+	clear(s.plaintext[:])
+}
+
+// generateECDHKey generates an ephemeral key on curve. Unlike
+// ecdh.Curve.GenerateKey, which may read an extra byte from rnd at random,
+// the key only depends on the bytes read from rnd, so a deterministic rnd
+// produces the same key.
+func generateECDHKey(rnd io.Reader, curve ecdh.Curve) (*ecdh.PrivateKey, error) {
+	var (
+		size int
+		mask byte = 0xff
+	)
+	switch curve {
+	case ecdh.P256():
+		size = 32
+	case ecdh.P384():
+		size = 48
+	case ecdh.P521():
+		// The order of P-521 has 521 bits, leaving 7 unused bits.
+		size, mask = 66, 0x01
+	default:
+		return nil, fmt.Errorf("unsupported curve %v", curve)
+	}
+	// Draw scalars until one is within the order of the curve, which is
+	// very likely to succeed on the first attempt.
+	b := make([]byte, size)
+	for i := 0; i < 100; i++ {
+		if _, err := io.ReadFull(rnd, b); err != nil {
+			return nil, err
+		}
+		b[0] &= mask
+		if priv, err := curve.NewPrivateKey(b); err == nil {
+			return priv, nil
+		}
+	}
+	return nil, errors.New("failed to generate an ephemeral key")
+}
+
+// ecdhCoordinates returns the X and Y coordinates of an uncompressed
+// NIST curve point.
+func ecdhCoordinates(pub *ecdh.PublicKey) (x, y []byte, err error) {
+	b := pub.Bytes()
+	if len(b) == 0 || b[0] != 4 {
+		return nil, nil, errors.New("public key is not an uncompressed curve point")
+	}
+	b = b[1:]
+	return b[:len(b)/2], b[len(b)/2:], nil
+}
@@ -2,10 +2,17 @@ package attest
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/x509"
 	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tspi/verification"
 )
 
 func TestMakeActivationBlob(t *testing.T) {
@@ -64,3 +71,105 @@ func TestGenerateChallengeSymHeader(t *testing.T) {
 		t.Errorf("symmetric mode = %v, want %v", got, want)
 	}
 }
+
+func TestParseSymCredential12(t *testing.T) {
+	ek, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("secretz")
+
+	for _, test := range []struct {
+		name     string
+		generate func() (asym, sym []byte, err error)
+		// keyOffset is the offset of the AES key within the decrypted
+		// asymmetric blob.
+		keyOffset int
+		tcsd      bool
+	}{
+		{
+			name: "raw",
+			generate: func() ([]byte, []byte, error) {
+				return generateChallenge12(rand.Reader, &ek.PublicKey, []byte("pubkey yo"), secret)
+			},
+			keyOffset: 18,
+		},
+		{
+			name: "tcsd",
+			generate: func() ([]byte, []byte, error) {
+				return verification.GenerateChallengeEx(&ek.PublicKey, []byte("pubkey yo"), secret)
+			},
+			keyOffset: 8,
+			tcsd:      true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			asym, sym, err := test.generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			iv, cipherText, tcsd, err := parseSymCredential12(sym)
+			if err != nil {
+				t.Fatalf("parseSymCredential12() failed: %v", err)
+			}
+			if tcsd != test.tcsd {
+				t.Errorf("parseSymCredential12() tcsd = %v, want %v", tcsd, test.tcsd)
+			}
+
+			plain, err := rsa.DecryptOAEP(sha1.New(), nil, ek, asym, []byte("TCPA"))
+			if err != nil {
+				t.Fatalf("DecryptOAEP() failed: %v", err)
+			}
+			block, err := aes.NewCipher(plain[test.keyOffset : test.keyOffset+16])
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, len(cipherText))
+			cipher.NewCBCDecrypter(block, iv).CryptBlocks(got, cipherText)
+			if want := pad(secret, aes.BlockSize); !bytes.Equal(got, want) {
+				t.Errorf("decrypted credential = %x, want %x", got, want)
+			}
+
+			if _, _, _, err := parseSymCredential12(sym[:len(sym)-1]); err == nil {
+				t.Error("parseSymCredential12() with truncated credential succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestCredentialScratch(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x5a}, 32)
+	long := bytes.Repeat([]byte{0xa5}, 200)
+	for _, alg := range []tpm2.Algorithm{tpm2.AlgSHA1, tpm2.AlgSHA256, tpm2.AlgSHA384, tpm2.AlgSHA512} {
+		s, err := newCredentialScratch(alg)
+		if err != nil {
+			t.Fatalf("newCredentialScratch(0x%x) failed: %v", alg, err)
+		}
+		// Keys are reused, and later ones must not depend on earlier ones.
+		for _, key := range [][]byte{seed, long, seed} {
+			s.setKey(key)
+			for _, size := range []int{16, 32, 48} {
+				want, err := tpm2.KDFa(alg, key, labelStorage, []byte("name"), nil, size*8)
+				if err != nil {
+					t.Fatalf("KDFa() failed: %v", err)
+				}
+				if got := s.kdfa(make([]byte, size), storageLabel, []byte("name"), nil); !bytes.Equal(got, want) {
+					t.Errorf("kdfa(0x%x, %d bytes) = %x, want %x", alg, size, got, want)
+				}
+			}
+			mac := hmac.New(s.hash.New, key)
+			mac.Write([]byte("data"))
+			if got, want := s.mac([]byte("da"), []byte("ta")), mac.Sum(nil); !bytes.Equal(got, want) {
+				t.Errorf("mac(0x%x) = %x, want %x", alg, got, want)
+			}
+		}
+
+		want, err := tpm2.KDFe(alg, seed, labelIdentity, []byte("u"), []byte("v"), 72*8)
+		if err != nil {
+			t.Fatalf("KDFe() failed: %v", err)
+		}
+		if got := s.kdfe(make([]byte, 72), seed, identityLabel, []byte("u"), []byte("v")); !bytes.Equal(got, want) {
+			t.Errorf("kdfe(0x%x) = %x, want %x", alg, got, want)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package attest
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
@@ -29,6 +30,7 @@ import (
 
 	// Ensure hashes are available.
 	_ "crypto/sha256"
+	_ "crypto/sha512"
 
 	"github.com/google/go-tpm/legacy/tpm2"
 	"github.com/google/go-tpm/tpmutil"
@@ -253,6 +255,92 @@ func (e *EventLog) verify(pcrs []PCR) ([]Event, error) {
 	return events, nil
 }
 
+// ReplayEventLog parses a measurement log and replays its events using the
+// digests of the given algorithm, returning the resulting PCR values keyed
+// by PCR index. PCRs with no events in the log are omitted.
+//
+// An error is returned if any event which extends a PCR does not have a
+// digest for alg. The replayed values carry no security guarantees until
+// they're compared against PCR values attested to by a TPM, for example
+// with VerifyAgainstQuote.
+func ReplayEventLog(log []byte, alg HashAlg) (map[int][]byte, error) {
+	el, err := ParseEventLog(log)
+	if err != nil {
+		return nil, fmt.Errorf("parsing event log: %v", err)
+	}
+	return replayDigests(el.rawEvents, alg)
+}
+
+func replayDigests(rawEvents []rawEvent, alg HashAlg) (map[int][]byte, error) {
+	h := alg.cryptoHash()
+	if h == 0 {
+		return nil, fmt.Errorf("unsupported hash algorithm: %v", alg)
+	}
+	if err := checkHashAvailable(h); err != nil {
+		return nil, err
+	}
+
+	var (
+		replayed = map[int][]byte{}
+		locality byte
+	)
+	for _, e := range rawEvents {
+		// See replayPCR for the handling of StartupLocality events.
+		if e.typ == eventTypeNoAction {
+			if e.index == 0 && len(e.data) == 17 && strings.HasPrefix(string(e.data), "StartupLocality") {
+				locality = e.data[len(e.data)-1]
+			}
+			continue
+		}
+		var l byte
+		if e.index == 0 {
+			l = locality
+		}
+		pcr := PCR{Index: e.index, DigestAlg: h, Digest: make([]byte, h.Size())}
+		v, _, err := extend(pcr, replayed[e.index], e, l)
+		if err != nil {
+			return nil, fmt.Errorf("replaying event %d (PCR %d): %v", e.sequence, e.index, err)
+		}
+		replayed[e.index] = v
+	}
+	return replayed, nil
+}
+
+// VerifyAgainstQuote checks PCR values returned by ReplayEventLog against
+// the PCR values covered by a quote. Every replayed PCR must have a value
+// with the same digest algorithm in pcrs, that value must have been verified
+// by AKPublic.Verify or AKPublic.VerifyAll, and the two values must match.
+func VerifyAgainstQuote(replayed map[int][]byte, alg HashAlg, pcrs []PCR) error {
+	h := alg.cryptoHash()
+	if h == 0 {
+		return fmt.Errorf("unsupported hash algorithm: %v", alg)
+	}
+
+	indices := make([]int, 0, len(replayed))
+	for i := range replayed {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+
+indexLoop:
+	for _, i := range indices {
+		for _, pcr := range pcrs {
+			if pcr.Index != i || pcr.DigestAlg != h {
+				continue
+			}
+			if !pcr.QuoteVerified() {
+				return fmt.Errorf("PCR %d (%v) was not verified against a quote", i, alg)
+			}
+			if !bytes.Equal(pcr.Digest, replayed[i]) {
+				return fmt.Errorf("PCR %d (%v) mismatch: event log replays to %x, quoted value is %x", i, alg, replayed[i], pcr.Digest)
+			}
+			continue indexLoop
+		}
+		return fmt.Errorf("PCR %d (%v) is not present in the quoted PCRs", i, alg)
+	}
+	return nil
+}
+
 type rawAttestationData struct {
 	Version [4]byte  // This MUST be 1.1.0.0
 	Fixed   [4]byte  // This SHALL always be the string ‘QUOT’
@@ -328,6 +416,9 @@ func (a *AKPublic) validate20Quote(quote Quote, pcrs []PCR, nonce []byte) error
 		return fmt.Errorf("parse quote signature: %v", err)
 	}
 
+	if err := checkHashAvailable(a.Hash); err != nil {
+		return err
+	}
 	sigHash := a.Hash.New()
 	sigHash.Write(quote.Quote)
 
@@ -340,8 +431,14 @@ func (a *AKPublic) validate20Quote(quote Quote, pcrs []PCR, nonce []byte) error
 		if err := rsa.VerifyPKCS1v15(pub, a.Hash, sigHash.Sum(nil), sigBytes); err != nil {
 			return fmt.Errorf("invalid quote signature: %v", err)
 		}
+	case *ecdsa.PublicKey:
+		if sig.ECC == nil {
+			return fmt.Errorf("ecdsa public key provided for rsa signature")
+		}
+		if !ecdsa.Verify(pub, sigHash.Sum(nil), sig.ECC.R, sig.ECC.S) {
+			return fmt.Errorf("invalid quote signature")
+		}
 	default:
-		// TODO(ericchiang): support ecdsa
 		return fmt.Errorf("unsupported public key type %T", pub)
 	}
 
@@ -397,6 +494,9 @@ func (a *AKPublic) validate20Quote(quote Quote, pcrs []PCR, nonce []byte) error
 
 func extend(pcr PCR, replay []byte, e rawEvent, locality byte) (pcrDigest []byte, eventDigest []byte, err error) {
 	h := pcr.DigestAlg
+	if err := checkHashAvailable(h); err != nil {
+		return nil, nil, err
+	}
 
 	for _, digest := range e.digests {
 		if digest.hash != pcr.DigestAlg {
@@ -473,6 +573,9 @@ func replayEvents(rawEvents []rawEvent, pcrs []PCR) ([]Event, error) {
 
 	// Replay the event log for every PCR and digest algorithm combination.
 	for _, pcr := range pcrs {
+		if err := checkHashAvailable(pcr.DigestAlg); err != nil {
+			return nil, fmt.Errorf("pcr %d: %v", pcr.Index, err)
+		}
 		events, ok := replayPCR(rawEvents, pcr)
 		allPCRReplays[pcr.Index] = append(allPCRReplays[pcr.Index], pcrReplayResult{events, ok})
 	}
@@ -538,10 +641,14 @@ func ParseEventLog(measurementLog []byte) (*EventLog, error) {
 				el.Algs = append(el.Algs, HashSHA1)
 			case tpm2.AlgSHA256:
 				el.Algs = append(el.Algs, HashSHA256)
+			case tpm2.AlgSHA384:
+				el.Algs = append(el.Algs, HashSHA384)
+			case tpm2.AlgSHA512:
+				el.Algs = append(el.Algs, HashSHA512)
 			}
 		}
 		if len(el.Algs) == 0 {
-			return nil, fmt.Errorf("measurement log didn't use sha1 or sha256 digests")
+			return nil, fmt.Errorf("measurement log didn't use sha1, sha256, sha384 or sha512 digests")
 		}
 		// Switch to parsing crypto agile events. Don't include this in the
 		// replayed events since it intentionally doesn't extend the PCRs.
@@ -810,6 +917,10 @@ func AppendEvents(base []byte, additional ...[]byte) ([]byte, error) {
 			for _, d := range e.digests {
 				var algID uint16
 				switch d.hash {
+				case crypto.SHA512:
+					algID = uint16(HashSHA512)
+				case crypto.SHA384:
+					algID = uint16(HashSHA384)
 				case crypto.SHA256:
 					algID = uint16(HashSHA256)
 				case crypto.SHA1:
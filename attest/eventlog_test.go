@@ -16,9 +16,11 @@ package attest
 
 import (
 	"bytes"
+	"crypto"
 	"encoding/base64"
 	"encoding/json"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/google/go-tpm/legacy/tpm2"
@@ -80,6 +82,7 @@ func TestParseCryptoAgileEventLog(t *testing.T) {
 }
 
 func TestEventLogLinux(t *testing.T) {
+	skipWithoutTPM12(t)
 	testEventLog(t, "testdata/linux_tpm12.json")
 }
 
@@ -125,6 +128,118 @@ func testEventLog(t *testing.T, testdata string) {
 	}
 }
 
+func TestReplayEventLogAgainstQuote(t *testing.T) {
+	data, err := os.ReadFile("testdata/windows_gcp_shielded_vm.json")
+	if err != nil {
+		t.Fatalf("reading test data: %v", err)
+	}
+	var dump Dump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		t.Fatalf("parsing test data: %v", err)
+	}
+
+	replayed, err := ReplayEventLog(dump.Log.Raw, HashSHA1)
+	if err != nil {
+		t.Fatalf("ReplayEventLog() failed: %v", err)
+	}
+	if len(replayed) == 0 {
+		t.Fatal("ReplayEventLog() returned no PCRs")
+	}
+	if err := VerifyAgainstQuote(replayed, HashSHA1, dump.Log.PCRs); err == nil {
+		t.Error("VerifyAgainstQuote() succeeded with PCRs not verified against a quote")
+	}
+
+	ak, err := ParseAKPublic(dump.Static.TPMVersion, dump.AK.Public)
+	if err != nil {
+		t.Fatalf("parsing AK: %v", err)
+	}
+	if err := ak.Verify(Quote{
+		Version:   dump.Static.TPMVersion,
+		Quote:     dump.Quote.Quote,
+		Signature: dump.Quote.Signature,
+	}, dump.Log.PCRs, dump.Quote.Nonce); err != nil {
+		t.Fatalf("verifying quote: %v", err)
+	}
+	if err := VerifyAgainstQuote(replayed, HashSHA1, dump.Log.PCRs); err != nil {
+		t.Errorf("VerifyAgainstQuote() failed: %v", err)
+	}
+	if err := VerifyAgainstQuote(replayed, HashSHA256, dump.Log.PCRs); err == nil {
+		t.Error("VerifyAgainstQuote() succeeded without any SHA-256 PCRs")
+	}
+
+	for i, v := range replayed {
+		tampered := map[int][]byte{i: append([]byte{}, v...)}
+		tampered[i][0] ^= 0xff
+		if err := VerifyAgainstQuote(tampered, HashSHA1, dump.Log.PCRs); err == nil {
+			t.Errorf("VerifyAgainstQuote() succeeded with tampered PCR %d", i)
+		}
+		break
+	}
+}
+
+func TestReplayEventLog(t *testing.T) {
+	data, err := os.ReadFile("testdata/ubuntu_2104_shielded_vm_no_secure_boot_eventlog")
+	if err != nil {
+		t.Fatalf("reading test data: %v", err)
+	}
+	el, err := ParseEventLog(data)
+	if err != nil {
+		t.Fatalf("parsing event log: %v", err)
+	}
+
+	for _, alg := range []HashAlg{HashSHA1, HashSHA256} {
+		t.Run(alg.String(), func(t *testing.T) {
+			replayed, err := ReplayEventLog(data, alg)
+			if err != nil {
+				t.Fatalf("ReplayEventLog() failed: %v", err)
+			}
+
+			var pcrs []PCR
+			for i, digest := range replayed {
+				if got, want := len(digest), alg.cryptoHash().Size(); got != want {
+					t.Errorf("PCR %d digest length = %d, want %d", i, got, want)
+				}
+				pcrs = append(pcrs, PCR{Index: i, Digest: digest, DigestAlg: alg.cryptoHash()})
+			}
+			// The replayed values must be accepted by the event log verifier.
+			if _, err := el.Verify(pcrs); err != nil {
+				t.Errorf("Verify() with replayed PCRs failed: %v", err)
+			}
+		})
+	}
+
+	if _, err := ReplayEventLog(data, HashAlg(0)); err == nil {
+		t.Error("ReplayEventLog() succeeded with an unknown hash algorithm")
+	}
+}
+
+func TestReplayEventLogHashUnavailable(t *testing.T) {
+	data, err := os.ReadFile("testdata/ubuntu_2104_shielded_vm_no_secure_boot_eventlog")
+	if err != nil {
+		t.Fatalf("reading test data: %v", err)
+	}
+	el, err := ParseEventLog(data)
+	if err != nil {
+		t.Fatalf("parsing event log: %v", err)
+	}
+	replayed, err := ReplayEventLog(data, HashSHA1)
+	if err != nil {
+		t.Fatalf("ReplayEventLog() failed: %v", err)
+	}
+	var pcrs []PCR
+	for i, digest := range replayed {
+		pcrs = append(pcrs, PCR{Index: i, Digest: digest, DigestAlg: crypto.SHA1})
+	}
+
+	stubHashUnavailable(t, crypto.SHA1)
+	if _, err := ReplayEventLog(data, HashSHA1); err == nil || !strings.Contains(err.Error(), "not available in this build") {
+		t.Errorf("ReplayEventLog() error = %v, want hash availability error", err)
+	}
+	if _, err := el.Verify(pcrs); err == nil || !strings.Contains(err.Error(), "not available in this build") {
+		t.Errorf("Verify() error = %v, want hash availability error", err)
+	}
+}
+
 func TestParseEventLogEventSizeTooLarge(t *testing.T) {
 	data := []byte{
 		// PCR index
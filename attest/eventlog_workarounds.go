@@ -47,6 +47,9 @@ func inject(e *EventLog, pcr int, data string) error {
 		sequence: e.rawEvents[len(e.rawEvents)-1].sequence + 1,
 	}
 	for _, alg := range e.Algs {
+		if err := checkHashAvailable(alg.cryptoHash()); err != nil {
+			return err
+		}
 		h := alg.cryptoHash().New()
 		h.Write([]byte(data))
 		evt.digests = append(evt.digests, digest{hash: alg.cryptoHash(), data: h.Sum(nil)})
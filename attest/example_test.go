@@ -140,6 +140,38 @@ func ExampleAK_credentialActivationWithEK() {
 	}
 }
 
+func ExampleActivationParameters_Enroll() {
+	tpm, err := attest.OpenTPM(nil)
+	if err != nil {
+		log.Fatalf("Failed to open TPM: %v", err)
+	}
+	defer tpm.Close()
+
+	ak, err := tpm.NewAK(nil)
+	if err != nil {
+		log.Fatalf("Failed to create AK: %v", err)
+	}
+	defer ak.Close(tpm)
+	ek, err := tpm.EKs()
+	if err != nil {
+		log.Fatalf("Failed to enumerate EKs: %v", err)
+	}
+
+	// Check the AK, challenge the device and compare the secret it returns
+	// (usually done on the server, which sends the challenge to the device).
+	activation := attest.ActivationParameters{
+		TPMVersion: tpm.Version(),
+		EK:         ek[0].Public,
+		AK:         ak.AttestationParameters(),
+	}
+	err = activation.Enroll(func(challenge attest.EncryptedCredential) ([]byte, error) {
+		return ak.ActivateCredential(tpm, challenge)
+	})
+	if err != nil {
+		log.Fatalf("Enrollment failed: %v", err)
+	}
+}
+
 func TestExampleAK(t *testing.T) {
 	if !*testExamples {
 		t.SkipNow()
@@ -147,6 +179,7 @@ func TestExampleAK(t *testing.T) {
 	ExampleAK()
 	ExampleAK_credentialActivation()
 	ExampleAK_credentialActivationWithEK()
+	ExampleActivationParameters_Enroll()
 }
 
 func TestExampleTPM(t *testing.T) {
@@ -0,0 +1,298 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package attest
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// imaEventNameLenMax is IMA_EVENT_NAME_LEN_MAX. The file name of events
+// using the original "ima" template is padded to one more than this many
+// bytes when computing their template digest.
+const imaEventNameLenMax = 255
+
+// imaSignatureType is EVM_IMA_XATTR_DIGSIG, the first byte of file
+// signatures recorded by the "ima-sig" template.
+const imaSignatureType = 0x03
+
+// IMAEvent is a measurement recorded by the Linux Integrity Measurement
+// Architecture (IMA), as listed in the ASCII measurement list exposed by
+// the kernel at /sys/kernel/security/ima/ascii_runtime_measurements.
+type IMAEvent struct {
+	// Line is the line of the measurement list the event was parsed
+	// from, starting at 1.
+	Line int
+	// PCR is the index of the PCR the event was extended into, usually 10.
+	PCR int
+	// TemplateDigest is the digest of the template data, which is what is
+	// extended into PCR. It is all zeros for violations.
+	TemplateDigest []byte
+	// Template is the name of the template describing the event: "ima",
+	// "ima-ng" or "ima-sig".
+	Template string
+	// FileDigestAlg names the algorithm of FileDigest as used by the
+	// kernel, for example "sha1" or "sha256".
+	FileDigestAlg string
+	// FileDigest is the digest of the measured file.
+	FileDigest []byte
+	// FileName is the path of the measured file.
+	FileName string
+	// Signature is the signature of the file recorded by the "ima-sig"
+	// template, if any.
+	Signature []byte
+
+	// templateData is the template data the template digest is computed
+	// over, used to replay PCR banks other than that of TemplateDigest.
+	templateData []byte
+}
+
+// Violation reports whether the event records a violation, such as a file
+// being measured while open for writing, rather than a measurement.
+func (e *IMAEvent) Violation() bool {
+	if len(e.TemplateDigest) == 0 {
+		return false
+	}
+	for _, b := range e.TemplateDigest {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// IMALineError is an error parsing a line of an IMA measurement list.
+type IMALineError struct {
+	// Line is the line which failed to parse, starting at 1.
+	Line int
+	Err  error
+}
+
+func (e *IMALineError) Error() string {
+	return fmt.Sprintf("IMA measurement list line %d: %v", e.Line, e.Err)
+}
+
+func (e *IMALineError) Unwrap() error {
+	return e.Err
+}
+
+// ParseIMAEventLog parses an IMA measurement list in the ASCII format, for
+// events using the "ima", "ima-ng" and "ima-sig" templates. The template
+// digest of each event is checked against its fields.
+//
+// Lines which fail to parse, including those of other templates, are
+// skipped rather than aborting the parse. The events of the other lines are
+// returned along with an error joining an *IMALineError for each of them.
+// PCRs extended by skipped events cannot be replayed.
+func ParseIMAEventLog(log []byte) ([]IMAEvent, error) {
+	var (
+		events []IMAEvent
+		errs   []error
+	)
+	for i, line := range strings.Split(string(log), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		e, err := parseIMAEvent(line)
+		if err != nil {
+			errs = append(errs, &IMALineError{Line: i + 1, Err: err})
+			continue
+		}
+		e.Line = i + 1
+		events = append(events, *e)
+	}
+	return events, errors.Join(errs...)
+}
+
+func parseIMAEvent(line string) (*IMAEvent, error) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("expected at least 4 fields, got %d", len(fields))
+	}
+	pcr, err := strconv.Atoi(fields[0])
+	if err != nil || pcr < 0 {
+		return nil, fmt.Errorf("invalid PCR index %q", fields[0])
+	}
+	templateDigest, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid template digest: %v", err)
+	}
+	e := &IMAEvent{
+		PCR:            pcr,
+		TemplateDigest: templateDigest,
+		Template:       fields[2],
+	}
+
+	// The file name may contain spaces, so only split off the digest.
+	digest, rest, ok := strings.Cut(fields[3], " ")
+	if !ok || rest == "" {
+		return nil, errors.New("missing file name")
+	}
+	switch e.Template {
+	case "ima":
+		e.FileDigestAlg = "sha1"
+	case "ima-ng", "ima-sig":
+		alg, d, ok := strings.Cut(digest, ":")
+		if !ok || alg == "" {
+			return nil, fmt.Errorf("file digest %q has no algorithm", digest)
+		}
+		e.FileDigestAlg, digest = alg, d
+	default:
+		return nil, fmt.Errorf("unsupported template %q", e.Template)
+	}
+	if e.FileDigest, err = hex.DecodeString(digest); err != nil {
+		return nil, fmt.Errorf("invalid file digest: %v", err)
+	}
+	e.FileName = rest
+	if e.Template == "ima-sig" {
+		if i := strings.LastIndexByte(rest, ' '); i >= 0 {
+			if sig, err := hex.DecodeString(rest[i+1:]); err == nil && len(sig) > 0 && sig[0] == imaSignatureType {
+				e.FileName, e.Signature = rest[:i], sig
+			}
+		}
+	}
+
+	if e.templateData, err = imaTemplateData(e); err != nil {
+		return nil, err
+	}
+	if e.Violation() {
+		return e, nil
+	}
+	h, ok := imaDigestHash(len(e.TemplateDigest))
+	if !ok {
+		return nil, fmt.Errorf("template digest has unexpected size %d", len(e.TemplateDigest))
+	}
+	if err := checkHashAvailable(h); err != nil {
+		return nil, err
+	}
+	hsh := h.New()
+	hsh.Write(e.templateData)
+	if !bytes.Equal(hsh.Sum(nil), e.TemplateDigest) {
+		return nil, errors.New("template digest does not match the event")
+	}
+	return e, nil
+}
+
+// imaTemplateData encodes the fields of e as the kernel does to compute its
+// template digest.
+func imaTemplateData(e *IMAEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	if e.Template == "ima" {
+		// The original template hashes the fields without their sizes,
+		// and pads the file name.
+		if len(e.FileDigest) != crypto.SHA1.Size() {
+			return nil, fmt.Errorf("file digest has size %d, want %d", len(e.FileDigest), crypto.SHA1.Size())
+		}
+		if len(e.FileName) > imaEventNameLenMax {
+			return nil, fmt.Errorf("file name longer than %d bytes", imaEventNameLenMax)
+		}
+		buf.Write(e.FileDigest)
+		buf.WriteString(e.FileName)
+		buf.Write(make([]byte, imaEventNameLenMax+1-len(e.FileName)))
+		return buf.Bytes(), nil
+	}
+
+	field := func(b []byte) {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(b)))
+		buf.Write(b)
+	}
+	field(append([]byte(e.FileDigestAlg+":\x00"), e.FileDigest...))
+	field(append([]byte(e.FileName), 0))
+	if e.Template == "ima-sig" {
+		field(e.Signature)
+	}
+	return buf.Bytes(), nil
+}
+
+// imaDigestHash returns the hash algorithm of a template digest of the given
+// size, as listed in the measurement list of the corresponding PCR bank.
+func imaDigestHash(size int) (crypto.Hash, bool) {
+	for _, h := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		if h.Size() == size {
+			return h, true
+		}
+	}
+	return 0, false
+}
+
+// ReplayIMAEventLog replays IMA events, as returned by ParseIMAEventLog, into
+// the PCR bank of the given algorithm, returning the resulting PCR values
+// keyed by PCR index. The template digests are recomputed for alg, so a
+// measurement list listing the digests of any bank can be replayed against
+// all of them. Violations extend a digest of all ones, as the kernel does.
+//
+// As with ReplayEventLog, the replayed values carry no security guarantees
+// until they're compared against PCR values attested to by a TPM, for
+// example with VerifyAgainstQuote.
+func ReplayIMAEventLog(events []IMAEvent, alg HashAlg) (map[int][]byte, error) {
+	h := alg.cryptoHash()
+	if h == 0 {
+		return nil, fmt.Errorf("unsupported hash algorithm: %v", alg)
+	}
+	if err := checkHashAvailable(h); err != nil {
+		return nil, err
+	}
+
+	replayed := map[int][]byte{}
+	for _, e := range events {
+		var digest []byte
+		switch {
+		case e.Violation():
+			digest = bytes.Repeat([]byte{0xff}, h.Size())
+		case e.templateData != nil:
+			hsh := h.New()
+			hsh.Write(e.templateData)
+			digest = hsh.Sum(nil)
+		case len(e.TemplateDigest) == h.Size():
+			digest = e.TemplateDigest
+		default:
+			return nil, fmt.Errorf("replaying IMA event on line %d: no %v template digest", e.Line, alg)
+		}
+
+		v, ok := replayed[e.PCR]
+		if !ok {
+			v = make([]byte, h.Size())
+		}
+		hsh := h.New()
+		hsh.Write(v)
+		hsh.Write(digest)
+		replayed[e.PCR] = hsh.Sum(nil)
+	}
+	return replayed, nil
+}
+
+// UnknownIMAMeasurements returns the events whose file digest is not one of
+// known, typically the digests of the files in a list of known-good
+// software. Digests are compared regardless of their algorithm. Violations
+// are always returned, as the content of the file they refer to is unknown.
+func UnknownIMAMeasurements(events []IMAEvent, known [][]byte) []IMAEvent {
+	knownSet := make(map[string]bool, len(known))
+	for _, d := range known {
+		knownSet[string(d)] = true
+	}
+	var unknown []IMAEvent
+	for _, e := range events {
+		if e.Violation() || !knownSet[string(e.FileDigest)] {
+			unknown = append(unknown, e)
+		}
+	}
+	return unknown
+}
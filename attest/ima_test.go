@@ -0,0 +1,149 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package attest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testIMALog is an IMA measurement list whose template digests were computed
+// independently of this package.
+var testIMALog = strings.Join([]string{
+	"10 eae0ec9c8156e3b7b967d2d6e0c7f862ecc5bcb8 ima 79ceb1d9bd3aa85195d2a9e08fe16545af1c8cfb /init",
+	"10 053bd1105630dcc267fc41941ed91c12dda57883 ima-ng sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824 /usr/bin/hello world",
+	"10 1604854ac4e5393703b4091ff33b0ecb6f06dfa4 ima-sig sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824 /bin/sh 030204aabbccdd0002",
+	"10 0000000000000000000000000000000000000000 ima-ng sha256:0000000000000000000000000000000000000000000000000000000000000000 /var/log/open-for-write",
+	"",
+}, "\n")
+
+func TestParseIMAEventLog(t *testing.T) {
+	events, err := ParseIMAEventLog([]byte(testIMALog))
+	if err != nil {
+		t.Fatalf("ParseIMAEventLog() failed: %v", err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("ParseIMAEventLog() returned %d events, want 4", len(events))
+	}
+	for i, want := range []struct {
+		template, alg, name string
+		violation           bool
+	}{
+		{"ima", "sha1", "/init", false},
+		{"ima-ng", "sha256", "/usr/bin/hello world", false},
+		{"ima-sig", "sha256", "/bin/sh", false},
+		{"ima-ng", "sha256", "/var/log/open-for-write", true},
+	} {
+		e := events[i]
+		if e.Line != i+1 || e.PCR != 10 || e.Template != want.template || e.FileDigestAlg != want.alg || e.FileName != want.name || e.Violation() != want.violation {
+			t.Errorf("event %d = %+v, want template %q, alg %q, name %q, violation %v", i, e, want.template, want.alg, want.name, want.violation)
+		}
+	}
+	if want, _ := hex.DecodeString("030204aabbccdd0002"); !bytes.Equal(events[2].Signature, want) {
+		t.Errorf("ima-sig signature = %x, want %x", events[2].Signature, want)
+	}
+}
+
+func TestParseIMAEventLogErrors(t *testing.T) {
+	lines := strings.Split(testIMALog, "\n")
+	log := strings.Join([]string{
+		lines[0],
+		"10 zz ima-ng sha256:00 /bad/template/digest",
+		lines[1],
+		// The template digest doesn't match the modified file name.
+		strings.Replace(lines[2], "/bin/sh", "/bin/bash", 1),
+		"10 0000000000000000000000000000000000000000 ima-buf sha256:00 kexec-cmdline 00",
+		"10 0000000000000000000000000000000000000000 ima-ng",
+	}, "\n")
+
+	events, err := ParseIMAEventLog([]byte(log))
+	if len(events) != 2 || events[0].Line != 1 || events[1].Line != 3 {
+		t.Errorf("ParseIMAEventLog() returned events %+v, want those of lines 1 and 3", events)
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("ParseIMAEventLog() err = %v, want joined errors", err)
+	}
+	var gotLines []int
+	for _, err := range joined.Unwrap() {
+		var lineErr *IMALineError
+		if !errors.As(err, &lineErr) {
+			t.Fatalf("error %v is not an *IMALineError", err)
+		}
+		gotLines = append(gotLines, lineErr.Line)
+	}
+	if want := []int{2, 4, 5, 6}; !reflect.DeepEqual(gotLines, want) {
+		t.Errorf("errors reported for lines %v, want %v", gotLines, want)
+	}
+}
+
+func TestReplayIMAEventLog(t *testing.T) {
+	events, err := ParseIMAEventLog([]byte(testIMALog))
+	if err != nil {
+		t.Fatalf("ParseIMAEventLog() failed: %v", err)
+	}
+
+	// SHA-1 replays the listed template digests, and all ones for the
+	// violation.
+	want := make([]byte, sha1.Size)
+	for i, e := range events {
+		d := e.TemplateDigest
+		if i == 3 {
+			d = bytes.Repeat([]byte{0xff}, sha1.Size)
+		}
+		sum := sha1.Sum(append(want, d...))
+		want = sum[:]
+	}
+	got, err := ReplayIMAEventLog(events, HashSHA1)
+	if err != nil {
+		t.Fatalf("ReplayIMAEventLog() failed: %v", err)
+	}
+	if len(got) != 1 || !bytes.Equal(got[10], want) {
+		t.Errorf("ReplayIMAEventLog(SHA1) = %x, want PCR 10 = %x", got, want)
+	}
+	pcrs := []PCR{{Index: 10, Digest: want, DigestAlg: HashSHA1.cryptoHash(), quoteVerified: true}}
+	if err := VerifyAgainstQuote(got, HashSHA1, pcrs); err != nil {
+		t.Errorf("VerifyAgainstQuote() failed: %v", err)
+	}
+
+	// Other banks recompute the template digests.
+	ng256, _ := hex.DecodeString("2ae99a45b164faf763a753f2497914dbd3fec4b3950c9dace13c256e62f07784")
+	wantSHA256 := sha256.Sum256(append(make([]byte, sha256.Size), ng256...))
+	got, err = ReplayIMAEventLog(events[1:2], HashSHA256)
+	if err != nil {
+		t.Fatalf("ReplayIMAEventLog() failed: %v", err)
+	}
+	if !bytes.Equal(got[10], wantSHA256[:]) {
+		t.Errorf("ReplayIMAEventLog(SHA256) = %x, want PCR 10 = %x", got[10], wantSHA256)
+	}
+}
+
+func TestUnknownIMAMeasurements(t *testing.T) {
+	events, err := ParseIMAEventLog([]byte(testIMALog))
+	if err != nil {
+		t.Fatalf("ParseIMAEventLog() failed: %v", err)
+	}
+	hello := sha256.Sum256([]byte("hello"))
+	unknown := UnknownIMAMeasurements(events, [][]byte{hello[:]})
+	if len(unknown) != 2 || unknown[0].FileName != "/init" || !unknown[1].Violation() {
+		t.Errorf("UnknownIMAMeasurements() = %+v, want /init and the violation", unknown)
+	}
+}
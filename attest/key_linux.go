@@ -12,8 +12,8 @@
 // License for the specific language governing permissions and limitations under
 // the License.
 
-//go:build linux && !gofuzz && cgo && tspi
-// +build linux,!gofuzz,cgo,tspi
+//go:build linux && !gofuzz && cgo && tspi && !notpm12
+// +build linux,!gofuzz,cgo,tspi,!notpm12
 
 package attest
 
@@ -57,6 +57,11 @@ func (k *trousersKey12) activateCredential(tb tpmBase, in EncryptedCredential, e
 	if !ok {
 		return nil, fmt.Errorf("expected *linuxTPM, got %T", tb)
 	}
+	// tcsd only understands its own format, so report a challenge generated
+	// without UseTCSDActivationFormat rather than failing obscurely.
+	if _, _, tcsd, err := parseSymCredential12(in.Secret); err == nil && !tcsd {
+		return nil, fmt.Errorf("challenge was generated without UseTCSDActivationFormat, which tcsd requires")
+	}
 
 	cred, err := attestation.AIKChallengeResponse(t.ctx, k.blob, in.Credential, in.Secret)
 	if err != nil {
@@ -89,6 +94,10 @@ func (k *trousersKey12) quote(tb tpmBase, nonce []byte, alg HashAlg, selectedPCR
 	}, nil
 }
 
+func (k *trousersKey12) tpmVersion() TPMVersion {
+	return TPMVersion12
+}
+
 func (k *trousersKey12) attestationParameters() AttestationParameters {
 	return AttestationParameters{
 		Public:                  k.public,
@@ -18,10 +18,13 @@
 package attest
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"fmt"
 
 	"github.com/google/go-tpm/legacy/tpm2"
-	tpm1 "github.com/google/go-tpm/tpm"
+	"github.com/google/go-tpm/tpmutil"
 )
 
 // windowsKey12 represents a Windows-managed key on a TPM1.2 TPM.
@@ -80,16 +83,9 @@ func (k *windowsKey12) quote(tb tpmBase, nonce []byte, alg HashAlg, selectedPCRs
 		return nil, fmt.Errorf("TPMCommandInterface() failed: %v", err)
 	}
 
-	sig, pcrc, err := tpm1.Quote(tpm, tpmKeyHnd, nonce, selectedPCRs[:], wellKnownAuth[:])
+	quote, sig, err := quote12(tpm, tpmKeyHnd, nonce, selectedPCRs)
 	if err != nil {
-		return nil, fmt.Errorf("Quote() failed: %v", err)
-	}
-	// Construct and return TPM_QUOTE_INFO
-	// Returning TPM_QUOTE_INFO allows us to verify the Quote at a higher resolution
-	// and matches what go-tspi returns.
-	quote, err := tpm1.NewQuoteInfo(nonce, selectedPCRs[:], pcrc)
-	if err != nil {
-		return nil, fmt.Errorf("failed to construct Quote Info: %v", err)
+		return nil, err
 	}
 	return &Quote{
 		Version:   TPMVersion12,
@@ -102,6 +98,10 @@ func (k *windowsKey12) close(tpm tpmBase) error {
 	return closeNCryptObject(k.hnd)
 }
 
+func (k *windowsKey12) tpmVersion() TPMVersion {
+	return TPMVersion12
+}
+
 func (k *windowsKey12) attestationParameters() AttestationParameters {
 	return AttestationParameters{
 		Public: k.public,
@@ -169,13 +169,17 @@ func (k *windowsKey20) quote(tb tpmBase, nonce []byte, alg HashAlg, selectedPCRs
 	if err != nil {
 		return nil, fmt.Errorf("TPMCommandInterface() failed: %v", err)
 	}
-	return quote20(tpm, tpmKeyHnd, alg.goTPMAlg(), nonce, selectedPCRs)
+	return quote20(tpm, tpmKeyHnd, "", alg.goTPMAlg(), nonce, selectedPCRs)
 }
 
 func (k *windowsKey20) close(tpm tpmBase) error {
 	return closeNCryptObject(k.hnd)
 }
 
+func (k *windowsKey20) tpmVersion() TPMVersion {
+	return TPMVersion20
+}
+
 func (k *windowsKey20) attestationParameters() AttestationParameters {
 	return AttestationParameters{
 		Public:            k.public,
@@ -210,5 +214,102 @@ func (k *windowsKey20) certify(tb tpmBase, handle interface{}) (*CertificationPa
 		Alg:  tpm2.AlgRSASSA,
 		Hash: tpm2.AlgSHA1, // PCP-created AK uses SHA1
 	}
-	return certify(tpm, hnd, akHnd, scheme)
+	return certify(tpm, hnd, akHnd, "", "", nil, scheme)
+}
+
+// windowsAppKey20 represents an application key created by the Windows
+// Platform Crypto Provider on a TPM 2.0.
+type windowsAppKey20 struct {
+	hnd uintptr
+
+	pcpKeyName        string
+	public            []byte
+	createAttestation []byte
+	createSignature   []byte
+}
+
+func newWindowsAppKey20(hnd uintptr, pcpKeyName string, public, createAttest, createSig []byte) key {
+	return &windowsAppKey20{
+		hnd:               hnd,
+		pcpKeyName:        pcpKeyName,
+		public:            public,
+		createAttestation: createAttest,
+		createSignature:   createSig,
+	}
+}
+
+func (k *windowsAppKey20) marshal() ([]byte, error) {
+	out := serializedKey{
+		Encoding:   keyEncodingOSManaged,
+		TPMVersion: TPMVersion20,
+		Name:       k.pcpKeyName,
+
+		Public:            k.public,
+		CreateAttestation: k.createAttestation,
+		CreateSignature:   k.createSignature,
+	}
+	return out.Serialize()
+}
+
+func (k *windowsAppKey20) close(tpm tpmBase) error {
+	if k.hnd == 0 {
+		// The handle was freed when the key was deleted.
+		return nil
+	}
+	return closeNCryptObject(k.hnd)
+}
+
+func (k *windowsAppKey20) certificationParameters() CertificationParameters {
+	return CertificationParameters{
+		Public:            k.public,
+		CreateAttestation: k.createAttestation,
+		CreateSignature:   k.createSignature,
+	}
+}
+
+func (k *windowsAppKey20) sign(tb tpmBase, digest []byte, pub crypto.PublicKey, opts crypto.SignerOpts) ([]byte, error) {
+	t, ok := tb.(*windowsTPM)
+	if !ok {
+		return nil, fmt.Errorf("expected *windowsTPM, got %T", tb)
+	}
+	tpmKeyHnd, err := t.pcp.TPMKeyHandle(k.hnd)
+	if err != nil {
+		return nil, fmt.Errorf("TPMKeyHandle() failed: %v", err)
+	}
+	tpm, err := t.pcp.TPMCommandInterface()
+	if err != nil {
+		return nil, fmt.Errorf("TPMCommandInterface() failed: %v", err)
+	}
+	switch p := pub.(type) {
+	case *ecdsa.PublicKey:
+		_, raw := opts.(*RawECDSAOptions)
+		return signECDSA(tpm, tpmKeyHnd, "", digest, p.Curve, raw)
+	case *rsa.PublicKey:
+		return signRSA(tpm, tpmKeyHnd, "", digest, opts)
+	}
+	return nil, fmt.Errorf("unsupported signing key type: %T", pub)
+}
+
+func (k *windowsAppKey20) decrypt(tpmBase, []byte, crypto.DecrypterOpts) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (k *windowsAppKey20) blobs() ([]byte, []byte, error) {
+	return nil, nil, fmt.Errorf("not supported by OS-managed keys")
+}
+
+func (k *windowsAppKey20) evict(tpmBase, tpmutil.Handle, bool) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (k *windowsAppKey20) delete(tb tpmBase) error {
+	t, ok := tb.(*windowsTPM)
+	if !ok {
+		return fmt.Errorf("expected *windowsTPM, got %T", tb)
+	}
+	if err := t.pcp.DeleteKey(k.hnd); err != nil {
+		return err
+	}
+	k.hnd = 0
+	return nil
 }
@@ -40,6 +40,12 @@ const (
 	ncryptOverwriteKeyFlag = 0x80
 	// Key usage value for AKs.
 	nCryptPropertyPCPKeyUsagePolicyIdentity = 0x8
+
+	// Values of NCRYPT_EXPORT_POLICY_PROPERTY and NCRYPT_KEY_USAGE_PROPERTY,
+	// from ncrypt.h.
+	nCryptAllowExportFlag  = 0x1
+	nCryptAllowSigningFlag = 0x2
+	nCryptAllowAllUsages   = 0x00ffffff
 )
 
 // DLL references.
@@ -511,6 +517,73 @@ func (h *winPCP) NewAK(name string) (uintptr, error) {
 	return kh, nil
 }
 
+// setNCryptUint32Property sets the DWORD property field of the key kh.
+func setNCryptUint32Property(kh uintptr, field string, value uint32) error {
+	wideField, err := windows.UTF16FromString(field)
+	if err != nil {
+		return err
+	}
+	r, _, msg := nCryptSetProperty.Call(kh, uintptr(unsafe.Pointer(&wideField[0])), uintptr(unsafe.Pointer(&value)), unsafe.Sizeof(value), 0)
+	if r != 0 {
+		if tpmErr := maybeWinErr(r); tpmErr != nil {
+			msg = tpmErr
+		}
+		return fmt.Errorf("NCryptSetProperty (%s) returned %X: %v", field, r, msg)
+	}
+	return nil
+}
+
+// NewKey creates a persistent application key of the specified name.
+// alg is the CNG algorithm identifier of the key, such as "RSA" or
+// "ECDSA_P384", and length its size in bits, which is only set if non-zero.
+// usage and export are the values of the key's NCRYPT_KEY_USAGE_PROPERTY
+// and NCRYPT_EXPORT_POLICY_PROPERTY.
+func (h *winPCP) NewKey(name, alg string, length, usage, export uint32) (uintptr, error) {
+	var kh uintptr
+	utf16Name, err := windows.UTF16FromString(name)
+	if err != nil {
+		return 0, err
+	}
+	utf16Alg, err := windows.UTF16FromString(alg)
+	if err != nil {
+		return 0, err
+	}
+
+	r, _, msg := nCryptCreatePersistedKey.Call(h.hProv, uintptr(unsafe.Pointer(&kh)), uintptr(unsafe.Pointer(&utf16Alg[0])), uintptr(unsafe.Pointer(&utf16Name[0])), 0, 0)
+	if r != 0 {
+		if tpmErr := maybeWinErr(r); tpmErr != nil {
+			msg = tpmErr
+		}
+		return 0, fmt.Errorf("NCryptCreatePersistedKey returned %X: %v", r, msg)
+	}
+	// The key is only created by NCryptFinalizeKey, so freeing the handle
+	// is enough to abandon it.
+	if length != 0 {
+		if err := setNCryptUint32Property(kh, "Length", length); err != nil {
+			closeNCryptObject(kh)
+			return 0, err
+		}
+	}
+	if err := setNCryptUint32Property(kh, "Key Usage", usage); err != nil {
+		closeNCryptObject(kh)
+		return 0, err
+	}
+	if err := setNCryptUint32Property(kh, "Export Policy", export); err != nil {
+		closeNCryptObject(kh)
+		return 0, err
+	}
+
+	r, _, msg = nCryptFinalizeKey.Call(kh, 0)
+	if r != 0 {
+		closeNCryptObject(kh)
+		if tpmErr := maybeWinErr(r); tpmErr != nil {
+			msg = tpmErr
+		}
+		return 0, fmt.Errorf("NCryptFinalizeKey returned %X: %v", r, msg)
+	}
+	return kh, nil
+}
+
 // EKPub returns a BCRYPT_RSA_BLOB structure representing the EK.
 func (h *winPCP) EKPub() ([]byte, error) {
 	return getNCryptBufferProperty(h.hProv, "PCP_EKPUB")
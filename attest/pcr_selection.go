@@ -0,0 +1,109 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package attest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// pcrSelectSize is the size in bytes of the PCR bitmap of a selection, as
+// encoded by go-tpm and covering PCRs 0 to 23.
+const pcrSelectSize = 3
+
+// PCRSelection selects PCRs of the PCR bank of a hash algorithm.
+type PCRSelection struct {
+	Hash HashAlg
+	PCRs []int
+}
+
+// Validate checks that s selects at least one PCR of a supported bank, and
+// that each PCR is selected once and has an index between 0 and 23.
+func (s PCRSelection) Validate() error {
+	if s.Hash.cryptoHash() == 0 {
+		return fmt.Errorf("unsupported PCR bank: %v", s.Hash)
+	}
+	if len(s.PCRs) == 0 {
+		return errors.New("no PCRs selected")
+	}
+	indices := s.sorted()
+	for i, idx := range indices {
+		if idx < 0 || idx >= 8*pcrSelectSize {
+			return fmt.Errorf("PCR index %d out of range", idx)
+		}
+		if i > 0 && indices[i-1] == idx {
+			return fmt.Errorf("PCR %d selected more than once", idx)
+		}
+	}
+	return nil
+}
+
+// sorted returns the PCRs of s in order of increasing index.
+func (s PCRSelection) sorted() []int {
+	indices := append([]int(nil), s.PCRs...)
+	sort.Ints(indices)
+	return indices
+}
+
+// Merge returns the PCRs selected by either s or other, in order of
+// increasing index. Both selections must be valid and of the same bank.
+func (s PCRSelection) Merge(other PCRSelection) (PCRSelection, error) {
+	if s.Hash != other.Hash {
+		return PCRSelection{}, fmt.Errorf("cannot merge selections of the %v and %v PCR banks", s.Hash, other.Hash)
+	}
+	if err := s.Validate(); err != nil {
+		return PCRSelection{}, err
+	}
+	if err := other.Validate(); err != nil {
+		return PCRSelection{}, err
+	}
+	out := PCRSelection{Hash: s.Hash}
+	for _, idx := range append(s.sorted(), other.PCRs...) {
+		if !out.selects(idx) {
+			out.PCRs = append(out.PCRs, idx)
+		}
+	}
+	sort.Ints(out.PCRs)
+	return out, nil
+}
+
+// selects reports whether s selects the PCR idx.
+func (s PCRSelection) selects(idx int) bool {
+	for _, pcr := range s.PCRs {
+		if pcr == idx {
+			return true
+		}
+	}
+	return false
+}
+
+// Marshal returns the TPMS_PCR_SELECTION encoding of s: the TPM algorithm
+// ID of the bank, the size of the PCR bitmap and the bitmap, in which PCR n
+// is bit n%8 of byte n/8. The bitmap covers PCRs 0 to 23, as go-tpm
+// encodes selections.
+func (s PCRSelection) Marshal() ([]byte, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	bitmap := make([]byte, pcrSelectSize)
+	for _, idx := range s.PCRs {
+		bitmap[idx/8] |= 1 << (idx % 8)
+	}
+	out := binary.BigEndian.AppendUint16(nil, uint16(s.Hash.goTPMAlg()))
+	out = append(out, pcrSelectSize)
+	return append(out, bitmap...), nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package attest
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+func TestPCRSelectionValidate(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		sel     PCRSelection
+		wantErr bool
+	}{
+		{"valid", PCRSelection{Hash: HashSHA256, PCRs: []int{7, 0, 23}}, false},
+		{"no PCRs", PCRSelection{Hash: HashSHA256}, true},
+		{"negative", PCRSelection{Hash: HashSHA256, PCRs: []int{-1}}, true},
+		{"out of range", PCRSelection{Hash: HashSHA256, PCRs: []int{24}}, true},
+		{"duplicate", PCRSelection{Hash: HashSHA1, PCRs: []int{3, 3}}, true},
+		{"unsupported bank", PCRSelection{Hash: HashAlg(tpm2.AlgSHA3_256), PCRs: []int{0}}, true},
+	} {
+		if err := test.sel.Validate(); (err != nil) != test.wantErr {
+			t.Errorf("%s: Validate() = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestPCRSelectionMerge(t *testing.T) {
+	got, err := PCRSelection{Hash: HashSHA256, PCRs: []int{7, 0}}.Merge(PCRSelection{Hash: HashSHA256, PCRs: []int{14, 7}})
+	if err != nil {
+		t.Fatalf("Merge() failed: %v", err)
+	}
+	if want := (PCRSelection{Hash: HashSHA256, PCRs: []int{0, 7, 14}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge() = %+v, want %+v", got, want)
+	}
+
+	if _, err := (PCRSelection{Hash: HashSHA256, PCRs: []int{0}}).Merge(PCRSelection{Hash: HashSHA1, PCRs: []int{0}}); err == nil {
+		t.Error("Merge() of different banks succeeded, want error")
+	}
+	if _, err := (PCRSelection{Hash: HashSHA256, PCRs: []int{0}}).Merge(PCRSelection{Hash: HashSHA256, PCRs: []int{24}}); err == nil {
+		t.Error("Merge() with an invalid selection succeeded, want error")
+	}
+}
+
+func TestPCRSelectionMarshal(t *testing.T) {
+	sel := PCRSelection{Hash: HashSHA256, PCRs: []int{0, 7, 8, 23}}
+	got, err := sel.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if want := []byte{0x00, 0x0b, 3, 0x81, 0x01, 0x80}; !bytes.Equal(got, want) {
+		t.Errorf("Marshal() = %x, want %x", got, want)
+	}
+
+	if _, err := (PCRSelection{Hash: HashSHA256}).Marshal(); err == nil {
+		t.Error("Marshal() of an empty selection succeeded, want error")
+	}
+}
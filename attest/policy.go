@@ -0,0 +1,74 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package attest
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// ComputePolicyPCRDigest computes the policy digest of a policy session of
+// hash algorithm alg after TPM2_PolicyPCR, for the PCRs selected by sel
+// holding the values in pcrs, keyed by PCR index. The result can be used
+// as the authPolicy of a key which may only be used while the PCRs hold
+// those values, such as AKConfig.AuthPolicy, or compared against the
+// authPolicy of an existing key.
+//
+// The selection is encoded as go-tpm encodes it, which is what the TPM
+// hashes into the policy digest, so the digest matches sessions satisfied
+// with tpm2.PolicyPCR.
+func ComputePolicyPCRDigest(sel PCRSelection, pcrs map[int][]byte, alg HashAlg) ([]byte, error) {
+	if err := sel.Validate(); err != nil {
+		return nil, err
+	}
+	policyHash := alg.cryptoHash()
+	if policyHash == 0 {
+		return nil, fmt.Errorf("unsupported policy hash algorithm: %v", alg)
+	}
+	if err := checkHashAvailable(policyHash); err != nil {
+		return nil, err
+	}
+
+	// The TPM hashes the PCR values in order of increasing index,
+	// regardless of the order they were selected in.
+	bankHash := sel.Hash.cryptoHash()
+	pcrDigest := policyHash.New()
+	for _, idx := range sel.sorted() {
+		v, ok := pcrs[idx]
+		if !ok {
+			return nil, fmt.Errorf("no value for PCR %d", idx)
+		}
+		if len(v) != bankHash.Size() {
+			return nil, fmt.Errorf("PCR %d value has size %d, want %d for %v", idx, len(v), bankHash.Size(), sel.Hash)
+		}
+		pcrDigest.Write(v)
+	}
+	wire, err := sel.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	// policyDigest = H(policyDigest || TPM_CC_PolicyPCR || pcrs || pcrDigest),
+	// starting from a digest of zeros.
+	h := policyHash.New()
+	h.Write(make([]byte, policyHash.Size()))
+	binary.Write(h, binary.BigEndian, uint32(tpm2.CmdPolicyPCR))
+	binary.Write(h, binary.BigEndian, uint32(1)) // Count of selections.
+	h.Write(wire)
+	h.Write(pcrDigest.Sum(nil))
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package attest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestComputePolicyPCRDigest(t *testing.T) {
+	zeros := make([]byte, sha256.Size)
+	ones := bytes.Repeat([]byte{1}, sha256.Size)
+	pcrs := map[int][]byte{0: zeros, 7: ones}
+
+	// The TPM checks the digest computed here against a trial session in
+	// TestSimTPM20ComputePolicyPCRDigest. Check it depends on the PCR values
+	// but not on the order they're selected in.
+	digest, err := ComputePolicyPCRDigest(PCRSelection{Hash: HashSHA256, PCRs: []int{0, 7}}, pcrs, HashSHA256)
+	if err != nil {
+		t.Fatalf("ComputePolicyPCRDigest() failed: %v", err)
+	}
+	if len(digest) != sha256.Size {
+		t.Errorf("ComputePolicyPCRDigest() returned %d bytes, want %d", len(digest), sha256.Size)
+	}
+	reordered, err := ComputePolicyPCRDigest(PCRSelection{Hash: HashSHA256, PCRs: []int{7, 0}}, pcrs, HashSHA256)
+	if err != nil {
+		t.Fatalf("ComputePolicyPCRDigest() failed: %v", err)
+	}
+	if !bytes.Equal(digest, reordered) {
+		t.Errorf("ComputePolicyPCRDigest() = %x for reordered selection, want %x", reordered, digest)
+	}
+	swapped, err := ComputePolicyPCRDigest(PCRSelection{Hash: HashSHA256, PCRs: []int{0, 7}}, map[int][]byte{0: ones, 7: zeros}, HashSHA256)
+	if err != nil {
+		t.Fatalf("ComputePolicyPCRDigest() failed: %v", err)
+	}
+	if bytes.Equal(digest, swapped) {
+		t.Error("ComputePolicyPCRDigest() returned the same digest for different PCR values")
+	}
+
+	for _, test := range []struct {
+		name string
+		sel  PCRSelection
+		pcrs map[int][]byte
+		alg  HashAlg
+	}{
+		{"no PCRs", PCRSelection{Hash: HashSHA256}, nil, HashSHA256},
+		{"missing value", PCRSelection{Hash: HashSHA256, PCRs: []int{0, 1}}, map[int][]byte{0: zeros}, HashSHA256},
+		{"wrong size", PCRSelection{Hash: HashSHA1, PCRs: []int{0}}, map[int][]byte{0: zeros}, HashSHA256},
+		{"out of range", PCRSelection{Hash: HashSHA256, PCRs: []int{24}}, map[int][]byte{24: zeros}, HashSHA256},
+		{"duplicate", PCRSelection{Hash: HashSHA256, PCRs: []int{0, 0}}, map[int][]byte{0: zeros}, HashSHA256},
+		{"bad bank", PCRSelection{Hash: HashAlg(0xff), PCRs: []int{0}}, map[int][]byte{0: zeros}, HashSHA256},
+		{"bad policy hash", PCRSelection{Hash: HashSHA256, PCRs: []int{0}}, map[int][]byte{0: zeros}, HashAlg(0xff)},
+	} {
+		if _, err := ComputePolicyPCRDigest(test.sel, test.pcrs, test.alg); err == nil {
+			t.Errorf("%s: ComputePolicyPCRDigest() succeeded, want error", test.name)
+		}
+	}
+}
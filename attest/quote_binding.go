@@ -0,0 +1,96 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package attest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// QuoteDataBinding returns the extraData of a quote binding data to the
+// quote along with nonce, using the hash algorithm alg. It is a TPMT_HA
+// structure: the identifier of alg followed by the digest of the length of
+// nonce as a big-endian uint32, nonce and data. Recording alg lets
+// verifiers detect a binding made with a different hash algorithm than
+// their policy requires.
+//
+// The TPM limits extraData to the size of its largest digest, so alg must
+// be supported by the TPM taking the quote.
+func QuoteDataBinding(nonce, data []byte, alg HashAlg) ([]byte, error) {
+	if len(nonce) == 0 {
+		return nil, errors.New("no nonce was provided")
+	}
+	h := alg.cryptoHash()
+	if h == 0 {
+		return nil, fmt.Errorf("unsupported binding hash algorithm: %v", alg)
+	}
+	if err := checkHashAvailable(h); err != nil {
+		return nil, err
+	}
+	d := h.New()
+	binary.Write(d, binary.BigEndian, uint32(len(nonce)))
+	d.Write(nonce)
+	d.Write(data)
+	return tpm2.HashValue{Alg: alg.goTPMAlg(), Value: d.Sum(nil)}.Encode()
+}
+
+// QuoteWithData is like QuoteSelection, but binds data to the quote along
+// with nonce, including the output of QuoteDataBinding with bindingHash as
+// the quote's extraData. Such quotes are verified with
+// AKPublic.VerifyWithData, given the same binding hash.
+//
+// This is only supported on TPM 2.0.
+func (k *AK) QuoteWithData(tpm *TPM, nonce, data []byte, bindingHash HashAlg, sel PCRSelection) (*Quote, error) {
+	if tpm.Version() != TPMVersion20 {
+		return nil, fmt.Errorf("QuoteWithData is only supported on TPM 2.0, got version %d", tpm.Version())
+	}
+	extraData, err := QuoteDataBinding(nonce, data, bindingHash)
+	if err != nil {
+		return nil, err
+	}
+	return k.QuoteSelection(tpm, extraData, sel)
+}
+
+// VerifyWithData is like Verify, but verifies a quote taken by
+// AK.QuoteWithData, checking that it binds data and nonce with
+// bindingHash. A quote binding them with another hash algorithm is
+// rejected with ErrQuoteBindingHashMismatch.
+//
+// This is only supported for TPM 2.0 quotes.
+func (a *AKPublic) VerifyWithData(quote Quote, pcrs []PCR, nonce, data []byte, bindingHash HashAlg) error {
+	if quote.Version != TPMVersion20 {
+		return fmt.Errorf("data bindings are only supported for TPM 2.0 quotes, got version %d", quote.Version)
+	}
+	want, err := QuoteDataBinding(nonce, data, bindingHash)
+	if err != nil {
+		return err
+	}
+	// Check the algorithm of the binding first, as a binding with another
+	// algorithm would otherwise be reported as a nonce mismatch.
+	att, err := quote.attestationData20()
+	if err != nil {
+		return err
+	}
+	if len(att.ExtraData) < 2 {
+		return fmt.Errorf("quote extraData of %d bytes is not a data binding", len(att.ExtraData))
+	}
+	if got := HashAlg(binary.BigEndian.Uint16(att.ExtraData)); got != bindingHash {
+		return fmt.Errorf("%w: quote binds its data with %v, want %v", ErrQuoteBindingHashMismatch, got, bindingHash)
+	}
+	return a.Verify(quote, pcrs, want)
+}
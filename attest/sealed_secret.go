@@ -0,0 +1,118 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package attest
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// sealedSecretLabel is the OAEP label of RSA sealed secrets, and the HKDF
+// info of ECC sealed secrets.
+const sealedSecretLabel = "go-attestation sealed secret"
+
+// sealSecret encrypts secret to the recipient key pub, drawing randomness
+// from rnd, as described on ActivationParameters.RecipientKey.
+func sealSecret(rnd io.Reader, pub crypto.PublicKey, secret []byte) ([]byte, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.EncryptOAEP(sha256.New(), rnd, pub, secret, []byte(sealedSecretLabel))
+	case *ecdsa.PublicKey:
+		ecdhPub, err := pub.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("converting recipient key to ECDH key: %v", err)
+		}
+		return sealSecret(rnd, ecdhPub, secret)
+	case *ecdh.PublicKey:
+		ephemeral, err := generateECDHKey(rnd, pub.Curve())
+		if err != nil {
+			return nil, fmt.Errorf("generating ephemeral key: %v", err)
+		}
+		z, err := ephemeral.ECDH(pub)
+		if err != nil {
+			return nil, fmt.Errorf("computing shared secret: %v", err)
+		}
+		ephemeralPub := ephemeral.PublicKey().Bytes()
+		aead, err := sealedSecretAEAD(z, ephemeralPub)
+		if err != nil {
+			return nil, err
+		}
+		// The key is used once, so a fixed nonce is safe.
+		return aead.Seal(ephemeralPub, make([]byte, aead.NonceSize()), secret, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported recipient key type %T", pub)
+	}
+}
+
+// sealedSecretAEAD returns the AES-256-GCM cipher of an ECC sealed secret,
+// whose key is derived from the shared secret z and the ephemeral public
+// key.
+func sealedSecretAEAD(z, ephemeralPub []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, z, ephemeralPub, []byte(sealedSecretLabel)), key); err != nil {
+		return nil, fmt.Errorf("deriving key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// OpenSealedSecret decrypts an EncryptedCredential.SealedSecret with the
+// private key of the ActivationParameters.RecipientKey it was sealed to,
+// returning the activation secret.
+func OpenSealedSecret(priv crypto.PrivateKey, sealed []byte) ([]byte, error) {
+	switch priv := priv.(type) {
+	case *rsa.PrivateKey:
+		return rsa.DecryptOAEP(sha256.New(), nil, priv, sealed, []byte(sealedSecretLabel))
+	case *ecdsa.PrivateKey:
+		ecdhPriv, err := priv.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("converting recipient key to ECDH key: %v", err)
+		}
+		return OpenSealedSecret(ecdhPriv, sealed)
+	case *ecdh.PrivateKey:
+		size := len(priv.PublicKey().Bytes())
+		if len(sealed) < size {
+			return nil, errors.New("sealed secret is too short")
+		}
+		ephemeral, err := priv.Curve().NewPublicKey(sealed[:size])
+		if err != nil {
+			return nil, fmt.Errorf("parsing ephemeral key: %v", err)
+		}
+		z, err := priv.ECDH(ephemeral)
+		if err != nil {
+			return nil, fmt.Errorf("computing shared secret: %v", err)
+		}
+		aead, err := sealedSecretAEAD(z, sealed[:size])
+		if err != nil {
+			return nil, err
+		}
+		return aead.Open(nil, make([]byte, aead.NonceSize()), sealed[size:], nil)
+	default:
+		return nil, fmt.Errorf("unsupported recipient key type %T", priv)
+	}
+}
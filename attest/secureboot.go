@@ -81,6 +81,10 @@ const (
 // the state cannot be determined, or if the event log is structured
 // in such a way that it may have been tampered post-execution of
 // platform firmware.
+//
+// If an error is returned, the returned state is partial: it describes
+// the events processed before the error was encountered, and may be used
+// for diagnostics but must not be relied on to make policy decisions.
 func ParseSecurebootState(events []Event) (*SecurebootState, error) {
 	// This algorithm verifies the following:
 	// - All events in PCR 7 have event types which are expected in PCR 7.
@@ -111,7 +115,7 @@ func ParseSecurebootState(events []Event) (*SecurebootState, error) {
 
 		et, err := internal.UntrustedParseEventType(uint32(e.Type))
 		if err != nil {
-			return nil, fmt.Errorf("unrecognised event type: %v", err)
+			return &out, fmt.Errorf("unrecognised event type: %v", err)
 		}
 		digestVerify := e.digestEquals(e.Data)
 
@@ -120,74 +124,74 @@ func ParseSecurebootState(events []Event) (*SecurebootState, error) {
 			switch et {
 			case internal.Separator:
 				if seenSeparator7 {
-					return nil, fmt.Errorf("duplicate separator at event %d", e.sequence)
+					return &out, fmt.Errorf("duplicate separator at event %d", e.sequence)
 				}
 				seenSeparator7 = true
 				if !bytes.Equal(e.Data, []byte{0, 0, 0, 0}) {
-					return nil, fmt.Errorf("invalid separator data at event %d: %v", e.sequence, e.Data)
+					return &out, fmt.Errorf("invalid separator data at event %d: %v", e.sequence, e.Data)
 				}
 				if digestVerify != nil {
-					return nil, fmt.Errorf("invalid separator digest at event %d: %v", e.sequence, digestVerify)
+					return &out, fmt.Errorf("invalid separator digest at event %d: %v", e.sequence, digestVerify)
 				}
 
 			case internal.EFIAction:
 				switch string(e.Data) {
 				case "UEFI Debug Mode":
-					return nil, errors.New("a UEFI debugger was present during boot")
+					return &out, errors.New("a UEFI debugger was present during boot")
 				case "DMA Protection Disabled":
 					if digestVerify != nil {
-						return nil, fmt.Errorf("invalid digest for EFI Action 'DMA Protection Disabled' on event %d: %v", e.sequence, digestVerify)
+						return &out, fmt.Errorf("invalid digest for EFI Action 'DMA Protection Disabled' on event %d: %v", e.sequence, digestVerify)
 					}
 					out.DMAProtectionDisabled = true
 				default:
-					return nil, fmt.Errorf("event %d: unexpected EFI action event", e.sequence)
+					return &out, fmt.Errorf("event %d: unexpected EFI action event", e.sequence)
 				}
 
 			case internal.EFIVariableDriverConfig:
 				v, err := internal.ParseUEFIVariableData(bytes.NewReader(e.Data))
 				if err != nil {
-					return nil, fmt.Errorf("failed parsing EFI variable at event %d: %v", e.sequence, err)
+					return &out, fmt.Errorf("failed parsing EFI variable at event %d: %v", e.sequence, err)
 				}
 				if _, seenBefore := seenVars[v.VarName()]; seenBefore {
-					return nil, fmt.Errorf("duplicate EFI variable %q at event %d", v.VarName(), e.sequence)
+					return &out, fmt.Errorf("duplicate EFI variable %q at event %d", v.VarName(), e.sequence)
 				}
 				seenVars[v.VarName()] = true
 				if seenSeparator7 {
-					return nil, fmt.Errorf("event %d: variable %q specified after separator", e.sequence, v.VarName())
+					return &out, fmt.Errorf("event %d: variable %q specified after separator", e.sequence, v.VarName())
 				}
 
 				if digestVerify != nil {
-					return nil, fmt.Errorf("invalid digest for variable %q on event %d: %v", v.VarName(), e.sequence, digestVerify)
+					return &out, fmt.Errorf("invalid digest for variable %q on event %d: %v", v.VarName(), e.sequence, digestVerify)
 				}
 
 				switch v.VarName() {
 				case "SecureBoot":
 					if len(v.VariableData) != 1 {
-						return nil, fmt.Errorf("event %d: SecureBoot data len is %d, expected 1", e.sequence, len(v.VariableData))
+						return &out, fmt.Errorf("event %d: SecureBoot data len is %d, expected 1", e.sequence, len(v.VariableData))
 					}
 					out.Enabled = v.VariableData[0] == 1
 				case "PK":
 					if out.PlatformKeys, out.PlatformKeyHashes, err = v.SignatureData(); err != nil {
-						return nil, fmt.Errorf("event %d: failed parsing platform keys: %v", e.sequence, err)
+						return &out, fmt.Errorf("event %d: failed parsing platform keys: %v", e.sequence, err)
 					}
 				case "KEK":
 					if out.ExchangeKeys, out.ExchangeKeyHashes, err = v.SignatureData(); err != nil {
-						return nil, fmt.Errorf("event %d: failed parsing key exchange keys: %v", e.sequence, err)
+						return &out, fmt.Errorf("event %d: failed parsing key exchange keys: %v", e.sequence, err)
 					}
 				case "db":
 					if out.PermittedKeys, out.PermittedHashes, err = v.SignatureData(); err != nil {
-						return nil, fmt.Errorf("event %d: failed parsing signature database: %v", e.sequence, err)
+						return &out, fmt.Errorf("event %d: failed parsing signature database: %v", e.sequence, err)
 					}
 				case "dbx":
 					if out.ForbiddenKeys, out.ForbiddenHashes, err = v.SignatureData(); err != nil {
-						return nil, fmt.Errorf("event %d: failed parsing forbidden signature database: %v", e.sequence, err)
+						return &out, fmt.Errorf("event %d: failed parsing forbidden signature database: %v", e.sequence, err)
 					}
 				}
 
 			case internal.EFIVariableAuthority:
 				v, err := internal.ParseUEFIVariableData(bytes.NewReader(e.Data))
 				if err != nil {
-					return nil, fmt.Errorf("failed parsing UEFI variable data: %v", err)
+					return &out, fmt.Errorf("failed parsing UEFI variable data: %v", err)
 				}
 
 				a, err := internal.ParseUEFIVariableAuthority(v)
@@ -202,12 +206,12 @@ func ParseSecurebootState(events []Event) (*SecurebootState, error) {
 							digestVerify = e.digestEquals(e.Data[:len(e.Data)-1])
 						}
 					} else {
-						return nil, fmt.Errorf("failed parsing EFI variable authority at event %d: %v", e.sequence, err)
+						return &out, fmt.Errorf("failed parsing EFI variable authority at event %d: %v", e.sequence, err)
 					}
 				}
 				seenAuthority = true
 				if digestVerify != nil {
-					return nil, fmt.Errorf("invalid digest for authority on event %d: %v", e.sequence, digestVerify)
+					return &out, fmt.Errorf("invalid digest for authority on event %d: %v", e.sequence, digestVerify)
 				}
 				if !seenSeparator7 {
 					out.PreSeparatorAuthority = append(out.PreSeparatorAuthority, a.Certs...)
@@ -216,32 +220,32 @@ func ParseSecurebootState(events []Event) (*SecurebootState, error) {
 				}
 
 			default:
-				return nil, fmt.Errorf("unexpected event type in PCR7: %v", et)
+				return &out, fmt.Errorf("unexpected event type in PCR7: %v", et)
 			}
 
 		case 2:
 			switch et {
 			case internal.Separator:
 				if seenSeparator2 {
-					return nil, fmt.Errorf("duplicate separator at event %d", e.sequence)
+					return &out, fmt.Errorf("duplicate separator at event %d", e.sequence)
 				}
 				seenSeparator2 = true
 				if !bytes.Equal(e.Data, []byte{0, 0, 0, 0}) {
-					return nil, fmt.Errorf("invalid separator data at event %d: %v", e.sequence, e.Data)
+					return &out, fmt.Errorf("invalid separator data at event %d: %v", e.sequence, e.Data)
 				}
 				if digestVerify != nil {
-					return nil, fmt.Errorf("invalid separator digest at event %d: %v", e.sequence, digestVerify)
+					return &out, fmt.Errorf("invalid separator digest at event %d: %v", e.sequence, digestVerify)
 				}
 
 			case internal.EFIBootServicesDriver:
 				if !seenSeparator2 {
 					imgLoad, err := internal.ParseEFIImageLoad(bytes.NewReader(e.Data))
 					if err != nil {
-						return nil, fmt.Errorf("failed parsing EFI image load at boot services driver event %d: %v", e.sequence, err)
+						return &out, fmt.Errorf("failed parsing EFI image load at boot services driver event %d: %v", e.sequence, err)
 					}
 					dp, err := imgLoad.DevicePath()
 					if err != nil {
-						return nil, fmt.Errorf("failed to parse device path for driver load event %d: %v", e.sequence, err)
+						return &out, fmt.Errorf("failed to parse device path for driver load event %d: %v", e.sequence, err)
 					}
 					driverSources = append(driverSources, dp)
 				}
@@ -280,16 +284,16 @@ sourceLoop:
 	}
 
 	if !seenAuthority {
-		return nil, errors.New("secure boot was enabled but no key was used")
+		return &out, errors.New("secure boot was enabled but no key was used")
 	}
 	if len(out.PlatformKeys) == 0 && len(out.PlatformKeyHashes) == 0 {
-		return nil, errors.New("secure boot was enabled but no platform keys were known")
+		return &out, errors.New("secure boot was enabled but no platform keys were known")
 	}
 	if len(out.ExchangeKeys) == 0 && len(out.ExchangeKeyHashes) == 0 {
-		return nil, errors.New("secure boot was enabled but no key exchange keys were known")
+		return &out, errors.New("secure boot was enabled but no key exchange keys were known")
 	}
 	if len(out.PermittedKeys) == 0 && len(out.PermittedHashes) == 0 {
-		return nil, errors.New("secure boot was enabled but no keys or hashes were permitted")
+		return &out, errors.New("secure boot was enabled but no keys or hashes were permitted")
 	}
 	return &out, nil
 }
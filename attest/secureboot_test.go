@@ -15,10 +15,13 @@
 package attest
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"os"
 	"testing"
+
+	"github.com/google/go-attestation/attest/internal"
 )
 
 func TestSecureBoot(t *testing.T) {
@@ -50,6 +53,68 @@ func TestSecureBoot(t *testing.T) {
 	}
 }
 
+func TestSecureBootTruncatedEvents(t *testing.T) {
+	data, err := os.ReadFile("testdata/windows_gcp_shielded_vm.json")
+	if err != nil {
+		t.Fatalf("reading test data: %v", err)
+	}
+	var dump Dump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		t.Fatalf("parsing test data: %v", err)
+	}
+	el, err := ParseEventLog(dump.Log.Raw)
+	if err != nil {
+		t.Fatalf("parsing event log: %v", err)
+	}
+	events, err := el.Verify(dump.Log.PCRs)
+	if err != nil {
+		t.Fatalf("validating event log: %v", err)
+	}
+
+	var truncatedDB bool
+	for i, e := range events {
+		if e.Index != 7 || len(e.Data) == 0 {
+			continue
+		}
+		for _, n := range []int{0, 1, len(e.Data) / 2, len(e.Data) - 1} {
+			truncated := append([]Event(nil), events...)
+			truncated[i].Data = e.Data[:n]
+
+			sbState, err := ParseSecurebootState(truncated)
+			if err == nil {
+				t.Errorf("ParseSecurebootState() with event %d truncated to %d bytes succeeded, want error", i, n)
+				continue
+			}
+			if sbState == nil {
+				t.Fatalf("ParseSecurebootState() with event %d truncated to %d bytes returned no partial state", i, n)
+			}
+			if isVariableEvent(e, "db") && !truncatedDB {
+				truncatedDB = true
+				// Variables measured before db are still reported.
+				if !sbState.Enabled {
+					t.Error("partial state with truncated db has Enabled = false, want true")
+				}
+				if len(sbState.PlatformKeys) == 0 {
+					t.Error("partial state with truncated db has no platform keys")
+				}
+			}
+		}
+	}
+	if !truncatedDB {
+		t.Error("no db variable event found in test data")
+	}
+}
+
+// isVariableEvent reports whether e measures the UEFI variable name.
+func isVariableEvent(e Event, name string) bool {
+	et, err := internal.UntrustedParseEventType(uint32(e.Type))
+	if err != nil || et != internal.EFIVariableDriverConfig {
+		return false
+	}
+	v, err := internal.ParseUEFIVariableData(bytes.NewReader(e.Data))
+	return err == nil && v.VarName() == name
+}
+
 // See: https://github.com/google/go-attestation/issues/157
 func TestSecureBootBug157(t *testing.T) {
 	raw, err := os.ReadFile("testdata/sb_cert_eventlog")
@@ -17,10 +17,21 @@ package attest
 import (
 	"encoding/json"
 	"fmt"
+
+	"github.com/google/go-tpm/tpmutil"
 )
 
+// serializedKeyVersion is the current version of the serializedKey format.
+// It should be incremented whenever the format changes in a way which older
+// versions of this package cannot load.
+const serializedKeyVersion = 1
+
 // serializedKey represents a loadable, TPM-backed key.
 type serializedKey struct {
+	// Version describes the version of the serialization format. Keys
+	// serialized before the format was versioned have a version of 0, and
+	// are treated as version 1.
+	Version int `json:",omitempty"`
 	// Encoding describes the strategy by which the key should be
 	// loaded/unloaded.
 	Encoding keyEncoding `json:"KeyEncoding"`
@@ -46,11 +57,19 @@ type serializedKey struct {
 	// Blob represents the key material for KeyEncodingEncrypted keys. This
 	// is only used on Linux.
 	Blob []byte `json:"KeyBlob"`
+	// PersistentHandle is the handle at which a TPM 2.0 key has been made
+	// persistent, or zero for transient keys.
+	PersistentHandle tpmutil.Handle `json:",omitempty"`
+	// EKHandle is the persistent handle of the EK which is the parent of
+	// a TPM 2.0 key in the endorsement hierarchy, or zero for keys whose
+	// parent is the SRK.
+	EKHandle tpmutil.Handle `json:",omitempty"`
 }
 
 // Serialize represents the key in a persistent format which may be
 // loaded at a later time using deserializeKey().
 func (k *serializedKey) Serialize() ([]byte, error) {
+	k.Version = serializedKeyVersion
 	return json.Marshal(k)
 }
 
@@ -61,6 +80,16 @@ func deserializeKey(b []byte, version TPMVersion) (*serializedKey, error) {
 		return nil, fmt.Errorf("json.Unmarshal() failed: %v", err)
 	}
 
+	if k.Version > serializedKeyVersion {
+		return nil, fmt.Errorf("key serialized with unsupported version %d, want version %d or earlier", k.Version, serializedKeyVersion)
+	}
+	if k.Version < 0 {
+		return nil, fmt.Errorf("invalid key serialization version %d", k.Version)
+	}
+	if len(k.Public) == 0 {
+		return nil, fmt.Errorf("serialized key is missing public area")
+	}
+
 	if k.TPMVersion != version {
 		return nil, fmt.Errorf("key for different TPM version: %v", k.TPMVersion)
 	}
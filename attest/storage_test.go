@@ -0,0 +1,110 @@
+// Copyright 2019 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package attest
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSerializeKeyRoundTrip(t *testing.T) {
+	for _, in := range []serializedKey{
+		{
+			Encoding:          keyEncodingEncrypted,
+			TPMVersion:        TPMVersion20,
+			Public:            []byte{1, 2, 3},
+			CreateData:        []byte{4},
+			CreateAttestation: []byte{5},
+			CreateSignature:   []byte{6},
+			Blob:              []byte{7, 8},
+		},
+		{
+			Encoding:   keyEncodingOSManaged,
+			TPMVersion: TPMVersion20,
+			Public:     []byte{1, 2, 3},
+			Name:       "key-name",
+		},
+		{
+			Encoding:   keyEncodingEncrypted,
+			TPMVersion: TPMVersion12,
+			Public:     []byte{1, 2, 3},
+			Blob:       []byte{7, 8},
+		},
+	} {
+		b, err := in.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize() failed: %v", err)
+		}
+		out, err := deserializeKey(b, in.TPMVersion)
+		if err != nil {
+			t.Fatalf("deserializeKey() failed: %v", err)
+		}
+		if out.Version != serializedKeyVersion {
+			t.Errorf("Version = %d, want %d", out.Version, serializedKeyVersion)
+		}
+		if !bytes.Equal(out.Public, in.Public) {
+			t.Errorf("Public = %x, want %x", out.Public, in.Public)
+		}
+		if out.Encoding != in.Encoding || out.Name != in.Name || !bytes.Equal(out.Blob, in.Blob) {
+			t.Errorf("deserializeKey() = %+v, want %+v", out, in)
+		}
+	}
+}
+
+func TestDeserializeKeyVersion(t *testing.T) {
+	// Keys serialized before the format was versioned must still load.
+	legacy := []byte(`{"KeyEncoding":2,"TPMVersion":2,"Public":"AQID","KeyBlob":"Bwg="}`)
+	if _, err := deserializeKey(legacy, TPMVersion20); err != nil {
+		t.Errorf("deserializeKey() failed for unversioned key: %v", err)
+	}
+
+	k := serializedKey{
+		Encoding:   keyEncodingEncrypted,
+		TPMVersion: TPMVersion20,
+		Public:     []byte{1, 2, 3},
+	}
+	for _, test := range []struct {
+		name    string
+		version int
+		wantErr string
+	}{
+		{"future", serializedKeyVersion + 1, "unsupported version"},
+		{"negative", -1, "invalid key serialization version"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			k.Version = test.version
+			b, err := json.Marshal(k)
+			if err != nil {
+				t.Fatalf("json.Marshal() failed: %v", err)
+			}
+			_, err = deserializeKey(b, TPMVersion20)
+			if err == nil {
+				t.Fatal("deserializeKey() succeeded, want error")
+			}
+			if !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("deserializeKey() returned %q, want error containing %q", err, test.wantErr)
+			}
+		})
+	}
+
+	if _, err := deserializeKey(legacy, TPMVersion12); err == nil {
+		t.Error("deserializeKey() succeeded for key from a different TPM version, want error")
+	}
+	if _, err := deserializeKey([]byte(`{"KeyEncoding":2,"TPMVersion":2}`), TPMVersion20); err == nil {
+		t.Error("deserializeKey() succeeded for key without a public area, want error")
+	}
+}
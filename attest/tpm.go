@@ -16,15 +16,23 @@ package attest
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/base64"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/google/go-tpm/legacy/tpm2"
@@ -42,13 +50,17 @@ const (
 	nvramECCCertIndex    = 0x1c0000a
 	nvramECCEkNonceIndex = 0x1c0000b
 
+	// maxCapAlgs is the number of algorithms requested per call to
+	// TPM2_GetCapability.
+	maxCapAlgs = 64
+
 	// Defined in "Registry of reserved TPM 2.0 handles and localities", and checked on a glinux machine.
 	commonRSAEkEquivalentHandle = 0x81010001
 	commonECCEkEquivalentHandle = 0x81010002
 )
 
 var (
-	akTemplate = tpm2.Public{
+	akTemplateRSA = tpm2.Public{
 		Type:       tpm2.AlgRSA,
 		NameAlg:    tpm2.AlgSHA256,
 		Attributes: tpm2.FlagSignerDefault | tpm2.FlagNoDA,
@@ -60,6 +72,22 @@ var (
 			KeyBits: 2048,
 		},
 	}
+	akTemplateECC = tpm2.Public{
+		Type:       tpm2.AlgECC,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: tpm2.FlagSignerDefault | tpm2.FlagNoDA,
+		ECCParameters: &tpm2.ECCParams{
+			Sign: &tpm2.SigScheme{
+				Alg:  tpm2.AlgECDSA,
+				Hash: tpm2.AlgSHA256,
+			},
+			CurveID: tpm2.CurveNISTP256,
+			Point: tpm2.ECPoint{
+				XRaw: make([]byte, 32),
+				YRaw: make([]byte, 32),
+			},
+		},
+	}
 	defaultRSASRKTemplate = tpm2.Public{
 		Type:       tpm2.AlgRSA,
 		NameAlg:    tpm2.AlgSHA256,
@@ -217,6 +245,42 @@ func readTPM2VendorAttributes(tpm io.ReadWriter) (tpm20Info, error) {
 	}, nil
 }
 
+// supportedAlgorithms20 returns the key algorithms advertised by a TPM 2.0
+// device, in the order they are declared.
+func supportedAlgorithms20(tpm io.ReadWriter) ([]Algorithm, error) {
+	have := map[tpm2.Algorithm]bool{}
+	for next, more := uint32(0), true; more; {
+		var (
+			caps []interface{}
+			err  error
+		)
+		caps, more, err = tpm2.GetCapability(tpm, tpm2.CapabilityAlgs, maxCapAlgs, next)
+		if err != nil {
+			return nil, fmt.Errorf("tpm2.GetCapability(TPM_CAP_ALGS) failed: %v", err)
+		}
+		for _, c := range caps {
+			desc, ok := c.(tpm2.AlgorithmDescription)
+			if !ok {
+				return nil, fmt.Errorf("got capability of type %T, want tpm2.AlgorithmDescription", c)
+			}
+			have[desc.ID] = true
+			next = uint32(desc.ID) + 1
+		}
+		if len(caps) == 0 {
+			break
+		}
+	}
+
+	var algs []Algorithm
+	if have[tpm2.AlgRSA] {
+		algs = append(algs, RSA)
+	}
+	if have[tpm2.AlgECC] && have[tpm2.AlgECDSA] {
+		algs = append(algs, ECDSA)
+	}
+	return algs, nil
+}
+
 // ParseEKCertificate parses a raw DER encoded EK certificate blob.
 func ParseEKCertificate(ekCert []byte) (*x509.Certificate, error) {
 	var wasWrapped bool
@@ -255,6 +319,413 @@ func ParseEKCertificate(ekCert []byte) (*x509.Certificate, error) {
 	return c, nil
 }
 
+var (
+	oidPublicKeyRSA       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidPublicKeyRSAESOAEP = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidPublicKeyECDSA     = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidNamedCurveP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+// EKPublicFromCert returns the public key of an EK certificate in a form
+// which can be used as ActivationParameters.EK: an *rsa.PublicKey, or an
+// *ecdsa.PublicKey on the NIST P-256, P-384 or P-521 curve.
+//
+// Some EK certificates identify RSA keys as RSAES-OAEP keys, which Go's
+// x509 package leaves unparsed, or omit the curve of ECC keys. Such keys
+// are decoded from the certificate's SubjectPublicKeyInfo, inferring a
+// missing curve from the size of the point.
+func EKPublicFromCert(cert *x509.Certificate) (crypto.PublicKey, error) {
+	if cert == nil {
+		return nil, errors.New("no EK certificate provided")
+	}
+	pub := cert.PublicKey
+	if pub == nil {
+		var err error
+		if pub, err = parseEKPublicKeyInfo(cert.RawSubjectPublicKeyInfo); err != nil {
+			return nil, fmt.Errorf("parsing EK certificate public key: %v", err)
+		}
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if pub.N == nil || pub.N.Sign() <= 0 || pub.E < 3 || pub.E%2 == 0 {
+			return nil, errors.New("EK certificate holds an invalid RSA key")
+		}
+		return pub, nil
+	case *ecdsa.PublicKey:
+		// Challenges for ECC EKs are generated with crypto/ecdh, which
+		// supports only the NIST curves.
+		if _, err := pub.ECDH(); err != nil {
+			return nil, fmt.Errorf("unsupported ECC EK: %v", err)
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported EK certificate key type %T: must be an RSA or ECC key", pub)
+	}
+}
+
+// parseEKPublicKeyInfo decodes an RSA or ECC SubjectPublicKeyInfo, accepting
+// the quirks of EK certificates described by EKPublicFromCert.
+func parseEKPublicKeyInfo(der []byte) (crypto.PublicKey, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	rest, err := asn1.Unmarshal(der, &spki)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after public key")
+	}
+	alg, key := spki.Algorithm, spki.PublicKey.RightAlign()
+
+	switch {
+	case alg.Algorithm.Equal(oidPublicKeyRSA), alg.Algorithm.Equal(oidPublicKeyRSAESOAEP):
+		var k struct {
+			N *big.Int
+			E int
+		}
+		if rest, err := asn1.Unmarshal(key, &k); err != nil {
+			return nil, fmt.Errorf("decoding RSA key: %v", err)
+		} else if len(rest) != 0 {
+			return nil, errors.New("trailing data after RSA key")
+		}
+		return &rsa.PublicKey{N: k.N, E: k.E}, nil
+
+	case alg.Algorithm.Equal(oidPublicKeyECDSA):
+		var curve elliptic.Curve
+		var namedCurve asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &namedCurve); err == nil {
+			switch {
+			case namedCurve.Equal(oidNamedCurveP256):
+				curve = elliptic.P256()
+			case namedCurve.Equal(oidNamedCurveP384):
+				curve = elliptic.P384()
+			case namedCurve.Equal(oidNamedCurveP521):
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("unsupported ECC curve %v", namedCurve)
+			}
+		} else {
+			// No named curve, so use the curve whose uncompressed points
+			// are the size of the key.
+			for _, c := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+				if len(key) == 1+2*((c.Params().BitSize+7)/8) {
+					curve = c
+					break
+				}
+			}
+			if curve == nil {
+				return nil, fmt.Errorf("cannot infer the curve of a %d byte ECC point", len(key))
+			}
+		}
+		x, y := elliptic.Unmarshal(curve, key)
+		if x == nil {
+			return nil, errors.New("invalid ECC point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported public key algorithm %v", alg.Algorithm)
+	}
+}
+
+var (
+	oidSubjectAltName             = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidSubjectDirectoryAttributes = asn1.ObjectIdentifier{2, 5, 29, 9}
+
+	// Defined in section 3.1.2 of the TCG EK Credential Profile.
+	oidTPMManufacturer = asn1.ObjectIdentifier{2, 23, 133, 2, 1}
+	oidTPMModel        = asn1.ObjectIdentifier{2, 23, 133, 2, 2}
+	oidTPMVersion      = asn1.ObjectIdentifier{2, 23, 133, 2, 3}
+)
+
+// VerifyEKCertificate verifies that an EK certificate chains to one of the
+// provided roots, through any of the provided intermediates, which may be
+// nil.
+//
+// EK certificates commonly mark their Subject Alternative Name extension as
+// critical while encoding only a directoryName holding the TPM manufacturer,
+// model and firmware version, and may carry a critical Subject Directory
+// Attributes extension describing the TPM specification. Go's x509 package
+// does not handle these, so they are permitted here. EK certificates also
+// frequently have an empty subject and use the TCG EK certificate extended
+// key usage, which are accepted as well.
+//
+// TPM manufacturers publish their root and intermediate CA certificates
+// (for example Infineon, STMicroelectronics, Nuvoton and Intel). These are
+// not bundled with this package; callers should download them from the
+// manufacturer and add them to roots and intermediates respectively, with
+// x509.CertPool.AddCert or x509.CertPool.AppendCertsFromPEM. Intermediate
+// certificates must not be placed in roots, as they would then be trusted
+// as anchors on their own.
+func VerifyEKCertificate(ekCert *x509.Certificate, roots, intermediates *x509.CertPool) error {
+	if ekCert == nil {
+		return errors.New("no EK certificate provided")
+	}
+	if roots == nil {
+		return errors.New("no roots provided")
+	}
+
+	// Operate on a copy so the caller's certificate is not modified.
+	c := *ekCert
+	c.UnhandledCriticalExtensions = nil
+	for _, oid := range ekCert.UnhandledCriticalExtensions {
+		if oid.Equal(oidSubjectAltName) || oid.Equal(oidSubjectDirectoryAttributes) {
+			continue
+		}
+		c.UnhandledCriticalExtensions = append(c.UnhandledCriticalExtensions, oid)
+	}
+
+	if _, err := c.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("verifying EK certificate: %v", err)
+	}
+	return nil
+}
+
+// EKTrust describes how an EK was established to belong to a TPM. Levels
+// are ordered, so policies can require a minimum level.
+type EKTrust uint8
+
+// EK trust levels.
+const (
+	// EKTrustPublicKeyOnly is the level of an EK accepted without a
+	// certificate, which is only allowed by EKVerifyOptions.VirtualTPM.
+	// Nothing vouches for the EK beyond the channel it was received over.
+	EKTrustPublicKeyOnly EKTrust = iota + 1
+	// EKTrustVirtualRoot is the level of an EK whose certificate chains to
+	// EKVerifyOptions.VirtualRoots, such as the CA of a cloud provider
+	// issuing certificates for its virtual TPMs.
+	EKTrustVirtualRoot
+	// EKTrustHardwareRoot is the level of an EK whose certificate chains to
+	// EKVerifyOptions.Roots, the roots of TPM manufacturers.
+	EKTrustHardwareRoot
+)
+
+// String returns a name describing the trust level.
+func (t EKTrust) String() string {
+	switch t {
+	case EKTrustPublicKeyOnly:
+		return "public key only"
+	case EKTrustVirtualRoot:
+		return "virtual TPM root"
+	case EKTrustHardwareRoot:
+		return "hardware root"
+	default:
+		return fmt.Sprintf("EKTrust(%d)", uint8(t))
+	}
+}
+
+// EKVerifyOptions configures VerifyEK.
+type EKVerifyOptions struct {
+	// Roots are the root certificates of TPM manufacturers, as for
+	// VerifyEKCertificate.
+	Roots *x509.CertPool
+
+	// Intermediates are intermediate certificates which may be used to
+	// chain EK certificates to Roots or VirtualRoots.
+	Intermediates *x509.CertPool
+
+	// VirtualTPM enables vTPM mode, for virtual and firmware TPMs such as
+	// those of cloud VMs. EK certificates may then also chain to
+	// VirtualRoots, and EKs without a certificate are accepted at
+	// EKTrustPublicKeyOnly. Neither carries the assurance of a hardware
+	// root, so callers enabling it should check the Trust of the result
+	// before relying on the TPM being a discrete chip.
+	VirtualTPM bool
+
+	// VirtualRoots are the root certificates of virtual TPM providers.
+	// Only used if VirtualTPM is set.
+	VirtualRoots *x509.CertPool
+}
+
+// EKVerification is the result of VerifyEK.
+type EKVerification struct {
+	// Trust is the level of assurance that the EK belongs to a TPM.
+	Trust EKTrust
+	// Certificate is the verified EK certificate, or nil at
+	// EKTrustPublicKeyOnly.
+	Certificate *x509.Certificate
+}
+
+// HardwareRooted reports whether the EK was verified by a certificate
+// chaining to a TPM manufacturer's root.
+func (v *EKVerification) HardwareRooted() bool {
+	return v.Trust == EKTrustHardwareRoot
+}
+
+// VerifyEK checks that ek, such as the EK of ActivationParameters, belongs
+// to a TPM, and reports how that was established. If cert is provided, it
+// must certify ek, as checked by MatchEKCertificate, and chain to
+// opts.Roots or, in vTPM mode, opts.VirtualRoots; a certificate which
+// chains to neither is rejected rather than downgraded to
+// EKTrustPublicKeyOnly. If cert is nil, ek is only accepted in vTPM mode.
+func VerifyEK(ek crypto.PublicKey, cert *x509.Certificate, opts EKVerifyOptions) (*EKVerification, error) {
+	if ek == nil {
+		return nil, errors.New("no EK provided")
+	}
+	if cert == nil {
+		if !opts.VirtualTPM {
+			return nil, errors.New("no EK certificate provided, and EKs without a certificate are only accepted for virtual TPMs")
+		}
+		return &EKVerification{Trust: EKTrustPublicKeyOnly}, nil
+	}
+	if err := MatchEKCertificate(ek, cert); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	if opts.Roots != nil {
+		err := VerifyEKCertificate(cert, opts.Roots, opts.Intermediates)
+		if err == nil {
+			return &EKVerification{Trust: EKTrustHardwareRoot, Certificate: cert}, nil
+		}
+		errs = append(errs, err)
+	}
+	if opts.VirtualTPM && opts.VirtualRoots != nil {
+		err := VerifyEKCertificate(cert, opts.VirtualRoots, opts.Intermediates)
+		if err == nil {
+			return &EKVerification{Trust: EKTrustVirtualRoot, Certificate: cert}, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no roots provided")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// EKInfo describes the TPM an EK certificate was issued to, as encoded
+// in the certificate's Subject Alternative Name.
+type EKInfo struct {
+	Manufacturer TCGVendorID
+	Model        string
+
+	// FirmwareVersionMajor and FirmwareVersionMinor describe the firmware
+	// version of the TPM, and are zero if the certificate does not
+	// include it.
+	FirmwareVersionMajor int
+	FirmwareVersionMinor int
+}
+
+// ParseEKCertificateInfo returns the TPM manufacturer, model and firmware
+// version recorded in an EK certificate.
+//
+// These are encoded as a directoryName in the Subject Alternative Name
+// extension. Certificates which implicitly tag the directoryName, spread
+// the attributes across several names, or record them in the certificate
+// subject are also accepted.
+func ParseEKCertificateInfo(cert *x509.Certificate) (*EKInfo, error) {
+	if cert == nil {
+		return nil, errors.New("no EK certificate provided")
+	}
+	var names []pkix.AttributeTypeAndValue
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		n, err := parseSANDirectoryNames(ext.Value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing subject alternative name: %v", err)
+		}
+		names = append(names, n...)
+	}
+	names = append(names, cert.Subject.Names...)
+
+	var (
+		info                         EKInfo
+		manufacturer, model, version string
+	)
+	for _, atv := range names {
+		v, ok := atv.Value.(string)
+		if !ok {
+			continue
+		}
+		switch {
+		case atv.Type.Equal(oidTPMManufacturer) && manufacturer == "":
+			manufacturer = v
+		case atv.Type.Equal(oidTPMModel) && model == "":
+			model = v
+		case atv.Type.Equal(oidTPMVersion) && version == "":
+			version = v
+		}
+	}
+	if manufacturer == "" {
+		return nil, errors.New("EK certificate does not specify the TPM manufacturer")
+	}
+
+	id, err := parseEKCertID(manufacturer)
+	if err != nil {
+		return nil, fmt.Errorf("parsing TPM manufacturer %q: %v", manufacturer, err)
+	}
+	info.Manufacturer = TCGVendorID(id)
+	info.Model = strings.TrimRight(model, "\x00")
+	if version != "" {
+		fw, err := parseEKCertID(version)
+		if err != nil {
+			return nil, fmt.Errorf("parsing TPM firmware version %q: %v", version, err)
+		}
+		info.FirmwareVersionMajor = int((fw & 0xffff0000) >> 16)
+		info.FirmwareVersionMinor = int(fw & 0x0000ffff)
+	}
+	return &info, nil
+}
+
+// parseSANDirectoryNames returns the attributes of all directoryNames in
+// a Subject Alternative Name extension.
+func parseSANDirectoryNames(san []byte) ([]pkix.AttributeTypeAndValue, error) {
+	var generalNames []asn1.RawValue
+	if rest, err := asn1.Unmarshal(san, &generalNames); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data after subject alternative name")
+	}
+
+	var out []pkix.AttributeTypeAndValue
+	for _, gn := range generalNames {
+		if gn.Class != asn1.ClassContextSpecific || gn.Tag != 4 {
+			continue
+		}
+		// directoryName is explicitly tagged, but some vendors tag it
+		// implicitly, omitting the Name's SEQUENCE header.
+		var rdns pkix.RDNSequence
+		if rest, err := asn1.Unmarshal(gn.Bytes, &rdns); err != nil || len(rest) != 0 {
+			if _, err := asn1.UnmarshalWithParams(gn.FullBytes, &rdns, "tag:4"); err != nil {
+				return nil, fmt.Errorf("parsing directoryName: %v", err)
+			}
+		}
+		for _, rdn := range rdns {
+			out = append(out, rdn...)
+		}
+	}
+	return out, nil
+}
+
+// parseEKCertID parses a hex encoded value of the form "id:XXXXXXXX", as
+// used for the TPM manufacturer and firmware version in EK certificates.
+// The prefix is matched case-insensitively and may be missing.
+func parseEKCertID(s string) (uint32, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "\x00")
+	if len(s) >= 3 && strings.EqualFold(s[:3], "id:") {
+		s = s[3:]
+	}
+	if len(s) == 0 || len(s) > 8 {
+		return 0, fmt.Errorf("invalid length %d", len(s))
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(v), nil
+}
+
 const (
 	manufacturerIntel     = "Intel"
 	intelEKCertServiceURL = "https://ekop.intel.com/ekcertservice/"
@@ -279,16 +750,24 @@ func readEKCertFromNVRAM20(tpm io.ReadWriter, nvramCertIndex tpmutil.Handle) (*x
 	return ParseEKCertificate(ekCert)
 }
 
-func quote20(tpm io.ReadWriter, akHandle tpmutil.Handle, hashAlg tpm2.Algorithm, nonce []byte, selectedPCRs []int) (*Quote, error) {
+func quote20(tpm io.ReadWriter, akHandle tpmutil.Handle, akAuth string, hashAlg tpm2.Algorithm, nonce []byte, selectedPCRs []int) (*Quote, error) {
 	sel := tpm2.PCRSelection{Hash: hashAlg,
 		PCRs: selectedPCRs}
 
-	quote, sig, err := tpm2.Quote(tpm, akHandle, "", "", nonce, sel, tpm2.AlgNull)
+	quote, sig, err := tpm2.Quote(tpm, akHandle, akAuth, "", nonce, sel, tpm2.AlgNull)
 	if err != nil {
 		return nil, err
 	}
 
-	rawSig, err := tpmutil.Pack(sig.Alg, sig.RSA.HashAlg, sig.RSA.Signature)
+	var rawSig []byte
+	switch sig.Alg {
+	case tpm2.AlgRSASSA:
+		rawSig, err = tpmutil.Pack(sig.Alg, sig.RSA.HashAlg, sig.RSA.Signature)
+	case tpm2.AlgECDSA:
+		rawSig, err = tpmutil.Pack(sig.Alg, sig.ECC.HashAlg, tpmutil.U16Bytes(sig.ECC.R.Bytes()), tpmutil.U16Bytes(sig.ECC.S.Bytes()))
+	default:
+		return nil, fmt.Errorf("unsupported quote signature algorithm: %v", sig.Alg)
+	}
 	return &Quote{
 		Version:   TPMVersion20,
 		Quote:     quote,
@@ -341,18 +820,30 @@ type tpmBase interface {
 	eks() ([]EK, error)
 	ekCertificates() ([]EK, error)
 	info() (*TPMInfo, error)
+	supportedAlgorithms() ([]Algorithm, error)
+	readClock() (ClockInfo, error)
+	persistentHandles() ([]tpmutil.Handle, error)
 
 	loadAK(opaqueBlob []byte) (*AK, error)
 	loadAKWithParent(opaqueBlob []byte, parent ParentKeyConfig) (*AK, error)
+	loadAKFromHandle(handle tpmutil.Handle, public []byte) (*AK, error)
 	newAK(opts *AKConfig) (*AK, error)
 	loadKey(opaqueBlob []byte) (*Key, error)
 	loadKeyWithParent(opaqueBlob []byte, parent ParentKeyConfig) (*Key, error)
+	loadKeyFromHandle(handle tpmutil.Handle, public []byte) (*Key, error)
 	newKey(ak *AK, opts *KeyConfig) (*Key, error)
+	importKey(pub crypto.PublicKey, sensitive []byte, parent ParentKeyConfig) (*Key, error)
 	pcrs(alg HashAlg) ([]PCR, error)
 	measurementLog() ([]byte, error)
 }
 
 // TPM interfaces with a TPM device on the system.
+//
+// A TPM 2.0 TPM, and the keys and AKs used with it, are safe for concurrent
+// use by multiple goroutines: commands are sent to the TPM one at a time.
+// Operations made of several commands, such as creating a key, may still
+// interleave, so each holds its own TPM objects, and running many at once
+// can exhaust the TPM's memory for loaded keys (ErrTPMObjectMemory).
 type TPM struct {
 	// tpm refers to a concrete implementation of TPM logic, based on the current
 	// platform and TPM version.
@@ -375,6 +866,11 @@ func (t *TPM) EKs() ([]EK, error) {
 
 // EKCertificates returns the endorsement key certificates burned-in to the platform.
 // It is guaranteed that each EK.Certificate field will be populated.
+//
+// On TPM 2.0, the RSA and ECC EK certificates are read from the NV indices
+// defined by the TCG EK Credential Profile (0x01c00002 and 0x01c0000a).
+// Indices which are absent or cannot be read are skipped, so an empty
+// result is returned if the TPM has no EK certificates provisioned.
 func (t *TPM) EKCertificates() ([]EK, error) {
 	return t.tpm.ekCertificates()
 }
@@ -398,6 +894,21 @@ func (t *TPM) LoadAKWithParent(opaqueBlob []byte, parent ParentKeyConfig) (*AK,
 	return t.tpm.loadAKWithParent(opaqueBlob, parent)
 }
 
+// LoadAKFromHandle returns an AK for a key which has already been made
+// persistent at handle, without needing a blob from AK.Marshal(). public
+// is the TPM 2.0 public area of the AK, such as AttestationParameters.Public
+// from enrollment, and an error is returned if it does not match the key
+// at handle.
+//
+// The creation data of the key is not available, so the returned AK's
+// AttestationParameters only holds its public area. The AK can be used
+// for quotes and certification, and is left in place by Close.
+//
+// This is only supported on TPM 2.0.
+func (t *TPM) LoadAKFromHandle(handle tpmutil.Handle, public []byte) (*AK, error) {
+	return t.tpm.loadAKFromHandle(handle, public)
+}
+
 // MeasurementLog returns the present value of the System Measurement Log.
 //
 // This is a low-level API. Consumers seeking to attest the state of the
@@ -421,16 +932,104 @@ func (t *TPM) NewAK(opts *AKConfig) (*AK, error) {
 	return t.tpm.newAK(opts)
 }
 
+// NewAKContext is like NewAK, but returns ctx.Err() if ctx is done before
+// the attestation key has been created. For TPM 2.0 devices, ctx is checked
+// before each command is sent to the TPM; otherwise it is only checked
+// before the operation starts.
+func (t *TPM) NewAKContext(ctx context.Context, opts *AKConfig) (*AK, error) {
+	tb, err := t.withContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ak, err := tb.newAK(opts)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return ak, err
+}
+
 // NewKey creates an application key certified by the attestation key. If opts is nil
 // then DefaultConfig is used.
 func (t *TPM) NewKey(ak *AK, opts *KeyConfig) (*Key, error) {
+	return t.tpm.newKey(ak, keyConfigOrDefault(opts))
+}
+
+// CertifyKey creates an application key as described by cfg and certifies
+// it with ak using TPM2_Certify, returning the key and its certification.
+// The certification can be checked against the public key of ak with
+// CertificationParameters.Verify. It is equivalent to NewKey followed by
+// Key.CertificationParameters.
+func (t *TPM) CertifyKey(ak *AK, cfg KeyConfig) (*Key, CertificationParameters, error) {
+	if ak == nil {
+		return nil, CertificationParameters{}, errors.New("no AK provided to certify the key")
+	}
+	k, err := t.NewKey(ak, &cfg)
+	if err != nil {
+		return nil, CertificationParameters{}, err
+	}
+	return k, k.CertificationParameters(), nil
+}
+
+// NewKeyContext is like NewKey, but returns ctx.Err() if ctx is done before
+// the key has been created and certified. See NewAKContext for how ctx is
+// honored.
+func (t *TPM) NewKeyContext(ctx context.Context, ak *AK, opts *KeyConfig) (*Key, error) {
+	tb, err := t.withContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	k, err := tb.newKey(ak, keyConfigOrDefault(opts))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	// The key must not use ctx once it has been created.
+	k.tpm = t.tpm
+	return k, nil
+}
+
+// ImportKey imports an externally generated signing key into the TPM under
+// parent, and returns it as a Key. pub is the public key, and sensitive
+// the corresponding private key, DER-encoded in PKCS #8 form. If parent
+// is nil, the default SRK is used.
+//
+// The private key is encrypted to the parent before being sent to the TPM.
+// As the key was not generated by the TPM, it is not certified by an AK,
+// and its CertificationParameters are empty.
+//
+// This is only supported on TPM 2.0.
+func (t *TPM) ImportKey(pub crypto.PublicKey, sensitive []byte, parent *ParentKeyConfig) (*Key, error) {
+	if parent == nil {
+		parent = &defaultParentConfig
+	}
+	return t.tpm.importKey(pub, sensitive, *parent)
+}
+
+func keyConfigOrDefault(opts *KeyConfig) *KeyConfig {
 	if opts == nil {
-		opts = defaultConfig
+		return defaultConfig
 	}
 	if opts.Algorithm == "" && opts.Size == 0 {
-		opts = defaultConfig
+		// Keep the other options, such as QualifyingData or Parent.
+		c := *opts
+		c.Algorithm, c.Size = defaultConfig.Algorithm, defaultConfig.Size
+		return &c
 	}
-	return t.tpm.newKey(ak, opts)
+	return opts
+}
+
+// withContext returns the TPM implementation to use for an operation
+// bounded by ctx.
+func (t *TPM) withContext(ctx context.Context) (tpmBase, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if w, ok := t.tpm.(*wrappedTPM20); ok {
+		return w.withContext(ctx), nil
+	}
+	return t.tpm, nil
 }
 
 // LoadKey loads a previously-created application key into the TPM for use.
@@ -441,6 +1040,53 @@ func (t *TPM) LoadKey(opaqueBlob []byte) (*Key, error) {
 	return t.tpm.loadKey(opaqueBlob)
 }
 
+// LoadKeyWithParent loads a previously-created application key into the TPM
+// under the given parent for use. The parent must match the one the key was
+// created under.
+func (t *TPM) LoadKeyWithParent(opaqueBlob []byte, parent ParentKeyConfig) (*Key, error) {
+	return t.tpm.loadKeyWithParent(opaqueBlob, parent)
+}
+
+// LoadKeyFromHandle returns an application key for a key which has already
+// been made persistent at handle. public is the TPM 2.0 public area of the
+// key, and an error is returned if it does not match the key at handle.
+// As with LoadAKFromHandle, the key's certification parameters are not
+// available.
+//
+// This is only supported on TPM 2.0.
+func (t *TPM) LoadKeyFromHandle(handle tpmutil.Handle, public []byte) (*Key, error) {
+	return t.tpm.loadKeyFromHandle(handle, public)
+}
+
+// SupportedAlgorithms returns the key algorithms supported by the TPM.
+// Algorithms which are supported by the TPM may not be supported for
+// key creation on every platform.
+func (t *TPM) SupportedAlgorithms() ([]Algorithm, error) {
+	return t.tpm.supportedAlgorithms()
+}
+
+// ReadClock returns the current state of the TPM's clock, for example as
+// a reference against which the clock of later quotes is checked with
+// ClockWindow. Only supported for TPM 2.0 devices.
+//
+// The reading is not signed by the TPM, so verifiers should only trust it
+// as far as they trust the connection to the device. ResetCount and
+// RestartCount are not obfuscated, so they can't be compared with those
+// of quotes by AKs created by this package.
+func (t *TPM) ReadClock() (ClockInfo, error) {
+	return t.tpm.readClock()
+}
+
+// PersistentHandles returns the handles of the objects which have been made
+// persistent on the TPM, such as keys loaded with LoadAKFromHandle or
+// LoadKeyFromHandle, in increasing order. It can be used to find a free
+// handle before making another key persistent.
+//
+// This is only supported on TPM 2.0.
+func (t *TPM) PersistentHandles() ([]tpmutil.Handle, error) {
+	return t.tpm.persistentHandles()
+}
+
 // PCRs returns the present value of Platform Configuration Registers with
 // the given digest algorithm.
 //
@@ -0,0 +1,92 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !notpm12
+// +build !notpm12
+
+package attest
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/tpm"
+	"github.com/google/go-tspi/verification"
+)
+
+// tpm12Built reports whether TPM 1.2 support is built, which the notpm12
+// build tag disables.
+const tpm12Built = true
+
+// parsePublic12 parses a TPM_PUBKEY structure.
+func parsePublic12(public []byte) (*rsa.PublicKey, error) {
+	return tpm.UnmarshalPubRSAPublicKey(public)
+}
+
+func (p *ActivationParameters) checkTPM12AKParameters() (err error) {
+	defer func() {
+		if err != nil {
+			p.logDebug("AK check failed", "error", err)
+		}
+	}()
+	if err := p.checkAKFieldSizes(); err != nil {
+		return err
+	}
+	if err := checkTPM12RSAKey(p.AK.Public); err != nil {
+		return err
+	}
+	if len(p.AllowedAKTemplates) > 0 {
+		return rejectionErrorf(ErrAKTemplateNotAllowed, "AK templates can only be checked for TPM 2.0 AKs")
+	}
+	_, props, err := ParsePublic(TPMVersion12, p.AK.Public)
+	if err != nil {
+		return err
+	}
+	p.logDebug("AK public key decoded", "bits", props.Bits)
+	if minBits := p.minAKBits(); props.Bits < minBits {
+		return rejectionErrorf(ErrAKTooSmall, "attestation key too small: must be at least %d bits but was %d bits", minBits, props.Bits)
+	}
+	p.logDebug("AK key size checked")
+	return nil
+}
+
+func (p *ActivationParameters) generateChallengeTPM12(rand io.Reader, secret []byte) (*EncryptedCredential, error) {
+	pk, ok := p.EK.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("TPM 1.2 supports only RSA endorsement keys, got EK of type %T", p.EK)
+	}
+	if err := checkTPM12RSAKey(p.AK.Public); err != nil {
+		return nil, err
+	}
+
+	var (
+		cred, encSecret []byte
+		err             error
+	)
+	if p.AK.UseTCSDActivationFormat {
+		cred, encSecret, err = verification.GenerateChallengeEx(pk, p.AK.Public, secret)
+	} else {
+		cred, encSecret, err = generateChallenge12(rand, pk, p.AK.Public, secret)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("challenge generation failed: %v", err)
+	}
+	return &EncryptedCredential{
+		Credential: cred,
+		Secret:     encSecret,
+		Parameters: &CredentialParameters{Cipher: "AES-128-CBC"},
+	}, nil
+}
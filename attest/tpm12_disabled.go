@@ -0,0 +1,39 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build notpm12
+// +build notpm12
+
+package attest
+
+import (
+	"crypto/rsa"
+	"io"
+)
+
+// tpm12Built reports whether TPM 1.2 support is built, which the notpm12
+// build tag disables.
+const tpm12Built = false
+
+func parsePublic12(public []byte) (*rsa.PublicKey, error) {
+	return nil, ErrTPM12NotBuilt
+}
+
+func (p *ActivationParameters) checkTPM12AKParameters() error {
+	return ErrTPM12NotBuilt
+}
+
+func (p *ActivationParameters) generateChallengeTPM12(rand io.Reader, secret []byte) (*EncryptedCredential, error) {
+	return nil, ErrTPM12NotBuilt
+}
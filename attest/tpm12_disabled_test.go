@@ -0,0 +1,44 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build notpm12
+// +build notpm12
+
+package attest
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTPM12NotBuilt(t *testing.T) {
+	dump := loadTPM12Dump(t)
+	p := ActivationParameters{
+		TPMVersion: TPMVersion12,
+		AK:         dump.AK,
+		EK:         &ekCertSigner(t).PublicKey,
+	}
+	if err := p.CheckAKParameters(); !errors.Is(err, ErrTPM12NotBuilt) {
+		t.Errorf("CheckAKParameters() = %v, want ErrTPM12NotBuilt", err)
+	}
+	if _, _, err := p.Generate(); !errors.Is(err, ErrTPM12NotBuilt) {
+		t.Errorf("Generate() = %v, want ErrTPM12NotBuilt", err)
+	}
+	if _, err := NewActivator(ActivatorConfig{TPMVersion: TPMVersion12}); !errors.Is(err, ErrTPM12NotBuilt) {
+		t.Errorf("NewActivator() = %v, want ErrTPM12NotBuilt", err)
+	}
+	if _, _, err := ParsePublic(TPMVersion12, dump.AK.Public); !errors.Is(err, ErrTPM12NotBuilt) {
+		t.Errorf("ParsePublic() = %v, want ErrTPM12NotBuilt", err)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build windows && notpm12
+// +build windows,notpm12
+
+package attest
+
+import (
+	"io"
+
+	"github.com/google/go-tpm/tpmutil"
+)
+
+func quote12(tpm io.ReadWriter, hnd tpmutil.Handle, nonce []byte, selectedPCRs []int) ([]byte, []byte, error) {
+	return nil, nil, ErrTPM12NotBuilt
+}
+
+func readTPM12VendorAttributes(tpm io.ReadWriter) (TCGVendorID, string, error) {
+	return TCGVendorID(0), "", ErrTPM12NotBuilt
+}
+
+func allPCRs12(tpm io.ReadWriter) (map[uint32][]byte, error) {
+	return nil, ErrTPM12NotBuilt
+}
@@ -12,8 +12,8 @@
 // License for the specific language governing permissions and limitations under
 // the License.
 
-//go:build linux && !gofuzz && cgo && tspi
-// +build linux,!gofuzz,cgo,tspi
+//go:build linux && !gofuzz && cgo && tspi && !notpm12
+// +build linux,!gofuzz,cgo,tspi,!notpm12
 
 package attest
 
@@ -21,9 +21,11 @@ import (
 	"crypto"
 	"crypto/x509"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
 
+	"github.com/google/go-tpm/tpmutil"
 	"github.com/google/go-tspi/attestation"
 	"github.com/google/go-tspi/tspi"
 	"github.com/google/go-tspi/tspiconst"
@@ -86,6 +88,18 @@ func (t *trousersTPM) info() (*TPMInfo, error) {
 	return &tInfo, nil
 }
 
+func (t *trousersTPM) supportedAlgorithms() ([]Algorithm, error) {
+	return []Algorithm{RSA}, nil
+}
+
+func (t *trousersTPM) readClock() (ClockInfo, error) {
+	return ClockInfo{}, errors.New("reading the clock is only supported on TPM 2.0")
+}
+
+func (t *trousersTPM) persistentHandles() ([]tpmutil.Handle, error) {
+	return nil, errors.New("listing persistent handles is only supported on TPM 2.0")
+}
+
 func readEKCertFromNVRAM12(ctx *tspi.Context) (*x509.Certificate, error) {
 	ekCert, err := attestation.GetEKCert(ctx)
 	if err != nil {
@@ -112,6 +126,10 @@ func (t *trousersTPM) newKey(*AK, *KeyConfig) (*Key, error) {
 	return nil, fmt.Errorf("not implemented")
 }
 
+func (t *trousersTPM) importKey(crypto.PublicKey, []byte, ParentKeyConfig) (*Key, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
 func (t *trousersTPM) loadKey(opaqueBlob []byte) (*Key, error) {
 	return nil, fmt.Errorf("not implemented")
 }
@@ -120,7 +138,18 @@ func (t *trousersTPM) loadKeyWithParent(opaqueBlob []byte, parent ParentKeyConfi
 	return nil, fmt.Errorf("not implemented")
 }
 
+func (t *trousersTPM) loadKeyFromHandle(tpmutil.Handle, []byte) (*Key, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (t *trousersTPM) loadAKFromHandle(tpmutil.Handle, []byte) (*AK, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
 func (t *trousersTPM) newAK(opts *AKConfig) (*AK, error) {
+	if err := checkAKConfigNoAuth(opts); err != nil {
+		return nil, err
+	}
 	pub, blob, err := attestation.CreateAIK(t.ctx)
 	if err != nil {
 		return nil, fmt.Errorf("CreateAIK failed: %v", err)
@@ -0,0 +1,40 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !notpm12
+// +build !notpm12
+
+package attest
+
+import (
+	"testing"
+)
+
+func TestTPM12Built(t *testing.T) {
+	dump := loadTPM12Dump(t)
+	p := ActivationParameters{
+		TPMVersion: TPMVersion12,
+		AK:         dump.AK,
+		EK:         &ekCertSigner(t).PublicKey,
+	}
+	if err := p.CheckAKParameters(); err != nil {
+		t.Errorf("CheckAKParameters() failed: %v", err)
+	}
+	if _, _, err := p.Generate(); err != nil {
+		t.Errorf("Generate() failed: %v", err)
+	}
+	if _, err := NewActivator(ActivatorConfig{TPMVersion: TPMVersion12}); err != nil {
+		t.Errorf("NewActivator() failed: %v", err)
+	}
+}
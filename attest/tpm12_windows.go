@@ -0,0 +1,74 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build windows && !notpm12
+// +build windows,!notpm12
+
+package attest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	tpm1 "github.com/google/go-tpm/tpm"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+var wellKnownAuth [20]byte
+
+// quote12 quotes the PCRs with the TPM 1.2 key at hnd, returning the
+// TPM_QUOTE_INFO structure and its signature.
+func quote12(tpm io.ReadWriter, hnd tpmutil.Handle, nonce []byte, selectedPCRs []int) ([]byte, []byte, error) {
+	sig, pcrc, err := tpm1.Quote(tpm, hnd, nonce, selectedPCRs[:], wellKnownAuth[:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("Quote() failed: %v", err)
+	}
+	// Construct and return TPM_QUOTE_INFO
+	// Returning TPM_QUOTE_INFO allows us to verify the Quote at a higher resolution
+	// and matches what go-tspi returns.
+	quote, err := tpm1.NewQuoteInfo(nonce, selectedPCRs[:], pcrc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to construct Quote Info: %v", err)
+	}
+	return quote, sig, nil
+}
+
+func readTPM12VendorAttributes(tpm io.ReadWriter) (TCGVendorID, string, error) {
+	vendor, err := tpm1.GetManufacturer(tpm)
+	if err != nil {
+		return TCGVendorID(0), "", fmt.Errorf("tpm1.GetCapability failed: %v", err)
+	}
+	vendorID := TCGVendorID(binary.BigEndian.Uint32(vendor))
+	return vendorID, vendorID.String(), nil
+}
+
+func allPCRs12(tpm io.ReadWriter) (map[uint32][]byte, error) {
+	numPCRs := 24
+	out := map[uint32][]byte{}
+
+	for pcr := 0; pcr < numPCRs; pcr++ {
+		pcrval, err := tpm1.ReadPCR(tpm, uint32(pcr))
+		if err != nil {
+			return nil, fmt.Errorf("tpm.ReadPCR() failed with err: %v", err)
+		}
+		out[uint32(pcr)] = pcrval
+	}
+
+	if len(out) != numPCRs {
+		return nil, fmt.Errorf("failed to read all PCRs, only read %d", len(out))
+	}
+
+	return out, nil
+}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"strings"
@@ -39,10 +40,12 @@ var getTPM12Impl func() (*TPM, error)
 // the provided simulated TPM. This method should be used for testing
 // only.
 func InjectSimulatedTPMForTest(rwc io.ReadWriteCloser) *TPM {
-	return &TPM{tpm: &wrappedTPM20{
+	w := &wrappedTPM20{
 		interf: TPMInterfaceCommandChannel,
 		rwc:    &fakeCmdChannel{rwc},
-	}}
+	}
+	w.serialize()
+	return &TPM{tpm: w}
 }
 
 func probeSystemTPMs() ([]probedTPM, error) {
@@ -77,16 +80,25 @@ func probeSystemTPMs() ([]probedTPM, error) {
 
 type linuxCmdChannel struct {
 	io.ReadWriteCloser
+	// logPath is the path of the measurement log of the TPM. If empty,
+	// that of the first TPM is used.
+	logPath string
 }
 
 // MeasurementLog implements CommandChannelTPM20.
 func (cc *linuxCmdChannel) MeasurementLog() ([]byte, error) {
+	if cc.logPath != "" {
+		return os.ReadFile(cc.logPath)
+	}
 	return os.ReadFile("/sys/kernel/security/tpm0/binary_bios_measurements")
 }
 
 func openTPM(tpm probedTPM) (*TPM, error) {
 	switch tpm.Version {
 	case TPMVersion12:
+		if !tpm12Built {
+			return nil, ErrTPM12NotBuilt
+		}
 		if getTPM12Impl == nil {
 			return nil, errors.New("support for Linux TPM 1.2 disabled (build with CGO to enable)")
 		}
@@ -114,10 +126,76 @@ func openTPM(tpm probedTPM) (*TPM, error) {
 
 		return &TPM{tpm: &wrappedTPM20{
 			interf: interf,
-			rwc:    &linuxCmdChannel{rwc},
+			rwc:    &linuxCmdChannel{ReadWriteCloser: rwc},
 		}}, nil
 
 	default:
 		return nil, fmt.Errorf("unsuported TPM version: %v", tpm.Version)
 	}
 }
+
+// openTPMDevice opens the TPM 2.0 character device at config.DevicePath,
+// or its resource manager if config.PreferResourceManager is set and the
+// kernel provides one.
+func openTPMDevice(config OpenConfig) (*TPM, error) {
+	if config.TPMVersion == TPMVersion12 {
+		return nil, errors.New("device paths can only be used with TPM 2.0 devices")
+	}
+	devPath := config.DevicePath
+	if config.PreferResourceManager {
+		if rmPath, ok := tpmDeviceResourceManager(devPath); ok {
+			devPath = rmPath
+		}
+	}
+	if _, err := os.Stat(devPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("TPM device %s does not exist: %w", devPath, fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("TPM device %s: %v", devPath, err)
+	}
+
+	rwc, err := tpm2.OpenTPM(devPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening TPM device %s: %v", devPath, err)
+	}
+	interf := TPMInterfaceDirect
+	if strings.HasPrefix(path.Base(devPath), "tpmrm") {
+		interf = TPMInterfaceKernelManaged
+	}
+	cc := &linuxCmdChannel{ReadWriteCloser: rwc}
+	if n, ok := tpmDeviceNumber(devPath); ok {
+		cc.logPath = path.Join("/sys/kernel/security", "tpm"+n, "binary_bios_measurements")
+	}
+	return &TPM{tpm: &wrappedTPM20{
+		interf: interf,
+		rwc:    cc,
+	}}, nil
+}
+
+// tpmDeviceNumber returns the number of a TPM device, such as "0" for both
+// /dev/tpm0 and /dev/tpmrm0.
+func tpmDeviceNumber(devPath string) (string, bool) {
+	base := path.Base(devPath)
+	n := strings.TrimPrefix(base, "tpmrm")
+	if n == base {
+		n = strings.TrimPrefix(base, "tpm")
+	}
+	if n == base || n == "" || strings.Trim(n, "0123456789") != "" {
+		return "", false
+	}
+	return n, true
+}
+
+// tpmDeviceResourceManager returns the path of the resource manager the
+// kernel provides for the raw TPM device at devPath, if it exists.
+func tpmDeviceResourceManager(devPath string) (string, bool) {
+	n, ok := tpmDeviceNumber(devPath)
+	if !ok || strings.HasPrefix(path.Base(devPath), "tpmrm") {
+		return "", false
+	}
+	rmPath := path.Join(path.Dir(devPath), "tpmrm"+n)
+	if _, err := os.Stat(rmPath); err != nil {
+		return "", false
+	}
+	return rmPath, true
+}
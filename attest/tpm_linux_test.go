@@ -0,0 +1,76 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build linux && !gofuzz
+// +build linux,!gofuzz
+
+package attest
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenTPMDevicePathMissing(t *testing.T) {
+	devPath := filepath.Join(t.TempDir(), "tpmrm7")
+	_, err := OpenTPM(&OpenConfig{DevicePath: devPath})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenTPM(%q) err = %v, want errors.Is(err, fs.ErrNotExist)", devPath, err)
+	}
+
+	if _, err := OpenTPM(&OpenConfig{DevicePath: devPath, TPMVersion: TPMVersion12}); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenTPM() with TPM 1.2 err = %v, want version error", err)
+	}
+}
+
+func TestTPMDeviceNumber(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"/dev/tpm0", "0", true},
+		{"/dev/tpmrm12", "12", true},
+		{"/dev/tpm", "", false},
+		{"/dev/tpmrm", "", false},
+		{"/dev/tpm0x", "", false},
+		{"/dev/vtpmx", "", false},
+	} {
+		got, ok := tpmDeviceNumber(test.path)
+		if got != test.want || ok != test.ok {
+			t.Errorf("tpmDeviceNumber(%q) = %q, %v, want %q, %v", test.path, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestTPMDeviceResourceManager(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"tpm0", "tpmrm0", "tpm1"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, ok := tpmDeviceResourceManager(filepath.Join(dir, "tpm0")); !ok || got != filepath.Join(dir, "tpmrm0") {
+		t.Errorf("tpmDeviceResourceManager(tpm0) = %q, %v, want tpmrm0", got, ok)
+	}
+	if got, ok := tpmDeviceResourceManager(filepath.Join(dir, "tpm1")); ok {
+		t.Errorf("tpmDeviceResourceManager(tpm1) = %q, want no resource manager", got)
+	}
+	if got, ok := tpmDeviceResourceManager(filepath.Join(dir, "tpmrm0")); ok {
+		t.Errorf("tpmDeviceResourceManager(tpmrm0) = %q, want no resource manager", got)
+	}
+}
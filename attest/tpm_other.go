@@ -30,3 +30,7 @@ func probeSystemTPMs() ([]probedTPM, error) {
 func openTPM(tpm probedTPM) (*TPM, error) {
 	return nil, errUnsupported
 }
+
+func openTPMDevice(config OpenConfig) (*TPM, error) {
+	return nil, errUnsupported
+}
@@ -1,11 +1,21 @@
 package attest
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
 	"fmt"
+	"math/big"
 	"testing"
+	"time"
 )
 
 // Created by downloading the base64-url encoded PEM data from
@@ -56,3 +66,379 @@ func TestIntelEKURL(t *testing.T) {
 		t.Fatalf("intelEKURL(), got=%q, want=%q", got, want)
 	}
 }
+
+// newTestCA returns a self-signed CA certificate and its private key.
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test TPM Manufacturer Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
+	if err != nil {
+		t.Fatalf("creating CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing CA certificate: %v", err)
+	}
+	return cert, priv
+}
+
+// marshalTestSAN returns a Subject Alternative Name extension holding only
+// the given directoryName. If implicit is set, the directoryName is
+// incorrectly encoded with an implicit tag, as some TPM vendors do.
+func marshalTestSAN(t *testing.T, rdns pkix.RDNSequence, implicit bool) []byte {
+	t.Helper()
+	name, err := asn1.Marshal(rdns)
+	if err != nil {
+		t.Fatalf("marshaling directoryName: %v", err)
+	}
+	if implicit {
+		var seq asn1.RawValue
+		if _, err := asn1.Unmarshal(name, &seq); err != nil {
+			t.Fatalf("unmarshaling directoryName: %v", err)
+		}
+		name = seq.Bytes
+	}
+	san, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: name}})
+	if err != nil {
+		t.Fatalf("marshaling subjectAltName: %v", err)
+	}
+	return san
+}
+
+// newTestEKCertificate returns an EK certificate issued by the given CA,
+// laid out like those issued by TPM manufacturers: an empty subject, the
+// given critical Subject Alternative Name extension and the TCG EK
+// certificate extended key usage.
+func newTestEKCertificate(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, sanExt []byte) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber:       big.NewInt(2),
+		NotBefore:          time.Now().Add(-time.Hour),
+		NotAfter:           time.Now().Add(time.Hour),
+		KeyUsage:           x509.KeyUsageKeyEncipherment,
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{{2, 23, 133, 8, 1}},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidSubjectAltName, Critical: true, Value: sanExt},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, testRSAKey, caKey)
+	if err != nil {
+		t.Fatalf("creating EK certificate: %v", err)
+	}
+	cert, err := ParseEKCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseEKCertificate() failed: %v", err)
+	}
+	return cert
+}
+
+var testEKCertSAN = pkix.RDNSequence{
+	{
+		{Type: asn1.ObjectIdentifier{2, 23, 133, 2, 1}, Value: "id:53544D20"},
+		{Type: asn1.ObjectIdentifier{2, 23, 133, 2, 2}, Value: "ST33HTPHAHD4"},
+		{Type: asn1.ObjectIdentifier{2, 23, 133, 2, 3}, Value: "id:00010102"},
+	},
+}
+
+func TestVerifyEKCertificate(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	ekCert := newTestEKCertificate(t, ca, caKey, marshalTestSAN(t, testEKCertSAN, false))
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	// Go's default verification rejects the critical SAN extension.
+	if _, err := ekCert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err == nil {
+		t.Fatal("x509.Certificate.Verify() succeeded, want error")
+	}
+	if err := VerifyEKCertificate(ekCert, roots, nil); err != nil {
+		t.Fatalf("VerifyEKCertificate() failed: %v", err)
+	}
+	if len(ekCert.UnhandledCriticalExtensions) == 0 {
+		t.Error("VerifyEKCertificate() modified the provided certificate")
+	}
+
+	otherCA, _ := newTestCA(t)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherCA)
+	if err := VerifyEKCertificate(ekCert, otherRoots, nil); err == nil {
+		t.Error("VerifyEKCertificate() succeeded with untrusted roots, want error")
+	}
+	if err := VerifyEKCertificate(ekCert, nil, nil); err == nil {
+		t.Error("VerifyEKCertificate() succeeded without roots, want error")
+	}
+	if err := VerifyEKCertificate(nil, roots, nil); err == nil {
+		t.Error("VerifyEKCertificate() succeeded without a certificate, want error")
+	}
+}
+
+func TestVerifyEKCertificateIntermediates(t *testing.T) {
+	root, rootKey := newTestCA(t)
+	intermediateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating intermediate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(3),
+		Subject:               pkix.Name{CommonName: "Test TPM Manufacturer Intermediate CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, root, intermediateKey.Public(), rootKey)
+	if err != nil {
+		t.Fatalf("creating intermediate CA certificate: %v", err)
+	}
+	intermediate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing intermediate CA certificate: %v", err)
+	}
+	ekCert := newTestEKCertificate(t, intermediate, intermediateKey, marshalTestSAN(t, testEKCertSAN, false))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+	if err := VerifyEKCertificate(ekCert, roots, intermediates); err != nil {
+		t.Fatalf("VerifyEKCertificate() failed: %v", err)
+	}
+	if err := VerifyEKCertificate(ekCert, roots, nil); err == nil {
+		t.Error("VerifyEKCertificate() succeeded without the intermediate, want error")
+	}
+	if _, err := VerifyEK(ekCert.PublicKey, ekCert, EKVerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Errorf("VerifyEK() failed: %v", err)
+	}
+}
+
+func TestVerifyEK(t *testing.T) {
+	hwCA, hwKey := newTestCA(t)
+	cloudCA, cloudKey := newTestCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(hwCA)
+	virtualRoots := x509.NewCertPool()
+	virtualRoots.AddCert(cloudCA)
+	hwCert := newTestEKCertificate(t, hwCA, hwKey, marshalTestSAN(t, testEKCertSAN, false))
+	cloudCert := newTestEKCertificate(t, cloudCA, cloudKey, marshalTestSAN(t, testEKCertSAN, false))
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+	strict := EKVerifyOptions{Roots: roots}
+	vtpm := EKVerifyOptions{Roots: roots, VirtualTPM: true, VirtualRoots: virtualRoots}
+
+	for _, test := range []struct {
+		name string
+		ek   crypto.PublicKey
+		cert *x509.Certificate
+		opts EKVerifyOptions
+		want EKTrust
+	}{
+		{"hardware", testRSAKey, hwCert, strict, EKTrustHardwareRoot},
+		{"hardware in vTPM mode", testRSAKey, hwCert, vtpm, EKTrustHardwareRoot},
+		{"virtual", testRSAKey, cloudCert, vtpm, EKTrustVirtualRoot},
+		{"public key only", testRSAKey, nil, vtpm, EKTrustPublicKeyOnly},
+		{"virtual root without vTPM mode", testRSAKey, cloudCert, EKVerifyOptions{Roots: roots, VirtualRoots: virtualRoots}, 0},
+		{"no certificate without vTPM mode", testRSAKey, nil, strict, 0},
+		{"mismatched EK", otherKey.Public(), hwCert, vtpm, 0},
+		{"untrusted certificate", testRSAKey, cloudCert, EKVerifyOptions{Roots: roots, VirtualTPM: true}, 0},
+		{"no EK", nil, hwCert, vtpm, 0},
+	} {
+		got, err := VerifyEK(test.ek, test.cert, test.opts)
+		if test.want == 0 {
+			if err == nil {
+				t.Errorf("%s: VerifyEK() = %+v, want error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: VerifyEK() failed: %v", test.name, err)
+			continue
+		}
+		if got.Trust != test.want || got.Certificate != test.cert {
+			t.Errorf("%s: VerifyEK() = %+v, want trust %v and certificate %p", test.name, got, test.want, test.cert)
+		}
+		if got.HardwareRooted() != (test.want == EKTrustHardwareRoot) {
+			t.Errorf("%s: HardwareRooted() = %v with trust %v", test.name, got.HardwareRooted(), got.Trust)
+		}
+	}
+}
+
+func TestParseEKCertificateInfo(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	want := EKInfo{
+		Manufacturer:         TCGVendorID(0x53544D20),
+		Model:                "ST33HTPHAHD4",
+		FirmwareVersionMajor: 1,
+		FirmwareVersionMinor: 0x102,
+	}
+
+	for _, test := range []struct {
+		name     string
+		san      pkix.RDNSequence
+		implicit bool
+		want     EKInfo
+	}{
+		{
+			name: "spec compliant",
+			san:  testEKCertSAN,
+			want: want,
+		},
+		{
+			name:     "implicit directoryName",
+			san:      testEKCertSAN,
+			implicit: true,
+			want:     want,
+		},
+		{
+			name: "separate RDNs",
+			san: pkix.RDNSequence{
+				{{Type: oidTPMManufacturer, Value: "id:53544D20"}},
+				{{Type: oidTPMModel, Value: "ST33HTPHAHD4"}},
+				{{Type: oidTPMVersion, Value: "id:00010102"}},
+			},
+			want: want,
+		},
+		{
+			name: "missing prefix and version",
+			san: pkix.RDNSequence{
+				{{Type: oidTPMManufacturer, Value: "494e5443"}},
+				{{Type: oidTPMModel, Value: "SLB9670"}},
+			},
+			want: EKInfo{Manufacturer: TCGVendorID(0x494E5443), Model: "SLB9670"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cert := newTestEKCertificate(t, ca, caKey, marshalTestSAN(t, test.san, test.implicit))
+			got, err := ParseEKCertificateInfo(cert)
+			if err != nil {
+				t.Fatalf("ParseEKCertificateInfo() failed: %v", err)
+			}
+			if *got != test.want {
+				t.Errorf("ParseEKCertificateInfo() = %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		name string
+		san  pkix.RDNSequence
+	}{
+		{
+			name: "missing manufacturer",
+			san:  pkix.RDNSequence{{{Type: oidTPMModel, Value: "ST33HTPHAHD4"}}},
+		},
+		{
+			name: "bad manufacturer",
+			san:  pkix.RDNSequence{{{Type: oidTPMManufacturer, Value: "id:STM"}}},
+		},
+		{
+			name: "bad version",
+			san: pkix.RDNSequence{{
+				{Type: oidTPMManufacturer, Value: "id:53544D20"},
+				{Type: oidTPMVersion, Value: "id:123456789"},
+			}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cert := newTestEKCertificate(t, ca, caKey, marshalTestSAN(t, test.san, false))
+			if _, err := ParseEKCertificateInfo(cert); err == nil {
+				t.Error("ParseEKCertificateInfo() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestEKPublicFromCert(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	rsaCert := newTestEKCertificate(t, ca, caKey, marshalTestSAN(t, testEKCertSAN, false))
+	eccKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eccPoint := elliptic.Marshal(eccKey.Curve, eccKey.X, eccKey.Y)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, eccKey.Public(), caKey)
+	if err != nil {
+		t.Fatalf("creating EK certificate: %v", err)
+	}
+	eccCert, err := ParseEKCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseEKCertificate() failed: %v", err)
+	}
+
+	// Some EK certificates identify RSA keys as RSAES-OAEP keys.
+	oaepSPKI := bytes.Replace(rsaCert.RawSubjectPublicKeyInfo,
+		[]byte{0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x01, 0x01},
+		[]byte{0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x01, 0x07}, 1)
+	if bytes.Equal(oaepSPKI, rsaCert.RawSubjectPublicKeyInfo) {
+		t.Fatal("RSA EK certificate does not use the rsaEncryption OID")
+	}
+	// Others omit the curve of ECC keys.
+	noCurveSPKI, err := asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA},
+		PublicKey: asn1.BitString{Bytes: eccPoint, BitLength: 8 * len(eccPoint)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		cert    *x509.Certificate
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{name: "RSA", cert: rsaCert, want: testRSAKey},
+		{name: "RSAES-OAEP", cert: &x509.Certificate{RawSubjectPublicKeyInfo: oaepSPKI}, want: testRSAKey},
+		{name: "ECC", cert: eccCert, want: &eccKey.PublicKey},
+		{name: "ECC without curve", cert: &x509.Certificate{RawSubjectPublicKeyInfo: noCurveSPKI}, want: &eccKey.PublicKey},
+		{name: "P224", cert: &x509.Certificate{PublicKey: &p224Key.PublicKey}, wantErr: true},
+		{name: "Ed25519", cert: &x509.Certificate{PublicKey: ed25519.PublicKey(make([]byte, ed25519.PublicKeySize))}, wantErr: true},
+		{name: "no certificate", wantErr: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := EKPublicFromCert(test.cert)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("EKPublicFromCert() returned err = %v, wantErr = %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !got.(interface{ Equal(crypto.PublicKey) bool }).Equal(test.want) {
+				t.Errorf("EKPublicFromCert() = %v, want %v", got, test.want)
+			}
+			params := ActivationParameters{
+				TPMVersion: TPMVersion20,
+				EK:         got,
+				AK:         rsaAKParameters(t),
+			}
+			if _, _, err := params.Generate(); err != nil {
+				t.Errorf("Generate() with the EK failed: %v", err)
+			}
+		})
+	}
+}
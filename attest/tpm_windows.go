@@ -19,6 +19,7 @@ package attest
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -26,16 +27,14 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
 	"math/big"
 
-	tpm1 "github.com/google/go-tpm/tpm"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
 	tpmtbs "github.com/google/go-tpm/tpmutil/tbs"
 	"golang.org/x/sys/windows"
 )
 
-var wellKnownAuth [20]byte
-
 type windowsTPM struct {
 	version TPMVersion
 	pcp     *winPCP
@@ -84,6 +83,21 @@ func tbsConvertVersion(info tbsDeviceInfo) (TPMVersion, error) {
 	}
 }
 
+// openTPMDevice opens the TPM exposed through TBS. Windows exposes a single
+// TPM, so the device path is ignored.
+func openTPMDevice(config OpenConfig) (*TPM, error) {
+	tpms, err := probeSystemTPMs()
+	if err != nil {
+		return nil, err
+	}
+	for _, tpm := range tpms {
+		if tpm.MatchesConfig(config) {
+			return openTPM(tpm)
+		}
+	}
+	return nil, ErrTPMNotAvailable
+}
+
 func openTPM(tpm probedTPM) (*TPM, error) {
 	pcp, err := openPCP()
 	if err != nil {
@@ -98,6 +112,10 @@ func openTPM(tpm probedTPM) (*TPM, error) {
 	if err != nil {
 		return nil, fmt.Errorf("tbsConvertVersion(%v) failed: %v", info.TBSInfo.TPMVersion, err)
 	}
+	if vers == TPMVersion12 && !tpm12Built {
+		pcp.Close()
+		return nil, ErrTPM12NotBuilt
+	}
 
 	return &TPM{tpm: &windowsTPM{
 		pcp:     pcp,
@@ -113,15 +131,6 @@ func (t *windowsTPM) close() error {
 	return t.pcp.Close()
 }
 
-func readTPM12VendorAttributes(tpm io.ReadWriter) (TCGVendorID, string, error) {
-	vendor, err := tpm1.GetManufacturer(tpm)
-	if err != nil {
-		return TCGVendorID(0), "", fmt.Errorf("tpm1.GetCapability failed: %v", err)
-	}
-	vendorID := TCGVendorID(binary.BigEndian.Uint32(vendor))
-	return vendorID, vendorID.String(), nil
-}
-
 func (t *windowsTPM) info() (*TPMInfo, error) {
 	tInfo := TPMInfo{
 		Version:   t.version,
@@ -152,6 +161,43 @@ func (t *windowsTPM) info() (*TPMInfo, error) {
 	return &tInfo, nil
 }
 
+func (t *windowsTPM) readClock() (ClockInfo, error) {
+	if t.version != TPMVersion20 {
+		return ClockInfo{}, errors.New("reading the clock is only supported on TPM 2.0")
+	}
+	tpm, err := t.pcp.TPMCommandInterface()
+	if err != nil {
+		return ClockInfo{}, fmt.Errorf("TPMCommandInterface() failed: %v", err)
+	}
+	return readClock20(tpm)
+}
+
+func (t *windowsTPM) persistentHandles() ([]tpmutil.Handle, error) {
+	if t.version != TPMVersion20 {
+		return nil, errors.New("listing persistent handles is only supported on TPM 2.0")
+	}
+	tpm, err := t.pcp.TPMCommandInterface()
+	if err != nil {
+		return nil, fmt.Errorf("TPMCommandInterface() failed: %v", err)
+	}
+	return persistentHandles20(tpm)
+}
+
+func (t *windowsTPM) supportedAlgorithms() ([]Algorithm, error) {
+	switch t.version {
+	case TPMVersion12:
+		return []Algorithm{RSA}, nil
+	case TPMVersion20:
+		tpm, err := t.pcp.TPMCommandInterface()
+		if err != nil {
+			return nil, fmt.Errorf("TPMCommandInterface() failed: %v", err)
+		}
+		return supportedAlgorithms20(tpm)
+	default:
+		return nil, fmt.Errorf("unsupported TPM version: %x", t.version)
+	}
+}
+
 func (t *windowsTPM) ekCertificates() ([]EK, error) {
 	ekCerts, err := t.pcp.EKCerts()
 	if err != nil {
@@ -248,36 +294,19 @@ func decodeWindowsBcryptRSABlob(b []byte) (*rsa.PublicKey, error) {
 }
 
 func decryptCredential(secretKey, blob []byte) ([]byte, error) {
-	var scheme uint32
-	symbuf := bytes.NewReader(blob)
-	if err := binary.Read(symbuf, binary.BigEndian, &scheme); err != nil {
-		return nil, fmt.Errorf("reading scheme: %v", err)
-	}
-	if scheme != 0x00000002 {
-		return nil, fmt.Errorf("can only handle CBC schemes")
-	}
-
-	iv := make([]byte, 16)
-	if err := binary.Read(symbuf, binary.BigEndian, &iv); err != nil {
+	// Challenges generated with UseTCSDActivationFormat are accepted too,
+	// so a verifier setting the flag incorrectly doesn't break activation.
+	iv, cipherText, _, err := parseSymCredential12(blob)
+	if err != nil {
 		return nil, err
 	}
-	cipherText := make([]byte, len(blob)-20)
-	if err := binary.Read(symbuf, binary.BigEndian, &cipherText); err != nil {
-		return nil, fmt.Errorf("reading ciphertext: %v", err)
-	}
 
 	// Decrypt the credential.
-	var (
-		block  cipher.Block
-		secret []byte
-		err    error
-	)
-	block, err = aes.NewCipher(secretKey)
+	block, err := aes.NewCipher(secretKey)
 	if err != nil {
 		return nil, fmt.Errorf("aes.NewCipher failed: %v", err)
 	}
-	secret = cipherText
-
+	secret := make([]byte, len(cipherText))
 	mode := cipher.NewCBCDecrypter(block, iv)
 	mode.CryptBlocks(secret, cipherText)
 	// Remove PKCS5 padding.
@@ -287,6 +316,12 @@ func decryptCredential(secretKey, blob []byte) ([]byte, error) {
 }
 
 func (t *windowsTPM) newAK(opts *AKConfig) (*AK, error) {
+	if err := checkAKConfigNoAuth(opts); err != nil {
+		return nil, err
+	}
+	if opts != nil && opts.Size != 0 && opts.Size != 2048 {
+		return nil, fmt.Errorf("pcp only supports 2048-bit AKs, not %d", opts.Size)
+	}
 	nameHex := make([]byte, 5)
 	if n, err := rand.Read(nameHex); err != nil || n != len(nameHex) {
 		return nil, fmt.Errorf("rand.Read() failed with %d/%d bytes read and error: %v", n, len(nameHex), err)
@@ -341,35 +376,118 @@ func (t *windowsTPM) loadAKWithParent(opaqueBlob []byte, parent ParentKeyConfig)
 	return nil, fmt.Errorf("not implemented")
 }
 
-func (t *windowsTPM) newKey(*AK, *KeyConfig) (*Key, error) {
-	return nil, fmt.Errorf("not implemented")
+// cngKeyAlgorithm returns the CNG algorithm identifier and length of keys
+// described by opts.
+func cngKeyAlgorithm(opts *KeyConfig) (string, uint32, error) {
+	switch opts.Algorithm {
+	case RSA:
+		if opts.Size == 0 {
+			return "RSA", 2048, nil
+		}
+		return "RSA", uint32(opts.Size), nil
+	case ECDSA:
+		// The curve of ECDSA keys is selected by the algorithm identifier.
+		if _, ok := opts.Curve(); !ok {
+			return "", 0, fmt.Errorf("unsupported ECDSA key size: %v", opts.Size)
+		}
+		return fmt.Sprintf("ECDSA_P%d", opts.Size), 0, nil
+	default:
+		return "", 0, fmt.Errorf("unsupported algorithm type: %q", opts.Algorithm)
+	}
 }
 
-func (t *windowsTPM) loadKey(opaqueBlob []byte) (*Key, error) {
-	return nil, fmt.Errorf("not implemented")
+func (t *windowsTPM) newKey(ak *AK, opts *KeyConfig) (*Key, error) {
+	if t.version != TPMVersion20 {
+		return nil, fmt.Errorf("application keys are not supported on TPM %v", t.version)
+	}
+	if opts.Parent != nil || opts.Decrypt || opts.QualifyingData != nil || opts.PersistentHandle != 0 || opts.Auth != nil || opts.Duplicable {
+		return nil, errors.New("pcp only supports the Algorithm, Size, Usage and Exportable key options")
+	}
+	alg, length, err := cngKeyAlgorithm(opts)
+	if err != nil {
+		return nil, err
+	}
+	var usage uint32 = nCryptAllowSigningFlag
+	if opts.Usage == KeyUsageAll {
+		usage = nCryptAllowAllUsages
+	}
+	var export uint32
+	if opts.Exportable {
+		export = nCryptAllowExportFlag
+	}
+
+	nameHex := make([]byte, 5)
+	if n, err := rand.Read(nameHex); err != nil || n != len(nameHex) {
+		return nil, fmt.Errorf("rand.Read() failed with %d/%d bytes read and error: %v", n, len(nameHex), err)
+	}
+	name := fmt.Sprintf("key-%x", nameHex)
+
+	kh, err := t.pcp.NewKey(name, alg, length, usage, export)
+	if err != nil {
+		return nil, fmt.Errorf("pcp failed to mint application key: %v", err)
+	}
+	// Certify application key by AK.
+	cp, err := ak.ak.certify(t, kh)
+	if err != nil {
+		t.pcp.DeleteKey(kh)
+		return nil, fmt.Errorf("ak.Certify() failed: %v", err)
+	}
+	pub, err := decodeKeyPublic(cp.Public)
+	if err != nil {
+		t.pcp.DeleteKey(kh)
+		return nil, err
+	}
+	return &Key{key: newWindowsAppKey20(kh, name, cp.Public, cp.CreateAttestation, cp.CreateSignature), pub: pub, tpm: t}, nil
 }
 
-func (t *windowsTPM) loadKeyWithParent(opaqueBlob []byte, parent ParentKeyConfig) (*Key, error) {
-	return nil, fmt.Errorf("not implemented")
+// decodeKeyPublic returns the public key of the encoded TPMT_PUBLIC
+// structure public.
+func decodeKeyPublic(public []byte) (crypto.PublicKey, error) {
+	tpmPub, err := tpm2.DecodePublic(public)
+	if err != nil {
+		return nil, fmt.Errorf("decode public key: %v", err)
+	}
+	pub, err := tpmPub.Key()
+	if err != nil {
+		return nil, fmt.Errorf("access public key: %v", err)
+	}
+	return pub, nil
 }
 
-func allPCRs12(tpm io.ReadWriter) (map[uint32][]byte, error) {
-	numPCRs := 24
-	out := map[uint32][]byte{}
+func (t *windowsTPM) importKey(crypto.PublicKey, []byte, ParentKeyConfig) (*Key, error) {
+	return nil, fmt.Errorf("not implemented")
+}
 
-	for pcr := 0; pcr < numPCRs; pcr++ {
-		pcrval, err := tpm1.ReadPCR(tpm, uint32(pcr))
-		if err != nil {
-			return nil, fmt.Errorf("tpm.ReadPCR() failed with err: %v", err)
-		}
-		out[uint32(pcr)] = pcrval
+func (t *windowsTPM) loadKey(opaqueBlob []byte) (*Key, error) {
+	sKey, err := deserializeKey(opaqueBlob, t.version)
+	if err != nil {
+		return nil, fmt.Errorf("deserializeKey() failed: %v", err)
+	}
+	if sKey.Encoding != keyEncodingOSManaged {
+		return nil, fmt.Errorf("unsupported key encoding: %x", sKey.Encoding)
+	}
+	pub, err := decodeKeyPublic(sKey.Public)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(out) != numPCRs {
-		return nil, fmt.Errorf("failed to read all PCRs, only read %d", len(out))
+	hnd, err := t.pcp.LoadKeyByName(sKey.Name)
+	if err != nil {
+		return nil, fmt.Errorf("pcp failed to load key: %v", err)
 	}
+	return &Key{key: newWindowsAppKey20(hnd, sKey.Name, sKey.Public, sKey.CreateAttestation, sKey.CreateSignature), pub: pub, tpm: t}, nil
+}
 
-	return out, nil
+func (t *windowsTPM) loadKeyWithParent(opaqueBlob []byte, parent ParentKeyConfig) (*Key, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (t *windowsTPM) loadKeyFromHandle(tpmutil.Handle, []byte) (*Key, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (t *windowsTPM) loadAKFromHandle(tpmutil.Handle, []byte) (*AK, error) {
+	return nil, fmt.Errorf("not implemented")
 }
 
 func (t *windowsTPM) pcrs(alg HashAlg) ([]PCR, error) {
@@ -16,17 +16,25 @@ package attest
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
 	"encoding/asn1"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"math/big"
+	"sync"
+	"time"
 
 	"github.com/google/go-tpm/legacy/tpm2"
+	tpm2direct "github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
 	"github.com/google/go-tpm/tpmutil"
 )
 
@@ -36,6 +44,9 @@ type wrappedTPM20 struct {
 	rwc              CommandChannelTPM20
 	tpmRSAEkTemplate *tpm2.Public
 	tpmECCEkTemplate *tpm2.Public
+	// retry, if set, is applied to commands sent while creating keys and
+	// signing.
+	retry *RetryPolicy
 }
 
 func (t *wrappedTPM20) rsaEkTemplate() tpm2.Public {
@@ -76,6 +87,192 @@ func (t *wrappedTPM20) tpmVersion() TPMVersion {
 	return TPMVersion20
 }
 
+// withContext returns a copy of t whose commands fail once ctx is done.
+func (t *wrappedTPM20) withContext(ctx context.Context) *wrappedTPM20 {
+	out := *t
+	out.rwc = &contextCmdChannel{CommandChannelTPM20: t.rwc, ctx: ctx}
+	return &out
+}
+
+// contextCmdChannel is a command channel which refuses to send commands once
+// its context is done. Commands which are already in flight are not
+// interrupted. TPM2_FlushContext is always sent, so that handles are
+// released when an operation is abandoned.
+type contextCmdChannel struct {
+	CommandChannelTPM20
+	ctx context.Context
+}
+
+// Write implements io.Writer.
+func (c *contextCmdChannel) Write(cmd []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		// Commands start with a 2 byte tag and 4 byte size, followed by
+		// the command code.
+		if len(cmd) < 10 || tpmutil.Command(binary.BigEndian.Uint32(cmd[6:10])) != tpm2.CmdFlushContext {
+			return 0, err
+		}
+	}
+	return c.CommandChannelTPM20.Write(cmd)
+}
+
+// withRetry returns a copy of t which resends commands failing with a
+// transient response code, according to t.retry. If no retry policy is
+// configured, or t already retries commands, t is returned.
+func (t *wrappedTPM20) withRetry() *wrappedTPM20 {
+	if t.retry == nil {
+		return t
+	}
+	if _, ok := t.rwc.(*retryCmdChannel); ok {
+		return t
+	}
+	out := *t
+	out.rwc = &retryCmdChannel{CommandChannelTPM20: t.rwc, policy: t.retry}
+	return &out
+}
+
+// Transient TPM 2.0 warning response codes, after which a command can be
+// sent again. See section 6.6.3 of the TPM 2.0 Structures specification.
+var retryableResponseCodes = map[uint32]bool{
+	0x908: true, // TPM_RC_YIELDED
+	0x90a: true, // TPM_RC_TESTING
+	0x920: true, // TPM_RC_NV_RATE
+	0x923: true, // TPM_RC_NV_UNAVAILABLE
+}
+
+// retryCmdChannel is a command channel which resends the last command
+// written if the TPM responds with a transient response code. It is
+// shared by every key created through the same TPM, so it holds a lock
+// from writing a command until its final response is read, keeping the
+// saved command to the goroutine which sent it. Other commands may be
+// sent between the attempts of a command.
+type retryCmdChannel struct {
+	CommandChannelTPM20
+	policy *RetryPolicy
+
+	mu sync.Mutex
+	// cmd is the command awaiting a response. It is only accessed with
+	// mu held.
+	cmd []byte
+}
+
+// Write implements io.Writer.
+func (c *retryCmdChannel) Write(cmd []byte) (int, error) {
+	c.mu.Lock()
+	c.cmd = append(c.cmd[:0], cmd...)
+	n, err := c.CommandChannelTPM20.Write(cmd)
+	if err != nil {
+		// No response follows a failed write.
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// Read implements io.Reader.
+func (c *retryCmdChannel) Read(p []byte) (int, error) {
+	defer c.mu.Unlock()
+	backoff := c.policy.initialBackoff()
+	for attempt := 1; ; attempt++ {
+		n, err := c.CommandChannelTPM20.Read(p)
+		// Responses start with a 2 byte tag and 4 byte size, followed by
+		// the response code.
+		if err != nil || n < 10 || attempt >= c.policy.maxAttempts() || !retryableResponseCodes[binary.BigEndian.Uint32(p[6:10])] {
+			return n, err
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > c.policy.maxBackoff() {
+			backoff = c.policy.maxBackoff()
+		}
+		if _, err := c.CommandChannelTPM20.Write(c.cmd); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// withAudit reports every command subsequently sent to the TPM to audit.
+// If audit is nil, t is left unchanged.
+func (t *wrappedTPM20) withAudit(audit func(tpmutil.Command, tpmutil.ResponseCode)) {
+	if audit == nil {
+		return
+	}
+	t.rwc = &auditCmdChannel{CommandChannelTPM20: t.rwc, audit: audit}
+}
+
+// auditCmdChannel is a command channel which reports the command code of
+// each command and the response code of its response.
+type auditCmdChannel struct {
+	CommandChannelTPM20
+	audit func(tpmutil.Command, tpmutil.ResponseCode)
+	// cmd is the command code awaiting a response, if pending is set.
+	cmd     tpmutil.Command
+	pending bool
+}
+
+// Write implements io.Writer.
+func (c *auditCmdChannel) Write(cmd []byte) (int, error) {
+	n, err := c.CommandChannelTPM20.Write(cmd)
+	c.pending = err == nil && len(cmd) >= 10
+	if c.pending {
+		c.cmd = tpmutil.Command(binary.BigEndian.Uint32(cmd[6:10]))
+	}
+	return n, err
+}
+
+// Read implements io.Reader.
+func (c *auditCmdChannel) Read(p []byte) (int, error) {
+	n, err := c.CommandChannelTPM20.Read(p)
+	if err == nil && n >= 10 && c.pending {
+		c.pending = false
+		c.audit(c.cmd, tpmutil.ResponseCode(binary.BigEndian.Uint32(p[6:10])))
+	}
+	return n, err
+}
+
+// serialize makes t send one command at a time, so that t and the keys
+// loaded through it can be used from multiple goroutines without commands
+// and responses interleaving. It must be applied after any channel which
+// holds per-command state shared by all users of t, such as withAudit.
+func (t *wrappedTPM20) serialize() {
+	if _, ok := t.rwc.(*lockedCmdChannel); ok {
+		return
+	}
+	t.rwc = &lockedCmdChannel{CommandChannelTPM20: t.rwc}
+}
+
+// lockedCmdChannel is a command channel which holds a lock from writing a
+// command until its response is read, as tpmutil.RunCommand does. Any
+// channel wrapping it must read exactly once after each successful write.
+type lockedCmdChannel struct {
+	CommandChannelTPM20
+	mu sync.Mutex
+}
+
+// Write implements io.Writer.
+func (c *lockedCmdChannel) Write(cmd []byte) (int, error) {
+	c.mu.Lock()
+	n, err := c.CommandChannelTPM20.Write(cmd)
+	if err != nil {
+		// No response follows a failed write.
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// Read implements io.Reader.
+func (c *lockedCmdChannel) Read(p []byte) (int, error) {
+	defer c.mu.Unlock()
+	return c.CommandChannelTPM20.Read(p)
+}
+
+// objectMemoryError annotates an error from the TPM command op, reporting
+// ErrTPMObjectMemory if the TPM had no room for a key.
+func objectMemoryError(op string, err error) error {
+	var w tpm2.Warning
+	if errors.As(err, &w) && w.Code == tpm2.RCObjectMemory {
+		return fmt.Errorf("%s failed: %w: %v", op, ErrTPMObjectMemory, err)
+	}
+	return fmt.Errorf("%s failed: %v", op, err)
+}
+
 func (t *wrappedTPM20) close() error {
 	return t.rwc.Close()
 }
@@ -101,6 +298,67 @@ func (t *wrappedTPM20) info() (*TPMInfo, error) {
 	return &tInfo, nil
 }
 
+func (t *wrappedTPM20) readClock() (ClockInfo, error) {
+	return readClock20(t.rwc)
+}
+
+func (t *wrappedTPM20) persistentHandles() ([]tpmutil.Handle, error) {
+	return persistentHandles20(t.rwc)
+}
+
+// persistentHandles20 lists the persistent handles of the TPM, following
+// the TPM's indication that more handles are available.
+func persistentHandles20(rw io.ReadWriter) ([]tpmutil.Handle, error) {
+	var out []tpmutil.Handle
+	next := uint32(tpm2.HandleTypePersistent) << 24
+	for {
+		vals, more, err := tpm2.GetCapability(rw, tpm2.CapabilityHandles, 64, next)
+		if err != nil {
+			return nil, fmt.Errorf("tpm2.GetCapability(handles) failed: %v", err)
+		}
+		for _, v := range vals {
+			h, ok := v.(tpmutil.Handle)
+			if !ok {
+				return nil, fmt.Errorf("unexpected capability value of type %T", v)
+			}
+			// The TPM returns handles from next onwards, which may run
+			// past the persistent range.
+			if uint32(h)>>24 != uint32(tpm2.HandleTypePersistent) {
+				return out, nil
+			}
+			out = append(out, h)
+			next = uint32(h) + 1
+		}
+		if !more || len(vals) == 0 {
+			return out, nil
+		}
+	}
+}
+
+// readClock20 runs TPM2_ReadClock. It is implemented here as go-tpm's
+// ReadClock does not return the reset and restart counts.
+func readClock20(rw io.ReadWriter) (ClockInfo, error) {
+	resp, code, err := tpmutil.RunCommand(rw, tpm2.TagNoSessions, tpm2.CmdReadClock)
+	if err != nil {
+		return ClockInfo{}, fmt.Errorf("TPM2_ReadClock failed: %v", err)
+	}
+	if code != tpmutil.RCSuccess {
+		return ClockInfo{}, fmt.Errorf("TPM2_ReadClock failed: response code 0x%x", code)
+	}
+	var (
+		time      uint64
+		clockInfo tpm2.ClockInfo
+	)
+	if _, err := tpmutil.Unpack(resp, &time, &clockInfo); err != nil {
+		return ClockInfo{}, fmt.Errorf("decoding TPM2_ReadClock response: %v", err)
+	}
+	return newClockInfo(clockInfo), nil
+}
+
+func (t *wrappedTPM20) supportedAlgorithms() ([]Algorithm, error) {
+	return supportedAlgorithms20(t.rwc)
+}
+
 // Return value: handle, whether we generated a new one, error.
 func (t *wrappedTPM20) getEndorsementKeyHandle(ek *EK) (tpmutil.Handle, bool, error) {
 	var ekHandle tpmutil.Handle
@@ -111,15 +369,19 @@ func (t *wrappedTPM20) getEndorsementKeyHandle(ek *EK) (tpmutil.Handle, bool, er
 		ekHandle = commonRSAEkEquivalentHandle
 		ekTemplate = t.rsaEkTemplate()
 	} else {
+		// If no handle was provided, assume the usual handle for the type
+		// of the EK.
 		ekHandle = ek.handle
-		if ekHandle == 0 {
-			// Assume RSA EK handle if it was not provided.
-			ekHandle = commonRSAEkEquivalentHandle
-		}
 		switch pub := ek.Public.(type) {
 		case *rsa.PublicKey:
+			if ekHandle == 0 {
+				ekHandle = commonRSAEkEquivalentHandle
+			}
 			ekTemplate = t.rsaEkTemplate()
 		case *ecdsa.PublicKey:
+			if ekHandle == 0 {
+				ekHandle = commonECCEkEquivalentHandle
+			}
 			ekTemplate = t.eccEkTemplate()
 		default:
 			return 0, false, fmt.Errorf("unsupported public key type %T", pub)
@@ -147,12 +409,42 @@ func (t *wrappedTPM20) getEndorsementKeyHandle(ek *EK) (tpmutil.Handle, bool, er
 	return ekHandle, true, nil
 }
 
+// checkParentKey verifies that pub describes a storage key of the
+// given algorithm. If alg is empty, keys of any algorithm are accepted.
+func checkParentKey(pub tpm2.Public, alg Algorithm) error {
+	var want tpm2.Algorithm
+	switch alg {
+	case "":
+		want = pub.Type
+	case RSA:
+		want = tpm2.AlgRSA
+	case ECDSA:
+		want = tpm2.AlgECC
+	default:
+		return fmt.Errorf("unsupported SRK algorithm: %v", alg)
+	}
+	if pub.Type != want {
+		return fmt.Errorf("got key of type 0x%x, want 0x%x for algorithm %v", pub.Type, want, alg)
+	}
+	if pub.Attributes&(tpm2.FlagRestricted|tpm2.FlagDecrypt) != tpm2.FlagRestricted|tpm2.FlagDecrypt {
+		return errors.New("key is not a restricted decryption key")
+	}
+	return nil
+}
+
 // Return value: handle, whether we generated a new one, error
 func (t *wrappedTPM20) getStorageRootKeyHandle(parent ParentKeyConfig) (tpmutil.Handle, bool, error) {
 	srkHandle := parent.Handle
-	_, _, _, err := tpm2.ReadPublic(t.rwc, srkHandle)
+	if srkHandle == 0 {
+		return 0, false, errors.New("no parent key handle specified")
+	}
+	pub, _, _, err := tpm2.ReadPublic(t.rwc, srkHandle)
 	if err == nil {
-		// Found the persistent handle, assume it's the key we want.
+		// Found the persistent handle, make sure it's usable as the parent
+		// we were asked for.
+		if err := checkParentKey(pub, parent.Algorithm); err != nil {
+			return 0, false, fmt.Errorf("key at handle 0x%x is not a suitable parent: %v", srkHandle, err)
+		}
 		return srkHandle, false, nil
 	}
 	rerr := err // Preserve this failure for later logging, if needed
@@ -235,24 +527,82 @@ func (t *wrappedTPM20) eks() ([]EK, error) {
 }
 
 func (t *wrappedTPM20) newAK(opts *AKConfig) (*AK, error) {
+	t = t.withRetry()
+	if err := checkAKConfigSize(opts); err != nil {
+		return nil, err
+	}
+	var ekHnd tpmutil.Handle
+	var err error
+	if opts != nil && opts.EK != nil {
+		if opts.Parent != nil {
+			return nil, errors.New("AKConfig.EK and AKConfig.Parent can't both be set")
+		}
+		if ekHnd, _, err = t.getEndorsementKeyHandle(opts.EK); err != nil {
+			return nil, fmt.Errorf("failed to get EK handle: %v", err)
+		}
+	}
 	var parent ParentKeyConfig
 	if opts != nil && opts.Parent != nil {
 		parent = *opts.Parent
 	} else {
 		parent = defaultParentConfig
 	}
-	srk, _, err := t.getStorageRootKeyHandle(parent)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get SRK handle: %v", err)
-	}
 
-	blob, pub, creationData, creationHash, tix, err := tpm2.CreateKey(t.rwc, srk, tpm2.PCRSelection{}, "", "", akTemplate)
-	if err != nil {
-		return nil, fmt.Errorf("CreateKeyEx() failed: %v", err)
+	var akTemplate tpm2.Public
+	var sigScheme *tpm2.SigScheme
+	// The default is RSA.
+	if opts != nil && opts.Algorithm == ECDSA {
+		akTemplate = akTemplateECC
+		sigScheme = akTemplateECC.ECCParameters.Sign
+	} else {
+		akTemplate = akTemplateRSA
+		sigScheme = akTemplateRSA.RSAParameters.Sign
+		if opts != nil && opts.Size != 0 {
+			params := *akTemplateRSA.RSAParameters
+			params.KeyBits = uint16(opts.Size)
+			akTemplate.RSAParameters = &params
+		}
 	}
-	keyHandle, _, err := tpm2.Load(t.rwc, srk, "", pub, blob)
-	if err != nil {
-		return nil, fmt.Errorf("Load() failed: %v", err)
+	var policySession func(io.ReadWriter, tpmutil.Handle) error
+	if opts != nil && opts.PolicySession != nil && len(opts.AuthPolicy) == 0 {
+		return nil, errors.New("PolicySession requires AuthPolicy to be set")
+	}
+	if opts != nil && len(opts.AuthPolicy) > 0 {
+		if opts.PolicySession == nil {
+			return nil, errors.New("AuthPolicy requires PolicySession to be set")
+		}
+		policySession = opts.PolicySession
+		akTemplate.AuthPolicy = opts.AuthPolicy
+		akTemplate.Attributes &^= tpm2.FlagUserWithAuth
+	}
+	var auth []byte
+	if opts != nil {
+		auth = opts.Auth
+	}
+	var blob, pub, creationData, creationHash []byte
+	var tix tpm2.Ticket
+	var keyHandle tpmutil.Handle
+	if ekHnd != 0 {
+		blob, pub, creationData, creationHash, tix, err = t.createUnderEK(ekHnd, string(auth), akTemplate)
+		if err != nil {
+			return nil, err
+		}
+		if keyHandle, err = t.loadUnderEK(ekHnd, pub, blob); err != nil {
+			return nil, err
+		}
+	} else {
+		var srk tpmutil.Handle
+		if srk, _, err = t.getStorageRootKeyHandle(parent); err != nil {
+			return nil, fmt.Errorf("failed to get SRK handle: %v", err)
+		}
+		blob, pub, creationData, creationHash, tix, err = tpm2.CreateKey(t.rwc, srk, tpm2.PCRSelection{}, "", string(auth), akTemplate)
+		if err != nil {
+			return nil, objectMemoryError("CreateKeyEx()", err)
+		}
+		keyHandle, _, err = tpm2.Load(t.rwc, srk, "", pub, blob)
+		if err != nil {
+			return nil, objectMemoryError("Load()", err)
+		}
 	}
 	// If any errors occur, free the AK's handle.
 	defer func() {
@@ -262,14 +612,177 @@ func (t *wrappedTPM20) newAK(opts *AKConfig) (*AK, error) {
 	}()
 
 	// We can only certify the creation immediately afterwards, so we cache the result.
-	attestation, sig, err := tpm2.CertifyCreation(t.rwc, "", keyHandle, keyHandle, nil, creationHash, tpm2.SigScheme{Alg: tpm2.AlgRSASSA, Hash: tpm2.AlgSHA256, Count: 0}, tix)
+	var attestation, sig []byte
+	if policySession != nil {
+		attestation, sig, err = certifyCreationWithPolicy(t.rwc, keyHandle, pub, creationHash, tix, policySession)
+	} else {
+		attestation, sig, err = tpm2.CertifyCreation(t.rwc, string(auth), keyHandle, keyHandle, nil, creationHash, *sigScheme, tix)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("CertifyCreation failed: %v", err)
 	}
-	return &AK{ak: newWrappedAK20(keyHandle, blob, pub, creationData, attestation, sig)}, nil
+	k := newWrappedAK20(keyHandle, blob, pub, creationData, attestation, sig)
+	k.(*wrappedKey20).setAuth(auth)
+	k.(*wrappedKey20).policySession = policySession
+	k.(*wrappedKey20).ekHandle = ekHnd
+	return &AK{ak: k}, nil
+}
+
+// ekPolicySession starts a policy session satisfying the policy of the EK
+// at ekHnd, which authorizes its user role with the endorsement hierarchy.
+// The session must be flushed by the caller.
+func (t *wrappedTPM20) ekPolicySession(ekHnd tpmutil.Handle) (tpmutil.Handle, error) {
+	// The policy of the EK is computed with its name algorithm, which the
+	// session must use.
+	ekPub, _, _, err := tpm2.ReadPublic(t.rwc, ekHnd)
+	if err != nil {
+		return 0, fmt.Errorf("reading EK public: %v", err)
+	}
+	sessHandle, _, err := tpm2.StartAuthSession(
+		t.rwc,
+		tpm2.HandleNull,  /*tpmKey*/
+		tpm2.HandleNull,  /*bindKey*/
+		make([]byte, 16), /*nonceCaller*/
+		nil,              /*secret*/
+		tpm2.SessionPolicy,
+		tpm2.AlgNull,
+		ekPub.NameAlg)
+	if err != nil {
+		return 0, fmt.Errorf("creating session: %v", err)
+	}
+	if _, _, err := tpm2.PolicySecret(t.rwc, tpm2.HandleEndorsement, tpm2.AuthCommand{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession}, sessHandle, nil, nil, nil, 0); err != nil {
+		tpm2.FlushContext(t.rwc, sessHandle)
+		return 0, fmt.Errorf("tpm2.PolicySecret() failed: %v", err)
+	}
+	return sessHandle, nil
+}
+
+// createUnderEK creates a key from template as a child of the EK at ekHnd.
+func (t *wrappedTPM20) createUnderEK(ekHnd tpmutil.Handle, auth string, template tpm2.Public) (blob, pub, creationData, creationHash []byte, tix tpm2.Ticket, err error) {
+	sess, err := t.ekPolicySession(ekHnd)
+	if err != nil {
+		return nil, nil, nil, nil, tpm2.Ticket{}, err
+	}
+	defer tpm2.FlushContext(t.rwc, sess)
+	blob, pub, creationData, creationHash, tix, err = tpm2.CreateKeyUsingAuth(t.rwc, ekHnd, tpm2.PCRSelection{}, tpm2.AuthCommand{Session: sess, Attributes: tpm2.AttrContinueSession}, auth, template)
+	if err != nil {
+		return nil, nil, nil, nil, tpm2.Ticket{}, objectMemoryError("CreateKeyUsingAuth()", err)
+	}
+	return blob, pub, creationData, creationHash, tix, nil
+}
+
+// loadUnderEK loads a key whose parent is the EK at ekHnd.
+func (t *wrappedTPM20) loadUnderEK(ekHnd tpmutil.Handle, public, blob []byte) (tpmutil.Handle, error) {
+	sess, err := t.ekPolicySession(ekHnd)
+	if err != nil {
+		return 0, err
+	}
+	defer tpm2.FlushContext(t.rwc, sess)
+	hnd, _, err := tpm2.LoadUsingAuth(t.rwc, ekHnd, tpm2.AuthCommand{Session: sess, Attributes: tpm2.AttrContinueSession}, public, blob)
+	if err != nil {
+		return 0, objectMemoryError("LoadUsingAuth()", err)
+	}
+	return hnd, nil
+}
+
+// policyAuthHandle returns the handle of the key with the given public
+// area, authorized by a policy session on which policy is run. The legacy
+// API only supports password sessions, so commands authorized by a policy
+// session use the direct API.
+func policyAuthHandle(rw io.ReadWriter, keyHandle tpmutil.Handle, public []byte, policy func(io.ReadWriter, tpmutil.Handle) error) (tpm2direct.AuthHandle, error) {
+	name, err := encodedName(public)
+	if err != nil {
+		return tpm2direct.AuthHandle{}, err
+	}
+	return tpm2direct.AuthHandle{
+		Handle: tpm2direct.TPMHandle(keyHandle),
+		Name:   tpm2direct.TPM2BName{Buffer: name},
+		Auth: tpm2direct.Policy(tpm2direct.TPMAlgSHA256, 16, func(_ transport.TPM, handle tpm2direct.TPMISHPolicy, _ tpm2direct.TPM2BNonce) error {
+			return policy(rw, tpmutil.Handle(handle))
+		}),
+	}, nil
+}
+
+// encodedName returns the name of the key with the given public area, as
+// expected by the Name of the handles of the direct API.
+func encodedName(public []byte) ([]byte, error) {
+	pub, err := tpm2.DecodePublic(public)
+	if err != nil {
+		return nil, fmt.Errorf("decoding public: %v", err)
+	}
+	name, err := pub.Name()
+	if err != nil {
+		return nil, fmt.Errorf("computing name: %v", err)
+	}
+	encoded, err := name.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("encoding name: %v", err)
+	}
+	// The encoded name is prefixed with its size, which TPM2BName adds itself.
+	return encoded[2:], nil
+}
+
+// certifyCreationWithPolicy certifies the creation of a key whose user role
+// can only be authorized with a policy session.
+func certifyCreationWithPolicy(rw io.ReadWriter, keyHandle tpmutil.Handle, public, creationHash []byte, tix tpm2.Ticket, policy func(io.ReadWriter, tpmutil.Handle) error) ([]byte, []byte, error) {
+	signHandle, err := policyAuthHandle(rw, keyHandle, public, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	rsp, err := tpm2direct.CertifyCreation{
+		SignHandle: signHandle,
+		ObjectHandle: tpm2direct.NamedHandle{
+			Handle: signHandle.Handle,
+			Name:   signHandle.Name,
+		},
+		CreationHash: tpm2direct.TPM2BDigest{Buffer: creationHash},
+		InScheme:     tpm2direct.TPMTSigScheme{Scheme: tpm2direct.TPMAlgNull},
+		CreationTicket: tpm2direct.TPMTTKCreation{
+			Tag:       tpm2direct.TPMST(tix.Type),
+			Hierarchy: tpm2direct.TPMIRHHierarchy(tix.Hierarchy),
+			Digest:    tpm2direct.TPM2BDigest{Buffer: tix.Digest},
+		},
+	}.Execute(transport.FromReadWriter(rw))
+	if err != nil {
+		return nil, nil, err
+	}
+	return rsp.CertifyInfo.Bytes(), tpm2direct.Marshal(rsp.Signature), nil
+}
+
+// quoteWithPolicy is like quote20, for an AK whose user role can only be
+// authorized with a policy session.
+func quoteWithPolicy(rw io.ReadWriter, akHandle tpmutil.Handle, akPublic []byte, policy func(io.ReadWriter, tpmutil.Handle) error, hashAlg tpm2.Algorithm, nonce []byte, selectedPCRs []int) (*Quote, error) {
+	signHandle, err := policyAuthHandle(rw, akHandle, akPublic, policy)
+	if err != nil {
+		return nil, err
+	}
+	pcrs := make([]uint, len(selectedPCRs))
+	for i, pcr := range selectedPCRs {
+		pcrs[i] = uint(pcr)
+	}
+	rsp, err := tpm2direct.Quote{
+		SignHandle:     signHandle,
+		QualifyingData: tpm2direct.TPM2BData{Buffer: nonce},
+		InScheme:       tpm2direct.TPMTSigScheme{Scheme: tpm2direct.TPMAlgNull},
+		PCRSelect: tpm2direct.TPMLPCRSelection{
+			PCRSelections: []tpm2direct.TPMSPCRSelection{{
+				Hash:      tpm2direct.TPMIAlgHash(hashAlg),
+				PCRSelect: tpm2direct.PCClientCompatible.PCRs(pcrs...),
+			}},
+		},
+	}.Execute(transport.FromReadWriter(rw))
+	if err != nil {
+		return nil, err
+	}
+	return &Quote{
+		Version:   TPMVersion20,
+		Quote:     rsp.Quoted.Bytes(),
+		Signature: tpm2direct.Marshal(rsp.Signature),
+	}, nil
 }
 
 func (t *wrappedTPM20) newKey(ak *AK, opts *KeyConfig) (*Key, error) {
+	t = t.withRetry()
 	k, ok := ak.ak.(*wrappedKey20)
 	if !ok {
 		return nil, fmt.Errorf("expected *wrappedKey20, got: %T", k)
@@ -277,12 +790,12 @@ func (t *wrappedTPM20) newKey(ak *AK, opts *KeyConfig) (*Key, error) {
 
 	parent, blob, pub, creationData, err := createKey(t, opts)
 	if err != nil {
-		return nil, fmt.Errorf("cannot create key: %v", err)
+		return nil, fmt.Errorf("cannot create key: %w", err)
 	}
 
 	keyHandle, _, err := tpm2.Load(t.rwc, parent, "", pub, blob)
 	if err != nil {
-		return nil, fmt.Errorf("Load() failed: %v", err)
+		return nil, objectMemoryError("Load()", err)
 	}
 	// If any errors occur, free the handle.
 	defer func() {
@@ -292,7 +805,7 @@ func (t *wrappedTPM20) newKey(ak *AK, opts *KeyConfig) (*Key, error) {
 	}()
 
 	// Certify application key by AK
-	cp, err := k.certify(t, keyHandle)
+	cp, err := k.certifyWithQualifyingData(t, keyHandle, string(opts.Auth), opts.QualifyingData)
 	if err != nil {
 		return nil, fmt.Errorf("ak.Certify() failed: %v", err)
 	}
@@ -309,7 +822,120 @@ func (t *wrappedTPM20) newKey(ak *AK, opts *KeyConfig) (*Key, error) {
 	if err != nil {
 		return nil, fmt.Errorf("access public key: %v", err)
 	}
-	return &Key{key: newWrappedKey20(keyHandle, blob, pub, creationData, cp.CreateAttestation, cp.CreateSignature), pub: pubKey, tpm: t}, nil
+	key := newWrappedKey20(keyHandle, blob, pub, creationData, cp.CreateAttestation, cp.CreateSignature)
+	key.(*wrappedKey20).setAuth(opts.Auth)
+	if opts.PersistentHandle != 0 {
+		if err = key.evict(t, opts.PersistentHandle, false); err != nil {
+			return nil, err
+		}
+	}
+	return &Key{key: key, pub: pubKey, tpm: t}, nil
+}
+
+func (t *wrappedTPM20) importKey(pub crypto.PublicKey, sensitive []byte, parent ParentKeyConfig) (*Key, error) {
+	t = t.withRetry()
+	tmpl, private, err := importTemplate(pub, sensitive)
+	if err != nil {
+		return nil, err
+	}
+	srk, _, err := t.getStorageRootKeyHandle(parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SRK handle: %v", err)
+	}
+	parentPub, _, _, err := tpm2.ReadPublic(t.rwc, srk)
+	if err != nil {
+		return nil, fmt.Errorf("ReadPublic() failed: %v", err)
+	}
+	duplicate, encSeed, err := duplicateForImport20(rand.Reader, parentPub, tmpl, private)
+	if err != nil {
+		return nil, fmt.Errorf("parent key at handle 0x%x cannot wrap the imported key: %v", srk, err)
+	}
+	public, err := tmpl.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("encoding public area: %v", err)
+	}
+	auth := tpm2.AuthCommand{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession}
+	blob, err := tpm2.Import(t.rwc, srk, auth, public, duplicate, encSeed, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Import() failed: %v", err)
+	}
+	hnd, _, err := tpm2.Load(t.rwc, srk, "", public, blob)
+	if err != nil {
+		return nil, objectMemoryError("Load()", err)
+	}
+	pubKey, err := tmpl.Key()
+	if err != nil {
+		tpm2.FlushContext(t.rwc, hnd)
+		return nil, fmt.Errorf("access public key: %v", err)
+	}
+	return &Key{key: newWrappedKey20(hnd, blob, public, nil, nil, nil), pub: pubKey, tpm: t}, nil
+}
+
+// importTemplate returns the public and sensitive areas of a signing key
+// imported from pub and sensitive, a PKCS #8 encoded private key.
+func importTemplate(pub crypto.PublicKey, sensitive []byte) (tpm2.Public, tpm2.Private, error) {
+	priv, err := x509.ParsePKCS8PrivateKey(sensitive)
+	if err != nil {
+		return tpm2.Public{}, tpm2.Private{}, fmt.Errorf("parsing PKCS #8 private key: %v", err)
+	}
+
+	var (
+		tmpl tpm2.Public
+		sens []byte
+	)
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		k, ok := priv.(*rsa.PrivateKey)
+		if !ok {
+			return tpm2.Public{}, tpm2.Private{}, fmt.Errorf("private key of type %T does not match public key of type %T", priv, pub)
+		}
+		if !k.PublicKey.Equal(p) {
+			return tpm2.Public{}, tpm2.Private{}, errors.New("private key does not correspond to the public key")
+		}
+		if len(k.Primes) != 2 {
+			return tpm2.Public{}, tpm2.Private{}, fmt.Errorf("RSA keys with %d primes are not supported", len(k.Primes))
+		}
+		if tmpl, err = templateFromConfig(&KeyConfig{Algorithm: RSA, Size: p.N.BitLen()}); err != nil {
+			return tpm2.Public{}, tpm2.Private{}, err
+		}
+		// The template's parameters are shared, so must be copied before
+		// being modified.
+		params := *tmpl.RSAParameters
+		params.ModulusRaw = p.N.Bytes()
+		if p.E != 1<<16+1 {
+			params.ExponentRaw = uint32(p.E)
+		}
+		tmpl.RSAParameters = &params
+		sens = k.Primes[0].Bytes()
+
+	case *ecdsa.PublicKey:
+		k, ok := priv.(*ecdsa.PrivateKey)
+		if !ok {
+			return tpm2.Public{}, tpm2.Private{}, fmt.Errorf("private key of type %T does not match public key of type %T", priv, pub)
+		}
+		if !k.PublicKey.Equal(p) {
+			return tpm2.Public{}, tpm2.Private{}, errors.New("private key does not correspond to the public key")
+		}
+		if tmpl, err = templateFromConfig(&KeyConfig{Algorithm: ECDSA, Size: p.Curve.Params().BitSize}); err != nil {
+			return tpm2.Public{}, tpm2.Private{}, err
+		}
+		size := (p.Curve.Params().BitSize + 7) / 8
+		params := *tmpl.ECCParameters
+		params.Point = tpm2.ECPoint{
+			XRaw: p.X.FillBytes(make([]byte, size)),
+			YRaw: p.Y.FillBytes(make([]byte, size)),
+		}
+		tmpl.ECCParameters = &params
+		sens = k.D.FillBytes(make([]byte, size))
+
+	default:
+		return tpm2.Public{}, tpm2.Private{}, fmt.Errorf("unsupported public key type: %T", pub)
+	}
+
+	// Imported keys cannot be bound to the TPM or their parent, and their
+	// sensitive data does not originate from the TPM.
+	tmpl.Attributes &^= tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin
+	return tmpl, tpm2.Private{Type: tmpl.Type, Sensitive: sens}, nil
 }
 
 func createKey(t *wrappedTPM20, opts *KeyConfig) (tpmutil.Handle, []byte, []byte, []byte, error) {
@@ -329,9 +955,9 @@ func createKey(t *wrappedTPM20, opts *KeyConfig) (tpmutil.Handle, []byte, []byte
 		return 0, nil, nil, nil, fmt.Errorf("incorrect key options: %v", err)
 	}
 
-	blob, pub, creationData, _, _, err := tpm2.CreateKey(t.rwc, srk, tpm2.PCRSelection{}, "", "", tmpl)
+	blob, pub, creationData, _, _, err := tpm2.CreateKey(t.rwc, srk, tpm2.PCRSelection{}, "", string(opts.Auth), tmpl)
 	if err != nil {
-		return 0, nil, nil, nil, fmt.Errorf("CreateKey() failed: %v", err)
+		return 0, nil, nil, nil, objectMemoryError("CreateKey()", err)
 	}
 
 	return srk, blob, pub, creationData, err
@@ -345,10 +971,26 @@ func templateFromConfig(opts *KeyConfig) (tpm2.Public, error) {
 		if opts.Size < 0 || opts.Size > 65535 { // basic sanity check
 			return tmpl, fmt.Errorf("incorrect size parameter")
 		}
+		// Copy the parameters so the shared template is left untouched.
+		params := *rsaKeyTemplate.RSAParameters
+		tmpl.RSAParameters = &params
 		tmpl.RSAParameters.KeyBits = uint16(opts.Size)
+		if opts.Size == 0 {
+			tmpl.RSAParameters.KeyBits = 2048
+		}
+		if opts.Decrypt {
+			tmpl.Attributes |= tpm2.FlagDecrypt
+		}
 
 	case ECDSA:
+		if opts.Decrypt {
+			return tmpl, fmt.Errorf("decryption is not supported for %v keys", opts.Algorithm)
+		}
 		tmpl = ecdsaKeyTemplate
+		params := *ecdsaKeyTemplate.ECCParameters
+		sign := *ecdsaKeyTemplate.ECCParameters.Sign
+		params.Sign = &sign
+		tmpl.ECCParameters = &params
 		switch opts.Size {
 		case 256:
 			tmpl.NameAlg = tpm2.AlgSHA256
@@ -381,9 +1023,107 @@ func templateFromConfig(opts *KeyConfig) (tpm2.Public, error) {
 		return tmpl, fmt.Errorf("unsupported algorithm type: %q", opts.Algorithm)
 	}
 
+	if opts.Duplicable {
+		policy, err := duplicationPolicy(tmpl.NameAlg)
+		if err != nil {
+			return tmpl, err
+		}
+		tmpl.Attributes &^= tpm2.FlagFixedTPM | tpm2.FlagFixedParent
+		tmpl.AuthPolicy = policy
+	}
 	return tmpl, nil
 }
 
+// duplicationPolicy returns the authorization policy of duplicable keys with
+// name algorithm nameAlg, which is satisfied by TPM2_PolicyCommandCode for
+// TPM2_Duplicate.
+func duplicationPolicy(nameAlg tpm2.Algorithm) ([]byte, error) {
+	h, err := tpmHash(nameAlg)
+	if err != nil {
+		return nil, err
+	}
+	// policyDigest = H(zeros || TPM_CC_PolicyCommandCode || TPM_CC_Duplicate)
+	d := h.New()
+	d.Write(make([]byte, h.Size()))
+	binary.Write(d, binary.BigEndian, uint32(tpm2.CmdPolicyCommandCode))
+	binary.Write(d, binary.BigEndian, uint32(tpm2direct.TPMCCDuplicate))
+	return d.Sum(nil), nil
+}
+
+// rewrapKey duplicates k, whose public key is pub, and imports it under
+// parent.
+func (t *wrappedTPM20) rewrapKey(k *wrappedKey20, pub crypto.PublicKey, parent ParentKeyConfig) (*Key, error) {
+	t = t.withRetry()
+	tmpl, err := tpm2.DecodePublic(k.public)
+	if err != nil {
+		return nil, fmt.Errorf("decoding public: %v", err)
+	}
+	if tmpl.Attributes&tpm2.FlagFixedParent != 0 {
+		return nil, fmt.Errorf("%w: key is fixed to its parent", ErrKeyNotDuplicable)
+	}
+	policy, err := duplicationPolicy(tmpl.NameAlg)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(tmpl.AuthPolicy, policy) {
+		return nil, fmt.Errorf("%w: key policy does not permit TPM2_Duplicate", ErrKeyNotDuplicable)
+	}
+	name, err := tmpl.Name()
+	if err != nil {
+		return nil, fmt.Errorf("computing name: %v", err)
+	}
+	encodedName, err := name.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("encoding name: %v", err)
+	}
+
+	srk, _, err := t.getStorageRootKeyHandle(parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SRK handle: %v", err)
+	}
+	_, parentName, _, err := tpm2.ReadPublic(t.rwc, srk)
+	if err != nil {
+		return nil, fmt.Errorf("ReadPublic() failed: %v", err)
+	}
+
+	// TPM2_Duplicate can only be authorized with a policy session, which
+	// the legacy API does not support, so the direct API is used. The
+	// encoded name is prefixed with its size, which TPM2BName adds itself.
+	session := tpm2direct.Policy(tpm2direct.TPMIAlgHash(tmpl.NameAlg), 16, func(_ transport.TPM, handle tpm2direct.TPMISHPolicy, _ tpm2direct.TPM2BNonce) error {
+		return tpm2.PolicyCommandCode(t.rwc, tpmutil.Handle(handle), tpmutil.Command(tpm2direct.TPMCCDuplicate))
+	})
+	dup, err := tpm2direct.Duplicate{
+		ObjectHandle: tpm2direct.AuthHandle{
+			Handle: tpm2direct.TPMHandle(k.hnd),
+			Name:   tpm2direct.TPM2BName{Buffer: encodedName[2:]},
+			Auth:   session,
+		},
+		NewParentHandle: tpm2direct.NamedHandle{
+			Handle: tpm2direct.TPMHandle(srk),
+			Name:   tpm2direct.TPM2BName{Buffer: parentName},
+		},
+		Symmetric: tpm2direct.TPMTSymDef{Algorithm: tpm2direct.TPMAlgNull},
+	}.Execute(transport.FromReadWriter(t.rwc))
+	if err != nil {
+		return nil, fmt.Errorf("Duplicate() failed: %v", err)
+	}
+
+	auth := tpm2.AuthCommand{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession}
+	blob, err := tpm2.Import(t.rwc, srk, auth, k.public, dup.Duplicate.Buffer, dup.OutSymSeed.Buffer, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Import() failed: %v", err)
+	}
+	hnd, _, err := tpm2.Load(t.rwc, srk, "", k.public, blob)
+	if err != nil {
+		return nil, objectMemoryError("Load()", err)
+	}
+	// The key's public area is unchanged, so its certification still
+	// applies.
+	w := newWrappedKey20(hnd, blob, k.public, k.createData, k.createAttestation, k.createSignature).(*wrappedKey20)
+	w.setAuth(k.auth)
+	return &Key{key: w, pub: pub, tpm: t}, nil
+}
+
 func (t *wrappedTPM20) deserializeAndLoad(opaqueBlob []byte, parent ParentKeyConfig) (tpmutil.Handle, *serializedKey, error) {
 	sKey, err := deserializeKey(opaqueBlob, TPMVersion20)
 	if err != nil {
@@ -392,6 +1132,20 @@ func (t *wrappedTPM20) deserializeAndLoad(opaqueBlob []byte, parent ParentKeyCon
 	if sKey.Encoding != keyEncodingEncrypted {
 		return 0, nil, fmt.Errorf("unsupported key encoding: %x", sKey.Encoding)
 	}
+	if sKey.PersistentHandle != 0 {
+		// The key is already loaded; check it is the key we expect.
+		if _, err := t.readPersistentKey(sKey.PersistentHandle, sKey.Public); err != nil {
+			return 0, nil, err
+		}
+		return sKey.PersistentHandle, sKey, nil
+	}
+	if sKey.EKHandle != 0 {
+		hnd, err := t.loadUnderEK(sKey.EKHandle, sKey.Public, sKey.Blob)
+		if err != nil {
+			return 0, nil, err
+		}
+		return hnd, sKey, nil
+	}
 
 	srk, _, err := t.getStorageRootKeyHandle(parent)
 	if err != nil {
@@ -399,11 +1153,61 @@ func (t *wrappedTPM20) deserializeAndLoad(opaqueBlob []byte, parent ParentKeyCon
 	}
 	var hnd tpmutil.Handle
 	if hnd, _, err = tpm2.Load(t.rwc, srk, "", sKey.Public, sKey.Blob); err != nil {
-		return 0, nil, fmt.Errorf("Load() failed: %v", err)
+		return 0, nil, objectMemoryError("Load()", err)
 	}
 	return hnd, sKey, nil
 }
 
+// readPersistentKey reads the public area of the key at a persistent handle,
+// returning an error if it does not match the encoded public area.
+func (t *wrappedTPM20) readPersistentKey(handle tpmutil.Handle, public []byte) (tpm2.Public, error) {
+	if handle < firstOwnerPersistentHandle || handle > lastOwnerPersistentHandle {
+		return tpm2.Public{}, fmt.Errorf("handle 0x%x is not a persistent handle in the owner hierarchy", handle)
+	}
+	pub, _, _, err := tpm2.ReadPublic(t.rwc, handle)
+	if err != nil {
+		return tpm2.Public{}, fmt.Errorf("reading persistent key at handle 0x%x: %v", handle, err)
+	}
+	encoded, err := pub.Encode()
+	if err != nil {
+		return tpm2.Public{}, fmt.Errorf("encoding public area: %v", err)
+	}
+	if !bytes.Equal(encoded, public) {
+		return tpm2.Public{}, fmt.Errorf("persistent key at handle 0x%x does not match the provided public area", handle)
+	}
+	return pub, nil
+}
+
+func (t *wrappedTPM20) loadAKFromHandle(handle tpmutil.Handle, public []byte) (*AK, error) {
+	pub, err := t.readPersistentKey(handle, public)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load attestation key: %w", err)
+	}
+	if want := tpm2.FlagRestricted | tpm2.FlagSign; pub.Attributes&want != want {
+		return nil, fmt.Errorf("key at handle 0x%x is not a restricted signing key", handle)
+	}
+	k := newWrappedAK20(handle, nil, public, nil, nil, nil)
+	k.(*wrappedKey20).persistent = true
+	return &AK{ak: k}, nil
+}
+
+func (t *wrappedTPM20) loadKeyFromHandle(handle tpmutil.Handle, public []byte) (*Key, error) {
+	tpmPub, err := t.readPersistentKey(handle, public)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load signing key: %w", err)
+	}
+	if tpmPub.Attributes&tpm2.FlagRestricted != 0 {
+		return nil, fmt.Errorf("key at handle 0x%x is a restricted key", handle)
+	}
+	pub, err := tpmPub.Key()
+	if err != nil {
+		return nil, fmt.Errorf("access public key: %v", err)
+	}
+	k := newWrappedKey20(handle, nil, public, nil, nil, nil)
+	k.(*wrappedKey20).persistent = true
+	return &Key{key: k, pub: pub, tpm: t}, nil
+}
+
 func (t *wrappedTPM20) loadAK(opaqueBlob []byte) (*AK, error) {
 	return t.loadAKWithParent(opaqueBlob, defaultParentConfig)
 }
@@ -411,9 +1215,12 @@ func (t *wrappedTPM20) loadAK(opaqueBlob []byte) (*AK, error) {
 func (t *wrappedTPM20) loadAKWithParent(opaqueBlob []byte, parent ParentKeyConfig) (*AK, error) {
 	hnd, sKey, err := t.deserializeAndLoad(opaqueBlob, parent)
 	if err != nil {
-		return nil, fmt.Errorf("cannot load attestation key: %v", err)
+		return nil, fmt.Errorf("cannot load attestation key: %w", err)
 	}
-	return &AK{ak: newWrappedAK20(hnd, sKey.Blob, sKey.Public, sKey.CreateData, sKey.CreateAttestation, sKey.CreateSignature)}, nil
+	k := newWrappedAK20(hnd, sKey.Blob, sKey.Public, sKey.CreateData, sKey.CreateAttestation, sKey.CreateSignature)
+	k.(*wrappedKey20).persistent = sKey.PersistentHandle != 0
+	k.(*wrappedKey20).ekHandle = sKey.EKHandle
+	return &AK{ak: k}, nil
 }
 
 func (t *wrappedTPM20) loadKey(opaqueBlob []byte) (*Key, error) {
@@ -423,7 +1230,7 @@ func (t *wrappedTPM20) loadKey(opaqueBlob []byte) (*Key, error) {
 func (t *wrappedTPM20) loadKeyWithParent(opaqueBlob []byte, parent ParentKeyConfig) (*Key, error) {
 	hnd, sKey, err := t.deserializeAndLoad(opaqueBlob, parent)
 	if err != nil {
-		return nil, fmt.Errorf("cannot load signing key: %v", err)
+		return nil, fmt.Errorf("cannot load signing key: %w", err)
 	}
 	tpmPub, err := tpm2.DecodePublic(sKey.Public)
 	if err != nil {
@@ -433,7 +1240,9 @@ func (t *wrappedTPM20) loadKeyWithParent(opaqueBlob []byte, parent ParentKeyConf
 	if err != nil {
 		return nil, fmt.Errorf("access public key: %v", err)
 	}
-	return &Key{key: newWrappedKey20(hnd, sKey.Blob, sKey.Public, sKey.CreateData, sKey.CreateAttestation, sKey.CreateSignature), pub: pub, tpm: t}, nil
+	k := newWrappedKey20(hnd, sKey.Blob, sKey.Public, sKey.CreateData, sKey.CreateAttestation, sKey.CreateSignature)
+	k.(*wrappedKey20).persistent = sKey.PersistentHandle != 0
+	return &Key{key: k, pub: pub, tpm: t}, nil
 }
 
 func (t *wrappedTPM20) pcrs(alg HashAlg) ([]PCR, error) {
@@ -461,12 +1270,22 @@ func (t *wrappedTPM20) measurementLog() ([]byte, error) {
 // wrappedKey20 represents a key manipulated through a *wrappedTPM20.
 type wrappedKey20 struct {
 	hnd tpmutil.Handle
+	// persistent is set if hnd is a persistent handle.
+	persistent bool
 
 	blob              []byte
 	public            []byte // used by both TPM1.2 and 2.0
 	createData        []byte
 	createAttestation []byte
 	createSignature   []byte
+	// auth is the authorization value of the key, if it has one.
+	auth []byte
+	// policySession, if set, authorizes the AK to sign within a policy
+	// session, as its user role can't be authorized with auth.
+	policySession func(io.ReadWriter, tpmutil.Handle) error
+	// ekHandle is the persistent handle of the EK which is the parent of
+	// the key, or zero if its parent is the SRK.
+	ekHandle tpmutil.Handle
 }
 
 func newWrappedAK20(hnd tpmutil.Handle, blob, public, createData, createAttestation, createSig []byte) ak {
@@ -501,17 +1320,127 @@ func (k *wrappedKey20) marshal() ([]byte, error) {
 		CreateData:        k.createData,
 		CreateAttestation: k.createAttestation,
 		CreateSignature:   k.createSignature,
+		PersistentHandle:  k.persistentHandle(),
+		EKHandle:          k.ekHandle,
 	}).Serialize()
 }
 
+// persistentHandle returns the handle of a persistent key, or zero.
+func (k *wrappedKey20) persistentHandle() tpmutil.Handle {
+	if !k.persistent {
+		return 0
+	}
+	return k.hnd
+}
+
+// setAuth replaces the authorization value of the key with a copy of auth,
+// zeroing the previous value.
+func (k *wrappedKey20) setAuth(auth []byte) {
+	clear(k.auth)
+	k.auth = nil
+	if len(auth) > 0 {
+		k.auth = append([]byte(nil), auth...)
+	}
+}
+
 func (k *wrappedKey20) close(t tpmBase) error {
 	tpm, ok := t.(*wrappedTPM20)
 	if !ok {
 		return fmt.Errorf("expected *wrappedTPM20, got %T", t)
 	}
+	k.setAuth(nil)
+	if k.persistent || k.hnd == tpm2.HandleNull {
+		// Persistent handles cannot be flushed, and are only removed by
+		// delete, after which there is nothing left to close.
+		return nil
+	}
 	return tpm2.FlushContext(tpm.rwc, k.hnd)
 }
 
+// Persistent handles in the owner hierarchy. See section 2.3.1 of the
+// TCG TPM v2.0 Provisioning Guidance.
+const (
+	firstOwnerPersistentHandle tpmutil.Handle = 0x81000000
+	lastOwnerPersistentHandle  tpmutil.Handle = 0x817fffff
+)
+
+func (k *wrappedKey20) evict(tb tpmBase, handle tpmutil.Handle, force bool) error {
+	t, ok := tb.(*wrappedTPM20)
+	if !ok {
+		return fmt.Errorf("expected *wrappedTPM20, got %T", tb)
+	}
+	if k.persistent {
+		return fmt.Errorf("key is already persistent at handle 0x%x", k.hnd)
+	}
+	if handle < firstOwnerPersistentHandle || handle > lastOwnerPersistentHandle {
+		return fmt.Errorf("handle 0x%x is not a persistent handle in the owner hierarchy", handle)
+	}
+	if err := t.freePersistentHandle(handle, force); err != nil {
+		return err
+	}
+	if err := tpm2.EvictControl(t.rwc, "", tpm2.HandleOwner, k.hnd, handle); err != nil {
+		return fmt.Errorf("EvictControl() failed: %v", err)
+	}
+	transient := k.hnd
+	k.hnd, k.persistent = handle, true
+	if err := tpm2.FlushContext(t.rwc, transient); err != nil {
+		return fmt.Errorf("key was made persistent, but flushing transient handle 0x%x failed: %v", transient, err)
+	}
+	return nil
+}
+
+// freePersistentHandle checks that no key is persistent at handle. If force
+// is set, a key found there is deleted instead.
+func (t *wrappedTPM20) freePersistentHandle(handle tpmutil.Handle, force bool) error {
+	_, _, _, err := tpm2.ReadPublic(t.rwc, handle)
+	var handleErr tpm2.HandleError
+	if errors.As(err, &handleErr) && handleErr.Code == tpm2.RCHandle {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("checking persistent handle 0x%x: %v", handle, err)
+	}
+	if !force {
+		return fmt.Errorf("%w: handle 0x%x holds %s", ErrHandleInUse, handle, persistentHandleOwner(handle))
+	}
+	if err := tpm2.EvictControl(t.rwc, "", tpm2.HandleOwner, handle, handle); err != nil {
+		return fmt.Errorf("deleting key at handle 0x%x: %v", handle, err)
+	}
+	return nil
+}
+
+// persistentHandleOwner describes the key this package expects to find at
+// a persistent handle.
+func persistentHandleOwner(handle tpmutil.Handle) string {
+	switch handle {
+	case defaultParentConfig.Handle:
+		return "the default SRK"
+	case commonRSAEkEquivalentHandle:
+		return "the RSA EK"
+	case commonECCEkEquivalentHandle:
+		return "the ECC EK"
+	}
+	return "another key"
+}
+
+func (k *wrappedKey20) delete(tb tpmBase) error {
+	t, ok := tb.(*wrappedTPM20)
+	if !ok {
+		return fmt.Errorf("expected *wrappedTPM20, got %T", tb)
+	}
+	if !k.persistent {
+		return errors.New("key is not persistent")
+	}
+	if err := tpm2.EvictControl(t.rwc, "", tpm2.HandleOwner, k.hnd, k.hnd); err != nil {
+		return fmt.Errorf("EvictControl() failed: %v", err)
+	}
+	// The key is no longer loaded, and its handle may be reused by another
+	// key.
+	k.hnd = tpm2.HandleNull
+	k.persistent = false
+	return nil
+}
+
 func (k *wrappedKey20) activateCredential(tb tpmBase, in EncryptedCredential, ek *EK) ([]byte, error) {
 	t, ok := tb.(*wrappedTPM20)
 	if !ok {
@@ -531,32 +1460,26 @@ func (k *wrappedKey20) activateCredential(tb tpmBase, in EncryptedCredential, ek
 	if err != nil {
 		return nil, err
 	}
-
-	sessHandle, _, err := tpm2.StartAuthSession(
-		t.rwc,
-		tpm2.HandleNull,  /*tpmKey*/
-		tpm2.HandleNull,  /*bindKey*/
-		make([]byte, 16), /*nonceCaller*/
-		nil,              /*secret*/
-		tpm2.SessionPolicy,
-		tpm2.AlgNull,
-		tpm2.AlgSHA256)
+	sessHandle, err := t.ekPolicySession(ekHnd)
 	if err != nil {
-		return nil, fmt.Errorf("creating session: %v", err)
+		return nil, err
 	}
 	defer tpm2.FlushContext(t.rwc, sessHandle)
 
-	if _, _, err := tpm2.PolicySecret(t.rwc, tpm2.HandleEndorsement, tpm2.AuthCommand{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession}, sessHandle, nil, nil, nil, 0); err != nil {
-		return nil, fmt.Errorf("tpm2.PolicySecret() failed: %v", err)
-	}
-
 	return tpm2.ActivateCredentialUsingAuth(t.rwc, []tpm2.AuthCommand{
-		{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession},
+		{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession, Auth: k.auth},
 		{Session: sessHandle, Attributes: tpm2.AttrContinueSession},
 	}, k.hnd, ekHnd, credential, secret)
 }
 
 func (k *wrappedKey20) certify(tb tpmBase, handle interface{}) (*CertificationParameters, error) {
+	return k.certifyWithQualifyingData(tb, handle, "", nil)
+}
+
+// certifyWithQualifyingData certifies the key at handle, whose
+// authorization value is auth, including qualifyingData in the signed
+// attestation.
+func (k *wrappedKey20) certifyWithQualifyingData(tb tpmBase, handle interface{}, auth string, qualifyingData []byte) (*CertificationParameters, error) {
 	t, ok := tb.(*wrappedTPM20)
 	if !ok {
 		return nil, fmt.Errorf("expected *wrappedTPM20, got %T", tb)
@@ -565,11 +1488,21 @@ func (k *wrappedKey20) certify(tb tpmBase, handle interface{}) (*CertificationPa
 	if !ok {
 		return nil, fmt.Errorf("expected tpmutil.Handle, got %T", handle)
 	}
+	pub, err := tpm2.DecodePublic(k.public)
+	if err != nil {
+		return nil, fmt.Errorf("decode public key: %v", err)
+	}
 	scheme := tpm2.SigScheme{
 		Alg:  tpm2.AlgRSASSA,
 		Hash: tpm2.AlgSHA256,
 	}
-	return certify(t.rwc, hnd, k.hnd, scheme)
+	if pub.Type == tpm2.AlgECC {
+		scheme.Alg = tpm2.AlgECDSA
+	}
+	if k.policySession != nil {
+		return certifyWithPolicy(t.rwc, hnd, k.hnd, k.public, auth, qualifyingData, k.policySession)
+	}
+	return certify(t.rwc, hnd, k.hnd, auth, string(k.auth), qualifyingData, scheme)
 }
 
 func (k *wrappedKey20) quote(tb tpmBase, nonce []byte, alg HashAlg, selectedPCRs []int) (*Quote, error) {
@@ -577,7 +1510,14 @@ func (k *wrappedKey20) quote(tb tpmBase, nonce []byte, alg HashAlg, selectedPCRs
 	if !ok {
 		return nil, fmt.Errorf("expected *wrappedTPM20, got %T", tb)
 	}
-	return quote20(t.rwc, k.hnd, tpm2.Algorithm(alg), nonce, selectedPCRs)
+	if k.policySession != nil {
+		return quoteWithPolicy(t.rwc, k.hnd, k.public, k.policySession, tpm2.Algorithm(alg), nonce, selectedPCRs)
+	}
+	return quote20(t.rwc, k.hnd, string(k.auth), tpm2.Algorithm(alg), nonce, selectedPCRs)
+}
+
+func (k *wrappedKey20) tpmVersion() TPMVersion {
+	return TPMVersion20
 }
 
 func (k *wrappedKey20) attestationParameters() AttestationParameters {
@@ -602,16 +1542,20 @@ func (k *wrappedKey20) sign(tb tpmBase, digest []byte, pub crypto.PublicKey, opt
 	if !ok {
 		return nil, fmt.Errorf("expected *wrappedTPM20, got %T", tb)
 	}
+	rw := t.withRetry().rwc
 	switch p := pub.(type) {
 	case *ecdsa.PublicKey:
-		return signECDSA(t.rwc, k.hnd, digest, p.Curve)
+		_, raw := opts.(*RawECDSAOptions)
+		return signECDSA(rw, k.hnd, string(k.auth), digest, p.Curve, raw)
 	case *rsa.PublicKey:
-		return signRSA(t.rwc, k.hnd, digest, opts)
+		return signRSA(rw, k.hnd, string(k.auth), digest, opts)
 	}
 	return nil, fmt.Errorf("unsupported signing key type: %T", pub)
 }
 
-func signECDSA(rw io.ReadWriter, key tpmutil.Handle, digest []byte, curve elliptic.Curve) ([]byte, error) {
+// signECDSA signs digest, returning an ASN.1 DER encoded signature, or the
+// concatenation of r and s if raw is set.
+func signECDSA(rw io.ReadWriter, key tpmutil.Handle, auth string, digest []byte, curve elliptic.Curve, raw bool) ([]byte, error) {
 	// https://cs.opensource.google/go/go/+/refs/tags/go1.19.2:src/crypto/ecdsa/ecdsa.go;l=181
 	orderBits := curve.Params().N.BitLen()
 	orderBytes := (orderBits + 7) / 8
@@ -627,20 +1571,26 @@ func signECDSA(rw io.ReadWriter, key tpmutil.Handle, digest []byte, curve ellipt
 	// that may have been dropped when converting the digest to an integer
 	digest = ret.FillBytes(digest)
 
-	sig, err := tpm2.Sign(rw, key, "", digest, nil, nil)
+	sig, err := tpm2.Sign(rw, key, auth, digest, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("cannot sign: %v", err)
 	}
 	if sig.ECC == nil {
 		return nil, fmt.Errorf("expected ECDSA signature, got: %v", sig.Alg)
 	}
+	if raw {
+		out := make([]byte, 2*orderBytes)
+		sig.ECC.R.FillBytes(out[:orderBytes])
+		sig.ECC.S.FillBytes(out[orderBytes:])
+		return out, nil
+	}
 	return asn1.Marshal(struct {
 		R *big.Int
 		S *big.Int
 	}{sig.ECC.R, sig.ECC.S})
 }
 
-func signRSA(rw io.ReadWriter, key tpmutil.Handle, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+func signRSA(rw io.ReadWriter, key tpmutil.Handle, auth string, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
 	h, err := tpm2.HashToAlgorithm(opts.HashFunc())
 	if err != nil {
 		return nil, fmt.Errorf("incorrect hash algorithm: %v", err)
@@ -651,14 +1601,18 @@ func signRSA(rw io.ReadWriter, key tpmutil.Handle, digest []byte, opts crypto.Si
 		Hash: h,
 	}
 
+	// The TPM always uses a salt length equal to the length of the digest
+	// when producing RSASSA-PSS signatures.
 	if pss, ok := opts.(*rsa.PSSOptions); ok {
-		if pss.SaltLength != rsa.PSSSaltLengthAuto && pss.SaltLength != len(digest) {
-			return nil, fmt.Errorf("PSS salt length %d is incorrect, expected rsa.PSSSaltLengthAuto or %d", pss.SaltLength, len(digest))
+		switch pss.SaltLength {
+		case rsa.PSSSaltLengthAuto, rsa.PSSSaltLengthEqualsHash, len(digest):
+		default:
+			return nil, fmt.Errorf("PSS salt length %d is incorrect, expected rsa.PSSSaltLengthAuto, rsa.PSSSaltLengthEqualsHash or %d", pss.SaltLength, len(digest))
 		}
 		scheme.Alg = tpm2.AlgRSAPSS
 	}
 
-	sig, err := tpm2.Sign(rw, key, "", digest, nil, scheme)
+	sig, err := tpm2.Sign(rw, key, auth, digest, nil, scheme)
 	if err != nil {
 		return nil, fmt.Errorf("cannot sign: %v", err)
 	}
@@ -668,8 +1622,56 @@ func signRSA(rw io.ReadWriter, key tpmutil.Handle, digest []byte, opts crypto.Si
 	return sig.RSA.Signature, nil
 }
 
-func (k *wrappedKey20) decrypt(tb tpmBase, ctxt []byte) ([]byte, error) {
-	return nil, fmt.Errorf("not implemented")
+func (k *wrappedKey20) decrypt(tb tpmBase, ctxt []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	t, ok := tb.(*wrappedTPM20)
+	if !ok {
+		return nil, fmt.Errorf("expected *wrappedTPM20, got %T", tb)
+	}
+	pub, err := tpm2.DecodePublic(k.public)
+	if err != nil {
+		return nil, fmt.Errorf("decode public key: %v", err)
+	}
+	if pub.Type != tpm2.AlgRSA {
+		return nil, fmt.Errorf("decryption is only supported for RSA keys, got key of type 0x%x", pub.Type)
+	}
+	if pub.Attributes&tpm2.FlagDecrypt == 0 {
+		return nil, errors.New("key was not created for decryption")
+	}
+
+	scheme := &tpm2.AsymScheme{Alg: tpm2.AlgRSAES}
+	var label string
+	switch o := opts.(type) {
+	case nil:
+	case *rsa.PKCS1v15DecryptOptions:
+		if o.SessionKeyLen > 0 {
+			return nil, errors.New("PKCS #1 v1.5 session key decryption is not supported")
+		}
+	case *rsa.OAEPOptions:
+		if o.MGFHash != 0 && o.MGFHash != o.Hash {
+			return nil, fmt.Errorf("OAEP MGF1 hash %v must match the label hash %v", o.MGFHash, o.Hash)
+		}
+		hashAlg, err := tpm2.HashToAlgorithm(o.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported OAEP hash: %v", err)
+		}
+		// The TPM requires labels to be NUL-terminated, and go-tpm appends
+		// the terminator itself.
+		if n := len(o.Label); n > 0 {
+			if o.Label[n-1] != 0 {
+				return nil, errors.New("OAEP label must be NUL-terminated")
+			}
+			label = string(o.Label[:n-1])
+		}
+		scheme = &tpm2.AsymScheme{Alg: tpm2.AlgOAEP, Hash: hashAlg}
+	default:
+		return nil, fmt.Errorf("unsupported decrypter options: %T", opts)
+	}
+
+	pt, err := tpm2.RSADecrypt(t.rwc, k.hnd, string(k.auth), ctxt, scheme, label)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt: %v", err)
+	}
+	return pt, nil
 }
 
 func (k *wrappedKey20) blobs() ([]byte, []byte, error) {
@@ -285,7 +285,7 @@ func ParseAttributeCertificate(asn1Data []byte) (*AttributeCertificate, error) {
 		return nil, asn1.SyntaxError{Msg: "attributecert: trailing data"}
 	}
 
-	return parseAttributeCertificate(&cert)
+	return parseAttributeCertificate(&cert, nil, nil)
 }
 
 type PlatformDataSequence []PlatformDataSET
@@ -472,7 +472,27 @@ func unmarshalSAN(v asn1.RawValue) ([]pkix.AttributeTypeAndValue, error) {
 	return nil, fmt.Errorf("attributecert: unexpected SAN type %v", v.Tag)
 }
 
-func parseAttributeCertificate(in *attributeCertificate) (*AttributeCertificate, error) {
+// unmarshalPlatformConfigurationV2 unmarshals a version 2 platform
+// configuration, including those encoding a single platform property.
+func unmarshalPlatformConfigurationV2(der []byte) (PlatformConfigurationV2, error) {
+	var platformConfiguration PlatformConfigurationV2
+	if _, err := asn1.Unmarshal(der, &platformConfiguration); err != nil {
+		var workaround PlatformConfigurationV2Workaround
+		if _, err := asn1.Unmarshal(der, &workaround); err != nil {
+			return platformConfiguration, err
+		}
+		platformConfiguration.ComponentIdentifiers = workaround.ComponentIdentifiers
+		platformConfiguration.ComponentIdentifiersURI = workaround.ComponentIdentifiersURI
+		platformConfiguration.PlatformProperties = append(platformConfiguration.PlatformProperties, workaround.PlatformProperty)
+		platformConfiguration.PlatformPropertiesURI = workaround.PlatformPropertiesURI
+	}
+	return platformConfiguration, nil
+}
+
+// parseAttributeCertificate parses in. If unknown is nil, attributes and
+// extensions which are unknown or fail to parse are an error, otherwise
+// they are appended to unknown.
+func parseAttributeCertificate(in *attributeCertificate, unknownAttributes *[]RawAttribute, unknownExtensions *[]pkix.Extension) (*AttributeCertificate, error) {
 	out := &AttributeCertificate{
 		Raw:                        in.Raw,
 		RawTBSAttributeCertificate: in.TBSAttributeCertificate.Raw,
@@ -513,239 +533,271 @@ func parseAttributeCertificate(in *attributeCertificate) (*AttributeCertificate,
 	out.NotAfter = in.TBSAttributeCertificate.Validity.NotAfter
 
 	for _, attribute := range in.TBSAttributeCertificate.Attributes {
-		switch {
-		case attribute.ID.Equal(oidAttributeUserNotice):
-			if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &out.UserNotice); err != nil {
+		if err := out.parseAttribute(attribute); err != nil {
+			var uerr unknownOIDError
+			if unknownAttributes == nil || !errors.As(err, &uerr) {
 				return nil, err
 			}
-		case attribute.ID.Equal(oid.TCGPlatformSpecification):
-			if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &out.TCGPlatformSpecification); err != nil {
-				return nil, err
-			}
-		case attribute.ID.Equal(oid.TBBSecurityAssertions):
-			if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &out.TBBSecurityAssertions); err != nil {
+			*unknownAttributes = append(*unknownAttributes, rawAttribute(attribute))
+		}
+	}
+
+	for _, extension := range in.TBSAttributeCertificate.Extensions {
+		if err := out.parseExtension(extension); err != nil {
+			// Unknown critical extensions must not be ignored.
+			var uerr unknownOIDError
+			if unknownExtensions == nil || extension.Critical || !errors.As(err, &uerr) {
 				return nil, err
 			}
-		case attribute.ID.Equal(oid.TCGCredentialSpecification):
-			var credentialSpecification TCGCredentialSpecification
+			*unknownExtensions = append(*unknownExtensions, extension)
+		}
+	}
+
+	return out, nil
+}
+
+// unknownOIDError is returned when an attribute or extension of a
+// certificate has an OID which isn't known to the parser.
+type unknownOIDError struct {
+	kind string
+	id   asn1.ObjectIdentifier
+}
+
+func (e unknownOIDError) Error() string {
+	return fmt.Sprintf("attributecert: unknown %s %v", e.kind, e.id)
+}
+
+// parseAttribute parses an attribute of the certificate into c.
+func (c *AttributeCertificate) parseAttribute(attribute attribute) error {
+	if len(attribute.RawValues) == 0 {
+		return fmt.Errorf("attributecert: attribute %v has no values", attribute.ID)
+	}
+	switch {
+	case attribute.ID.Equal(oidAttributeUserNotice):
+		if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &c.UserNotice); err != nil {
+			return err
+		}
+	case attribute.ID.Equal(oid.TCGPlatformSpecification):
+		if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &c.TCGPlatformSpecification); err != nil {
+			return err
+		}
+	case attribute.ID.Equal(oid.TBBSecurityAssertions):
+		if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &c.TBBSecurityAssertions); err != nil {
+			return err
+		}
+	case attribute.ID.Equal(oid.TCGCredentialSpecification):
+		var credentialSpecification TCGCredentialSpecification
+		if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &credentialSpecification); err != nil {
+			var credentialSpecification TCGSpecificationVersion
 			if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &credentialSpecification); err != nil {
-				var credentialSpecification TCGSpecificationVersion
-				if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &credentialSpecification); err != nil {
-					return nil, err
-				}
+				return err
 			}
-		case attribute.ID.Equal(oid.TCGCredentialType):
-			var credentialType TCGCredentialType
-			if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &credentialType); err != nil {
-				return nil, err
+		}
+	case attribute.ID.Equal(oid.TCGCredentialType):
+		var credentialType TCGCredentialType
+		if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &credentialType); err != nil {
+			return err
+		}
+	case attribute.ID.Equal(oid.PlatformConfigurationV1):
+		var platformConfiguration PlatformConfigurationV1
+		if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &platformConfiguration); err != nil {
+			return err
+		}
+		for _, component := range platformConfiguration.ComponentIdentifiers {
+			t := Component{
+				Manufacturer:     component.ComponentManufacturer,
+				Model:            component.ComponentModel,
+				Serial:           component.ComponentSerial,
+				Revision:         component.ComponentRevision,
+				ManufacturerID:   component.ComponentManufacturerID,
+				FieldReplaceable: component.FieldReplaceable,
+				Addresses:        component.ComponentAddresses,
 			}
-		case attribute.ID.Equal(oid.PlatformConfigurationV1):
-			var platformConfiguration PlatformConfigurationV1
-			if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &platformConfiguration); err != nil {
-				return nil, err
+			c.Components = append(c.Components, t)
+		}
+		c.Properties = platformConfiguration.PlatformProperties
+		c.PropertiesURI = platformConfiguration.PlatformPropertiesURI.UniformResourceIdentifier
+	case attribute.ID.Equal(oid.PlatformConfigurationV2):
+		platformConfiguration, err := unmarshalPlatformConfigurationV2(attribute.RawValues[0].FullBytes)
+		if err != nil {
+			return err
+		}
+		for _, component := range platformConfiguration.ComponentIdentifiers {
+			t := Component{
+				Manufacturer:     component.ComponentManufacturer,
+				Model:            component.ComponentModel,
+				Serial:           component.ComponentSerial,
+				Revision:         component.ComponentRevision,
+				ManufacturerID:   component.ComponentManufacturerID,
+				FieldReplaceable: component.FieldReplaceable,
+				Addresses:        component.ComponentAddresses,
 			}
-			for _, component := range platformConfiguration.ComponentIdentifiers {
-				t := Component{
-					Manufacturer:     component.ComponentManufacturer,
-					Model:            component.ComponentModel,
-					Serial:           component.ComponentSerial,
-					Revision:         component.ComponentRevision,
-					ManufacturerID:   component.ComponentManufacturerID,
-					FieldReplaceable: component.FieldReplaceable,
-					Addresses:        component.ComponentAddresses,
-				}
-				out.Components = append(out.Components, t)
+			c.Components = append(c.Components, t)
+		}
+		c.Properties = platformConfiguration.PlatformProperties
+		c.PropertiesURI = platformConfiguration.PlatformPropertiesURI.UniformResourceIdentifier
+	case attribute.ID.Equal(oid.PlatformConfigURI):
+		var platformConfigurationURI URIReference
+		if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &platformConfigurationURI); err != nil {
+			return err
+		}
+	default:
+		return unknownOIDError{kind: "attribute", id: attribute.ID}
+	}
+	return nil
+}
+
+// parseExtension parses an extension of the certificate into c.
+func (c *AttributeCertificate) parseExtension(extension pkix.Extension) error {
+	switch {
+	case extension.Id.Equal(oid.SubjectAltName):
+		var seq asn1.RawValue
+		rest, err := asn1.Unmarshal(extension.Value, &seq)
+		if err != nil {
+			return err
+		} else if len(rest) != 0 {
+			return errors.New("attributecert: trailing data after X.509 extension")
+		}
+		rest = seq.Bytes
+		for len(rest) > 0 {
+			var v asn1.RawValue
+			rest, err = asn1.Unmarshal(rest, &v)
+			if err != nil {
+				return err
 			}
-			out.Properties = platformConfiguration.PlatformProperties
-			out.PropertiesURI = platformConfiguration.PlatformPropertiesURI.UniformResourceIdentifier
-		case attribute.ID.Equal(oid.PlatformConfigurationV2):
-			var platformConfiguration PlatformConfigurationV2
-			if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &platformConfiguration); err != nil {
-				var workaround PlatformConfigurationV2Workaround
-				if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &workaround); err != nil {
-					return nil, err
-				}
-				platformConfiguration.ComponentIdentifiers = workaround.ComponentIdentifiers
-				platformConfiguration.ComponentIdentifiersURI = workaround.ComponentIdentifiersURI
-				platformConfiguration.PlatformProperties = append(platformConfiguration.PlatformProperties, workaround.PlatformProperty)
-				platformConfiguration.PlatformPropertiesURI = workaround.PlatformPropertiesURI
+			tcgdata, err := unmarshalSAN(v)
+			if err != nil {
+				return fmt.Errorf("attributecert: failed to unmarshal SAN: %v", err)
 			}
-			for _, component := range platformConfiguration.ComponentIdentifiers {
-				t := Component{
-					Manufacturer:     component.ComponentManufacturer,
-					Model:            component.ComponentModel,
-					Serial:           component.ComponentSerial,
-					Revision:         component.ComponentRevision,
-					ManufacturerID:   component.ComponentManufacturerID,
-					FieldReplaceable: component.FieldReplaceable,
-					Addresses:        component.ComponentAddresses,
+			for _, e := range tcgdata {
+				switch {
+				case e.Type.Equal(oidTcgPlatformManufacturerStrV1):
+					c.PlatformManufacturer = e.Value.(string)
+				case e.Type.Equal(oidTcgPlatformModelV1):
+					c.PlatformModel = e.Value.(string)
+				case e.Type.Equal(oidTcgPlatformVersionV1):
+					c.PlatformVersion = e.Value.(string)
+				case e.Type.Equal(oid.TCGCredentialSpecification):
+					// This OID appears to be misused in this context
+					c.PlatformSerial = e.Value.(string)
+				case e.Type.Equal(oid.PlatformManufacturerStr):
+					c.PlatformManufacturer = e.Value.(string)
+				case e.Type.Equal(oid.PlatformManufacturerID):
+					// We can't parse these out at present
+					break
+				case e.Type.Equal(oid.PlatformModel):
+					c.PlatformModel = e.Value.(string)
+				case e.Type.Equal(oid.PlatformVersion):
+					c.PlatformVersion = e.Value.(string)
+				case e.Type.Equal(oid.PlatformSerial):
+					c.PlatformSerial = e.Value.(string)
+				default:
+					return fmt.Errorf("attributecert: unhandled attribute: %v", e.Type)
 				}
-				out.Components = append(out.Components, t)
 			}
-			out.Properties = platformConfiguration.PlatformProperties
-			out.PropertiesURI = platformConfiguration.PlatformPropertiesURI.UniformResourceIdentifier
-		case attribute.ID.Equal(oid.PlatformConfigURI):
-			var platformConfigurationURI URIReference
-			if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &platformConfigurationURI); err != nil {
-				return nil, err
-			}
-		default:
-			return nil, fmt.Errorf("attributecert: unknown attribute %v", attribute.ID)
 		}
-	}
 
-	for _, extension := range in.TBSAttributeCertificate.Extensions {
-		switch {
-		case extension.Id.Equal(oid.SubjectAltName):
-			var seq asn1.RawValue
-			rest, err := asn1.Unmarshal(extension.Value, &seq)
+	case extension.Id.Equal(oid.SubjectDirectoryAttributes):
+		var seq asn1.RawValue
+		rest, err := asn1.Unmarshal(extension.Value, &seq)
+		if err != nil {
+			return err
+		} else if len(rest) != 0 {
+			return errors.New("attributecert: trailing data after X.509 extension")
+		}
+		rest = seq.Bytes
+		for len(rest) > 0 {
+			var e TCGDirectoryEntry
+			rest, err = asn1.Unmarshal(rest, &e)
 			if err != nil {
-				return nil, err
-			} else if len(rest) != 0 {
-				return nil, errors.New("attributecert: trailing data after X.509 extension")
+				return err
 			}
-			rest = seq.Bytes
-			for len(rest) > 0 {
-				var v asn1.RawValue
-				rest, err = asn1.Unmarshal(rest, &v)
+			switch {
+			case e.ID.Equal(oid.TCGPlatformSpecification):
+				var platformSpecification TCGPlatformSpecification
+				_, err := asn1.Unmarshal(e.Data.Bytes, &platformSpecification)
 				if err != nil {
-					return nil, err
+					return err
 				}
-				tcgdata, err := unmarshalSAN(v)
+				c.TCGPlatformSpecification = platformSpecification
+			case e.ID.Equal(oid.TBBSecurityAssertions):
+				var securityAssertions TBBSecurityAssertions_sda
+				_, err := asn1.Unmarshal(e.Data.Bytes, &securityAssertions)
 				if err != nil {
-					return nil, fmt.Errorf("attributecert: failed to unmarshal SAN: %v", err)
-				}
-				for _, e := range tcgdata {
-					switch {
-					case e.Type.Equal(oidTcgPlatformManufacturerStrV1):
-						out.PlatformManufacturer = e.Value.(string)
-					case e.Type.Equal(oidTcgPlatformModelV1):
-						out.PlatformModel = e.Value.(string)
-					case e.Type.Equal(oidTcgPlatformVersionV1):
-						out.PlatformVersion = e.Value.(string)
-					case e.Type.Equal(oid.TCGCredentialSpecification):
-						// This OID appears to be misused in this context
-						out.PlatformSerial = e.Value.(string)
-					case e.Type.Equal(oid.PlatformManufacturerStr):
-						out.PlatformManufacturer = e.Value.(string)
-					case e.Type.Equal(oid.PlatformManufacturerID):
-						// We can't parse these out at present
-						break
-					case e.Type.Equal(oid.PlatformModel):
-						out.PlatformModel = e.Value.(string)
-					case e.Type.Equal(oid.PlatformVersion):
-						out.PlatformVersion = e.Value.(string)
-					case e.Type.Equal(oid.PlatformSerial):
-						out.PlatformSerial = e.Value.(string)
-					default:
-						return nil, fmt.Errorf("attributecert: unhandled attribute: %v", e.Type)
-					}
+					return err
 				}
+				c.TBBSecurityAssertions.Version = securityAssertions.Version
+				c.TBBSecurityAssertions.CcInfo = CommonCriteriaMeasures(securityAssertions.CcInfo)
+				c.TBBSecurityAssertions.FipsLevel = securityAssertions.FipsLevel
+				c.TBBSecurityAssertions.RtmType = securityAssertions.RtmType
+				c.TBBSecurityAssertions.Iso9000Certified = securityAssertions.Iso9000Certified
+				c.TBBSecurityAssertions.Iso9000URI = securityAssertions.Iso9000URI
+			default:
+				return fmt.Errorf("attributecert: unhandled TCG directory attribute: %v", e.ID)
 			}
+		}
 
-		case extension.Id.Equal(oid.SubjectDirectoryAttributes):
-			var seq asn1.RawValue
-			rest, err := asn1.Unmarshal(extension.Value, &seq)
-			if err != nil {
-				return nil, err
-			} else if len(rest) != 0 {
-				return nil, errors.New("attributecert: trailing data after X.509 extension")
-			}
-			rest = seq.Bytes
-			for len(rest) > 0 {
-				var e TCGDirectoryEntry
-				rest, err = asn1.Unmarshal(rest, &e)
+	case extension.Id.Equal(oid.CertificatePolicies):
+		var policies []policyInformation
+		_, err := asn1.Unmarshal(extension.Value, &policies)
+		if err != nil {
+			return err
+		}
+		for _, policy := range policies {
+			if policy.ID.Equal(oidTcgCertificatePolicy) {
+				var subpolicies []policyInformation
+				_, err := asn1.Unmarshal(policy.Policy.FullBytes, &subpolicies)
 				if err != nil {
-					return nil, err
+					return err
 				}
-				switch {
-				case e.ID.Equal(oid.TCGPlatformSpecification):
-					var platformSpecification TCGPlatformSpecification
-					_, err := asn1.Unmarshal(e.Data.Bytes, &platformSpecification)
-					if err != nil {
-						return nil, err
-					}
-					out.TCGPlatformSpecification = platformSpecification
-				case e.ID.Equal(oid.TBBSecurityAssertions):
-					var securityAssertions TBBSecurityAssertions_sda
-					_, err := asn1.Unmarshal(e.Data.Bytes, &securityAssertions)
-					if err != nil {
-						return nil, err
-					}
-					out.TBBSecurityAssertions.Version = securityAssertions.Version
-					out.TBBSecurityAssertions.CcInfo = CommonCriteriaMeasures(securityAssertions.CcInfo)
-					out.TBBSecurityAssertions.FipsLevel = securityAssertions.FipsLevel
-					out.TBBSecurityAssertions.RtmType = securityAssertions.RtmType
-					out.TBBSecurityAssertions.Iso9000Certified = securityAssertions.Iso9000Certified
-					out.TBBSecurityAssertions.Iso9000URI = securityAssertions.Iso9000URI
-				default:
-					return nil, fmt.Errorf("attributecert: unhandled TCG directory attribute: %v", e.ID)
-				}
-			}
-
-		case extension.Id.Equal(oid.CertificatePolicies):
-			var policies []policyInformation
-			_, err := asn1.Unmarshal(extension.Value, &policies)
-			if err != nil {
-				return nil, err
-			}
-			for _, policy := range policies {
-				if policy.ID.Equal(oidTcgCertificatePolicy) {
-					var subpolicies []policyInformation
-					_, err := asn1.Unmarshal(policy.Policy.FullBytes, &subpolicies)
-					if err != nil {
-						return nil, err
-					}
-					for _, subpolicy := range subpolicies {
-						switch {
-						case subpolicy.ID.Equal(oidCpsCertificatePolicy):
-							var cpsPolicy cpsPolicy
-							_, err := asn1.Unmarshal(subpolicy.Raw, &cpsPolicy)
-							if err != nil {
-								return nil, err
-							}
-						case subpolicy.ID.Equal(oidAttributeUserNotice):
-							var userNotice string
-							_, err := asn1.Unmarshal(subpolicy.Policy.Bytes, &userNotice)
-							if err != nil {
-								return nil, err
-							}
-						default:
-							return nil, fmt.Errorf("attributecert: unhandled certificate policy: %v", subpolicy.ID)
+				for _, subpolicy := range subpolicies {
+					switch {
+					case subpolicy.ID.Equal(oidCpsCertificatePolicy):
+						var cpsPolicy cpsPolicy
+						_, err := asn1.Unmarshal(subpolicy.Raw, &cpsPolicy)
+						if err != nil {
+							return err
 						}
+					case subpolicy.ID.Equal(oidAttributeUserNotice):
+						var userNotice string
+						_, err := asn1.Unmarshal(subpolicy.Policy.Bytes, &userNotice)
+						if err != nil {
+							return err
+						}
+					default:
+						return fmt.Errorf("attributecert: unhandled certificate policy: %v", subpolicy.ID)
 					}
 				}
 			}
+		}
 
-		case extension.Id.Equal(oidExtensionAuthorityKeyIdentifier):
-			var a authKeyID
-			_, err := asn1.Unmarshal(extension.Value, &a)
-			if err != nil {
-				return nil, err
-			}
+	case extension.Id.Equal(oidExtensionAuthorityKeyIdentifier):
+		var a authKeyID
+		_, err := asn1.Unmarshal(extension.Value, &a)
+		if err != nil {
+			return err
+		}
 
-		case extension.Id.Equal(oidAuthorityInfoAccess):
-			var aia []authorityInfoAccess
-			_, err := asn1.Unmarshal(extension.Value, &aia)
-			if err != nil {
-				return nil, err
-			}
-			for _, v := range aia {
-				if v.Method.Equal(oidAuthorityInfoAccessOcsp) {
-					//TODO
-				} else if v.Method.Equal(oidAuthorityInfoAccessIssuers) {
-					//TODO
-				} else {
-					return nil, fmt.Errorf("attributecert: unhandled Authority Info Access type %v", v.Method)
-				}
+	case extension.Id.Equal(oidAuthorityInfoAccess):
+		var aia []authorityInfoAccess
+		_, err := asn1.Unmarshal(extension.Value, &aia)
+		if err != nil {
+			return err
+		}
+		for _, v := range aia {
+			if v.Method.Equal(oidAuthorityInfoAccessOcsp) {
+				//TODO
+			} else if v.Method.Equal(oidAuthorityInfoAccessIssuers) {
+				//TODO
+			} else {
+				return fmt.Errorf("attributecert: unhandled Authority Info Access type %v", v.Method)
 			}
-
-		default:
-			return nil, fmt.Errorf("attributecert: unknown extension ID %v", extension.Id)
 		}
-	}
 
-	return out, nil
+	default:
+		return unknownOIDError{kind: "extension ID", id: extension.Id}
+	}
+	return nil
 }
 
 // CheckSignatureFrom verifies that the signature on c is a valid signature
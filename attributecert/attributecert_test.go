@@ -16,11 +16,16 @@ package attributecert
 
 import (
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/json"
+	"math/big"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/google/go-attestation/oid"
 )
 
 func TestVerifyAttributeCert(t *testing.T) {
@@ -91,3 +96,133 @@ func TestParseAttributeCerts(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePlatformCertificate(t *testing.T) {
+	files, err := os.ReadDir("testdata")
+	if err != nil {
+		t.Fatalf("failed to read test dir: %v", err)
+	}
+	for _, file := range files {
+		if strings.Contains(file.Name(), "Signing") || strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		filename := "testdata/" + file.Name()
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("failed to read test data %s: %v", filename, err)
+		}
+		want, err := ParseAttributeCertificate(data)
+		if err != nil {
+			t.Fatalf("failed to parse test data %s: %v", filename, err)
+		}
+		got, err := ParsePlatformCertificate(data)
+		if err != nil {
+			t.Fatalf("failed to parse %s as platform certificate: %v", filename, err)
+		}
+		if !reflect.DeepEqual(&got.AttributeCertificate, want) {
+			t.Errorf("%s: attribute certificate does not match ParseAttributeCertificate", filename)
+		}
+		if len(got.UnknownAttributes) != 0 {
+			t.Errorf("%s: unexpected unknown attributes %v", filename, got.UnknownAttributes)
+		}
+		if len(got.ComponentIdentifiers) != len(want.Components) {
+			t.Errorf("%s: got %d component identifiers, want %d", filename, len(got.ComponentIdentifiers), len(want.Components))
+		}
+		for i, c := range got.ComponentIdentifiers {
+			if c.ComponentManufacturer != want.Components[i].Manufacturer || c.ComponentSerial != want.Components[i].Serial {
+				t.Errorf("%s: component identifier %d = %+v, want %+v", filename, i, c, want.Components[i])
+			}
+		}
+
+		ekCert := &x509.Certificate{RawIssuer: got.HolderRawIssuer, SerialNumber: got.Holder.Serial}
+		if err := got.CheckHolder(ekCert); err != nil {
+			t.Errorf("%s: CheckHolder() failed: %v", filename, err)
+		}
+		ekCert.SerialNumber = new(big.Int).Add(got.Holder.Serial, big.NewInt(1))
+		if err := got.CheckHolder(ekCert); err == nil {
+			t.Errorf("%s: CheckHolder() succeeded with the wrong serial number", filename)
+		}
+		ekCert = &x509.Certificate{RawIssuer: []byte{0x30, 0}, SerialNumber: got.Holder.Serial}
+		if err := got.CheckHolder(ekCert); err == nil {
+			t.Errorf("%s: CheckHolder() succeeded with the wrong issuer", filename)
+		}
+	}
+}
+
+func TestParsePlatformCertificateUnknownAttributes(t *testing.T) {
+	data, err := os.ReadFile("testdata/plat_cert1.cer")
+	if err != nil {
+		t.Fatalf("failed to read test data: %v", err)
+	}
+	var cert attributeCertificate
+	if _, err := asn1.Unmarshal(data, &cert); err != nil {
+		t.Fatalf("failed to unmarshal test data: %v", err)
+	}
+	unknownOID := asn1.ObjectIdentifier{1, 2, 3, 4}
+	tbs := &cert.TBSAttributeCertificate
+	tbs.Attributes = append(tbs.Attributes, attribute{ID: unknownOID, RawValues: []asn1.RawValue{{FullBytes: asn1.NullBytes}}})
+	tbs.Extensions = append(tbs.Extensions, pkix.Extension{Id: unknownOID, Value: []byte{1, 2}})
+
+	if _, err := parseAttributeCertificate(&cert, nil, nil); err == nil {
+		t.Error("parseAttributeCertificate() succeeded with unknown attributes")
+	}
+	got, err := parsePlatformCertificate(&cert)
+	if err != nil {
+		t.Fatalf("parsePlatformCertificate() failed: %v", err)
+	}
+	wantAttributes := []RawAttribute{{ID: unknownOID, Values: [][]byte{asn1.NullBytes}}}
+	if !reflect.DeepEqual(got.UnknownAttributes, wantAttributes) {
+		t.Errorf("UnknownAttributes = %v, want %v", got.UnknownAttributes, wantAttributes)
+	}
+	wantExtensions := []pkix.Extension{{Id: unknownOID, Value: []byte{1, 2}}}
+	if !reflect.DeepEqual(got.UnknownExtensions, wantExtensions) {
+		t.Errorf("UnknownExtensions = %v, want %v", got.UnknownExtensions, wantExtensions)
+	}
+	if got.PlatformManufacturer == "" || len(got.ComponentIdentifiers) == 0 {
+		t.Errorf("known attributes were not parsed: %+v", got)
+	}
+}
+
+func TestParsePlatformCertificateRejects(t *testing.T) {
+	data, err := os.ReadFile("testdata/plat_cert1.cer")
+	if err != nil {
+		t.Fatalf("failed to read test data: %v", err)
+	}
+	unknownOID := asn1.ObjectIdentifier{1, 2, 3, 4}
+	tests := []struct {
+		name      string
+		attribute *attribute
+		extension *pkix.Extension
+	}{
+		{
+			name:      "known attribute which fails to parse",
+			attribute: &attribute{ID: oid.PlatformConfigURI, RawValues: []asn1.RawValue{{FullBytes: asn1.NullBytes}}},
+		},
+		{
+			name:      "known extension which fails to parse",
+			extension: &pkix.Extension{Id: oidExtensionAuthorityKeyIdentifier, Value: []byte{1, 2}},
+		},
+		{
+			name:      "unknown critical extension",
+			extension: &pkix.Extension{Id: unknownOID, Critical: true, Value: []byte{1, 2}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var cert attributeCertificate
+			if _, err := asn1.Unmarshal(data, &cert); err != nil {
+				t.Fatalf("failed to unmarshal test data: %v", err)
+			}
+			tbs := &cert.TBSAttributeCertificate
+			if test.attribute != nil {
+				tbs.Attributes = append(tbs.Attributes, *test.attribute)
+			}
+			if test.extension != nil {
+				tbs.Extensions = append(tbs.Extensions, *test.extension)
+			}
+			if _, err := parsePlatformCertificate(&cert); err == nil {
+				t.Error("parsePlatformCertificate() succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2026 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package attributecert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-attestation/oid"
+)
+
+// RawAttribute is an attribute of a certificate with an unknown OID, as its
+// OID and DER encoded values.
+type RawAttribute struct {
+	ID     asn1.ObjectIdentifier
+	Values [][]byte
+}
+
+func rawAttribute(a attribute) RawAttribute {
+	ra := RawAttribute{ID: a.ID}
+	for _, v := range a.RawValues {
+		ra.Values = append(ra.Values, v.FullBytes)
+	}
+	return ra
+}
+
+// PlatformCertificate is a TCG Platform Attribute Certificate, which binds
+// the components of a platform to the EK certificate of its TPM.
+type PlatformCertificate struct {
+	AttributeCertificate
+
+	// HolderRawIssuer is the DER encoded issuer name of the EK
+	// certificate the platform certificate is bound to, whose serial
+	// number is Holder.Serial.
+	HolderRawIssuer []byte
+	// ComponentIdentifiers are the components of the platform, as listed
+	// by its platform configuration. Components of version 1 platform
+	// configurations only have a ComponentClassValue.
+	ComponentIdentifiers []ComponentIdentifierV2
+	// UnknownAttributes are the attributes of the certificate with
+	// unknown OIDs.
+	UnknownAttributes []RawAttribute
+	// UnknownExtensions are the non-critical extensions of the
+	// certificate with unknown OIDs.
+	UnknownExtensions []pkix.Extension
+}
+
+// ParsePlatformCertificate parses a TCG Platform Attribute Certificate from
+// the given ASN.1 DER data. Unlike ParseAttributeCertificate, attributes
+// and non-critical extensions with unknown OIDs are preserved in
+// UnknownAttributes and UnknownExtensions rather than failing the parse.
+// Known attributes or extensions which fail to parse, and unknown critical
+// extensions, are still errors.
+func ParsePlatformCertificate(der []byte) (*PlatformCertificate, error) {
+	var cert attributeCertificate
+	rest, err := asn1.Unmarshal(der, &cert)
+	if err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, asn1.SyntaxError{Msg: "attributecert: trailing data"}
+	}
+	return parsePlatformCertificate(&cert)
+}
+
+func parsePlatformCertificate(in *attributeCertificate) (*PlatformCertificate, error) {
+	out := &PlatformCertificate{}
+	ac, err := parseAttributeCertificate(in, &out.UnknownAttributes, &out.UnknownExtensions)
+	if err != nil {
+		return nil, err
+	}
+	out.AttributeCertificate = *ac
+
+	// parseAttributeCertificate already checked the holder is a
+	// directory name.
+	var v asn1.RawValue
+	if _, err := asn1.Unmarshal(in.TBSAttributeCertificate.Holder.BaseCertificateID.Issuer.Bytes, &v); err != nil {
+		return nil, err
+	}
+	out.HolderRawIssuer = v.Bytes
+
+	for _, attribute := range in.TBSAttributeCertificate.Attributes {
+		if len(attribute.RawValues) == 0 {
+			continue
+		}
+		switch {
+		case attribute.ID.Equal(oid.PlatformConfigurationV1):
+			var platformConfiguration PlatformConfigurationV1
+			if _, err := asn1.Unmarshal(attribute.RawValues[0].FullBytes, &platformConfiguration); err != nil {
+				return nil, err
+			}
+			for _, component := range platformConfiguration.ComponentIdentifiers {
+				out.ComponentIdentifiers = append(out.ComponentIdentifiers, ComponentIdentifierV2{
+					ComponentClass:          ComponentClass{ComponentClassValue: component.ComponentClass},
+					ComponentManufacturer:   component.ComponentManufacturer,
+					ComponentModel:          component.ComponentModel,
+					ComponentSerial:         component.ComponentSerial,
+					ComponentRevision:       component.ComponentRevision,
+					ComponentManufacturerID: component.ComponentManufacturerID,
+					FieldReplaceable:        component.FieldReplaceable,
+					ComponentAddresses:      component.ComponentAddresses,
+				})
+			}
+		case attribute.ID.Equal(oid.PlatformConfigurationV2):
+			platformConfiguration, err := unmarshalPlatformConfigurationV2(attribute.RawValues[0].FullBytes)
+			if err != nil {
+				return nil, err
+			}
+			out.ComponentIdentifiers = append(out.ComponentIdentifiers, platformConfiguration.ComponentIdentifiers...)
+		}
+	}
+	return out, nil
+}
+
+// CheckHolder checks that the platform certificate is bound to the given EK
+// certificate, by the issuer and serial number of the EK certificate. The
+// signatures of neither certificate are checked.
+func (c *PlatformCertificate) CheckHolder(ekCert *x509.Certificate) error {
+	if c.Holder.Serial == nil {
+		return errors.New("attributecert: platform certificate has no holder serial number")
+	}
+	if ekCert.SerialNumber == nil || c.Holder.Serial.Cmp(ekCert.SerialNumber) != 0 {
+		return fmt.Errorf("attributecert: platform certificate holder serial number %v does not match EK certificate serial number %v", c.Holder.Serial, ekCert.SerialNumber)
+	}
+	if !bytes.Equal(c.HolderRawIssuer, ekCert.RawIssuer) {
+		return fmt.Errorf("attributecert: platform certificate holder issuer %q does not match EK certificate issuer %q", c.Holder.Issuer, ekCert.Issuer)
+	}
+	return nil
+}
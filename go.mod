@@ -9,11 +9,11 @@ require (
 	github.com/google/go-tpm v0.9.1
 	github.com/google/go-tpm-tools v0.4.4
 	github.com/google/go-tspi v0.3.0
+	golang.org/x/crypto v0.17.0
 	golang.org/x/sys v0.21.0
 )
 
 require (
 	github.com/google/certificate-transparency-go v1.1.2 // indirect
 	github.com/google/go-configfs-tsm v0.2.2 // indirect
-	golang.org/x/crypto v0.17.0 // indirect
 )